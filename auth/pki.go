@@ -9,6 +9,8 @@ import (
 
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+
+	"go.uber.org/zap"
 )
 
 // TriremePKI contains all the keys and cert for the local Trireme node.
@@ -35,6 +37,7 @@ func LoadPKI(nodeName string, kubeconfigPath string) (*TriremePKI, error) {
 
 	certManager, err := certificates.NewCertManager(nodeName, certClient)
 
+	logger().Debug("Generating private key and CSR", zap.String("nodeName", nodeName))
 	err = certManager.GeneratePrivateKey()
 	if err != nil {
 		return nil, fmt.Errorf("Error generating privateKey %s", err)
@@ -45,10 +48,12 @@ func LoadPKI(nodeName string, kubeconfigPath string) (*TriremePKI, error) {
 		return nil, fmt.Errorf("Error generating CSR %s", err)
 	}
 
+	logger().Info("Sending CSR and waiting for certificate", zap.String("nodeName", nodeName))
 	err = certManager.SendAndWaitforCert(time.Minute)
 	if err != nil {
 		return nil, fmt.Errorf("Error Sending and waiting %s", err)
 	}
+	logger().Info("Certificate received", zap.String("nodeName", nodeName))
 
 	keyPEM, err := certManager.GetKeyPEM()
 	if err != nil {
@@ -0,0 +1,227 @@
+package collector
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"go.uber.org/zap"
+)
+
+// Tags added to the flows by the AggregatingCollector.
+const (
+	// FirstSeenTag and LastSeenTag are the RFC3339 times of the first and last aggregated flows.
+	FirstSeenTag = "@aggregate:firstseen"
+	LastSeenTag  = "@aggregate:lastseen"
+	// SampleRateTag is the rate at which the accepted flows were sampled. The count of the
+	// sampled flows is divided by the rate to estimate the number of flows.
+	SampleRateTag = "@aggregate:samplerate"
+)
+
+// maxAggregates bounds the number of flows aggregated in a window. The window is flushed
+// early once reached.
+const maxAggregates = 100000
+
+// aggregateKey identifies the aggregated flows.
+type aggregateKey struct {
+	source      string
+	destination string
+	port        uint16
+	rejected    bool
+}
+
+// aggregate is the flows aggregated for a key.
+type aggregate struct {
+	record    collector.FlowRecord
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// AggregatingCollector is an EventCollector rolling up the flows by source, destination, port
+// and verdict over a window before forwarding them to a backend collector. The accepted flows
+// are optionally sampled while the rejected flows are always kept. The container events are
+// forwarded immediately.
+type AggregatingCollector struct {
+	collector  collector.EventCollector
+	window     time.Duration
+	sampleRate float64
+	random     *rand.Rand
+	aggregates map[aggregateKey]*aggregate
+	stop       chan struct{}
+	stopped    chan struct{}
+	sync.Mutex
+}
+
+// NewAggregatingCollector returns an AggregatingCollector forwarding to the collector given in
+// parameter. The flows are not aggregated if window is zero. The accepted flows are kept with
+// the probability sampleRate, between 0 and 1.
+func NewAggregatingCollector(backend collector.EventCollector, window time.Duration, sampleRate float64) *AggregatingCollector {
+	logger().Info("Aggregating the flows", zap.Duration("window", window), zap.Float64("sampleRate", sampleRate))
+
+	a := &AggregatingCollector{
+		collector:  backend,
+		window:     window,
+		sampleRate: sampleRate,
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		aggregates: map[aggregateKey]*aggregate{},
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	if window > 0 {
+		go a.run()
+	} else {
+		close(a.stopped)
+	}
+	return a
+}
+
+// CollectFlowEvent samples and aggregates the flow.
+func (a *AggregatingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	rejected := record.Action&policy.Reject != 0
+	now := time.Now()
+
+	a.Lock()
+	if !rejected && a.sampleRate < 1 && a.random.Float64() >= a.sampleRate {
+		a.Unlock()
+		return
+	}
+
+	if a.window == 0 {
+		a.Unlock()
+		a.collector.CollectFlowEvent(a.tagged(&aggregate{record: *record, firstSeen: now, lastSeen: now}))
+		return
+	}
+
+	key := aggregateKey{
+		source:      endpointIdentity(record.Source),
+		destination: endpointIdentity(record.Destination),
+		rejected:    rejected,
+	}
+	if record.Destination != nil {
+		key.port = record.Destination.Port
+	}
+
+	count := record.Count
+	if count == 0 {
+		count = 1
+	}
+
+	var flushed map[aggregateKey]*aggregate
+	if current, ok := a.aggregates[key]; ok {
+		current.record.Count += count
+		current.lastSeen = now
+	} else {
+		if len(a.aggregates) >= maxAggregates {
+			flushed = a.aggregates
+			a.aggregates = map[aggregateKey]*aggregate{}
+		}
+		a.aggregates[key] = &aggregate{
+			record:    aggregatedRecord(record, count),
+			firstSeen: now,
+			lastSeen:  now,
+		}
+	}
+	a.Unlock()
+
+	if flushed != nil {
+		logger().Debug("Too many aggregated flows. Flushing early", zap.Int("flows", len(flushed)))
+		a.forward(flushed)
+	}
+}
+
+// CollectContainerEvent forwards the container event to the backend.
+func (a *AggregatingCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	a.collector.CollectContainerEvent(record)
+}
+
+// Stop forwards the flows of the current window.
+func (a *AggregatingCollector) Stop() {
+	select {
+	case <-a.stopped:
+		return
+	default:
+	}
+
+	close(a.stop)
+	<-a.stopped
+}
+
+// run forwards the aggregated flows at the end of each window until stopped.
+func (a *AggregatingCollector) run() {
+	defer close(a.stopped)
+
+	ticker := time.NewTicker(a.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.flush()
+		case <-a.stop:
+			a.flush()
+			return
+		}
+	}
+}
+
+// flush forwards the aggregated flows and starts a new window.
+func (a *AggregatingCollector) flush() {
+	a.Lock()
+	aggregates := a.aggregates
+	a.aggregates = map[aggregateKey]*aggregate{}
+	a.Unlock()
+
+	a.forward(aggregates)
+}
+
+// forward sends the aggregated flows to the backend.
+func (a *AggregatingCollector) forward(aggregates map[aggregateKey]*aggregate) {
+	for _, flows := range aggregates {
+		a.collector.CollectFlowEvent(a.tagged(flows))
+	}
+}
+
+// tagged returns the record of the aggregated flows with the aggregation tags.
+func (a *AggregatingCollector) tagged(flows *aggregate) *collector.FlowRecord {
+	tags := append([]string{}, tagSlice(flows.record.Tags)...)
+	tags = append(tags,
+		FirstSeenTag+"="+flows.firstSeen.UTC().Format(time.RFC3339Nano),
+		LastSeenTag+"="+flows.lastSeen.UTC().Format(time.RFC3339Nano),
+	)
+	if a.sampleRate < 1 && flows.record.Action&policy.Reject == 0 {
+		tags = append(tags, SampleRateTag+"="+strconv.FormatFloat(a.sampleRate, 'g', -1, 64))
+	}
+
+	record := flows.record
+	record.Tags = &policy.TagStore{Tags: tags}
+	return &record
+}
+
+// aggregatedRecord returns a copy of the record for the aggregation. The source port isn't
+// part of the aggregation and is reset.
+func aggregatedRecord(record *collector.FlowRecord, count int) collector.FlowRecord {
+	aggregated := *record
+	aggregated.Count = count
+	if record.Source != nil {
+		source := *record.Source
+		source.Port = 0
+		aggregated.Source = &source
+	}
+	return aggregated
+}
+
+// endpointIdentity returns the contextID of a PU endpoint or the IP of an address.
+func endpointIdentity(endpoint *collector.EndPoint) string {
+	if endpoint == nil {
+		return ""
+	}
+	if endpoint.Type == collector.PU {
+		return "pu:" + endpoint.ID
+	}
+	return "ip:" + endpoint.IP
+}
@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+func flowFrom(sourcePort uint16, action policy.ActionType) *collector.FlowRecord {
+	return &collector.FlowRecord{
+		ContextID:   "abc",
+		Count:       1,
+		Source:      &collector.EndPoint{IP: "10.0.0.1", Port: sourcePort, Type: collector.Address},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Action:      action,
+	}
+}
+
+func hasTag(record *collector.FlowRecord, prefix string) bool {
+	for _, tag := range record.Tags.Tags {
+		if strings.HasPrefix(tag, prefix+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAggregatingCollector(t *testing.T) {
+	backend := &recordingCollector{}
+	aggregatingCollector := NewAggregatingCollector(backend, time.Hour, 1)
+
+	// The source ports are not part of the aggregation.
+	for port := uint16(1000); port < 1003; port++ {
+		aggregatingCollector.CollectFlowEvent(flowFrom(port, policy.Accept))
+	}
+	aggregatingCollector.CollectFlowEvent(flowFrom(2000, policy.Reject))
+	aggregatingCollector.Stop()
+
+	if len(backend.flows) != 2 {
+		t.Fatalf("Expected 2 aggregated flows, got %d", len(backend.flows))
+	}
+	for _, flow := range backend.flows {
+		expected := 3
+		if flow.Action&policy.Reject != 0 {
+			expected = 1
+		}
+		if flow.Count != expected || flow.Source.Port != 0 {
+			t.Errorf("Unexpected aggregated flow %+v", flow)
+		}
+		if !hasTag(flow, FirstSeenTag) || !hasTag(flow, LastSeenTag) || hasTag(flow, SampleRateTag) {
+			t.Errorf("Unexpected aggregation tags %v", flow.Tags.Tags)
+		}
+	}
+}
+
+func TestAggregatingCollectorSampling(t *testing.T) {
+	backend := &recordingCollector{}
+	aggregatingCollector := NewAggregatingCollector(backend, 0, 0.1)
+	aggregatingCollector.random = rand.New(rand.NewSource(1))
+
+	for i := 0; i < 1000; i++ {
+		aggregatingCollector.CollectFlowEvent(flowFrom(1000, policy.Accept))
+		aggregatingCollector.CollectFlowEvent(flowFrom(1000, policy.Reject))
+	}
+	aggregatingCollector.Stop()
+
+	accepted := 0
+	rejected := 0
+	for _, flow := range backend.flows {
+		if flow.Action&policy.Reject != 0 {
+			rejected++
+			continue
+		}
+		accepted++
+		if !hasTag(flow, SampleRateTag) {
+			t.Errorf("Sampled flow without rate %v", flow.Tags.Tags)
+		}
+	}
+
+	if rejected != 1000 {
+		t.Errorf("Expected all the 1000 rejected flows, got %d", rejected)
+	}
+	if accepted < 50 || accepted > 150 {
+		t.Errorf("Expected about 100 sampled flows, got %d", accepted)
+	}
+}
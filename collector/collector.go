@@ -1,25 +1,38 @@
 package collector
 
 import (
-	"github.com/aporeto-inc/trireme-statistics/influxdb"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/aporeto-inc/trireme/collector"
-	"go.uber.org/zap"
 )
 
 // NewDefaultCollector returns an empty collectorInstance
 func NewDefaultCollector() collector.EventCollector {
-	zap.L().Info("Using default empty collector")
+	logger().Info("Using default empty collector")
 	return &collector.DefaultCollector{}
 }
 
-// NewInfluxDBCollector returns a collector implementation for InfluxDB
-func NewInfluxDBCollector(user, pass, url, db string, insecureSkipVerify bool) collector.EventCollector {
-	zap.L().Info("Using Influx collector", zap.String("endpoint", url), zap.String("user", user))
-	collectorInstance, err := influxdb.NewDBConnection(user, pass, url, db, insecureSkipVerify)
+// CheckInfluxDBEndpoint verifies that the InfluxDB endpoint is reachable by calling its ping API.
+func CheckInfluxDBEndpoint(url string, insecureSkipVerify bool) error {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+		},
+	}
+
+	resp, err := client.Get(strings.TrimSuffix(url, "/") + "/ping")
 	if err != nil {
-		zap.L().Error("Error instantiating Influx collector, using default", zap.String("endpoint", url), zap.String("user", user), zap.Error(err))
-		return NewDefaultCollector()
+		return fmt.Errorf("Couldn't reach InfluxDB endpoint %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected answer from InfluxDB endpoint %s: %s", url, resp.Status)
 	}
-	collectorInstance.Start()
-	return collectorInstance
+	return nil
 }
@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"sync"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+// recordingCollector keeps all the events it receives. If release is not nil, each event
+// blocks until release is closed.
+type recordingCollector struct {
+	flows      []*collector.FlowRecord
+	containers []*collector.ContainerRecord
+	release    chan struct{}
+	sync.Mutex
+}
+
+func (r *recordingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	if r.release != nil {
+		<-r.release
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.flows = append(r.flows, record)
+}
+
+func (r *recordingCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	if r.release != nil {
+		<-r.release
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.containers = append(r.containers, record)
+}
+
+// lastFlow returns the last flow received, or nil if none.
+func (r *recordingCollector) lastFlow() *collector.FlowRecord {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.flows) == 0 {
+		return nil
+	}
+	return r.flows[len(r.flows)-1]
+}
+
+// lastContainer returns the last container event received, or nil if none.
+func (r *recordingCollector) lastContainer() *collector.ContainerRecord {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.containers) == 0 {
+		return nil
+	}
+	return r.containers[len(r.containers)-1]
+}
+
+// events returns the number of events received.
+func (r *recordingCollector) events() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.flows) + len(r.containers)
+}
+
+// reset forgets the events received.
+func (r *recordingCollector) reset() {
+	r.Lock()
+	defer r.Unlock()
+	r.flows = nil
+	r.containers = nil
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"sort"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// Prefixes of the tags added by the EnrichingCollector. The flow endpoints are described
+// with the SourceTagPrefix and DestinationTagPrefix, and the container events with PodTagPrefix.
+const (
+	PodTagPrefix         = "@k8s:"
+	SourceTagPrefix      = "@k8s:source:"
+	DestinationTagPrefix = "@k8s:destination:"
+	// NetworkPolicyTag is the NetworkPolicy, as namespace/name, of the rule matching the flow.
+	NetworkPolicyTag = "@k8s:networkpolicy"
+	// LabelTagPrefix follows the prefix of a pod in the tags of its labels.
+	LabelTagPrefix = "label:"
+)
+
+// PodMetadata is the Kubernetes metadata of a pod.
+type PodMetadata struct {
+	Name           string
+	Namespace      string
+	Node           string
+	Workload       string
+	ServiceAccount string
+	Labels         map[string]string
+}
+
+// MetadataResolver returns the Kubernetes metadata of the PUs and policies.
+type MetadataResolver interface {
+	// PodMetadata returns the metadata of the local pod of the contextID.
+	PodMetadata(contextID string) (*PodMetadata, bool)
+	// RemotePodMetadata returns the metadata of the pod of the IP on any node.
+	RemotePodMetadata(ip string) (*PodMetadata, bool)
+	// NetworkPolicy returns the NetworkPolicy, as namespace/name, that generated the rules of policyID.
+	NetworkPolicy(policyID string) (string, bool)
+}
+
+// EnrichingCollector is an EventCollector adding the Kubernetes metadata of the pods and
+// NetworkPolicies to the tags of the events before forwarding them to a backend collector.
+// The flow endpoints that aren't local pods are looked up by IP.
+type EnrichingCollector struct {
+	collector collector.EventCollector
+	resolver  MetadataResolver
+}
+
+// NewEnrichingCollector returns an EnrichingCollector forwarding to the collector given in parameter.
+func NewEnrichingCollector(backend collector.EventCollector, resolver MetadataResolver) *EnrichingCollector {
+	logger().Info("Enriching the events with the Kubernetes metadata")
+
+	return &EnrichingCollector{
+		collector: backend,
+		resolver:  resolver,
+	}
+}
+
+// CollectFlowEvent enriches the flow event and forwards it to the backend.
+func (e *EnrichingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	tags := append([]string{}, tagSlice(record.Tags)...)
+	tags = append(tags, e.endpointTags(SourceTagPrefix, record.Source)...)
+	tags = append(tags, e.endpointTags(DestinationTagPrefix, record.Destination)...)
+	if record.PolicyID != "" {
+		if networkPolicy, ok := e.resolver.NetworkPolicy(record.PolicyID); ok {
+			tags = append(tags, NetworkPolicyTag+"="+networkPolicy)
+		}
+	}
+
+	enriched := *record
+	enriched.Tags = &policy.TagStore{Tags: tags}
+	e.collector.CollectFlowEvent(&enriched)
+}
+
+// CollectContainerEvent enriches the container event and forwards it to the backend.
+func (e *EnrichingCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	tags := append([]string{}, tagSlice(record.Tags)...)
+	if metadata, ok := e.resolver.PodMetadata(record.ContextID); ok {
+		tags = append(tags, podTags(PodTagPrefix, metadata)...)
+	}
+
+	enriched := *record
+	enriched.Tags = &policy.TagStore{Tags: tags}
+	e.collector.CollectContainerEvent(&enriched)
+}
+
+// endpointTags returns the tags of the flow endpoint if it is a pod. The local pods are
+// identified by contextID and the remote ones by IP.
+func (e *EnrichingCollector) endpointTags(prefix string, endpoint *collector.EndPoint) []string {
+	if endpoint == nil {
+		return nil
+	}
+
+	if endpoint.Type == collector.PU {
+		if metadata, ok := e.resolver.PodMetadata(endpoint.ID); ok {
+			return podTags(prefix, metadata)
+		}
+	}
+	if metadata, ok := e.resolver.RemotePodMetadata(endpoint.IP); ok {
+		return podTags(prefix, metadata)
+	}
+	return nil
+}
+
+// podTags returns the non empty metadata as key=value tags prefixed with prefix. The labels
+// are sorted by key.
+func podTags(prefix string, metadata *PodMetadata) []string {
+	tags := []string{}
+	for _, tag := range []struct {
+		key   string
+		value string
+	}{
+		{"pod", metadata.Name},
+		{"namespace", metadata.Namespace},
+		{"node", metadata.Node},
+		{"workload", metadata.Workload},
+		{"serviceaccount", metadata.ServiceAccount},
+	} {
+		if tag.value != "" {
+			tags = append(tags, prefix+tag.key+"="+tag.value)
+		}
+	}
+
+	keys := []string{}
+	for key := range metadata.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tags = append(tags, prefix+LabelTagPrefix+key+"="+metadata.Labels[key])
+	}
+	return tags
+}
@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+type staticResolver map[string]*PodMetadata
+
+func (s staticResolver) PodMetadata(contextID string) (*PodMetadata, bool) {
+	metadata, ok := s[contextID]
+	return metadata, ok
+}
+
+func (s staticResolver) RemotePodMetadata(ip string) (*PodMetadata, bool) {
+	metadata, ok := s[ip]
+	return metadata, ok
+}
+
+func (s staticResolver) NetworkPolicy(policyID string) (string, bool) {
+	return policyID, policyID == "shop/allow-frontend"
+}
+
+func TestEnrichingCollector(t *testing.T) {
+	backend := &recordingCollector{}
+	enrichingCollector := NewEnrichingCollector(backend, staticResolver{
+		"abc": {Name: "frontend-x2x4z", Namespace: "shop", Node: "node-1", Workload: "Deployment/frontend", ServiceAccount: "default",
+			Labels: map[string]string{"tier": "web", "app": "frontend"}},
+	})
+
+	record := &collector.FlowRecord{
+		ContextID:   "abc",
+		Source:      &collector.EndPoint{IP: "10.0.0.1", Type: collector.Address},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Tags:        &policy.TagStore{Tags: []string{"app=frontend"}},
+		PolicyID:    "shop/allow-frontend",
+	}
+	enrichingCollector.CollectFlowEvent(record)
+
+	expected := []string{
+		"app=frontend",
+		"@k8s:destination:pod=frontend-x2x4z",
+		"@k8s:destination:namespace=shop",
+		"@k8s:destination:node=node-1",
+		"@k8s:destination:workload=Deployment/frontend",
+		"@k8s:destination:serviceaccount=default",
+		"@k8s:destination:label:app=frontend",
+		"@k8s:destination:label:tier=web",
+		"@k8s:networkpolicy=shop/allow-frontend",
+	}
+	if flow := backend.lastFlow(); flow == nil || !reflect.DeepEqual(flow.Tags.Tags, expected) {
+		t.Errorf("Unexpected flow %+v", flow)
+	}
+	if len(record.Tags.Tags) != 1 {
+		t.Errorf("Original record modified: %v", record.Tags.Tags)
+	}
+
+	enrichingCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Event: "start"})
+	if container := backend.lastContainer(); container == nil || len(container.Tags.Tags) != 7 || container.Tags.Tags[0] != "@k8s:pod=frontend-x2x4z" {
+		t.Errorf("Unexpected container event %+v", container)
+	}
+}
+
+func TestEnrichingCollectorRemotePod(t *testing.T) {
+	backend := &recordingCollector{}
+	enrichingCollector := NewEnrichingCollector(backend, staticResolver{
+		"10.1.0.5": {Name: "runner-7f9c", Namespace: "ci", Node: "node-2"},
+	})
+
+	for _, source := range []*collector.EndPoint{
+		{ID: "def", IP: "10.1.0.5", Type: collector.PU},
+		{IP: "10.1.0.5", Type: collector.Address},
+	} {
+		enrichingCollector.CollectFlowEvent(&collector.FlowRecord{
+			ContextID:   "abc",
+			Source:      source,
+			Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+			Tags:        &policy.TagStore{Tags: []string{}},
+		})
+
+		expected := []string{"@k8s:source:pod=runner-7f9c", "@k8s:source:namespace=ci", "@k8s:source:node=node-2"}
+		if flow := backend.lastFlow(); flow == nil || !reflect.DeepEqual(flow.Tags.Tags, expected) {
+			t.Errorf("Unexpected flow from %+v: %+v", source, flow)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/aporeto-inc/trireme/collector"
+
+	"go.uber.org/zap"
+)
+
+// backendMetrics publishes the health of each fan-out backend through expvar.
+var backendMetrics = expvar.NewMap("trireme_collector_backends")
+
+// event is a flow or container event queued for a backend.
+type event struct {
+	flow      *collector.FlowRecord
+	container *collector.ContainerRecord
+}
+
+// queuedBackend is a backend collector fed through a bounded queue by its own goroutine.
+type queuedBackend struct {
+	name      string
+	collector collector.EventCollector
+	queue     chan event
+	metrics   *expvar.Map
+	dropping  bool
+	sync.Mutex
+}
+
+// FanoutCollector is an EventCollector forwarding every event to several backends concurrently.
+// Each backend has a bounded queue: the events are dropped when it is full so that a slow backend
+// never blocks the datapath.
+type FanoutCollector struct {
+	backends  []*queuedBackend
+	queueSize int
+	stopped   sync.WaitGroup
+}
+
+// NewFanoutCollector returns a FanoutCollector with queues of queueSize events per backend.
+func NewFanoutCollector(queueSize int) *FanoutCollector {
+	return &FanoutCollector{
+		queueSize: queueSize,
+	}
+}
+
+// AddBackend starts forwarding the events to the backend collector. Must be called before
+// the first event is collected.
+func (f *FanoutCollector) AddBackend(name string, backend collector.EventCollector) {
+	logger().Info("Adding collector backend", zap.String("backend", name), zap.Int("queueSize", f.queueSize))
+
+	b := &queuedBackend{
+		name:      name,
+		collector: backend,
+		queue:     make(chan event, f.queueSize),
+		metrics:   new(expvar.Map).Init(),
+	}
+	b.metrics.Set("queued", expvar.Func(func() interface{} { return len(b.queue) }))
+	b.metrics.Add("sent", 0)
+	b.metrics.Add("dropped", 0)
+	backendMetrics.Set(name, b.metrics)
+
+	f.backends = append(f.backends, b)
+	f.stopped.Add(1)
+	go func() {
+		defer f.stopped.Done()
+		b.run()
+	}()
+}
+
+// CollectFlowEvent queues the flow event for every backend.
+func (f *FanoutCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	for _, b := range f.backends {
+		b.enqueue(event{flow: record})
+	}
+}
+
+// CollectContainerEvent queues the container event for every backend.
+func (f *FanoutCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	for _, b := range f.backends {
+		b.enqueue(event{container: record})
+	}
+}
+
+// Stop flushes the queues and stops the backends that support it.
+func (f *FanoutCollector) Stop() {
+	for _, b := range f.backends {
+		close(b.queue)
+	}
+	f.stopped.Wait()
+
+	for _, b := range f.backends {
+		if stoppable, ok := b.collector.(interface {
+			Stop()
+		}); ok {
+			stoppable.Stop()
+		}
+	}
+}
+
+// enqueue queues the event without blocking. The event is dropped if the queue is full.
+func (b *queuedBackend) enqueue(e event) {
+	select {
+	case b.queue <- e:
+		b.setDropping(false)
+	default:
+		b.metrics.Add("dropped", 1)
+		b.setDropping(true)
+	}
+}
+
+// setDropping logs the transitions between dropping and queueing the events.
+func (b *queuedBackend) setDropping(dropping bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.dropping == dropping {
+		return
+	}
+	b.dropping = dropping
+	if dropping {
+		logger().Warn("Collector backend queue full. Dropping events", zap.String("backend", b.name))
+	} else {
+		logger().Info("Collector backend queue available again", zap.String("backend", b.name))
+	}
+}
+
+// run forwards the queued events to the backend until the queue is closed.
+func (b *queuedBackend) run() {
+	for e := range b.queue {
+		if e.flow != nil {
+			b.collector.CollectFlowEvent(e.flow)
+		} else {
+			b.collector.CollectContainerEvent(e.container)
+		}
+		b.metrics.Add("sent", 1)
+	}
+}
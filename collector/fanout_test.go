@@ -0,0 +1,41 @@
+package collector
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+func TestFanoutCollector(t *testing.T) {
+	fast := &recordingCollector{}
+	slow := &recordingCollector{release: make(chan struct{})}
+
+	fanout := NewFanoutCollector(2)
+	fanout.AddBackend("fast", fast)
+	fanout.AddBackend("slow", slow)
+
+	// The slow backend holds the first event and queues the next two. The other events
+	// must be dropped without blocking.
+	for i := 0; i < 10; i++ {
+		fanout.CollectFlowEvent(&collector.FlowRecord{ContextID: "abc"})
+	}
+	fanout.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Event: "start"})
+
+	close(slow.release)
+	fanout.Stop()
+
+	// Every event is either forwarded or dropped.
+	fastDropped := backendMetrics.Get("fast").(*expvar.Map).Get("dropped").(*expvar.Int).Value()
+	if int64(fast.events())+fastDropped != 11 {
+		t.Errorf("Fast backend received %d events and dropped %d, expected 11 in total", fast.events(), fastDropped)
+	}
+
+	slowDropped := backendMetrics.Get("slow").(*expvar.Map).Get("dropped").(*expvar.Int).Value()
+	if slow.events() > 3 {
+		t.Errorf("Slow backend received %d events, expected at most 3", slow.events())
+	}
+	if int64(slow.events())+slowDropped != 11 {
+		t.Errorf("Slow backend received %d events and dropped %d, expected 11 in total", slow.events(), slowDropped)
+	}
+}
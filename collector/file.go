@@ -0,0 +1,390 @@
+package collector
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"go.uber.org/zap"
+)
+
+// backupTimeFormat is the timestamp appended to the name of the rotated files.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// rotationRetryInterval is the time before retrying a failed rotation. The events are
+// written to the current file in the meantime.
+var rotationRetryInterval = time.Minute
+
+// FileCollector is an EventCollector writing each flow and container event as a JSON
+// object on its own line. The file is rotated when it exceeds maxSize bytes or is older
+// than maxAge. Rotated files are optionally gzipped and only the last maxBackups are kept.
+type FileCollector struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+	file       *os.File
+	size       int64
+	opened     time.Time
+	failing    bool
+	stopped    bool
+	// rotationRetry is the time before which a failed rotation isn't retried.
+	rotationRetry time.Time
+	// rotated are the rotated files waiting for the housekeeping goroutine, which compresses
+	// them and removes the old backups one rotation at a time. rotatedReady wakes it up.
+	rotated      []string
+	rotatedReady chan struct{}
+	background   sync.WaitGroup
+	sync.Mutex
+}
+
+// FlowEvent is the JSON representation of a flow event.
+type FlowEvent struct {
+	Time        time.Time      `json:"time"`
+	Type        string         `json:"type"`
+	ContextID   string         `json:"contextID"`
+	Count       int            `json:"count"`
+	Source      *EndpointEvent `json:"source,omitempty"`
+	Destination *EndpointEvent `json:"destination,omitempty"`
+	Action      string         `json:"action"`
+	Encrypted   bool           `json:"encrypted"`
+	DropReason  string         `json:"dropReason,omitempty"`
+	PolicyID    string         `json:"policyID,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+}
+
+// EndpointEvent is the JSON representation of a flow endpoint.
+type EndpointEvent struct {
+	ID   string `json:"id,omitempty"`
+	IP   string `json:"ip"`
+	Port uint16 `json:"port"`
+	Type string `json:"type"`
+}
+
+// ContainerEvent is the JSON representation of a container event.
+type ContainerEvent struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	ContextID string    `json:"contextID,omitempty"`
+	IPAddress string    `json:"ipAddress,omitempty"`
+	Event     string    `json:"event"`
+	Tags      []string  `json:"tags,omitempty"`
+}
+
+// NewFileCollector returns a FileCollector writing to path. A zero maxSize or maxAge
+// disables the corresponding rotation and a zero maxBackups keeps all the rotated files.
+func NewFileCollector(path string, maxSize int64, maxAge time.Duration, maxBackups int, compress bool) (*FileCollector, error) {
+	logger().Info("Using file collector", zap.String("path", path), zap.Int64("maxSize", maxSize), zap.Duration("maxAge", maxAge), zap.Int("maxBackups", maxBackups))
+
+	f := &FileCollector{
+		path:         path,
+		maxSize:      maxSize,
+		maxAge:       maxAge,
+		maxBackups:   maxBackups,
+		compress:     compress,
+		rotatedReady: make(chan struct{}, 1),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("Couldn't create directory for %s: %s", path, err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	f.background.Add(1)
+	go f.housekeep()
+	return f, nil
+}
+
+// CollectFlowEvent writes the flow event.
+func (f *FileCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	event := &FlowEvent{
+		Time:        time.Now(),
+		Type:        "flow",
+		ContextID:   record.ContextID,
+		Count:       record.Count,
+		Source:      endpointEvent(record.Source),
+		Destination: endpointEvent(record.Destination),
+		Action:      "accept",
+		Encrypted:   record.Action&policy.Encrypt != 0,
+		DropReason:  record.DropReason,
+		PolicyID:    record.PolicyID,
+		Tags:        tagSlice(record.Tags),
+	}
+	if record.Action&policy.Reject != 0 {
+		event.Action = "reject"
+	}
+
+	f.write(event)
+}
+
+// CollectContainerEvent writes the container event.
+func (f *FileCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	f.write(&ContainerEvent{
+		Time:      time.Now(),
+		Type:      "container",
+		ContextID: record.ContextID,
+		IPAddress: record.IPAddress,
+		Event:     record.Event,
+		Tags:      tagSlice(record.Tags),
+	})
+}
+
+// Stop closes the file and waits for the rotated files to be compressed.
+func (f *FileCollector) Stop() {
+	f.Lock()
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			logger().Warn("Couldn't close flow log file", zap.String("path", f.path), zap.Error(err))
+		}
+		f.file = nil
+	}
+	if !f.stopped {
+		close(f.rotatedReady)
+	}
+	f.stopped = true
+	f.Unlock()
+
+	f.background.Wait()
+}
+
+// write appends the event to the file, rotating it if needed. Write errors are logged
+// once until the file can be written again.
+func (f *FileCollector) write(event interface{}) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		logger().Error("Couldn't encode event", zap.Error(err))
+		return
+	}
+	line = append(line, '\n')
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.stopped {
+		return
+	}
+	// The file is reopened if it couldn't be after the last rotation.
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			f.fail(err)
+			return
+		}
+	}
+
+	if f.isRotationNeeded(int64(len(line))) {
+		if err := f.rotate(); err != nil {
+			f.fail(err)
+			return
+		}
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		f.fail(err)
+		return
+	}
+	if f.failing {
+		logger().Info("Flow log file writable again", zap.String("path", f.path))
+		f.failing = false
+	}
+}
+
+// fail logs the error unless already failing. Must be called with the lock held.
+func (f *FileCollector) fail(err error) {
+	if !f.failing {
+		logger().Error("Couldn't write flow log file. Dropping events", zap.String("path", f.path), zap.Error(err))
+		f.failing = true
+	}
+}
+
+// isRotationNeeded returns true if writing length bytes exceeds the limits.
+// Must be called with the lock held.
+func (f *FileCollector) isRotationNeeded(length int64) bool {
+	if f.size == 0 || time.Now().Before(f.rotationRetry) {
+		return false
+	}
+	if f.maxSize > 0 && f.size+length > f.maxSize {
+		return true
+	}
+	return f.maxAge > 0 && time.Since(f.opened) > f.maxAge
+}
+
+// open opens the file for appending. Must be called with the lock held.
+func (f *FileCollector) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Couldn't open flow log file %s: %s", f.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Couldn't stat flow log file %s: %s", f.path, err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+// rotate renames the current file with a timestamp and opens a new one. The rotated
+// file is compressed and the old backups removed in the background. If the file can't be
+// renamed, the events are written to the current file until the next retry.
+// Must be called with the lock held.
+func (f *FileCollector) rotate() error {
+	if err := f.file.Close(); err != nil {
+		logger().Warn("Couldn't close flow log file", zap.String("path", f.path), zap.Error(err))
+	}
+
+	backup := f.path + "." + time.Now().UTC().Format(backupTimeFormat)
+	renameErr := os.Rename(f.path, backup)
+
+	if err := f.open(); err != nil {
+		f.file = nil
+		return err
+	}
+
+	if renameErr != nil {
+		logger().Error("Couldn't rotate flow log file. Retrying later", zap.String("path", f.path), zap.Duration("retryInterval", rotationRetryInterval), zap.Error(renameErr))
+		f.rotationRetry = time.Now().Add(rotationRetryInterval)
+		return nil
+	}
+
+	f.rotated = append(f.rotated, backup)
+	select {
+	case f.rotatedReady <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// housekeep compresses the rotated files and removes the old backups until the collector
+// is stopped. A backup is never removed while it is compressed.
+func (f *FileCollector) housekeep() {
+	defer f.background.Done()
+
+	for range f.rotatedReady {
+		for {
+			f.Lock()
+			if len(f.rotated) == 0 {
+				f.Unlock()
+				break
+			}
+			backup := f.rotated[0]
+			f.rotated = f.rotated[1:]
+			f.Unlock()
+
+			if f.compress {
+				// The backup is already removed if more files were rotated than kept.
+				if err := compressFile(backup); err != nil && !os.IsNotExist(err) {
+					logger().Error("Couldn't compress rotated flow log file", zap.String("path", backup), zap.Error(err))
+				}
+			}
+			f.removeOldBackups()
+		}
+	}
+}
+
+// removeOldBackups removes the oldest rotated files beyond maxBackups.
+func (f *FileCollector) removeOldBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		logger().Error("Couldn't list rotated flow log files", zap.String("path", f.path), zap.Error(err))
+		return
+	}
+
+	// The timestamps sort the backups from the oldest to the newest. The temporary files
+	// of an interrupted compression are skipped.
+	backups := []string{}
+	for _, match := range matches {
+		if !strings.HasSuffix(match, ".tmp") {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+
+	for i := 0; i < len(backups)-f.maxBackups; i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			logger().Warn("Couldn't remove rotated flow log file", zap.String("path", backups[i]), zap.Error(err))
+		}
+	}
+}
+
+// compressFile gzips the file into file.gz and removes it.
+func compressFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	temporary := path + ".gz.tmp"
+	destination, err := os.OpenFile(temporary, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(destination)
+	if _, err := io.Copy(writer, source); err != nil {
+		destination.Close()
+		os.Remove(temporary)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		destination.Close()
+		os.Remove(temporary)
+		return err
+	}
+	if err := destination.Close(); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+
+	if err := os.Rename(temporary, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func endpointEvent(endpoint *collector.EndPoint) *EndpointEvent {
+	if endpoint == nil {
+		return nil
+	}
+
+	endpointType := "address"
+	if endpoint.Type == collector.PU {
+		endpointType = "pu"
+	}
+	return &EndpointEvent{
+		ID:   endpoint.ID,
+		IP:   endpoint.IP,
+		Port: endpoint.Port,
+		Type: endpointType,
+	}
+}
+
+func tagSlice(tags *policy.TagStore) []string {
+	if tags == nil {
+		return nil
+	}
+	return tags.Tags
+}
@@ -0,0 +1,132 @@
+package collector
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+func TestFileCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowlog")
+	if err != nil {
+		t.Fatalf("Couldn't create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flows.log")
+	fileCollector, err := NewFileCollector(path, 0, 0, 0, false)
+	if err != nil {
+		t.Fatalf("NewFileCollector failed: %s", err)
+	}
+
+	fileCollector.CollectFlowEvent(&collector.FlowRecord{
+		ContextID:   "abc",
+		Count:       1,
+		Source:      &collector.EndPoint{IP: "10.0.0.1", Type: collector.Address},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Action:      policy.Reject,
+	})
+	fileCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Event: "start"})
+	fileCollector.Stop()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Couldn't open flow log: %s", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatalf("Flow event not written")
+	}
+	flow := FlowEvent{}
+	if err := json.Unmarshal(scanner.Bytes(), &flow); err != nil {
+		t.Fatalf("Invalid flow event: %s", err)
+	}
+	if flow.Type != "flow" || flow.Action != "reject" || flow.Destination.Port != 80 || flow.Destination.Type != "pu" {
+		t.Errorf("Unexpected flow event %+v", flow)
+	}
+
+	if !scanner.Scan() {
+		t.Fatalf("Container event not written")
+	}
+	container := ContainerEvent{}
+	if err := json.Unmarshal(scanner.Bytes(), &container); err != nil {
+		t.Fatalf("Invalid container event: %s", err)
+	}
+	if container.Type != "container" || container.Event != "start" {
+		t.Errorf("Unexpected container event %+v", container)
+	}
+}
+
+func TestFileCollectorRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowlog")
+	if err != nil {
+		t.Fatalf("Couldn't create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Each event is bigger than the maximum size, so every write rotates the file.
+	path := filepath.Join(dir, "flows.log")
+	fileCollector, err := NewFileCollector(path, 10, 0, 2, true)
+	if err != nil {
+		t.Fatalf("NewFileCollector failed: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		fileCollector.CollectContainerEvent(&collector.ContainerRecord{Event: "start"})
+	}
+	fileCollector.Stop()
+
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatalf("Couldn't list backups: %s", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Found backups %v, expected 2", backups)
+	}
+
+	for _, backup := range backups {
+		if filepath.Ext(backup) != ".gz" {
+			t.Errorf("Backup %s is not compressed", backup)
+			continue
+		}
+		file, err := os.Open(backup)
+		if err != nil {
+			t.Fatalf("Couldn't open backup: %s", err)
+		}
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("Invalid gzip backup %s: %s", backup, err)
+		}
+		content, _ := ioutil.ReadAll(reader)
+		if len(content) == 0 {
+			t.Errorf("Backup %s is empty", backup)
+		}
+		file.Close()
+	}
+}
+
+func TestFileCollectorRotationRetry(t *testing.T) {
+	f := &FileCollector{maxSize: 10, size: 5}
+	if !f.isRotationNeeded(10) {
+		t.Errorf("isRotationNeeded => false, expected true beyond the maximum size")
+	}
+
+	// After a failed rotation, the events are written to the current file until the retry.
+	f.rotationRetry = time.Now().Add(time.Minute)
+	if f.isRotationNeeded(10) {
+		t.Errorf("isRotationNeeded => true, expected false before the retry")
+	}
+	f.rotationRetry = time.Now().Add(-time.Second)
+	if !f.isRotationNeeded(10) {
+		t.Errorf("isRotationNeeded => false, expected true after the retry interval")
+	}
+}
@@ -0,0 +1,202 @@
+package collector
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"go.uber.org/zap"
+)
+
+// Verdicts of the flows kept by an EventFilter.
+const (
+	VerdictAccept = "accept"
+	VerdictReject = "reject"
+)
+
+// filterMetrics are the filter counters published through expvar on the management server.
+var filterMetrics = expvar.NewMap("trireme_collector_filter")
+
+// EventFilter selects the events sent to the collectors. The namespaces and labels of the pods
+// are read from the tags added by the EnrichingCollector.
+type EventFilter struct {
+	include             map[string]bool
+	exclude             map[string]bool
+	verdicts            map[string]bool
+	sourceSelector      labels.Selector
+	destinationSelector labels.Selector
+}
+
+// NewEventFilter returns an EventFilter. A flow is kept if none of its pods is in the exclude
+// namespaces and, if there are include namespaces, one of its pods is in them. Its verdict,
+// accept or reject, must be in verdicts if any, and its source and destination pods must match
+// the source and destination label selectors. The container events are only filtered by
+// namespace. An empty filter keeps every event.
+func NewEventFilter(include []string, exclude []string, verdicts []string, sourceSelector string, destinationSelector string) (*EventFilter, error) {
+	f := &EventFilter{
+		include:  map[string]bool{},
+		exclude:  map[string]bool{},
+		verdicts: map[string]bool{},
+	}
+
+	for _, namespace := range include {
+		f.include[namespace] = true
+	}
+	for _, namespace := range exclude {
+		f.exclude[namespace] = true
+	}
+	for _, verdict := range verdicts {
+		if verdict != VerdictAccept && verdict != VerdictReject {
+			return nil, fmt.Errorf("Invalid verdict %s: should be accept or reject", verdict)
+		}
+		f.verdicts[verdict] = true
+	}
+
+	var err error
+	if f.sourceSelector, err = labels.Parse(sourceSelector); err != nil {
+		return nil, fmt.Errorf("Invalid source selector %s: %s", sourceSelector, err)
+	}
+	if f.destinationSelector, err = labels.Parse(destinationSelector); err != nil {
+		return nil, fmt.Errorf("Invalid destination selector %s: %s", destinationSelector, err)
+	}
+	return f, nil
+}
+
+// keepFlow returns true if the flow passes the filter.
+func (f *EventFilter) keepFlow(record *collector.FlowRecord) bool {
+	verdict := VerdictAccept
+	if record.Action&policy.Reject != 0 {
+		verdict = VerdictReject
+	}
+	if len(f.verdicts) > 0 && !f.verdicts[verdict] {
+		return false
+	}
+
+	tags := tagSlice(record.Tags)
+	namespaces := []string{}
+	for _, prefix := range []string{SourceTagPrefix, DestinationTagPrefix} {
+		if namespace := TagValue(tags, prefix+"namespace"); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	if !f.keepNamespaces(namespaces) {
+		return false
+	}
+
+	return f.sourceSelector.Matches(podLabels(tags, SourceTagPrefix)) &&
+		f.destinationSelector.Matches(podLabels(tags, DestinationTagPrefix))
+}
+
+// keepContainerEvent returns true if the namespace of the container event passes the filter.
+// The events reported by the resolver without a contextID, such as the NetworkPolicy window
+// events, aren't enriched and give their namespace in the @namespace tag.
+func (f *EventFilter) keepContainerEvent(record *collector.ContainerRecord) bool {
+	tags := tagSlice(record.Tags)
+	namespace := TagValue(tags, PodTagPrefix+"namespace")
+	if namespace == "" {
+		namespace = TagValue(tags, "@namespace")
+	}
+
+	namespaces := []string{}
+	if namespace != "" {
+		namespaces = append(namespaces, namespace)
+	}
+	return f.keepNamespaces(namespaces)
+}
+
+// keepNamespaces returns true if none of the namespaces is excluded, and one is included if
+// there are included namespaces.
+func (f *EventFilter) keepNamespaces(namespaces []string) bool {
+	included := len(f.include) == 0
+	for _, namespace := range namespaces {
+		if f.exclude[namespace] {
+			return false
+		}
+		included = included || f.include[namespace]
+	}
+	return included
+}
+
+// FilteringCollector is an EventCollector forwarding to a backend collector the events kept by
+// its EventFilter. The filter can be replaced at runtime.
+type FilteringCollector struct {
+	collector collector.EventCollector
+	filter    *EventFilter
+	sync.RWMutex
+}
+
+// NewFilteringCollector returns a FilteringCollector forwarding to the collector given in
+// parameter the events kept by filter.
+func NewFilteringCollector(backend collector.EventCollector, filter *EventFilter) *FilteringCollector {
+	return &FilteringCollector{
+		collector: backend,
+		filter:    filter,
+	}
+}
+
+// SetFilter replaces the filter.
+func (f *FilteringCollector) SetFilter(filter *EventFilter) {
+	f.Lock()
+	f.filter = filter
+	f.Unlock()
+
+	logger().Info("Collector filter replaced")
+}
+
+// CollectFlowEvent forwards the flow event if it is kept by the filter.
+func (f *FilteringCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	f.RLock()
+	keep := f.filter.keepFlow(record)
+	f.RUnlock()
+
+	if !keep {
+		filterMetrics.Add("filteredFlows", 1)
+		logger().Debug("Flow filtered", zap.String("contextID", record.ContextID))
+		return
+	}
+	f.collector.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent forwards the container event if it is kept by the filter.
+func (f *FilteringCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	f.RLock()
+	keep := f.filter.keepContainerEvent(record)
+	f.RUnlock()
+
+	if !keep {
+		filterMetrics.Add("filteredContainerEvents", 1)
+		return
+	}
+	f.collector.CollectContainerEvent(record)
+}
+
+// podLabels returns the labels of the pod described by the tags prefixed with prefix.
+func podLabels(tags []string, prefix string) labels.Set {
+	podLabels := labels.Set{}
+	prefix += LabelTagPrefix
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		if parts := strings.SplitN(tag[len(prefix):], "=", 2); len(parts) == 2 {
+			podLabels[parts[0]] = parts[1]
+		}
+	}
+	return podLabels
+}
+
+// TagValue returns the value of the key=value tag, or an empty string.
+func TagValue(tags []string, key string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, key+"=") {
+			return tag[len(key)+1:]
+		}
+	}
+	return ""
+}
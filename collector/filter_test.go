@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+func filteredFlow(action policy.ActionType, tags ...string) *collector.FlowRecord {
+	return &collector.FlowRecord{
+		ContextID:   "abc",
+		Source:      &collector.EndPoint{IP: "10.0.0.1", Type: collector.PU},
+		Destination: &collector.EndPoint{IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Tags:        &policy.TagStore{Tags: tags},
+		Action:      action,
+	}
+}
+
+func TestFilteringCollector(t *testing.T) {
+	filter, err := NewEventFilter([]string{"shop"}, []string{"kube-system"}, []string{VerdictReject}, "", "app=db")
+	if err != nil {
+		t.Fatalf("NewEventFilter failed: %s", err)
+	}
+	backend := &recordingCollector{}
+	filteringCollector := NewFilteringCollector(backend, filter)
+
+	tests := []struct {
+		name string
+		flow *collector.FlowRecord
+		kept bool
+	}{
+		{"rejected flow to db", filteredFlow(policy.Reject, "@k8s:source:namespace=ci", "@k8s:destination:namespace=shop", "@k8s:destination:label:app=db"), true},
+		{"accepted flow", filteredFlow(policy.Accept, "@k8s:destination:namespace=shop", "@k8s:destination:label:app=db"), false},
+		{"excluded namespace", filteredFlow(policy.Reject, "@k8s:source:namespace=kube-system", "@k8s:destination:namespace=shop", "@k8s:destination:label:app=db"), false},
+		{"not included namespace", filteredFlow(policy.Reject, "@k8s:destination:namespace=ci", "@k8s:destination:label:app=db"), false},
+		{"not selected destination", filteredFlow(policy.Reject, "@k8s:destination:namespace=shop", "@k8s:destination:label:app=web"), false},
+	}
+	for _, test := range tests {
+		backend.reset()
+		filteringCollector.CollectFlowEvent(test.flow)
+		if (backend.lastFlow() != nil) != test.kept {
+			t.Errorf("%s: expected kept %t", test.name, test.kept)
+		}
+	}
+
+	filteringCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Tags: &policy.TagStore{Tags: []string{"@k8s:namespace=kube-system"}}})
+	if backend.lastContainer() != nil {
+		t.Errorf("Container event of an excluded namespace forwarded")
+	}
+
+	// The events reported by the resolver without a contextID give their namespace in the
+	// @namespace tag.
+	backend.reset()
+	filteringCollector.CollectContainerEvent(&collector.ContainerRecord{Event: "start", Tags: &policy.TagStore{Tags: []string{"@namespace=kube-system", "@networkpolicy=deny-all"}}})
+	if backend.lastContainer() != nil {
+		t.Errorf("NetworkPolicy event of an excluded namespace forwarded")
+	}
+	filteringCollector.CollectContainerEvent(&collector.ContainerRecord{Event: "start", Tags: &policy.TagStore{Tags: []string{"@namespace=shop", "@networkpolicy=deny-all"}}})
+	if backend.lastContainer() == nil {
+		t.Errorf("NetworkPolicy event of an included namespace not forwarded")
+	}
+
+	// An empty filter keeps every event.
+	filter, err = NewEventFilter(nil, nil, nil, "", "")
+	if err != nil {
+		t.Fatalf("NewEventFilter failed: %s", err)
+	}
+	filteringCollector.SetFilter(filter)
+	filteringCollector.CollectFlowEvent(filteredFlow(policy.Accept))
+	filteringCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Tags: &policy.TagStore{Tags: []string{"@k8s:namespace=kube-system"}}})
+	if backend.lastFlow() == nil || backend.lastContainer() == nil {
+		t.Errorf("Events not forwarded by an empty filter")
+	}
+
+	if _, err := NewEventFilter(nil, nil, []string{"drop"}, "", ""); err == nil {
+		t.Errorf("Invalid verdict accepted")
+	}
+	if _, err := NewEventFilter(nil, nil, nil, "app in (", ""); err == nil {
+		t.Errorf("Invalid selector accepted")
+	}
+}
@@ -0,0 +1,454 @@
+package collector
+
+import (
+	"bytes"
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// influxBatchSize is the maximum number of points sent in a single write.
+	influxBatchSize = 1000
+	// influxFlushInterval is the maximum time a point is buffered while InfluxDB is reachable.
+	influxFlushInterval = time.Second
+	// influxMinBackoff and influxMaxBackoff bound the delay between the retries.
+	influxMinBackoff = time.Second
+	influxMaxBackoff = time.Minute
+)
+
+// InfluxDB counters published through expvar on the management server.
+var (
+	influxMetrics      = expvar.NewMap("trireme_influxdb")
+	influxWritten      = new(expvar.Int)
+	influxDropped      = new(expvar.Int)
+	influxSpilled      = new(expvar.Int)
+	influxWriteErrors  = new(expvar.Int)
+	influxMetricsSetup sync.Once
+)
+
+// InfluxDBCollector is an EventCollector writing the flow and container events to InfluxDB
+// using the line protocol. The connection is lazy: the points are buffered in memory until
+// InfluxDB is reachable and the writes are retried with an exponential backoff. Once the
+// memory buffer is full, the points are spilled to a file if a spill directory is given,
+// and dropped otherwise.
+type InfluxDBCollector struct {
+	endpoint   string
+	db         string
+	user       string
+	pass       string
+	client     *http.Client
+	bufferSize int
+	buffer     [][]byte
+	spill      *spillFile
+	connected  bool
+	created    bool
+	dropping   bool
+	flush      chan struct{}
+	stop       chan struct{}
+	stopped    chan struct{}
+	sync.Mutex
+}
+
+// NewInfluxDBCollector returns an InfluxDBCollector buffering up to bufferSize points in memory.
+// The points beyond bufferSize are written to a file in spillDir, up to spillMaxSize bytes.
+// The spill file is disabled if spillDir is empty.
+func NewInfluxDBCollector(user, pass, endpoint, db string, insecureSkipVerify bool, bufferSize int, spillDir string, spillMaxSize int64) (*InfluxDBCollector, error) {
+	logger().Info("Using Influx collector", zap.String("endpoint", endpoint), zap.String("user", user), zap.Int("bufferSize", bufferSize), zap.String("spillDir", spillDir))
+
+	i := &InfluxDBCollector{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		db:       db,
+		user:     user,
+		pass:     pass,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+			},
+		},
+		bufferSize: bufferSize,
+		flush:      make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	if spillDir != "" {
+		spill, err := acquireSpillFile(spillDir, spillMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		i.spill = spill
+	}
+
+	influxMetricsSetup.Do(func() {
+		influxMetrics.Set("written", influxWritten)
+		influxMetrics.Set("dropped", influxDropped)
+		influxMetrics.Set("spilled", influxSpilled)
+		influxMetrics.Set("writeErrors", influxWriteErrors)
+	})
+	influxMetrics.Set("buffered", expvar.Func(func() interface{} {
+		i.Lock()
+		defer i.Unlock()
+		return len(i.buffer)
+	}))
+	influxMetrics.Set("connected", expvar.Func(func() interface{} {
+		i.Lock()
+		defer i.Unlock()
+		return i.connected
+	}))
+
+	go i.run()
+	return i, nil
+}
+
+// CollectFlowEvent buffers the flow event.
+func (i *InfluxDBCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	i.add(flowPoint(record, time.Now()))
+}
+
+// CollectContainerEvent buffers the container event.
+func (i *InfluxDBCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	fields := []string{
+		stringField("ContextID", record.ContextID),
+		stringField("IPAddress", record.IPAddress),
+		stringField("Event", record.Event),
+		stringField("Tags", strings.Join(tagSlice(record.Tags), ",")),
+	}
+
+	i.add(point("ContainerEvents", record.Event, fields, time.Now()))
+}
+
+// flowPoint returns the line protocol representation of the flow event.
+func flowPoint(record *collector.FlowRecord, timestamp time.Time) []byte {
+	action := "accept"
+	if record.Action&policy.Reject != 0 {
+		action = "reject"
+	}
+
+	fields := []string{
+		stringField("ContextID", record.ContextID),
+		"Count=" + strconv.Itoa(record.Count) + "i",
+		stringField("Action", action),
+		"Encrypted=" + strconv.FormatBool(record.Action&policy.Encrypt != 0),
+		stringField("DropReason", record.DropReason),
+		stringField("PolicyID", record.PolicyID),
+		stringField("Tags", strings.Join(tagSlice(record.Tags), ",")),
+	}
+	fields = append(fields, endpointFields("Source", record.Source)...)
+	fields = append(fields, endpointFields("Destination", record.Destination)...)
+
+	return point("FlowEvents", "FlowEvents", fields, timestamp)
+}
+
+// Stop makes a last attempt to write the buffered points. The points that couldn't be
+// written are kept in the spill file if enabled.
+func (i *InfluxDBCollector) Stop() {
+	close(i.stop)
+	<-i.stopped
+
+	i.Lock()
+	defer i.Unlock()
+
+	if len(i.buffer) > 0 {
+		i.spillPoints(i.buffer)
+		i.buffer = nil
+	}
+	if i.spill != nil {
+		i.spill.release()
+		i.spill = nil
+	}
+}
+
+// add buffers the point in memory, or in the spill file once the memory buffer is full.
+func (i *InfluxDBCollector) add(line []byte) {
+	i.Lock()
+	defer i.Unlock()
+
+	if len(i.buffer) < i.bufferSize {
+		i.buffer = append(i.buffer, line)
+		if len(i.buffer) >= influxBatchSize {
+			select {
+			case i.flush <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+	i.spillPoints([][]byte{line})
+}
+
+// spillPoints writes the points to the spill file and drops the ones that don't fit.
+// Must be called with the lock held.
+func (i *InfluxDBCollector) spillPoints(lines [][]byte) {
+	written := 0
+	if i.spill != nil {
+		var err error
+		written, err = i.spill.write(lines)
+		if err != nil {
+			logger().Error("Couldn't write InfluxDB spill file", zap.String("path", i.spill.path), zap.Error(err))
+		}
+		influxSpilled.Add(int64(written))
+	}
+
+	if written < len(lines) {
+		influxDropped.Add(int64(len(lines) - written))
+		if !i.dropping {
+			logger().Warn("InfluxDB buffer full. Dropping points", zap.String("endpoint", i.endpoint))
+			i.dropping = true
+		}
+	}
+}
+
+// run writes the buffered points until stopped, retrying with an exponential backoff.
+func (i *InfluxDBCollector) run() {
+	defer close(i.stopped)
+
+	ticker := time.NewTicker(influxFlushInterval)
+	defer ticker.Stop()
+
+	backoff := influxMinBackoff
+	for {
+		select {
+		case <-i.stop:
+			i.writeAll()
+			return
+		case <-ticker.C:
+		case <-i.flush:
+		}
+
+		if err := i.writeAll(); err != nil {
+			select {
+			case <-i.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > influxMaxBackoff {
+				backoff = influxMaxBackoff
+			}
+			continue
+		}
+		backoff = influxMinBackoff
+	}
+}
+
+// writeAll writes the memory buffer, then the spill file, in batches.
+func (i *InfluxDBCollector) writeAll() error {
+	for {
+		i.Lock()
+		count := len(i.buffer)
+		if count > influxBatchSize {
+			count = influxBatchSize
+		}
+		batch := i.buffer[:count]
+		spill := i.spill
+		i.Unlock()
+
+		if count > 0 {
+			if err := i.write(batch); err != nil {
+				return err
+			}
+			// Only this goroutine removes points, so the batch is still at the head of the buffer.
+			i.Lock()
+			i.buffer = i.buffer[count:]
+			if len(i.buffer) == 0 {
+				i.buffer = nil
+			}
+			i.Unlock()
+			continue
+		}
+
+		if spill == nil {
+			return nil
+		}
+		lines, next, err := spill.read(influxBatchSize)
+		if err != nil {
+			logger().Error("Couldn't read InfluxDB spill file", zap.String("path", spill.path), zap.Error(err))
+			return nil
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+		if err := i.write(lines); err != nil {
+			return err
+		}
+		if err := spill.commit(next); err != nil {
+			logger().Error("Couldn't truncate InfluxDB spill file", zap.String("path", spill.path), zap.Error(err))
+		}
+	}
+}
+
+// write sends the points to InfluxDB, creating the database first if needed.
+// The points rejected as malformed are dropped as retrying wouldn't help.
+func (i *InfluxDBCollector) write(lines [][]byte) error {
+	err := i.createDatabase()
+	if err == nil {
+		err = i.post("/write", url.Values{"db": {i.db}, "precision": {"ns"}}, bytes.Join(lines, []byte("\n")))
+	}
+
+	if influxErr, ok := err.(*influxError); ok {
+		switch influxErr.status {
+		case http.StatusBadRequest:
+			logger().Error("Points rejected by InfluxDB. Dropping them", zap.String("endpoint", i.endpoint), zap.Int("points", len(lines)), zap.Error(err))
+			influxDropped.Add(int64(len(lines)))
+			i.setConnected(true, nil)
+			return nil
+		case http.StatusNotFound:
+			// The database was removed.
+			i.created = false
+		}
+	}
+
+	if err != nil {
+		influxWriteErrors.Add(1)
+		i.setConnected(false, err)
+		return err
+	}
+
+	influxWritten.Add(int64(len(lines)))
+	i.setConnected(true, nil)
+	return nil
+}
+
+// createDatabase creates the database once per connection.
+func (i *InfluxDBCollector) createDatabase() error {
+	if i.created {
+		return nil
+	}
+	if err := i.post("/query", url.Values{"q": {"CREATE DATABASE " + strconv.Quote(i.db)}}, nil); err != nil {
+		return err
+	}
+	i.created = true
+	return nil
+}
+
+// post sends the request to the InfluxDB API.
+func (i *InfluxDBCollector) post(path string, parameters url.Values, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, i.endpoint+path+"?"+parameters.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Invalid InfluxDB endpoint %s: %s", i.endpoint, err)
+	}
+	if i.user != "" {
+		request.SetBasicAuth(i.user, i.pass)
+	}
+
+	resp, err := i.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("Couldn't reach InfluxDB endpoint %s: %s", i.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return &influxError{status: resp.StatusCode, message: strings.TrimSpace(string(message))}
+	}
+	return nil
+}
+
+// setConnected logs the transitions between the connected and disconnected states.
+func (i *InfluxDBCollector) setConnected(connected bool, err error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if connected {
+		i.dropping = false
+	}
+	if i.connected == connected {
+		return
+	}
+	i.connected = connected
+	if connected {
+		logger().Info("Connected to InfluxDB", zap.String("endpoint", i.endpoint))
+	} else {
+		logger().Warn("InfluxDB unreachable. Buffering points", zap.String("endpoint", i.endpoint), zap.Error(err))
+	}
+}
+
+// influxError is an error answer of the InfluxDB API.
+type influxError struct {
+	status  int
+	message string
+}
+
+func (e *influxError) Error() string {
+	return fmt.Sprintf("InfluxDB answered %d: %s", e.status, e.message)
+}
+
+// point returns the line protocol representation of a point.
+func point(measurement, eventType string, fields []string, timestamp time.Time) []byte {
+	return []byte(measurement + ",EventName=" + measurement + ",EventType=" + escapeTag(eventType) + " " + strings.Join(fields, ",") + " " + strconv.FormatInt(timestamp.UnixNano(), 10))
+}
+
+// endpointFields returns the fields of a flow endpoint, prefixed with prefix.
+func endpointFields(prefix string, endpoint *collector.EndPoint) []string {
+	event := endpointEvent(endpoint)
+	if event == nil {
+		return nil
+	}
+
+	return []string{
+		stringField(prefix+"ID", event.ID),
+		stringField(prefix+"IP", event.IP),
+		prefix + "Port=" + strconv.Itoa(int(event.Port)) + "i",
+		stringField(prefix+"Type", event.Type),
+	}
+}
+
+// stringField returns the line protocol representation of a string field. Only the double
+// quotes and the backslashes are escaped: InfluxDB keeps the other escape sequences as is,
+// and the newlines are allowed within the quotes.
+func stringField(key, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	return key + `="` + value + `"`
+}
+
+// pointEnd returns the index of the newline ending the first line protocol point of data,
+// or -1 if data has no complete point. The newlines of the string fields don't end the point.
+func pointEnd(data []byte) int {
+	quoted := false
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '\n':
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// scanPoints is a bufio.SplitFunc returning the line protocol points, one per token.
+func scanPoints(data []byte, atEOF bool) (int, []byte, error) {
+	if end := pointEnd(data); end >= 0 {
+		return end + 1, data[:end], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// escapeTag escapes a tag value for the line protocol.
+func escapeTag(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`).Replace(value)
+}
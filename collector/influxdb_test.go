@@ -0,0 +1,270 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// influxServer records the points written and fails the first requests.
+type influxServer struct {
+	failures int
+	queries  []string
+	points   []string
+	sync.Mutex
+}
+
+func (s *influxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/query":
+		s.queries = append(s.queries, r.URL.Query().Get("q"))
+	case "/write":
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Split(scanPoints)
+		for scanner.Scan() {
+			s.points = append(s.points, scanner.Text())
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *influxServer) written() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.points...)
+}
+
+// waitForPoints waits until count points are written.
+func waitForPoints(t *testing.T, server *influxServer, count int) []string {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if points := server.written(); len(points) >= count {
+			return points
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d points, got %d", count, len(server.written()))
+	return nil
+}
+
+func TestInfluxDBCollectorRetry(t *testing.T) {
+	server := &influxServer{failures: 2}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	influxDBCollector, err := NewInfluxDBCollector("", "", httpServer.URL, "flowDB", false, 10, "", 0)
+	if err != nil {
+		t.Fatalf("NewInfluxDBCollector failed: %s", err)
+	}
+	defer influxDBCollector.Stop()
+
+	influxDBCollector.CollectFlowEvent(&collector.FlowRecord{
+		ContextID:   "abc",
+		Count:       1,
+		Source:      &collector.EndPoint{IP: "10.0.0.1", Type: collector.Address},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Action:      policy.Reject,
+		PolicyID:    `say "hello"`,
+	})
+	influxDBCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Event: "start"})
+
+	points := waitForPoints(t, server, 2)
+	if len(server.queries) != 1 || server.queries[0] != `CREATE DATABASE "flowDB"` {
+		t.Errorf("Unexpected queries %v", server.queries)
+	}
+	if !strings.HasPrefix(points[0], "FlowEvents,EventName=FlowEvents,EventType=FlowEvents ") ||
+		!strings.Contains(points[0], `Action="reject"`) ||
+		!strings.Contains(points[0], `PolicyID="say \"hello\""`) ||
+		!strings.Contains(points[0], "DestinationPort=80i") {
+		t.Errorf("Unexpected flow point %s", points[0])
+	}
+	if !strings.HasPrefix(points[1], "ContainerEvents,EventName=ContainerEvents,EventType=start ") {
+		t.Errorf("Unexpected container point %s", points[1])
+	}
+}
+
+func TestInfluxDBCollectorSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatalf("Couldn't create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Nothing listens on the endpoint: one point is kept in memory and the others spilled.
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	influxDBCollector, err := NewInfluxDBCollector("", "", unreachable.URL, "flowDB", false, 1, dir, 0)
+	if err != nil {
+		t.Fatalf("NewInfluxDBCollector failed: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		influxDBCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Event: "start"})
+	}
+	influxDBCollector.Stop()
+
+	// The points are sent by the next collector using the spill directory.
+	server := &influxServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	influxDBCollector, err = NewInfluxDBCollector("", "", httpServer.URL, "flowDB", false, 1, dir, 0)
+	if err != nil {
+		t.Fatalf("NewInfluxDBCollector failed: %s", err)
+	}
+	defer influxDBCollector.Stop()
+
+	waitForPoints(t, server, 5)
+}
+
+// TestInfluxDBSchema pins the points written, which are read by the statistics bundle
+// and by ReadFlowEvents.
+func TestInfluxDBSchema(t *testing.T) {
+	timestamp := time.Unix(1500000000, 0)
+	flow := &collector.FlowRecord{
+		ContextID:   "abc",
+		Count:       2,
+		Source:      &collector.EndPoint{IP: "10.0.0.1", Port: 4242, Type: collector.Address},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Tags:        &policy.TagStore{Tags: []string{"app=web", "@namespace=shop"}},
+		Action:      policy.Accept | policy.Encrypt,
+		PolicyID:    "shop/allow",
+	}
+	expected := `FlowEvents,EventName=FlowEvents,EventType=FlowEvents ContextID="abc",Count=2i,Action="accept",Encrypted=true,DropReason="",PolicyID="shop/allow",Tags="app=web,@namespace=shop",` +
+		`SourceID="",SourceIP="10.0.0.1",SourcePort=4242i,SourceType="address",DestinationID="abc",DestinationIP="10.0.0.2",DestinationPort=80i,DestinationType="pu" 1500000000000000000`
+	if point := string(flowPoint(flow, timestamp)); point != expected {
+		t.Errorf("flowPoint =>\n%s\nexpected\n%s", point, expected)
+	}
+
+	fields := []string{stringField("ContextID", "abc"), stringField("IPAddress", "10.0.0.2"), stringField("Event", "start"), stringField("Tags", "app=web")}
+	expected = `ContainerEvents,EventName=ContainerEvents,EventType=start ContextID="abc",IPAddress="10.0.0.2",Event="start",Tags="app=web" 1500000000000000000`
+	if point := string(point("ContainerEvents", "start", fields, timestamp)); point != expected {
+		t.Errorf("point =>\n%s\nexpected\n%s", point, expected)
+	}
+}
+
+func TestStringField(t *testing.T) {
+	tests := map[string]string{
+		"plain":          `key="plain"`,
+		`say "hello"`:    `key="say \"hello\""`,
+		`C:\temp`:        `key="C:\\temp"`,
+		"two\nlines":     "key=\"two\nlines\"",
+		`literal \n end`: `key="literal \\n end"`,
+	}
+	for value, expected := range tests {
+		if field := stringField("key", value); field != expected {
+			t.Errorf("stringField(%q) => %s, expected %s", value, field, expected)
+		}
+	}
+}
+
+func TestScanPoints(t *testing.T) {
+	data := "a f=\"one\nvalue\" 1\nb f=\"quote \\\" and\nnewline\" 2\nc f=1i 3"
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Split(scanPoints)
+	points := []string{}
+	for scanner.Scan() {
+		points = append(points, scanner.Text())
+	}
+	expected := []string{"a f=\"one\nvalue\" 1", "b f=\"quote \\\" and\nnewline\" 2", "c f=1i 3"}
+	if len(points) != len(expected) {
+		t.Fatalf("scanPoints => %q, expected %q", points, expected)
+	}
+	for i := range points {
+		if points[i] != expected[i] {
+			t.Errorf("scanPoints => %q, expected %q", points[i], expected[i])
+		}
+	}
+}
+
+func TestSpillFileMultilinePoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatalf("Couldn't create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	spill, err := acquireSpillFile(dir, 0)
+	if err != nil {
+		t.Fatalf("acquireSpillFile failed: %s", err)
+	}
+	defer spill.release()
+
+	points := [][]byte{
+		flowPoint(&collector.FlowRecord{DropReason: "first\nsecond"}, time.Now()),
+		flowPoint(&collector.FlowRecord{DropReason: "third"}, time.Now()),
+	}
+	if written, err := spill.write(points); err != nil || written != 2 {
+		t.Fatalf("write => %d %v, expected 2 points", written, err)
+	}
+
+	read, next, err := spill.read(1)
+	if err != nil || len(read) != 1 || !bytes.Equal(read[0], points[0]) {
+		t.Fatalf("read => %q %v, expected the first point", read, err)
+	}
+	if err := spill.commit(next); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	read, next, err = spill.read(10)
+	if err != nil || len(read) != 1 || !bytes.Equal(read[0], points[1]) {
+		t.Fatalf("read => %q %v, expected the second point", read, err)
+	}
+	if err := spill.commit(next); err != nil || spill.size != 0 {
+		t.Errorf("commit => size %d %v, expected the file truncated", spill.size, err)
+	}
+}
+
+func TestSpillFilePartialPoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatalf("Couldn't create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The enforcer crashed while writing a point, in the middle of a string field.
+	complete := flowPoint(&collector.FlowRecord{DropReason: "first\nsecond"}, time.Now())
+	data := append(append([]byte{}, complete...), '\n')
+	partial := flowPoint(&collector.FlowRecord{DropReason: "third"}, time.Now())
+	data = append(data, partial[:bytes.Index(partial, []byte(`DropReason="`))+14]...)
+	if err := ioutil.WriteFile(filepath.Join(dir, spillFileName), data, 0644); err != nil {
+		t.Fatalf("Couldn't write spill file: %s", err)
+	}
+
+	spill, err := acquireSpillFile(dir, 0)
+	if err != nil {
+		t.Fatalf("acquireSpillFile failed: %s", err)
+	}
+	defer spill.release()
+	if spill.size != int64(len(complete)+1) {
+		t.Errorf("acquireSpillFile => size %d, expected the partial point removed", spill.size)
+	}
+
+	point := flowPoint(&collector.FlowRecord{DropReason: "fourth"}, time.Now())
+	if written, err := spill.write([][]byte{point}); err != nil || written != 1 {
+		t.Fatalf("write => %d %v, expected 1 point", written, err)
+	}
+	read, _, err := spill.read(10)
+	if err != nil || len(read) != 2 || !bytes.Equal(read[0], complete) || !bytes.Equal(read[1], point) {
+		t.Errorf("read => %q %v, expected the complete points", read, err)
+	}
+}
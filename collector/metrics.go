@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Values of the result label of the flow results counter.
+const (
+	EncryptedResult = "encrypted"
+	PlaintextResult = "plaintext"
+	RejectedResult  = "rejected"
+)
+
+// MetricsCollector is an EventCollector counting the encrypted, plaintext and rejected
+// flows in a Prometheus counter before forwarding all the events to a backend collector.
+type MetricsCollector struct {
+	collector collector.EventCollector
+	results   *prometheus.CounterVec
+}
+
+// NewMetricsCollector returns a MetricsCollector forwarding to the collector given in parameter.
+// The counter is registered with registerer.
+func NewMetricsCollector(backend collector.EventCollector, registerer prometheus.Registerer) (*MetricsCollector, error) {
+	m := &MetricsCollector{
+		collector: backend,
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trireme_flow_results_total",
+			Help: "Number of flows reported by Trireme per result: encrypted, plaintext or rejected.",
+		}, []string{"result"}),
+	}
+	if err := registerer.Register(m.results); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CollectFlowEvent counts the flow and forwards it to the backend.
+func (m *MetricsCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	count := float64(record.Count)
+	if count == 0 {
+		count = 1
+	}
+
+	m.results.WithLabelValues(flowResult(record)).Add(count)
+	m.collector.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent forwards the container event to the backend.
+func (m *MetricsCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	m.collector.CollectContainerEvent(record)
+}
+
+// flowResult returns the result label of the flow.
+func flowResult(record *collector.FlowRecord) string {
+	switch {
+	case record.Action&policy.Reject != 0:
+		return RejectedResult
+	case record.Action&policy.Encrypt != 0:
+		return EncryptedResult
+	default:
+		return PlaintextResult
+	}
+}
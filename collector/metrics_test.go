@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counterValues returns the values of the counter per value of the label.
+func counterValues(t *testing.T, registry *prometheus.Registry, name string, label string) map[string]float64 {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %s", err)
+	}
+
+	values := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == label {
+					values[pair.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return values
+}
+
+func TestMetricsCollector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	backend := &recordingCollector{}
+	metricsCollector, err := NewMetricsCollector(backend, registry)
+	if err != nil {
+		t.Fatalf("NewMetricsCollector failed: %s", err)
+	}
+
+	metricsCollector.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept | policy.Encrypt})
+	metricsCollector.CollectFlowEvent(&collector.FlowRecord{Action: policy.Accept, Count: 3})
+	metricsCollector.CollectFlowEvent(&collector.FlowRecord{Action: policy.Reject, Count: 2})
+	if len(backend.flows) != 3 || backend.lastFlow().Action != policy.Reject {
+		t.Errorf("Flows not forwarded to the backend")
+	}
+
+	values := counterValues(t, registry, "trireme_flow_results_total", "result")
+	expected := map[string]float64{EncryptedResult: 1, PlaintextResult: 3, RejectedResult: 2}
+	for result, count := range expected {
+		if values[result] != count {
+			t.Errorf("trireme_flow_results_total{result=%q} => %v, expected %v", result, values[result], count)
+		}
+	}
+
+	// The counter can only be registered once.
+	if _, err := NewMetricsCollector(backend, registry); err == nil {
+		t.Errorf("NewMetricsCollector registered the counter twice")
+	}
+}
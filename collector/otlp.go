@@ -0,0 +1,441 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// otlpScopeName is the instrumentation scope of the logs and metrics.
+	otlpScopeName = "github.com/aporeto-inc/trireme-kubernetes"
+	// otlpMaxBatch is the maximum number of log records exported in a single request.
+	otlpMaxBatch = 512
+	// otlpMaxBuffer bounds the number of log records waiting to be exported.
+	otlpMaxBuffer = 10000
+	// otlpTimeout bounds each export.
+	otlpTimeout = 10 * time.Second
+)
+
+// otlpMetrics are the OTLP counters published through expvar on the management server.
+var otlpMetrics = expvar.NewMap("trireme_otlp")
+
+// flowCountKey identifies the flow counters.
+type flowCountKey struct {
+	verdict   string
+	encrypted bool
+}
+
+// OTLPCollector is an EventCollector exporting the flows and container events as OTLP log
+// records, and their counts as cumulative OTLP metrics, over HTTP with the JSON encoding. The
+// log records are exported in batches at each interval and dropped if the export fails.
+type OTLPCollector struct {
+	exporter        *otlpExporter
+	resource        otlpResource
+	interval        time.Duration
+	records         []*otlpLogRecord
+	flowCounts      map[flowCountKey]uint64
+	containerCounts map[string]uint64
+	start           time.Time
+	failing         bool
+	flush           chan struct{}
+	stop            chan struct{}
+	stopped         chan struct{}
+	sync.Mutex
+}
+
+// NewOTLPCollector returns an OTLPCollector exporting to endpoint, the base URL of the
+// receiver, every interval. The cluster, node and serverID are set as resource attributes.
+func NewOTLPCollector(endpoint string, interval time.Duration, cluster string, node string, serverID string) (*OTLPCollector, error) {
+	logger().Info("Using OTLP collector", zap.String("endpoint", endpoint), zap.Duration("interval", interval))
+
+	exporter, err := newOTLPExporter(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := []otlpKeyValue{
+		stringAttribute("service.name", "trireme"),
+		stringAttribute("k8s.node.name", node),
+		stringAttribute("trireme.server_id", serverID),
+	}
+	if cluster != "" {
+		attributes = append(attributes, stringAttribute("k8s.cluster.name", cluster))
+	}
+
+	o := &OTLPCollector{
+		exporter:        exporter,
+		resource:        otlpResource{Attributes: attributes},
+		interval:        interval,
+		flowCounts:      map[flowCountKey]uint64{},
+		containerCounts: map[string]uint64{},
+		start:           time.Now(),
+		flush:           make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+		stopped:         make(chan struct{}),
+	}
+	go o.run()
+	return o, nil
+}
+
+// CollectFlowEvent counts the flow and queues its log record.
+func (o *OTLPCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	now := time.Now()
+	count := record.Count
+	if count == 0 {
+		count = 1
+	}
+
+	key := flowCountKey{verdict: "accept", encrypted: record.Action&policy.Encrypt != 0}
+	severity, severityText := otlpSeverityInfo, "INFO"
+	if record.Action&policy.Reject != 0 {
+		key.verdict = "reject"
+		severity, severityText = otlpSeverityWarn, "WARN"
+	}
+
+	attributes := []otlpKeyValue{
+		stringAttribute("event.name", "trireme.flow"),
+		stringAttribute("trireme.context_id", record.ContextID),
+		intAttribute("trireme.flow.count", int64(count)),
+		stringAttribute("trireme.flow.verdict", key.verdict),
+		boolAttribute("trireme.flow.encrypted", key.encrypted),
+	}
+	if record.PolicyID != "" {
+		attributes = append(attributes, stringAttribute("trireme.policy_id", record.PolicyID))
+	}
+	if record.DropReason != "" {
+		attributes = append(attributes, stringAttribute("trireme.flow.drop_reason", record.DropReason))
+	}
+	attributes = append(attributes, otlpEndpointAttributes("source", record.Source)...)
+	attributes = append(attributes, otlpEndpointAttributes("destination", record.Destination)...)
+	attributes = append(attributes, tagsAttribute(record.Tags)...)
+
+	o.Lock()
+	o.flowCounts[key] += uint64(count)
+	o.Unlock()
+
+	o.add(&otlpLogRecord{
+		TimeUnixNano:         uint64(now.UnixNano()),
+		ObservedTimeUnixNano: uint64(now.UnixNano()),
+		SeverityNumber:       severity,
+		SeverityText:         severityText,
+		Body:                 stringValue("Flow " + key.verdict + "ed"),
+		Attributes:           attributes,
+	})
+}
+
+// CollectContainerEvent counts the container event and queues its log record.
+func (o *OTLPCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	now := time.Now()
+
+	attributes := []otlpKeyValue{
+		stringAttribute("event.name", "trireme.container"),
+		stringAttribute("trireme.context_id", record.ContextID),
+		stringAttribute("trireme.container.event", record.Event),
+	}
+	if record.IPAddress != "" {
+		attributes = append(attributes, stringAttribute("trireme.container.ip", record.IPAddress))
+	}
+	attributes = append(attributes, tagsAttribute(record.Tags)...)
+
+	o.Lock()
+	o.containerCounts[record.Event]++
+	o.Unlock()
+
+	o.add(&otlpLogRecord{
+		TimeUnixNano:         uint64(now.UnixNano()),
+		ObservedTimeUnixNano: uint64(now.UnixNano()),
+		SeverityNumber:       otlpSeverityInfo,
+		SeverityText:         "INFO",
+		Body:                 stringValue("Container event " + record.Event),
+		Attributes:           attributes,
+	})
+}
+
+// Stop exports the remaining log records and the metrics.
+func (o *OTLPCollector) Stop() {
+	close(o.stop)
+	<-o.stopped
+}
+
+// add queues the log record, dropping it if the buffer is full.
+func (o *OTLPCollector) add(record *otlpLogRecord) {
+	o.Lock()
+	defer o.Unlock()
+
+	if len(o.records) >= otlpMaxBuffer {
+		otlpMetrics.Add("droppedLogs", 1)
+		return
+	}
+	o.records = append(o.records, record)
+	if len(o.records) >= otlpMaxBatch {
+		select {
+		case o.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run exports the log records at each interval or once a batch is full, and the metrics at
+// each interval, until stopped.
+func (o *OTLPCollector) run() {
+	defer close(o.stopped)
+
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.flush:
+			o.exportLogs()
+		case <-ticker.C:
+			o.exportLogs()
+			o.exportMetrics()
+		case <-o.stop:
+			o.exportLogs()
+			o.exportMetrics()
+			return
+		}
+	}
+}
+
+// exportLogs exports the queued log records in batches.
+func (o *OTLPCollector) exportLogs() {
+	o.Lock()
+	records := o.records
+	o.records = nil
+	o.Unlock()
+
+	for len(records) > 0 {
+		count := len(records)
+		if count > otlpMaxBatch {
+			count = otlpMaxBatch
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+		err := o.exporter.exportLogs(ctx, &otlpLogsData{
+			ResourceLogs: []otlpResourceLogs{{
+				Resource: o.resource,
+				ScopeLogs: []otlpScopeLogs{{
+					Scope:      otlpScope{Name: otlpScopeName},
+					LogRecords: records[:count],
+				}},
+			}},
+		})
+		cancel()
+
+		if err != nil {
+			otlpMetrics.Add("droppedLogs", int64(count))
+		} else {
+			otlpMetrics.Add("exportedLogs", int64(count))
+		}
+		o.setFailing(err)
+		records = records[count:]
+	}
+}
+
+// exportMetrics exports the cumulative flow and container event counts.
+func (o *OTLPCollector) exportMetrics() {
+	now := uint64(time.Now().UnixNano())
+	start := uint64(o.start.UnixNano())
+
+	o.Lock()
+	flowPoints := []otlpNumberDataPoint{}
+	for key, count := range o.flowCounts {
+		flowPoints = append(flowPoints, otlpNumberDataPoint{
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			AsInt:             int64(count),
+			Attributes: []otlpKeyValue{
+				stringAttribute("verdict", key.verdict),
+				boolAttribute("encrypted", key.encrypted),
+			},
+		})
+	}
+	containerPoints := []otlpNumberDataPoint{}
+	for event, count := range o.containerCounts {
+		containerPoints = append(containerPoints, otlpNumberDataPoint{
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			AsInt:             int64(count),
+			Attributes:        []otlpKeyValue{stringAttribute("event", event)},
+		})
+	}
+	o.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+
+	err := o.exporter.exportMetrics(ctx, &otlpMetricsData{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: o.resource,
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope: otlpScope{Name: otlpScopeName},
+				Metrics: []otlpMetric{
+					cumulativeSum("trireme.flows", "Number of flows reported by Trireme.", flowPoints),
+					cumulativeSum("trireme.container.events", "Number of container events reported by Trireme.", containerPoints),
+				},
+			}},
+		}},
+	})
+	if err == nil {
+		otlpMetrics.Add("exportedMetrics", 1)
+	}
+	o.setFailing(err)
+}
+
+// setFailing logs the transitions between failing and successful exports.
+func (o *OTLPCollector) setFailing(err error) {
+	if err != nil && !o.failing {
+		logger().Error("Couldn't export to OTLP receiver. Dropping log records", zap.Error(err))
+	}
+	if err == nil && o.failing {
+		logger().Info("OTLP receiver reachable again")
+	}
+	o.failing = err != nil
+}
+
+// newOTLPExporter returns the exporter to the endpoint, which must be an HTTP or HTTPS URL.
+func newOTLPExporter(endpoint string) (*otlpExporter, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+		return nil, fmt.Errorf("Invalid OTLP endpoint %s: should be an http or https URL", endpoint)
+	}
+
+	return &otlpExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: otlpTimeout},
+	}, nil
+}
+
+// CheckOTLPEndpoint verifies that the OTLP endpoint accepts the logs by exporting an empty request.
+func CheckOTLPEndpoint(endpoint string) error {
+	exporter, err := newOTLPExporter(endpoint)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	if err := exporter.exportLogs(ctx, &otlpLogsData{ResourceLogs: []otlpResourceLogs{}}); err != nil {
+		return fmt.Errorf("Couldn't export to OTLP endpoint %s: %s", endpoint, err)
+	}
+	return nil
+}
+
+// otlpExporter exports to the receiver over HTTP with the JSON encoding.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (e *otlpExporter) exportLogs(ctx context.Context, request *otlpLogsData) error {
+	return e.post(ctx, "/v1/logs", request)
+}
+
+func (e *otlpExporter) exportMetrics(ctx context.Context, request *otlpMetricsData) error {
+	return e.post(ctx, "/v1/metrics", request)
+}
+
+// post sends the request to the path of the receiver.
+func (e *otlpExporter) post(ctx context.Context, path string, message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("Couldn't encode OTLP request: %s", err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Invalid OTLP endpoint %s: %s", e.endpoint, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Couldn't reach OTLP endpoint %s: %s", e.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("OTLP endpoint %s answered %s: %s", e.endpoint, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// cumulativeSum returns a monotonic cumulative sum metric.
+func cumulativeSum(name string, description string, points []otlpNumberDataPoint) otlpMetric {
+	return otlpMetric{
+		Name:        name,
+		Description: description,
+		Unit:        "1",
+		Sum: otlpSum{
+			AggregationTemporality: otlpTemporalityCumulative,
+			IsMonotonic:            true,
+			DataPoints:             points,
+		},
+	}
+}
+
+// otlpEndpointAttributes returns the attributes of a flow endpoint, prefixed with prefix.
+func otlpEndpointAttributes(prefix string, endpoint *collector.EndPoint) []otlpKeyValue {
+	event := endpointEvent(endpoint)
+	if event == nil {
+		return nil
+	}
+
+	attributes := []otlpKeyValue{
+		stringAttribute(prefix+".address", event.IP),
+		intAttribute(prefix+".port", int64(event.Port)),
+		stringAttribute("trireme."+prefix+".type", event.Type),
+	}
+	if event.ID != "" {
+		attributes = append(attributes, stringAttribute("trireme."+prefix+".id", event.ID))
+	}
+	return attributes
+}
+
+// tagsAttribute returns the trireme.tags array attribute, or nothing if there are no tags.
+func tagsAttribute(tags *policy.TagStore) []otlpKeyValue {
+	values := []otlpAnyValue{}
+	for _, tag := range tagSlice(tags) {
+		values = append(values, stringValue(tag))
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	return []otlpKeyValue{{
+		Key:   "trireme.tags",
+		Value: otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}},
+	}}
+}
+
+func stringValue(value string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &value}
+}
+
+func stringAttribute(key string, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: stringValue(value)}
+}
+
+func intAttribute(key string, value int64) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &value}}
+}
+
+func boolAttribute(key string, value bool) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{BoolValue: &value}}
+}
@@ -0,0 +1,221 @@
+package collector
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// otlpReceiver is a stand-in OTLP/HTTP receiver keeping the exported requests.
+type otlpReceiver struct {
+	logs    []*otlpLogsData
+	metrics []*otlpMetricsData
+	sync.Mutex
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	switch req.URL.Path {
+	case "/v1/logs":
+		request := &otlpLogsData{}
+		if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.logs = append(r.logs, request)
+	case "/v1/metrics":
+		request := &otlpMetricsData{}
+		if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.metrics = append(r.metrics, request)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *otlpReceiver) logRecords() []*otlpLogRecord {
+	r.Lock()
+	defer r.Unlock()
+
+	records := []*otlpLogRecord{}
+	for _, request := range r.logs {
+		for _, resourceLogs := range request.ResourceLogs {
+			for _, scopeLogs := range resourceLogs.ScopeLogs {
+				records = append(records, scopeLogs.LogRecords...)
+			}
+		}
+	}
+	return records
+}
+
+// lastMetric returns the data points of the metric in the last export.
+func (r *otlpReceiver) lastMetric(name string) []otlpNumberDataPoint {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.metrics) == 0 {
+		return nil
+	}
+	for _, metric := range r.metrics[len(r.metrics)-1].ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if metric.Name == name {
+			return metric.Sum.DataPoints
+		}
+	}
+	return nil
+}
+
+func attribute(attributes []otlpKeyValue, key string) otlpAnyValue {
+	for _, attribute := range attributes {
+		if attribute.Key == key {
+			return attribute.Value
+		}
+	}
+	return otlpAnyValue{}
+}
+
+func stringOf(value otlpAnyValue) string {
+	if value.StringValue == nil {
+		return ""
+	}
+	return *value.StringValue
+}
+
+func intOf(value otlpAnyValue) int64 {
+	if value.IntValue == nil {
+		return 0
+	}
+	return *value.IntValue
+}
+
+func boolOf(value otlpAnyValue) bool {
+	return value.BoolValue != nil && *value.BoolValue
+}
+
+func collectOTLPEvents(otlpCollector *OTLPCollector) {
+	otlpCollector.CollectFlowEvent(rejectedFlow())
+	otlpCollector.CollectFlowEvent(&collector.FlowRecord{
+		ContextID:   "abc",
+		Count:       2,
+		Source:      &collector.EndPoint{ID: "def", IP: "10.0.0.3", Port: 4242, Type: collector.PU},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Tags:        &policy.TagStore{Tags: []string{"app=web"}},
+		Action:      policy.Accept | policy.Encrypt,
+	})
+	otlpCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", IPAddress: "10.0.0.2", Event: "start"})
+	otlpCollector.Stop()
+}
+
+func checkOTLPExports(t *testing.T, receiver *otlpReceiver) {
+	records := receiver.logRecords()
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 log records, got %d", len(records))
+	}
+	if records[0].SeverityNumber != otlpSeverityWarn ||
+		stringOf(records[0].Body) != "Flow rejected" ||
+		intOf(attribute(records[0].Attributes, "destination.port")) != 80 ||
+		stringOf(attribute(records[0].Attributes, "trireme.policy_id")) != "shop/deny=all" {
+		t.Errorf("Unexpected rejected flow %v", records[0])
+	}
+	if tags := attribute(records[1].Attributes, "trireme.tags").ArrayValue; stringOf(records[1].Body) != "Flow accepted" ||
+		!boolOf(attribute(records[1].Attributes, "trireme.flow.encrypted")) ||
+		tags == nil || len(tags.Values) != 1 || stringOf(tags.Values[0]) != "app=web" {
+		t.Errorf("Unexpected accepted flow %v", records[1])
+	}
+	if stringOf(records[2].Body) != "Container event start" {
+		t.Errorf("Unexpected container event %v", records[2])
+	}
+
+	resource := receiver.logs[0].ResourceLogs[0].Resource
+	if stringOf(attribute(resource.Attributes, "k8s.cluster.name")) != "prod" ||
+		stringOf(attribute(resource.Attributes, "k8s.node.name")) != "node-1" ||
+		stringOf(attribute(resource.Attributes, "trireme.server_id")) != "trireme-abc" {
+		t.Errorf("Unexpected resource %v", resource)
+	}
+
+	flows := map[string]int64{}
+	for _, point := range receiver.lastMetric("trireme.flows") {
+		flows[stringOf(attribute(point.Attributes, "verdict"))] += point.AsInt
+	}
+	if flows["accept"] != 2 || flows["reject"] != 1 {
+		t.Errorf("Unexpected flow counts %v", flows)
+	}
+	events := receiver.lastMetric("trireme.container.events")
+	if len(events) != 1 || events[0].AsInt != 1 {
+		t.Errorf("Unexpected container event counts %v", events)
+	}
+}
+
+func TestOTLPCollector(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	otlpCollector, err := NewOTLPCollector(server.URL, time.Hour, "prod", "node-1", "trireme-abc")
+	if err != nil {
+		t.Fatalf("NewOTLPCollector failed: %s", err)
+	}
+
+	collectOTLPEvents(otlpCollector)
+	checkOTLPExports(t, receiver)
+}
+
+func TestOTLPCollectorUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	otlpCollector, err := NewOTLPCollector(server.URL, time.Hour, "", "node-1", "trireme-abc")
+	if err != nil {
+		t.Fatalf("NewOTLPCollector failed: %s", err)
+	}
+
+	before := int64(0)
+	if dropped, ok := otlpMetrics.Get("droppedLogs").(*expvar.Int); ok {
+		before = dropped.Value()
+	}
+
+	otlpCollector.CollectFlowEvent(rejectedFlow())
+	otlpCollector.Stop()
+
+	if after := otlpMetrics.Get("droppedLogs").(*expvar.Int).Value(); after != before+1 {
+		t.Errorf("Expected the log record to be dropped, got %d dropped", after-before)
+	}
+}
+
+func TestCheckOTLPEndpoint(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	if err := CheckOTLPEndpoint(server.URL); err != nil {
+		t.Errorf("CheckOTLPEndpoint failed: %s", err)
+	}
+	if records := receiver.logRecords(); len(records) != 0 {
+		t.Errorf("CheckOTLPEndpoint exported %d log records", len(records))
+	}
+	server.Close()
+	if err := CheckOTLPEndpoint(server.URL); err == nil {
+		t.Errorf("CheckOTLPEndpoint succeeded without receiver")
+	}
+
+	for _, endpoint := range []string{"otel-collector:4318", "grpc://otel-collector:4317", "http://"} {
+		if err := CheckOTLPEndpoint(endpoint); err == nil {
+			t.Errorf("CheckOTLPEndpoint accepted the invalid endpoint %s", endpoint)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package collector
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"go.uber.org/zap"
+)
+
+// Label values used when the endpoint of a flow cannot be identified.
+const (
+	// ExternalLabel is used for the addresses outside of Trireme.
+	ExternalLabel = "external"
+	// UnknownLabel is used for the PUs that are not local to the node.
+	UnknownLabel = "unknown"
+	// OverflowLabel replaces the label values of the new series once the limit is reached.
+	OverflowLabel = "other"
+)
+
+// flowLabels are the labels of the flow counter. The flow records don't carry the protocol,
+// so there is no protocol label.
+var flowLabels = []string{"source_namespace", "source_workload", "destination_namespace", "destination_workload", "port", "verdict"}
+
+// EndpointResolver identifies the local PUs of the flows.
+type EndpointResolver interface {
+	// PUEndpoint returns the namespace and the workload of the PU.
+	PUEndpoint(contextID string) (namespace string, workload string, ok bool)
+}
+
+// PrometheusCollector is an EventCollector aggregating the flows and container events into
+// Prometheus counters. The number of flow series is bounded: once maxSeries is reached,
+// the new series are aggregated with the OverflowLabel. The series of a workload are
+// deleted once all its PUs are deleted. The flows must be collected before the sampling of
+// the AggregatingCollector, which would make the counters underestimated.
+type PrometheusCollector struct {
+	endpoints  EndpointResolver
+	registry   *prometheus.Registry
+	flows      *prometheus.CounterVec
+	containers *prometheus.CounterVec
+	overflows  prometheus.Counter
+	maxSeries  int
+	portLabel  bool
+	// series are the label values of the flow series, by series key.
+	series map[string][]string
+	// pus are the namespace and workload labels of the local PUs seen in the flows, by contextID.
+	pus map[string][2]string
+	// workloadPUs is the number of local PUs of each namespace and workload labels.
+	workloadPUs map[[2]string]int
+	sync.Mutex
+}
+
+// NewPrometheusCollector returns a PrometheusCollector identifying the local PUs with endpoints.
+// The destination port is only used as a label if portLabel is true.
+func NewPrometheusCollector(endpoints EndpointResolver, maxSeries int, portLabel bool) *PrometheusCollector {
+	logger().Info("Using Prometheus collector", zap.Int("maxSeries", maxSeries), zap.Bool("portLabel", portLabel))
+
+	p := &PrometheusCollector{
+		endpoints: endpoints,
+		registry:  prometheus.NewRegistry(),
+		flows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trireme_flows_total",
+			Help: "Number of flows reported by Trireme.",
+		}, flowLabels),
+		containers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trireme_container_events_total",
+			Help: "Number of container events reported by Trireme.",
+		}, []string{"namespace", "event"}),
+		overflows: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "trireme_flows_overflow_total",
+			Help: "Number of flows aggregated because the series limit was reached.",
+		}),
+		maxSeries:   maxSeries,
+		portLabel:   portLabel,
+		series:      map[string][]string{},
+		pus:         map[string][2]string{},
+		workloadPUs: map[[2]string]int{},
+	}
+	p.registry.MustRegister(p.flows, p.containers, p.overflows)
+
+	return p
+}
+
+// Handler returns the HTTP handler exposing the metrics.
+func (p *PrometheusCollector) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// Registerer returns the registry of the exposed metrics, to register the other Trireme counters.
+func (p *PrometheusCollector) Registerer() prometheus.Registerer {
+	return p.registry
+}
+
+// CollectFlowEvent counts the flow.
+func (p *PrometheusCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	count := float64(record.Count)
+	if count == 0 {
+		count = 1
+	}
+
+	p.flows.WithLabelValues(p.flowLabelValues(record)...).Add(count)
+}
+
+// CollectContainerEvent counts the container event. The flow series of the workload of
+// a deleted PU are deleted if it was the last PU of the workload.
+func (p *PrometheusCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	namespace := ""
+	if record.ContextID != "" {
+		namespace, _, _ = p.endpoints.PUEndpoint(record.ContextID)
+	}
+
+	p.containers.WithLabelValues(namespace, record.Event).Inc()
+
+	if record.Event == collector.ContainerDelete {
+		p.deletePU(record.ContextID)
+	}
+}
+
+// flowLabelValues returns the label values of the flow, in the flowLabels order.
+func (p *PrometheusCollector) flowLabelValues(record *collector.FlowRecord) []string {
+	sourceNamespace, sourceWorkload := p.endpoint(record.Source)
+	destinationNamespace, destinationWorkload := p.endpoint(record.Destination)
+
+	port := ""
+	if p.portLabel && record.Destination != nil {
+		port = strconv.Itoa(int(record.Destination.Port))
+	}
+
+	verdict := "accept"
+	if record.Action&policy.Reject != 0 {
+		verdict = "reject"
+	}
+
+	values := []string{sourceNamespace, sourceWorkload, destinationNamespace, destinationWorkload, port, verdict}
+
+	p.Lock()
+	defer p.Unlock()
+
+	key := strings.Join(values, "\x00")
+	if _, ok := p.series[key]; ok {
+		return values
+	}
+	if len(p.series) >= p.maxSeries {
+		p.overflows.Inc()
+		return []string{OverflowLabel, OverflowLabel, OverflowLabel, OverflowLabel, OverflowLabel, verdict}
+	}
+	p.series[key] = values
+	return values
+}
+
+// endpoint returns the namespace and workload labels of the flow endpoint.
+func (p *PrometheusCollector) endpoint(endpoint *collector.EndPoint) (string, string) {
+	if endpoint == nil {
+		return UnknownLabel, UnknownLabel
+	}
+	if endpoint.Type != collector.PU {
+		return ExternalLabel, ExternalLabel
+	}
+
+	namespace, workload, ok := p.endpoints.PUEndpoint(endpoint.ID)
+	if !ok {
+		return UnknownLabel, UnknownLabel
+	}
+	p.trackPU(endpoint.ID, [2]string{namespace, workload})
+	return namespace, workload
+}
+
+// trackPU records the namespace and workload labels of the local PU.
+func (p *PrometheusCollector) trackPU(contextID string, workload [2]string) {
+	p.Lock()
+	defer p.Unlock()
+
+	previous, ok := p.pus[contextID]
+	if ok && previous == workload {
+		return
+	}
+	if ok {
+		p.workloadPUs[previous]--
+	}
+	p.pus[contextID] = workload
+	p.workloadPUs[workload]++
+}
+
+// deletePU forgets the deleted PU, and deletes the flow series of its workload
+// if it has no PU left.
+func (p *PrometheusCollector) deletePU(contextID string) {
+	p.Lock()
+	defer p.Unlock()
+
+	workload, ok := p.pus[contextID]
+	if !ok {
+		return
+	}
+	delete(p.pus, contextID)
+	p.workloadPUs[workload]--
+	if p.workloadPUs[workload] > 0 {
+		return
+	}
+	delete(p.workloadPUs, workload)
+
+	for key, values := range p.series {
+		source := [2]string{values[0], values[1]}
+		destination := [2]string{values[2], values[3]}
+		if source != workload && destination != workload {
+			continue
+		}
+		p.flows.DeleteLabelValues(values...)
+		delete(p.series, key)
+	}
+	logger().Debug("Deleted the flow series of workload", zap.String("namespace", workload[0]), zap.String("workload", workload[1]))
+}
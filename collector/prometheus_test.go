@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// staticEndpoints is an EndpointResolver of the PUs as namespace/workload by contextID.
+type staticEndpoints map[string]string
+
+func (s staticEndpoints) PUEndpoint(contextID string) (string, string, bool) {
+	endpoint, ok := s[contextID]
+	if !ok {
+		return "", "", false
+	}
+	parts := strings.SplitN(endpoint, "/", 2)
+	return parts[0], parts[1], true
+}
+
+// flowSeries returns the value of each trireme_flows_total series by label values, joined with commas.
+func flowSeries(t *testing.T, p *PrometheusCollector) map[string]float64 {
+	families, err := p.registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %s", err)
+	}
+
+	series := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "trireme_flows_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			values := []string{}
+			for _, name := range flowLabels {
+				values = append(values, labels[name])
+			}
+			series[strings.Join(values, ",")] = metric.GetCounter().GetValue()
+		}
+	}
+	return series
+}
+
+func puFlow(source string, destination string, port uint16, action policy.ActionType) *collector.FlowRecord {
+	return &collector.FlowRecord{
+		Source:      &collector.EndPoint{ID: source, Type: collector.PU},
+		Destination: &collector.EndPoint{ID: destination, Type: collector.PU, Port: port},
+		Action:      action,
+	}
+}
+
+func TestPrometheusCollectorFlows(t *testing.T) {
+	endpoints := staticEndpoints{"1": "shop/Deployment/web", "2": "shop/Deployment/api"}
+	p := NewPrometheusCollector(endpoints, 3, true)
+
+	p.CollectFlowEvent(puFlow("1", "2", 80, policy.Accept))
+	p.CollectFlowEvent(puFlow("1", "2", 80, policy.Accept))
+	p.CollectFlowEvent(&collector.FlowRecord{
+		Source:      &collector.EndPoint{IP: "8.8.8.8", Type: collector.Address},
+		Destination: &collector.EndPoint{ID: "3", Type: collector.PU, Port: 443},
+		Action:      policy.Reject,
+		Count:       5,
+	})
+	p.CollectFlowEvent(puFlow("2", "1", 8080, policy.Accept))
+	// The series limit is reached.
+	p.CollectFlowEvent(puFlow("2", "1", 9090, policy.Reject))
+
+	expected := map[string]float64{
+		"shop,Deployment/web,shop,Deployment/api,80,accept":   2,
+		"external,external,unknown,unknown,443,reject":        5,
+		"shop,Deployment/api,shop,Deployment/web,8080,accept": 1,
+		"other,other,other,other,other,reject":                1,
+	}
+	series := flowSeries(t, p)
+	if len(series) != len(expected) {
+		t.Errorf("trireme_flows_total => %v, expected %v", series, expected)
+	}
+	for labels, value := range expected {
+		if series[labels] != value {
+			t.Errorf("trireme_flows_total{%s} => %v, expected %v", labels, series[labels], value)
+		}
+	}
+}
+
+func TestPrometheusCollectorPortLabel(t *testing.T) {
+	p := NewPrometheusCollector(staticEndpoints{"1": "shop/web", "2": "shop/api"}, 10, false)
+	p.CollectFlowEvent(puFlow("1", "2", 80, policy.Accept))
+	p.CollectFlowEvent(puFlow("1", "2", 443, policy.Accept))
+
+	series := flowSeries(t, p)
+	if value := series["shop,web,shop,api,,accept"]; len(series) != 1 || value != 2 {
+		t.Errorf("trireme_flows_total without port label => %v", series)
+	}
+}
+
+func TestPrometheusCollectorDeletedPUs(t *testing.T) {
+	endpoints := staticEndpoints{"1": "shop/Deployment/web", "2": "shop/Deployment/web", "3": "shop/Deployment/api", "4": "ci/Deployment/runner"}
+	p := NewPrometheusCollector(endpoints, 10, true)
+
+	p.CollectFlowEvent(puFlow("1", "3", 80, policy.Accept))
+	p.CollectFlowEvent(puFlow("2", "3", 80, policy.Accept))
+	p.CollectFlowEvent(puFlow("4", "3", 80, policy.Reject))
+	if series := flowSeries(t, p); len(series) != 2 {
+		t.Fatalf("trireme_flows_total => %v, expected 2 series", series)
+	}
+
+	// The web workload still has a PU.
+	p.CollectContainerEvent(&collector.ContainerRecord{ContextID: "1", Event: collector.ContainerDelete})
+	if series := flowSeries(t, p); len(series) != 2 {
+		t.Errorf("trireme_flows_total after deleting a web PU => %v, expected 2 series", series)
+	}
+
+	p.CollectContainerEvent(&collector.ContainerRecord{ContextID: "2", Event: collector.ContainerDelete})
+	series := flowSeries(t, p)
+	if _, ok := series["ci,Deployment/runner,shop,Deployment/api,80,reject"]; len(series) != 1 || !ok {
+		t.Errorf("trireme_flows_total after deleting the web PUs => %v, expected only the runner series", series)
+	}
+
+	p.CollectContainerEvent(&collector.ContainerRecord{ContextID: "3", Event: collector.ContainerStop})
+	p.CollectContainerEvent(&collector.ContainerRecord{ContextID: "3", Event: collector.ContainerDelete})
+	if series := flowSeries(t, p); len(series) != 0 {
+		t.Errorf("trireme_flows_total after deleting the api PU => %v, expected none", series)
+	}
+	if len(p.pus) != 1 || len(p.workloadPUs) != 1 {
+		t.Errorf("deletePU => %d PUs and %d workloads left, expected 1", len(p.pus), len(p.workloadPUs))
+	}
+}
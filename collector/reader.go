@@ -0,0 +1,242 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the flow logs read by ReadFlowEvents.
+const (
+	// FlowLogFormatJSONL is the format of the FileCollector, one JSON event per line.
+	FlowLogFormatJSONL = "jsonl"
+	// FlowLogFormatInfluxDB is the line protocol of the InfluxDBCollector, as exported by
+	// influx_inspect export.
+	FlowLogFormatInfluxDB = "influxdb"
+)
+
+// maxFlowLogLine bounds the length of a line of a flow log.
+const maxFlowLogLine = 1024 * 1024
+
+// ReadFlowLog calls handle with each flow event of the flow log file at path. The file is
+// decompressed if its name ends with .gz, as the files rotated by the FileCollector.
+func ReadFlowLog(path string, format string, handle func(event *FlowEvent)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Couldn't open flow log %s: %s", path, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("Couldn't decompress flow log %s: %s", path, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	if err := ReadFlowEvents(reader, format, handle); err != nil {
+		return fmt.Errorf("Couldn't read flow log %s: %s", path, err)
+	}
+	return nil
+}
+
+// ReadFlowEvents calls handle with each flow event read from reader in the given format, as
+// soon as it is read. The container events, the comments and the other measurements are skipped.
+// The events handled before an invalid line are not reverted.
+func ReadFlowEvents(reader io.Reader, format string, handle func(event *FlowEvent)) error {
+	var parse func(line string) (*FlowEvent, error)
+	switch format {
+	case FlowLogFormatJSONL:
+		parse = parseJSONFlowEvent
+	case FlowLogFormatInfluxDB:
+		parse = parseInfluxDBFlowEvent
+	default:
+		return fmt.Errorf("Invalid flow log format %s", format)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxFlowLogLine)
+	if format == FlowLogFormatInfluxDB {
+		// The string fields of the points may contain newlines.
+		scanner.Split(scanPoints)
+	}
+	for number, next := 1, 1; scanner.Scan(); number = next {
+		next = number + 1 + strings.Count(scanner.Text(), "\n")
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		event, err := parse(line)
+		if err != nil {
+			return fmt.Errorf("Invalid line %d: %s", number, err)
+		}
+		if event != nil {
+			handle(event)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseJSONFlowEvent returns the flow event of a line written by the FileCollector, or nil for
+// the other events.
+func parseJSONFlowEvent(line string) (*FlowEvent, error) {
+	event := &FlowEvent{}
+	if err := json.Unmarshal([]byte(line), event); err != nil {
+		return nil, err
+	}
+	if event.Type != "flow" {
+		return nil, nil
+	}
+	return event, nil
+}
+
+// parseInfluxDBFlowEvent returns the flow event of a point written by the InfluxDBCollector,
+// or nil for the other measurements and statements.
+func parseInfluxDBFlowEvent(line string) (*FlowEvent, error) {
+	// The statements of the export, such as CREATE DATABASE, aren't points.
+	if !strings.HasPrefix(line, "FlowEvents,") && !strings.HasPrefix(line, "FlowEvents ") {
+		return nil, nil
+	}
+
+	_, fields, timestamp, err := parseLineProtocol(line)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &FlowEvent{
+		Time:       timestamp,
+		Type:       "flow",
+		ContextID:  fields["ContextID"],
+		Action:     fields["Action"],
+		DropReason: fields["DropReason"],
+		PolicyID:   fields["PolicyID"],
+	}
+	if count, ok := fields["Count"]; ok {
+		if event.Count, err = strconv.Atoi(strings.TrimSuffix(count, "i")); err != nil {
+			return nil, fmt.Errorf("Invalid Count %s", count)
+		}
+	}
+	if encrypted, ok := fields["Encrypted"]; ok {
+		if event.Encrypted, err = strconv.ParseBool(encrypted); err != nil {
+			return nil, fmt.Errorf("Invalid Encrypted %s", encrypted)
+		}
+	}
+	if tags := fields["Tags"]; tags != "" {
+		event.Tags = strings.Split(tags, ",")
+	}
+	if event.Source, err = lineProtocolEndpoint("Source", fields); err != nil {
+		return nil, err
+	}
+	if event.Destination, err = lineProtocolEndpoint("Destination", fields); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// lineProtocolEndpoint returns the flow endpoint of the fields prefixed with prefix, or nil if
+// there is none.
+func lineProtocolEndpoint(prefix string, fields map[string]string) (*EndpointEvent, error) {
+	ip, ok := fields[prefix+"IP"]
+	if !ok {
+		return nil, nil
+	}
+
+	endpoint := &EndpointEvent{
+		ID:   fields[prefix+"ID"],
+		IP:   ip,
+		Type: fields[prefix+"Type"],
+	}
+	if port, ok := fields[prefix+"Port"]; ok {
+		value, err := strconv.ParseUint(strings.TrimSuffix(port, "i"), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %sPort %s", prefix, port)
+		}
+		endpoint.Port = uint16(value)
+	}
+	return endpoint, nil
+}
+
+// parseLineProtocol returns the measurement, the fields and the timestamp of a line protocol
+// point. The string fields are unescaped and the other fields are returned as written.
+func parseLineProtocol(line string) (string, map[string]string, time.Time, error) {
+	// The measurement and the tags end at the first unescaped space.
+	end := 0
+	for end < len(line) && line[end] != ' ' {
+		if line[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	measurement := line[:end]
+	for i := 0; i < end; i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if line[i] == ',' {
+			measurement = line[:i]
+			break
+		}
+	}
+	if end >= len(line) {
+		return measurement, nil, time.Time{}, fmt.Errorf("Missing fields")
+	}
+
+	fields := map[string]string{}
+	i := end + 1
+	for {
+		equal := strings.IndexByte(line[i:], '=')
+		if equal <= 0 {
+			return measurement, nil, time.Time{}, fmt.Errorf("Invalid field at %d", i)
+		}
+		key := line[i : i+equal]
+		i += equal + 1
+
+		if i < len(line) && line[i] == '"' {
+			var value bytes.Buffer
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				value.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return measurement, nil, time.Time{}, fmt.Errorf("Unterminated string field %s", key)
+			}
+			fields[key] = value.String()
+			i++
+		} else {
+			start := i
+			for i < len(line) && line[i] != ',' && line[i] != ' ' {
+				i++
+			}
+			fields[key] = line[start:i]
+		}
+
+		if i >= len(line) || line[i] == ' ' {
+			break
+		}
+		i++
+	}
+
+	var timestamp time.Time
+	if value := strings.TrimSpace(line[i:]); value != "" {
+		nanoseconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return measurement, nil, time.Time{}, fmt.Errorf("Invalid timestamp %s", value)
+		}
+		timestamp = time.Unix(0, nanoseconds).UTC()
+	}
+	return measurement, fields, timestamp, nil
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// readFlowEvents returns all the flow events read by ReadFlowEvents.
+func readFlowEvents(reader io.Reader, format string) ([]*FlowEvent, error) {
+	events := []*FlowEvent{}
+	err := ReadFlowEvents(reader, format, func(event *FlowEvent) { events = append(events, event) })
+	return events, err
+}
+
+func TestReadFlowLogJSONL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowlog")
+	if err != nil {
+		t.Fatalf("Couldn't create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "flows.log")
+	fileCollector, err := NewFileCollector(path, 0, 0, 0, false)
+	if err != nil {
+		t.Fatalf("NewFileCollector failed: %s", err)
+	}
+	fileCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Event: "start"})
+	fileCollector.CollectFlowEvent(rejectedFlow())
+	fileCollector.Stop()
+
+	// The rotated files are compressed.
+	if err := compressFile(path); err != nil {
+		t.Fatalf("Couldn't compress flow log: %s", err)
+	}
+
+	events := []*FlowEvent{}
+	err = ReadFlowLog(path+".gz", FlowLogFormatJSONL, func(event *FlowEvent) { events = append(events, event) })
+	if err != nil {
+		t.Fatalf("ReadFlowLog failed: %s", err)
+	}
+	if len(events) != 1 || events[0].Action != "reject" || events[0].Source.Port != 4242 || events[0].PolicyID != "shop/deny=all" {
+		t.Errorf("Unexpected flow events %+v", events)
+	}
+
+	if _, err := readFlowEvents(strings.NewReader("{\"type\":\"flow\"}\nnot json\n"), FlowLogFormatJSONL); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
+
+func TestReadFlowEventsInfluxDB(t *testing.T) {
+	timestamp := time.Unix(1500000000, 0).UTC()
+	flow := &collector.FlowRecord{
+		ContextID:   "abc",
+		Count:       3,
+		Source:      &collector.EndPoint{ID: "def", IP: "10.0.0.3", Port: 4242, Type: collector.PU},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Tags:        &policy.TagStore{Tags: []string{"app=web", `note=a "quoted" value`}},
+		Action:      policy.Accept | policy.Encrypt,
+	}
+
+	export := strings.Join([]string{
+		"# DDL",
+		"CREATE DATABASE flowDB WITH NAME autogen",
+		"# DML",
+		"# CONTEXT-DATABASE:flowDB",
+		`ContainerEvents,EventName=ContainerEvents,EventType=start ContextID="abc",IPAddress="",Event="start",Tags="" 1500000000000000000`,
+		string(flowPoint(flow, timestamp)),
+	}, "\n")
+
+	events, err := readFlowEvents(strings.NewReader(export), FlowLogFormatInfluxDB)
+	if err != nil {
+		t.Fatalf("ReadFlowEvents failed: %s", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 flow event, got %d", len(events))
+	}
+
+	event := events[0]
+	if !event.Time.Equal(timestamp) || event.ContextID != "abc" || event.Count != 3 || event.Action != "accept" || !event.Encrypted {
+		t.Errorf("Unexpected flow event %+v", event)
+	}
+	if event.Source.IP != "10.0.0.3" || event.Source.Port != 4242 || event.Source.Type != "pu" || event.Destination.Port != 80 {
+		t.Errorf("Unexpected endpoints %+v %+v", event.Source, event.Destination)
+	}
+	if len(event.Tags) != 2 || event.Tags[1] != `note=a "quoted" value` {
+		t.Errorf("Unexpected tags %v", event.Tags)
+	}
+
+	// The string fields may span several lines.
+	rejected := &collector.FlowRecord{ContextID: "abc", Action: policy.Reject, DropReason: "first\nsecond"}
+	export = string(flowPoint(rejected, timestamp)) + "\n" + string(flowPoint(flow, timestamp)) + "\nFlowEvents,EventName=FlowEvents invalid"
+	events, err = readFlowEvents(strings.NewReader(export), FlowLogFormatInfluxDB)
+	if err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("Expected an error on line 4, got %v", err)
+	}
+	events, err = readFlowEvents(strings.NewReader(strings.TrimSuffix(export, "\nFlowEvents,EventName=FlowEvents invalid")), FlowLogFormatInfluxDB)
+	if err != nil || len(events) != 2 || events[0].DropReason != "first\nsecond" || events[1].Count != 3 {
+		t.Errorf("Unexpected flow events %+v %v", events, err)
+	}
+
+	if _, err := readFlowEvents(strings.NewReader(`FlowEvents,EventName=FlowEvents ContextID="abc`), FlowLogFormatInfluxDB); err == nil {
+		t.Errorf("Unterminated string accepted")
+	}
+}
@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"sync"
+
+	"github.com/aporeto-inc/trireme/collector"
+)
+
+// ReloadableCollector is an EventCollector forwarding all the events to a backend
+// collector that can be replaced at runtime.
+type ReloadableCollector struct {
+	collector collector.EventCollector
+	sync.RWMutex
+}
+
+// NewReloadableCollector returns a ReloadableCollector forwarding to the collector given in parameter.
+func NewReloadableCollector(backend collector.EventCollector) *ReloadableCollector {
+	return &ReloadableCollector{
+		collector: backend,
+	}
+}
+
+// CollectFlowEvent forwards the flow event to the current backend.
+func (r *ReloadableCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	r.RLock()
+	defer r.RUnlock()
+	r.collector.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent forwards the container event to the current backend.
+func (r *ReloadableCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	r.RLock()
+	defer r.RUnlock()
+	r.collector.CollectContainerEvent(record)
+}
+
+// Swap replaces the current backend. The previous backend is stopped if it supports it.
+func (r *ReloadableCollector) Swap(backend collector.EventCollector) {
+	r.Lock()
+	previous := r.collector
+	r.collector = backend
+	r.Unlock()
+
+	if stoppable, ok := previous.(interface {
+		Stop()
+	}); ok {
+		stoppable.Stop()
+	}
+	logger().Info("Collector backend replaced")
+}
@@ -0,0 +1,218 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// spillFileName is the name of the InfluxDB spill file in the spill directory.
+const spillFileName = "influxdb.spill"
+
+// spillFiles are the opened spill files by path. A spill file is shared by the collectors
+// using the same directory, as the previous collector is only stopped after its replacement
+// is created on a reload.
+var spillFiles = struct {
+	files map[string]*spillFile
+	sync.Mutex
+}{
+	files: map[string]*spillFile{},
+}
+
+// spillFile is a file of line protocol points, one per line. The points are appended at the
+// end and read from offset. The file is truncated once all the points are read. The offset is
+// only kept in memory: after a restart, the points already sent are sent again. They keep their
+// timestamp, so InfluxDB overwrites them instead of storing duplicates.
+type spillFile struct {
+	path       string
+	maxSize    int64
+	file       *os.File
+	size       int64
+	offset     int64
+	references int
+	sync.Mutex
+}
+
+// acquireSpillFile returns the spill file of dir, opening it if needed. The points left
+// by a previous run are kept. A zero maxSize doesn't bound the file.
+func acquireSpillFile(dir string, maxSize int64) (*spillFile, error) {
+	path := filepath.Join(dir, spillFileName)
+
+	spillFiles.Lock()
+	defer spillFiles.Unlock()
+
+	if s, ok := spillFiles.files[path]; ok {
+		s.Lock()
+		s.references++
+		s.maxSize = maxSize
+		s.Unlock()
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Couldn't create spill directory %s: %s", dir, err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open spill file %s: %s", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Couldn't stat spill file %s: %s", path, err)
+	}
+
+	// A crash can leave a partial point. It is removed, as an unbalanced quote would make all
+	// the points appended after it part of a string field.
+	size, err := completePointsSize(io.NewSectionReader(file, 0, info.Size()))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Couldn't read spill file %s: %s", path, err)
+	}
+	if size < info.Size() {
+		logger().Warn("Removing partial point from spill file", zap.String("path", path), zap.Int64("bytes", info.Size()-size))
+		if err := file.Truncate(size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Couldn't truncate spill file %s: %s", path, err)
+		}
+	}
+
+	s := &spillFile{
+		path:       path,
+		maxSize:    maxSize,
+		file:       file,
+		size:       size,
+		references: 1,
+	}
+	spillFiles.files[path] = s
+	return s, nil
+}
+
+// release closes the file once it is not used anymore.
+func (s *spillFile) release() {
+	spillFiles.Lock()
+	defer spillFiles.Unlock()
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.references--
+	if s.references > 0 {
+		return
+	}
+	delete(spillFiles.files, s.path)
+	if err := s.file.Close(); err != nil {
+		logger().Warn("Couldn't close spill file", zap.String("path", s.path), zap.Error(err))
+	}
+}
+
+// write appends the lines that fit in the file and returns their number.
+func (s *spillFile) write(lines [][]byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	data := []byte{}
+	written := 0
+	for _, line := range lines {
+		if s.maxSize > 0 && s.size+int64(len(data)+len(line)+1) > s.maxSize {
+			break
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+		written++
+	}
+	if written == 0 {
+		return 0, nil
+	}
+
+	if _, err := s.file.Write(data); err != nil {
+		// Remove the partial line so that the next points are not appended to it.
+		if truncateErr := s.file.Truncate(s.size); truncateErr != nil {
+			logger().Warn("Couldn't truncate spill file", zap.String("path", s.path), zap.Error(truncateErr))
+		}
+		return 0, err
+	}
+	s.size += int64(len(data))
+	return written, nil
+}
+
+// read returns up to max lines from the offset, and the offset following them. A line is
+// a complete point, which may span several lines of the file.
+func (s *spillFile) read(max int) ([][]byte, int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	scanner := bufio.NewScanner(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	scanner.Buffer(make([]byte, 64*1024), maxFlowLogLine)
+	next := s.offset
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		// A point without its newline is left.
+		end := pointEnd(data)
+		if end < 0 {
+			return 0, nil, nil
+		}
+		next += int64(end + 1)
+		return end + 1, data[:end], nil
+	})
+
+	lines := [][]byte{}
+	for len(lines) < max && scanner.Scan() {
+		if line := scanner.Bytes(); len(line) > 0 {
+			lines = append(lines, append([]byte{}, line...))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return lines, next, nil
+}
+
+// commit marks the lines before next as sent and truncates the file if none are left.
+func (s *spillFile) commit(next int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.offset = next
+	if s.offset < s.size {
+		return nil
+	}
+
+	s.offset = 0
+	s.size = 0
+	return s.file.Truncate(0)
+}
+
+// completePointsSize returns the size of the complete points at the start of data, up to
+// the newline of the last one. It follows the quotes of the string fields like pointEnd.
+func completePointsSize(data io.Reader) (int64, error) {
+	reader := bufio.NewReader(data)
+	size := int64(0)
+	quoted := false
+	escaped := false
+	for position := int64(1); ; position++ {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == '\n' && !quoted:
+			size = position
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package collector
+
+import (
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	"go.uber.org/zap"
+)
+
+// Syslog formats of the SyslogCollector.
+const (
+	SyslogFormatRFC5424 = "rfc5424"
+	SyslogFormatCEF     = "cef"
+)
+
+// SyslogEventReject is the event name of the rejected flows in the severity mapping.
+const SyslogEventReject = "reject"
+
+const (
+	// syslogFacility is the local0 facility.
+	syslogFacility = 16
+	// syslogStructuredDataID identifies the structured data of the messages. 32473 is the
+	// private enterprise number reserved for documentation.
+	syslogStructuredDataID = "trireme@32473"
+	// syslogTimeout bounds the connection and the writes.
+	syslogTimeout = 5 * time.Second
+	// syslogNone disables the events in the severity mapping.
+	syslogNone = -1
+)
+
+// syslogSeverities are the names of the syslog severities.
+var syslogSeverities = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"warning": 4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+	"none":    syslogNone,
+}
+
+// cefSeverities maps the syslog severities to the CEF severities.
+var cefSeverities = []int{10, 9, 8, 7, 5, 3, 2, 1}
+
+// DefaultSyslogSeverities are the severities of the security events. The other events are
+// not sent.
+var DefaultSyslogSeverities = map[string]int{
+	SyslogEventReject:        4,
+	"quarantine":             3,
+	"quarantineended":        5,
+	"enforcementbypass":      4,
+	"enforcementbypassended": 5,
+	"breakglass":             4,
+	"breakglassactivated":    2,
+	"breakglassdeactivated":  5,
+	"start":                  6,
+	"stop":                   6,
+}
+
+// syslogMetrics are the syslog counters published through expvar on the management server.
+var syslogMetrics = expvar.NewMap("trireme_syslog")
+
+// SyslogCollector is an EventCollector sending the rejected flows and the security related
+// container events as RFC5424 syslog messages over UDP, TCP or TLS. The message is either a
+// plain text description or a CEF record. The connection is opened on the first event and
+// reopened after an error. The events are dropped while the syslog server is unreachable.
+type SyslogCollector struct {
+	network    string
+	address    string
+	tlsConfig  *tls.Config
+	format     string
+	hostname   string
+	severities map[string]int
+	conn       net.Conn
+	failing    bool
+	sync.Mutex
+}
+
+// NewSyslogCollector returns a SyslogCollector sending to address over network, which is udp,
+// tcp or tls. The hostname identifies the node in the messages and the severities give the
+// severity of each event name, as returned by ParseSyslogSeverities.
+func NewSyslogCollector(network, address string, tlsConfig *tls.Config, format string, hostname string, severities map[string]int) (*SyslogCollector, error) {
+	logger().Info("Using syslog collector", zap.String("network", network), zap.String("address", address), zap.String("format", format))
+
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("Invalid syslog network %s", network)
+	}
+	switch format {
+	case SyslogFormatRFC5424, SyslogFormatCEF:
+	default:
+		return nil, fmt.Errorf("Invalid syslog format %s", format)
+	}
+
+	return &SyslogCollector{
+		network:    network,
+		address:    address,
+		tlsConfig:  tlsConfig,
+		format:     format,
+		hostname:   hostname,
+		severities: severities,
+	}, nil
+}
+
+// ParseSyslogSeverities returns the DefaultSyslogSeverities overridden by the event=severity
+// comma separated list. The severity is a syslog severity name or number, or none to
+// disable the event.
+func ParseSyslogSeverities(mapping string) (map[string]int, error) {
+	severities := map[string]int{}
+	for event, severity := range DefaultSyslogSeverities {
+		severities[event] = severity
+	}
+
+	for _, entry := range strings.Split(mapping, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid syslog severity %s: expected event=severity", entry)
+		}
+
+		severity, ok := syslogSeverities[parts[1]]
+		if !ok {
+			number, err := strconv.Atoi(parts[1])
+			if err != nil || number < 0 || number > 7 {
+				return nil, fmt.Errorf("Invalid syslog severity %s for event %s", parts[1], parts[0])
+			}
+			severity = number
+		}
+		severities[parts[0]] = severity
+	}
+	return severities, nil
+}
+
+// CollectFlowEvent sends the flow if it was rejected.
+func (s *SyslogCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	if record.Action&policy.Reject == 0 {
+		return
+	}
+	severity, ok := s.severity(SyslogEventReject)
+	if !ok {
+		return
+	}
+
+	fields := []syslogField{
+		{"action", "act", "reject"},
+		{"contextID", "cs1", record.ContextID},
+		{"policyID", "cs2", record.PolicyID},
+		{"dropReason", "reason", record.DropReason},
+		{"tags", "cs3", strings.Join(tagSlice(record.Tags), " ")},
+	}
+	if record.Source != nil {
+		fields = append(fields, syslogField{"srcIP", "src", record.Source.IP}, syslogField{"srcPort", "spt", strconv.Itoa(int(record.Source.Port))})
+	}
+	if record.Destination != nil {
+		fields = append(fields, syslogField{"dstIP", "dst", record.Destination.IP}, syslogField{"dstPort", "dpt", strconv.Itoa(int(record.Destination.Port))})
+	}
+
+	s.send(SyslogEventReject, severity, "Flow rejected", fields)
+}
+
+// CollectContainerEvent sends the container event if it has a severity.
+func (s *SyslogCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	severity, ok := s.severity(record.Event)
+	if !ok {
+		return
+	}
+
+	fields := []syslogField{
+		{"contextID", "cs1", record.ContextID},
+		{"ipAddress", "src", record.IPAddress},
+		{"tags", "cs3", strings.Join(tagSlice(record.Tags), " ")},
+	}
+
+	s.send(record.Event, severity, "Container event "+record.Event, fields)
+}
+
+// Stop closes the connection.
+func (s *SyslogCollector) Stop() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// syslogField is a field of the message, with its structured data and CEF keys.
+type syslogField struct {
+	key    string
+	cefKey string
+	value  string
+}
+
+// severity returns the severity of the event and false if the event isn't sent.
+func (s *SyslogCollector) severity(event string) (int, bool) {
+	severity, ok := s.severities[event]
+	if !ok || severity == syslogNone {
+		return 0, false
+	}
+	return severity, true
+}
+
+// send formats and writes the message, reconnecting if needed.
+func (s *SyslogCollector) send(event string, severity int, description string, fields []syslogField) {
+	message := s.message(time.Now(), event, severity, description, fields)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.write(message); err != nil {
+		if s.conn != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+		syslogMetrics.Add("dropped", 1)
+		if !s.failing {
+			logger().Error("Couldn't send syslog message. Dropping events", zap.String("address", s.address), zap.Error(err))
+			s.failing = true
+		}
+		return
+	}
+
+	syslogMetrics.Add("sent", 1)
+	if s.failing {
+		logger().Info("Syslog server reachable again", zap.String("address", s.address))
+		s.failing = false
+	}
+}
+
+// write writes the message, opening the connection if needed. The messages are framed with
+// their length over TCP and TLS. Must be called with the lock held.
+func (s *SyslogCollector) write(message string) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	if s.network != "udp" {
+		message = strconv.Itoa(len(message)) + " " + message
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout)); err != nil {
+		return err
+	}
+	_, err := s.conn.Write([]byte(message))
+	return err
+}
+
+// Check verifies that the syslog server is reachable by opening a connection. Over UDP, only the
+// address is verified as there is no connection.
+func (s *SyslogCollector) Check() error {
+	conn, err := s.dial()
+	if err != nil {
+		return fmt.Errorf("Couldn't reach syslog server %s: %s", s.address, err)
+	}
+	return conn.Close()
+}
+
+// dial opens the connection to the syslog server.
+func (s *SyslogCollector) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	if s.network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", s.address, s.tlsConfig)
+	}
+	return dialer.Dial(s.network, s.address)
+}
+
+// message returns the RFC5424 message. The fields are given as structured data with a
+// plain text description, or as the extension of a CEF record.
+func (s *SyslogCollector) message(timestamp time.Time, event string, severity int, description string, fields []syslogField) string {
+	header := fmt.Sprintf("<%d>1 %s %s trireme %d %s",
+		syslogFacility*8+severity,
+		timestamp.UTC().Format(time.RFC3339Nano),
+		syslogHeaderValue(s.hostname),
+		os.Getpid(),
+		syslogHeaderValue(event),
+	)
+
+	if s.format == SyslogFormatCEF {
+		return header + " - " + cefRecord(event, severity, description, fields)
+	}
+
+	data := []string{}
+	for _, field := range fields {
+		if field.value != "" {
+			data = append(data, field.key+`="`+escapeParameter(field.value)+`"`)
+		}
+	}
+	sort.Strings(data)
+	return header + " [" + syslogStructuredDataID + " " + strings.Join(data, " ") + "] " + description
+}
+
+// cefRecord returns the CEF record of the event.
+func cefRecord(event string, severity int, description string, fields []syslogField) string {
+	extension := []string{}
+	labels := map[string]string{"cs1": "contextID", "cs2": "policyID", "cs3": "tags"}
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		extension = append(extension, field.cefKey+"="+escapeCEFExtension(field.value))
+		if label, ok := labels[field.cefKey]; ok {
+			extension = append(extension, field.cefKey+"Label="+label)
+		}
+	}
+
+	return strings.Join([]string{
+		"CEF:0",
+		"Aporeto",
+		"Trireme",
+		"2.0",
+		escapeCEFHeader(event),
+		escapeCEFHeader(description),
+		strconv.Itoa(cefSeverities[severity]),
+		strings.Join(extension, " "),
+	}, "|")
+}
+
+// syslogHeaderValue returns the value, or the nil value if empty, without spaces.
+func syslogHeaderValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Replace(value, " ", "_", -1)
+}
+
+// escapeParameter escapes a structured data parameter value.
+func escapeParameter(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// escapeCEFHeader escapes a CEF header field.
+func escapeCEFHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`).Replace(value)
+}
+
+// escapeCEFExtension escapes a CEF extension value.
+func escapeCEFExtension(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
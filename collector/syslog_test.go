@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+func rejectedFlow() *collector.FlowRecord {
+	return &collector.FlowRecord{
+		ContextID:   "abc",
+		Count:       1,
+		Source:      &collector.EndPoint{IP: "10.0.0.1", Port: 4242, Type: collector.Address},
+		Destination: &collector.EndPoint{ID: "abc", IP: "10.0.0.2", Port: 80, Type: collector.PU},
+		Action:      policy.Reject,
+		PolicyID:    "shop/deny=all",
+	}
+}
+
+func TestSyslogCollectorUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	defer listener.Close()
+
+	severities, err := ParseSyslogSeverities("start=none,quarantine=crit")
+	if err != nil {
+		t.Fatalf("ParseSyslogSeverities failed: %s", err)
+	}
+	syslogCollector, err := NewSyslogCollector("udp", listener.LocalAddr().String(), nil, SyslogFormatRFC5424, "node-1", severities)
+	if err != nil {
+		t.Fatalf("NewSyslogCollector failed: %s", err)
+	}
+	defer syslogCollector.Stop()
+
+	// Only the rejected flows and the events with a severity are sent.
+	syslogCollector.CollectFlowEvent(&collector.FlowRecord{ContextID: "abc", Action: policy.Accept})
+	syslogCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", Event: "start"})
+	syslogCollector.CollectFlowEvent(rejectedFlow())
+	syslogCollector.CollectContainerEvent(&collector.ContainerRecord{ContextID: "abc", IPAddress: "10.0.0.2", Event: "quarantine"})
+
+	messages := []string{}
+	buffer := make([]byte, 2048)
+	for i := 0; i < 2; i++ {
+		if err := listener.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatalf("Couldn't set deadline: %s", err)
+		}
+		n, _, err := listener.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Couldn't read message: %s", err)
+		}
+		messages = append(messages, string(buffer[:n]))
+	}
+
+	if !strings.HasPrefix(messages[0], "<132>1 ") ||
+		!strings.Contains(messages[0], " node-1 trireme ") ||
+		!strings.Contains(messages[0], ` reject [trireme@32473 `) ||
+		!strings.Contains(messages[0], `dstPort="80"`) ||
+		!strings.HasSuffix(messages[0], "] Flow rejected") {
+		t.Errorf("Unexpected flow message %s", messages[0])
+	}
+	if !strings.HasPrefix(messages[1], "<130>1 ") || !strings.Contains(messages[1], ` quarantine [trireme@32473 `) {
+		t.Errorf("Unexpected container message %s", messages[1])
+	}
+}
+
+func TestSyslogCollectorTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	defer listener.Close()
+
+	syslogCollector, err := NewSyslogCollector("tcp", listener.Addr().String(), nil, SyslogFormatCEF, "node-1", DefaultSyslogSeverities)
+	if err != nil {
+		t.Fatalf("NewSyslogCollector failed: %s", err)
+	}
+	defer syslogCollector.Stop()
+
+	syslogCollector.CollectFlowEvent(rejectedFlow())
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Couldn't accept: %s", err)
+	}
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("Couldn't set deadline: %s", err)
+	}
+
+	// The messages are framed with their length.
+	reader := bufio.NewReader(conn)
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		t.Fatalf("Couldn't read length: %s", err)
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		t.Fatalf("Invalid length %s", length)
+	}
+	message := make([]byte, size)
+	if _, err := reader.Read(message); err != nil {
+		t.Fatalf("Couldn't read message: %s", err)
+	}
+
+	expected := "CEF:0|Aporeto|Trireme|2.0|reject|Flow rejected|5|act=reject cs1=abc cs1Label=contextID cs2=shop/deny\\=all cs2Label=policyID src=10.0.0.1 spt=4242 dst=10.0.0.2 dpt=80"
+	if !strings.HasSuffix(string(message), " - "+expected) {
+		t.Errorf("Unexpected message %s", message)
+	}
+}
+
+func TestSyslogCollectorCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	address := listener.Addr().String()
+
+	syslogCollector, err := NewSyslogCollector("tcp", address, nil, SyslogFormatRFC5424, "node-1", DefaultSyslogSeverities)
+	if err != nil {
+		t.Fatalf("NewSyslogCollector failed: %s", err)
+	}
+	if err := syslogCollector.Check(); err != nil {
+		t.Errorf("Check failed: %s", err)
+	}
+
+	listener.Close()
+	if err := syslogCollector.Check(); err == nil {
+		t.Errorf("Check succeeded without syslog server")
+	}
+}
+
+func TestParseSyslogSeverities(t *testing.T) {
+	if _, err := ParseSyslogSeverities("reject=loud"); err == nil {
+		t.Errorf("Invalid severity accepted")
+	}
+	if _, err := ParseSyslogSeverities("reject"); err == nil {
+		t.Errorf("Invalid mapping accepted")
+	}
+
+	severities, err := ParseSyslogSeverities("reject=2, custom=7")
+	if err != nil {
+		t.Fatalf("ParseSyslogSeverities failed: %s", err)
+	}
+	if severities["reject"] != 2 || severities["custom"] != 7 || severities["quarantine"] != DefaultSyslogSeverities["quarantine"] {
+		t.Errorf("Unexpected severities %v", severities)
+	}
+}
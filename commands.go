@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme-kubernetes/collector"
+	"github.com/aporeto-inc/trireme-kubernetes/config"
+	"github.com/aporeto-inc/trireme-kubernetes/kubernetes"
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+	"github.com/aporeto-inc/trireme-kubernetes/version"
+
+	"github.com/ghodss/yaml"
+)
+
+// runCommand runs the commands that don't launch Trireme and returns their exit code.
+// false is returned if the command is the daemon or the enforcer.
+func runCommand(currentConfig *config.Configuration) (int, bool) {
+	switch currentConfig.Command {
+	case config.VersionCommand:
+		return printVersion(currentConfig.Output), true
+	case config.ValidateConfigCommand:
+		return validateConfig(currentConfig), true
+	case config.RecommendPoliciesCommand:
+		return recommendPolicies(currentConfig), true
+	}
+	return 0, false
+}
+
+// printVersion prints the version and build information as text or JSON.
+func printVersion(output string) int {
+	info := version.BuildInfo()
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(info); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding version: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Printf("Version:    %s\n", info.Version)
+	fmt.Printf("Revision:   %s\n", info.Revision)
+	fmt.Printf("Go version: %s\n", info.GoVersion)
+	fmt.Printf("Compiler:   %s\n", info.Compiler)
+	fmt.Printf("Platform:   %s\n", info.Platform)
+	return 0
+}
+
+// validationReport is the JSON output of the validate-config command.
+type validationReport struct {
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
+// validateConfig validates the configuration as well as the connectivity to the Kubernetes API
+// and to the collectors. Every problem found is reported.
+func validateConfig(currentConfig *config.Configuration) int {
+	problems := []string{}
+	for _, err := range config.ValidateConfig(currentConfig) {
+		problems = append(problems, err.Error())
+	}
+	for _, err := range checkConnectivity(currentConfig) {
+		problems = append(problems, err.Error())
+	}
+
+	report := validationReport{
+		Valid:    len(problems) == 0,
+		Problems: problems,
+	}
+
+	if currentConfig.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding report: %s\n", err)
+		}
+	} else if report.Valid {
+		fmt.Println("Configuration is valid")
+	} else {
+		fmt.Printf("Configuration has %d problem(s):\n", len(problems))
+		for _, problem := range problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+	}
+
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
+
+// checkConnectivity verifies that the Kubernetes API and the collectors are reachable.
+func checkConnectivity(currentConfig *config.Configuration) []error {
+	errs := []error{}
+
+	client, err := kubernetes.NewClient(currentConfig.KubeconfigPath, currentConfig.KubeNodeName)
+	if err != nil {
+		errs = append(errs, err)
+	} else if _, err := client.ServerVersion(); err != nil {
+		errs = append(errs, err)
+	} else {
+		if currentConfig.KubeNodeName != "" {
+			if _, err := client.Node(currentConfig.KubeNodeName); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		if currentConfig.ConfigMapName != "" {
+			configMap, err := client.ConfigMap(currentConfig.ConfigMapName, currentConfig.ConfigMapNamespace)
+			if err != nil {
+				errs = append(errs, err)
+			} else if err := config.ValidateConfigMap(configMap.Data); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if currentConfig.CollectorEndpoint != "" {
+		if err := collector.CheckInfluxDBEndpoint(currentConfig.CollectorEndpoint, currentConfig.CollectorInsecureSkipVerify); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if currentConfig.CollectorOTLPEndpoint != "" {
+		if err := collector.CheckOTLPEndpoint(currentConfig.CollectorOTLPEndpoint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if currentConfig.CollectorSyslogAddress != "" {
+		syslogCollector, err := newSyslogCollector(currentConfig)
+		if err != nil {
+			errs = append(errs, err)
+		} else if err := syslogCollector.Check(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// recommendPolicies prints as YAML the NetworkPolicies allowing the accepted flows of the flow
+// logs to the current pods. The flows rejected by the current NetworkPolicies and the flows that
+// couldn't be allowed are reported on stderr.
+func recommendPolicies(currentConfig *config.Configuration) int {
+	client, err := kubernetes.NewClient(currentConfig.KubeconfigPath, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to Kubernetes: %s\n", err)
+		return 1
+	}
+	pods, err := client.AllPods()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing pods: %s\n", err)
+		return 1
+	}
+	namespaces, err := client.AllNamespaces()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing namespaces: %s\n", err)
+		return 1
+	}
+	networkPolicies, err := client.AllNetworkPolicies()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing NetworkPolicies: %s\n", err)
+		return 1
+	}
+
+	// The flows are streamed into the recommender as the flow logs can be large.
+	recommender := resolver.NewRecommender(pods, namespaces, networkPolicies, currentConfig.EgressNetPolicies, time.Now())
+	flows := 0
+	for _, path := range strings.Split(currentConfig.RecommendFlowLogs, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		err := collector.ReadFlowLog(path, currentConfig.RecommendFlowLogFormat, func(flow *collector.FlowEvent) {
+			recommender.Add(flow)
+			flows++
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading flows: %s\n", err)
+			return 1
+		}
+	}
+	recommendation := recommender.Result()
+
+	for _, networkPolicy := range recommendation.NetworkPolicies {
+		data, err := yaml.Marshal(networkPolicy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding NetworkPolicy %s: %s\n", networkPolicy.GetName(), err)
+			return 1
+		}
+		fmt.Printf("---\n%s", data)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d flow(s) read, %d ignored, %d NetworkPolicies recommended\n", flows, recommendation.Ignored, len(recommendation.NetworkPolicies))
+	for _, warning := range recommendation.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s. Its flows aren't allowed\n", warning)
+	}
+	if currentConfig.EgressNetPolicies {
+		fmt.Fprintf(os.Stderr, "Warning: the egress rules only allow the observed TCP flows. DNS and the other UDP flows must be allowed separately\n")
+	}
+	if len(recommendation.Blocked) > 0 {
+		fmt.Fprintf(os.Stderr, "%d observed flow(s) would be rejected by the current NetworkPolicies:\n", len(recommendation.Blocked))
+		for _, blocked := range recommendation.Blocked {
+			fmt.Fprintf(os.Stderr, "  - %s -> %s port %d (%d flows)\n", blocked.Source, blocked.Destination, blocked.Port, blocked.Count)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "The rejected flows are estimated from the ingress rules of the NetworkPolicies and the default postures only. Not evaluated: %s\n", strings.Join(resolver.RecommendationLimits, "; "))
+	return 0
+}
@@ -3,17 +3,37 @@ package config
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 
 	flag "github.com/spf13/pflag"
+
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // DefaultKubeConfigLocation is the default location of the KubeConfig file.
 const DefaultKubeConfigLocation = "/.kube/config"
 
+// Commands are given as the first positional argument.
+const (
+	// DaemonCommand runs the Trireme-Kubernetes daemon. This is the default.
+	DaemonCommand = "daemon"
+	// EnforceCommand runs the remote enforcer (spawned into POD namespaces).
+	EnforceCommand = "enforce"
+	// VersionCommand prints the version and build information.
+	VersionCommand = "version"
+	// ValidateConfigCommand validates the configuration and the connectivity to external services.
+	ValidateConfigCommand = "validate-config"
+	// RecommendPoliciesCommand prints the NetworkPolicies allowing the flows of flow logs.
+	RecommendPoliciesCommand = "recommend-policies"
+)
+
+var commands = []string{DaemonCommand, EnforceCommand, VersionCommand, ValidateConfigCommand, RecommendPoliciesCommand}
+
 // Configuration contains all the User Parameter for Trireme-Kubernetes.
 type Configuration struct {
 	// AuthType defines if Trireme uses PSK or PKI
@@ -38,11 +58,59 @@ type Configuration struct {
 	TriremeNetworks       string
 	ParsedTriremeNetworks []string
 
+	// NamespaceInclude and NamespaceExclude are space separated lists of namespaces
+	// that are policed or not. NamespaceIncludeSelector and NamespaceExcludeSelector are
+	// label selectors matching the namespaces that are policed or not.
+	NamespaceInclude         string
+	NamespaceExclude         string
+	NamespaceIncludeSelector string
+	NamespaceExcludeSelector string
+	// EnforceKubeSystem defines if the kube-system namespace is policed. If not set, kube-system
+	// is policed with the GA NetworkPolicies and not with the beta NetworkPolicies.
+	EnforceKubeSystem bool
+	// EnforcementOptOutNamespaces is a space separated list of namespaces in which pods
+	// can disable enforcement with the trireme.io/enforcement annotation. * allows all namespaces.
+	EnforcementOptOutNamespaces string
+	// QuarantineForensicNamespace is the namespace still allowed to communicate with
+	// the pods quarantined with the trireme.io/quarantine label.
+	QuarantineForensicNamespace string
+	// ClusterNetworkPolicies enables the ClusterNetworkPolicy CRD support.
+	ClusterNetworkPolicies bool
+
+	// AutoAllowDNS allows every egress isolated pod to reach the cluster DNS on port 53.
+	// The DNS pods are selected by DNSSelector in DNSNamespace, and the IPs of the
+	// DNSServiceName service are allowed.
+	AutoAllowDNS   bool
+	DNSSelector    string
+	DNSNamespace   string
+	DNSServiceName string
+
+	// FQDNPolicies enables the FQDNs allowed by the trireme.io/egress-fqdns NetworkPolicy
+	// annotation. They are resolved through FQDNResolver (host:port, system DNS if empty)
+	// and refreshed according to their TTL bounded by FQDNMinTTL and FQDNMaxTTL.
+	FQDNPolicies bool
+	FQDNResolver string
+	FQDNMinTTL   time.Duration
+	FQDNMaxTTL   time.Duration
+
+	// ServicePolicies enables the services allowed by the trireme.io/egress-services
+	// NetworkPolicy annotation.
+	ServicePolicies bool
+
+	// StrictHTTPRules removes the ingress rules restricted by the trireme.io/http-rules
+	// NetworkPolicy annotation instead of enforcing them at L4 only.
+	StrictHTTPRules bool
+
 	KubeconfigPath string
 
 	LogFormat string
 	LogLevel  string
 
+	// ManagementAddress is the address serving the management endpoints (log levels...).
+	// The management server is disabled if empty. As the enforcer runs on the host network, it
+	// should listen on localhost unless the metrics are scraped from other nodes.
+	ManagementAddress string
+
 	// Credentials info for InfluxDB Collector interface
 	CollectorEndpoint           string
 	CollectorUser               string
@@ -50,11 +118,104 @@ type Configuration struct {
 	CollectorDB                 string
 	CollectorInsecureSkipVerify bool
 
+	// CollectorBufferSize is the number of points buffered in memory while InfluxDB is unreachable.
+	// The points beyond are written to a file in CollectorSpillDir, up to CollectorSpillMaxSize
+	// megabytes, and dropped if CollectorSpillDir is empty.
+	CollectorBufferSize   int
+	CollectorSpillDir     string
+	CollectorSpillMaxSize int
+
+	// CollectorFile is the path of the JSON Lines flow log. The file is rotated when it exceeds
+	// CollectorFileMaxSize megabytes or is older than CollectorFileMaxAge. The rotated files are
+	// gzipped if CollectorFileCompress is true and only the last CollectorFileMaxBackups are kept.
+	CollectorFile           string
+	CollectorFileMaxSize    int
+	CollectorFileMaxAge     time.Duration
+	CollectorFileMaxBackups int
+	CollectorFileCompress   bool
+
+	// CollectorSyslogAddress is the syslog server receiving the rejected flows and the security
+	// events over CollectorSyslogNetwork (udp, tcp or tls). The messages are formatted as
+	// CollectorSyslogFormat (rfc5424 or cef) with the severities overridden by the
+	// CollectorSyslogSeverities event=severity list. The TLS server certificate is verified with
+	// the CollectorSyslogCA file, or the system CAs if empty.
+	CollectorSyslogAddress            string
+	CollectorSyslogNetwork            string
+	CollectorSyslogFormat             string
+	CollectorSyslogSeverities         string
+	CollectorSyslogCA                 string
+	CollectorSyslogInsecureSkipVerify bool
+
+	// CollectorOTLPEndpoint is the OpenTelemetry receiver of the flow and container event logs
+	// and their counts, exported every CollectorOTLPInterval over OTLP/HTTP. The endpoint is the
+	// base URL of the receiver. CollectorOTLPClusterName identifies the cluster in the resource
+	// attributes.
+	CollectorOTLPEndpoint    string
+	CollectorOTLPInterval    time.Duration
+	CollectorOTLPClusterName string
+
+	// CollectorAggregationWindow rolls up the flows by source, destination, port and verdict
+	// over the window before sending them to the collectors. Disabled if zero.
+	// CollectorSampleRate is the fraction of the accepted flows kept. The rejected flows are
+	// always kept.
+	CollectorAggregationWindow time.Duration
+	CollectorSampleRate        float64
+
+	// CollectorMetadata adds the Kubernetes metadata of the pods and NetworkPolicies to the tags
+	// of the events sent to the collectors.
+	CollectorMetadata bool
+
+	// CollectorFilterNamespaceInclude and CollectorFilterNamespaceExclude are the space separated
+	// namespaces of the pods whose events are sent to the collectors, or not. The flows are
+	// further filtered by CollectorFilterVerdicts, space separated accept or reject, and by the
+	// labels of their source and destination pods with CollectorFilterSourceSelector and
+	// CollectorFilterDestinationSelector. The namespaces and labels require CollectorMetadata.
+	CollectorFilterNamespaceInclude    string
+	CollectorFilterNamespaceExclude    string
+	CollectorFilterVerdicts            string
+	CollectorFilterSourceSelector      string
+	CollectorFilterDestinationSelector string
+
+	// CollectorQueueSize is the number of events queued for each collector backend. The events
+	// are dropped when a backend queue is full.
+	CollectorQueueSize int
+
+	// PrometheusMetrics exposes the flows and container events as Prometheus counters on the
+	// /metrics management endpoint. The number of flow series is bounded by PrometheusMaxSeries
+	// and the destination port label is only set if PrometheusPortLabel is true.
+	PrometheusMetrics   bool
+	PrometheusMaxSeries int
+	PrometheusPortLabel bool
+
+	// AuditMode defines if the computed policies are only logged and not enforced.
+	AuditMode bool
+
+	// ConfigFile is an optional YAML or JSON configuration file.
+	ConfigFile string
+	// ConfigMapName and ConfigMapNamespace define an optional ConfigMap that is
+	// watched for configuration changes.
+	ConfigMapName      string
+	ConfigMapNamespace string
+	// BreakGlassConfigMapName is the ConfigMap in ConfigMapNamespace watched for the
+	// cluster-wide break-glass switch. Disabled if empty.
+	BreakGlassConfigMapName string
+
 	// Enforce defines if this process is an enforcer process (spawned into POD namespaces)
 	Enforce bool `mapstructure:"Enforce"`
+
+	// Command is the command to run.
+	Command string
+	// Output is the output format of the version and validate-config commands: text or json.
+	Output string
+
+	// RecommendFlowLogs is the comma separated list of flow logs read by the recommend-policies
+	// command, in the RecommendFlowLogFormat: jsonl or influxdb.
+	RecommendFlowLogs      string
+	RecommendFlowLogFormat string
 }
 
 func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [%s] [flags]\n", os.Args[0], strings.Join(commands, "|"))
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -62,7 +223,8 @@ func usage() {
 // LoadConfig loads a Configuration struct:
 // 1) If presents flags are used
 // 2) If no flags, Env Variables are used
-// 3) If no Env Variables, defaults are used when possible.
+// 3) If no Env Variables, the configuration file is used if given
+// 4) If no configuration file, defaults are used when possible.
 func LoadConfig() (*Configuration, error) {
 	flag.Usage = usage
 	flag.String("AuthType", "", "Authentication type: PKI/PSK")
@@ -73,15 +235,71 @@ func LoadConfig() (*Configuration, error) {
 	flag.Bool("BetaNetPolicies", false, "Use old deprecated Beta Network policy model (default: use GA).")
 	flag.Bool("EgressNetPolicies", true, "Use new Egress Network policy model (default: use Egress).")
 	flag.String("TriremeNetworks", "", "TriremeNetworks")
+	flag.String("NamespaceInclude", "", "Space separated list of namespaces to police. Default to all")
+	flag.String("NamespaceExclude", "", "Space separated list of namespaces not to police")
+	flag.String("NamespaceIncludeSelector", "", "Label selector of the namespaces to police. Default to all")
+	flag.String("NamespaceExcludeSelector", "", "Label selector of the namespaces not to police")
+	flag.Bool("EnforceKubeSystem", false, "Police the kube-system namespace. Default to true with GA NetworkPolicies, false with beta NetworkPolicies")
+	flag.String("EnforcementOptOutNamespaces", "", "Space separated list of namespaces in which pods can disable enforcement by annotation")
+	flag.String("QuarantineForensicNamespace", "", "Namespace allowed to communicate with quarantined pods")
+	flag.Bool("ClusterNetworkPolicies", false, "Enforce the ClusterNetworkPolicy CRD. The CRD must be installed.")
+	flag.Bool("AutoAllowDNS", false, "Allow every egress isolated pod to reach the cluster DNS.")
+	flag.String("DNSSelector", "", "Label selector of the cluster DNS pods. Default to k8s-app=kube-dns")
+	flag.String("DNSNamespace", "", "Namespace of the cluster DNS. Default to kube-system")
+	flag.String("DNSServiceName", "", "Service of the cluster DNS. Default to kube-dns")
+	flag.Bool("FQDNPolicies", false, "Allow the FQDNs given in the trireme.io/egress-fqdns NetworkPolicy annotation.")
+	flag.String("FQDNResolver", "", "DNS server (host:port) used to resolve the FQDNs. Default to the system one")
+	flag.Duration("FQDNMinTTL", 0, "Minimum refresh interval of the FQDNs. Default to 5s")
+	flag.Duration("FQDNMaxTTL", 0, "Maximum refresh interval of the FQDNs. Default to 5m")
+	flag.Bool("ServicePolicies", false, "Allow the services given in the trireme.io/egress-services NetworkPolicy annotation.")
+	flag.Bool("StrictHTTPRules", false, "Deny the ingress rules restricted by HTTP rules, which cannot be enforced.")
 	flag.String("KubeconfigPath", "", "KubeConfig used to connect to Kubernetes")
 	flag.String("LogLevel", "", "Log level. Default to info (trace//debug//info//warn//error//fatal)")
 	flag.String("LogFormat", "", "Log Format. Default to human")
+	flag.String("ManagementAddress", "", "Listen address for the management endpoints. Disabled if empty")
 	flag.String("CollectorEndpoint", "", "Endpoint for InfluxDB customer collector")
 	flag.String("CollectorUser", "", "User info for InfluxDB")
 	flag.String("CollectorPass", "", "Pass for InfluxDB")
 	flag.String("CollectorDB", "", "DB for InfluxDB")
 	flag.Bool("CollectorInsecureSkipVerify", false, "InsecureSkipVerify for InfluxDB")
+	flag.Int("CollectorBufferSize", 0, "Number of points buffered in memory while InfluxDB is unreachable. Default to 100000")
+	flag.String("CollectorSpillDir", "", "Directory of the file buffering the points beyond CollectorBufferSize. Disabled if empty")
+	flag.Int("CollectorSpillMaxSize", 0, "Size in megabytes of the InfluxDB spill file. Default to 100")
+	flag.String("CollectorFile", "", "Path of the JSON Lines flow log. Disabled if empty")
+	flag.Int("CollectorFileMaxSize", 0, "Size in megabytes after which the flow log is rotated. Default to 100")
+	flag.Duration("CollectorFileMaxAge", 0, "Age after which the flow log is rotated. Default to 24h")
+	flag.Int("CollectorFileMaxBackups", 0, "Number of rotated flow logs kept. Default to 7")
+	flag.Bool("CollectorFileCompress", true, "Compress the rotated flow logs")
+	flag.String("CollectorSyslogAddress", "", "Syslog server receiving the security events. Disabled if empty")
+	flag.String("CollectorSyslogNetwork", "", "Syslog transport: udp, tcp or tls. Default to udp")
+	flag.String("CollectorSyslogFormat", "", "Syslog message format: rfc5424 or cef. Default to rfc5424")
+	flag.String("CollectorSyslogSeverities", "", "Comma separated event=severity list overriding the default syslog severities")
+	flag.String("CollectorSyslogCA", "", "CA file verifying the syslog TLS server. Default to the system CAs")
+	flag.Bool("CollectorSyslogInsecureSkipVerify", false, "InsecureSkipVerify for the syslog TLS server")
+	flag.String("CollectorOTLPEndpoint", "", "OTLP/HTTP base URL of the receiver of the flow logs and metrics. Disabled if empty")
+	flag.Duration("CollectorOTLPInterval", 0, "Interval between the OTLP exports. Default to 10s")
+	flag.String("CollectorOTLPClusterName", "", "Cluster name set in the OTLP resource attributes")
+	flag.Duration("CollectorAggregationWindow", 0, "Window over which the flows are aggregated. Disabled if zero")
+	flag.Float64("CollectorSampleRate", 1, "Fraction of the accepted flows sent to the collectors")
+	flag.Bool("CollectorMetadata", true, "Add the Kubernetes metadata to the tags of the collected events")
+	flag.String("CollectorFilterNamespaceInclude", "", "Space separated namespaces whose events are collected. Default to all")
+	flag.String("CollectorFilterNamespaceExclude", "", "Space separated namespaces whose events aren't collected")
+	flag.String("CollectorFilterVerdicts", "", "Space separated verdicts of the collected flows: accept or reject. Default to all")
+	flag.String("CollectorFilterSourceSelector", "", "Label selector on the source pods of the collected flows")
+	flag.String("CollectorFilterDestinationSelector", "", "Label selector on the destination pods of the collected flows")
+	flag.Int("CollectorQueueSize", 0, "Number of events queued for each collector backend. Default to 10000")
+	flag.Bool("PrometheusMetrics", false, "Expose the flows as Prometheus metrics on the management server")
+	flag.Int("PrometheusMaxSeries", 0, "Maximum number of Prometheus flow series. Default to 10000")
+	flag.Bool("PrometheusPortLabel", true, "Use the destination port as a Prometheus label")
+	flag.Bool("AuditMode", false, "Only log the computed policies without enforcing them.")
+	flag.String("ConfigFile", "", "Optional YAML or JSON configuration file")
+	flag.String("ConfigMapName", "", "Optional ConfigMap watched for configuration changes")
+	flag.String("ConfigMapNamespace", "", "Namespace of the watched ConfigMap. Default to kube-system")
+	flag.String("BreakGlassConfigMapName", "", "ConfigMap watched for the break-glass switch. Default to trireme-breakglass")
 	flag.Bool("Enforce", false, "Run Trireme-Kubernetes in Enforce mode.")
+	flag.String("Output", "", "Output format for the version and validate-config commands: text or json. Default to text")
+	flag.String("RecommendFlowLogs", "", "Comma separated flow logs read by the recommend-policies command")
+	flag.String("RecommendFlowLogFormat", "", "Format of the flow logs read by the recommend-policies command: jsonl or influxdb. Default to jsonl")
 
 	// Setting up default configuration
 	viper.SetDefault("AuthType", "PSK")
@@ -92,15 +310,70 @@ func LoadConfig() (*Configuration, error) {
 	viper.SetDefault("BetaNetPolicies", false)
 	viper.SetDefault("EgressNetPolicies", true)
 	viper.SetDefault("TriremeNetworks", "")
+	viper.SetDefault("NamespaceInclude", "")
+	viper.SetDefault("NamespaceExclude", "")
+	viper.SetDefault("NamespaceIncludeSelector", "")
+	viper.SetDefault("NamespaceExcludeSelector", "")
+	viper.SetDefault("EnforcementOptOutNamespaces", "")
+	viper.SetDefault("QuarantineForensicNamespace", "")
+	viper.SetDefault("ClusterNetworkPolicies", false)
+	viper.SetDefault("AutoAllowDNS", false)
+	viper.SetDefault("DNSSelector", "k8s-app=kube-dns")
+	viper.SetDefault("DNSNamespace", "kube-system")
+	viper.SetDefault("DNSServiceName", "kube-dns")
+	viper.SetDefault("FQDNPolicies", false)
+	viper.SetDefault("FQDNResolver", "")
+	viper.SetDefault("FQDNMinTTL", 5*time.Second)
+	viper.SetDefault("FQDNMaxTTL", 5*time.Minute)
+	viper.SetDefault("ServicePolicies", false)
+	viper.SetDefault("StrictHTTPRules", false)
 	viper.SetDefault("KubeconfigPath", "")
 	viper.SetDefault("LogLevel", "info")
 	viper.SetDefault("LogFormat", "human")
+	viper.SetDefault("ManagementAddress", "")
 	viper.SetDefault("CollectorEndpoint", "")
 	viper.SetDefault("CollectorUser", "")
 	viper.SetDefault("CollectorPass", "")
 	viper.SetDefault("CollectorDB", "")
 	viper.SetDefault("CollectorInsecureSkipVerify", "")
+	viper.SetDefault("CollectorBufferSize", 100000)
+	viper.SetDefault("CollectorSpillDir", "")
+	viper.SetDefault("CollectorSpillMaxSize", 100)
+	viper.SetDefault("CollectorFile", "")
+	viper.SetDefault("CollectorFileMaxSize", 100)
+	viper.SetDefault("CollectorFileMaxAge", 24*time.Hour)
+	viper.SetDefault("CollectorFileMaxBackups", 7)
+	viper.SetDefault("CollectorFileCompress", true)
+	viper.SetDefault("CollectorSyslogAddress", "")
+	viper.SetDefault("CollectorSyslogNetwork", "udp")
+	viper.SetDefault("CollectorSyslogFormat", "rfc5424")
+	viper.SetDefault("CollectorSyslogSeverities", "")
+	viper.SetDefault("CollectorSyslogCA", "")
+	viper.SetDefault("CollectorSyslogInsecureSkipVerify", false)
+	viper.SetDefault("CollectorOTLPEndpoint", "")
+	viper.SetDefault("CollectorOTLPInterval", 10*time.Second)
+	viper.SetDefault("CollectorOTLPClusterName", "")
+	viper.SetDefault("CollectorAggregationWindow", 0)
+	viper.SetDefault("CollectorSampleRate", 1)
+	viper.SetDefault("CollectorMetadata", true)
+	viper.SetDefault("CollectorFilterNamespaceInclude", "")
+	viper.SetDefault("CollectorFilterNamespaceExclude", "")
+	viper.SetDefault("CollectorFilterVerdicts", "")
+	viper.SetDefault("CollectorFilterSourceSelector", "")
+	viper.SetDefault("CollectorFilterDestinationSelector", "")
+	viper.SetDefault("CollectorQueueSize", 10000)
+	viper.SetDefault("PrometheusMetrics", false)
+	viper.SetDefault("PrometheusMaxSeries", 10000)
+	viper.SetDefault("PrometheusPortLabel", true)
+	viper.SetDefault("AuditMode", false)
+	viper.SetDefault("ConfigFile", "")
+	viper.SetDefault("ConfigMapName", "")
+	viper.SetDefault("ConfigMapNamespace", "kube-system")
+	viper.SetDefault("BreakGlassConfigMapName", "trireme-breakglass")
 	viper.SetDefault("Enforce", false)
+	viper.SetDefault("Output", "text")
+	viper.SetDefault("RecommendFlowLogs", "")
+	viper.SetDefault("RecommendFlowLogFormat", "jsonl")
 
 	// Binding ENV variables
 	// Each config will be of format TRIREME_XYZ as env variable, where XYZ
@@ -114,19 +387,42 @@ func LoadConfig() (*Configuration, error) {
 
 	var config Configuration
 
-	// Manual check for Enforce mode as this is given as a simple argument
-	if len(os.Args) > 1 {
-		if os.Args[1] == "enforce" {
-			config.Enforce = true
-			config.LogLevel = viper.GetString("LogLevel")
-			return &config, nil
+	command, err := parseCommand(flag.Args())
+	if err != nil {
+		return nil, err
+	}
+	config.Command = command
+
+	switch config.Command {
+	case EnforceCommand:
+		config.Enforce = true
+		config.LogLevel = viper.GetString("LogLevel")
+		return &config, nil
+	case VersionCommand:
+		config.Output = viper.GetString("Output")
+		return &config, nil
+	case RecommendPoliciesCommand:
+		return recommendConfig(&config)
+	}
+
+	// Loading the optional configuration file. Unknown keys are rejected.
+	// The validate-config command reports a wrong configuration file along with all the other problems.
+	if configFile := viper.GetString("ConfigFile"); configFile != "" {
+		err := readConfigFile(configFile)
+		if err != nil && config.Command != ValidateConfigCommand {
+			return nil, err
 		}
 	}
 
-	err := viper.Unmarshal(&config)
+	err = viper.Unmarshal(&config)
 	if err != nil {
 		return nil, fmt.Errorf("Error unmarshalling:%s", err)
 	}
+	defaultEnforceKubeSystem(&config)
+
+	if config.Command == ValidateConfigCommand {
+		return &config, nil
+	}
 
 	err = validateConfig(&config)
 	if err != nil {
@@ -136,8 +432,87 @@ func LoadConfig() (*Configuration, error) {
 	return &config, nil
 }
 
+// parseCommand returns the command given as the first positional argument, or the daemon
+// command if there is none.
+func parseCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return DaemonCommand, nil
+	}
+
+	for _, command := range commands {
+		if args[0] == command {
+			return command, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown command %s. Should be one of %s", args[0], strings.Join(commands, ", "))
+}
+
+// defaultEnforceKubeSystem sets EnforceKubeSystem if it was not given. Every namespace,
+// kube-system included, is activated with the GA NetworkPolicies while kube-system is never
+// activated with the beta NetworkPolicies.
+func defaultEnforceKubeSystem(config *Configuration) {
+	if !viper.IsSet("EnforceKubeSystem") {
+		config.EnforceKubeSystem = !config.BetaNetPolicies
+	}
+}
+
+// recommendConfig loads the settings of the recommend-policies command.
+func recommendConfig(config *Configuration) (*Configuration, error) {
+	config.KubeconfigPath = viper.GetString("KubeconfigPath")
+	config.RecommendFlowLogs = viper.GetString("RecommendFlowLogs")
+	config.RecommendFlowLogFormat = viper.GetString("RecommendFlowLogFormat")
+
+	if config.RecommendFlowLogs == "" {
+		return nil, fmt.Errorf("RecommendFlowLogs is required by the %s command", RecommendPoliciesCommand)
+	}
+	if config.RecommendFlowLogFormat != "jsonl" && config.RecommendFlowLogFormat != "influxdb" {
+		return nil, fmt.Errorf("RecommendFlowLogFormat should be jsonl or influxdb")
+	}
+
+	// In case not running as InCluster, we try to infer a possible KubeConfig location
+	if os.Getenv("KUBERNETES_PORT") == "" && config.KubeconfigPath == "" {
+		config.KubeconfigPath = os.Getenv("HOME") + DefaultKubeConfigLocation
+	}
+	return config, nil
+}
+
+// readConfigFile validates and loads the configuration file into Viper.
+func readConfigFile(configFile string) error {
+	if _, err := parseConfigFile(configFile); err != nil {
+		return err
+	}
+	viper.SetConfigFile(configFile)
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Error reading config file %s: %s", configFile, err)
+	}
+	return nil
+}
+
 // validateConfig is validating the Configuration struct.
 func validateConfig(config *Configuration) error {
+	errs := ValidateConfig(config)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Errorf("%s", strings.Join(messages, "; "))
+}
+
+// ValidateConfig is validating the Configuration struct and returns all the problems found.
+func ValidateConfig(config *Configuration) []error {
+	errs := []error{}
+
+	// Validating the configuration file
+	if config.ConfigFile != "" {
+		if _, err := parseConfigFile(config.ConfigFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	// Validating KUBECONFIG
 	// In case not running as InCluster, we try to infer a possible KubeConfig location
 	if os.Getenv("KUBERNETES_PORT") == "" {
@@ -150,26 +525,105 @@ func validateConfig(config *Configuration) error {
 
 	// Validating KUBE NODENAME
 	if !config.Enforce && config.KubeNodeName == "" {
-		return fmt.Errorf("Couldn't load NodeName. Ensure Kubernetes Nodename is given as a parameter")
+		errs = append(errs, fmt.Errorf("Couldn't load NodeName. Ensure Kubernetes Nodename is given as a parameter"))
 	}
 
 	// Validating AUTHTYPE
 	if config.AuthType != "PSK" && config.AuthType != "PKI" {
-		return fmt.Errorf("AuthType should be PSK or PKI")
+		errs = append(errs, fmt.Errorf("AuthType should be PSK or PKI"))
 	}
 
 	// Validating PSK
 	if config.AuthType == "PSK" && config.PSK == "" {
-		return fmt.Errorf("PSK should be provided")
+		errs = append(errs, fmt.Errorf("PSK should be provided"))
 	}
 
 	parsedTriremeNetworks, err := parseTriremeNets(config.TriremeNetworks)
 	if err != nil {
-		return fmt.Errorf("TargetNetwork is invalid: %s", err)
+		errs = append(errs, fmt.Errorf("TargetNetwork is invalid: %s", err))
 	}
 	config.ParsedTriremeNetworks = parsedTriremeNetworks
 
-	return nil
+	// Validating the namespace selectors
+	if _, err := labels.Parse(config.NamespaceIncludeSelector); err != nil {
+		errs = append(errs, fmt.Errorf("NamespaceIncludeSelector is invalid: %s", err))
+	}
+	if _, err := labels.Parse(config.NamespaceExcludeSelector); err != nil {
+		errs = append(errs, fmt.Errorf("NamespaceExcludeSelector is invalid: %s", err))
+	}
+	if _, err := labels.Parse(config.DNSSelector); err != nil {
+		errs = append(errs, fmt.Errorf("DNSSelector is invalid: %s", err))
+	}
+	if config.FQDNMinTTL > config.FQDNMaxTTL {
+		errs = append(errs, fmt.Errorf("FQDNMinTTL %s is greater than FQDNMaxTTL %s", config.FQDNMinTTL, config.FQDNMaxTTL))
+	}
+
+	// Validating the flow log rotation
+	if config.CollectorFileMaxSize < 0 || config.CollectorFileMaxAge < 0 || config.CollectorFileMaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("CollectorFile rotation settings cannot be negative"))
+	}
+	if config.CollectorBufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("CollectorBufferSize should be positive"))
+	}
+	if config.CollectorSpillMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("CollectorSpillMaxSize cannot be negative"))
+	}
+	if config.CollectorSyslogNetwork != "udp" && config.CollectorSyslogNetwork != "tcp" && config.CollectorSyslogNetwork != "tls" {
+		errs = append(errs, fmt.Errorf("CollectorSyslogNetwork should be udp, tcp or tls"))
+	}
+	if config.CollectorSyslogFormat != "rfc5424" && config.CollectorSyslogFormat != "cef" {
+		errs = append(errs, fmt.Errorf("CollectorSyslogFormat should be rfc5424 or cef"))
+	}
+	if config.CollectorOTLPEndpoint != "" {
+		if endpoint, err := url.Parse(config.CollectorOTLPEndpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			errs = append(errs, fmt.Errorf("CollectorOTLPEndpoint should be an http or https URL"))
+		}
+	}
+	if config.CollectorOTLPInterval <= 0 {
+		errs = append(errs, fmt.Errorf("CollectorOTLPInterval should be positive"))
+	}
+	if config.CollectorAggregationWindow < 0 {
+		errs = append(errs, fmt.Errorf("CollectorAggregationWindow cannot be negative"))
+	}
+	if config.CollectorSampleRate <= 0 || config.CollectorSampleRate > 1 {
+		errs = append(errs, fmt.Errorf("CollectorSampleRate should be greater than 0 and at most 1"))
+	}
+	if config.CollectorQueueSize <= 0 {
+		errs = append(errs, fmt.Errorf("CollectorQueueSize should be positive"))
+	}
+
+	// Validating the collector filter
+	for _, verdict := range strings.Fields(config.CollectorFilterVerdicts) {
+		if verdict != "accept" && verdict != "reject" {
+			errs = append(errs, fmt.Errorf("CollectorFilterVerdicts should be accept or reject, got %s", verdict))
+		}
+	}
+	if _, err := labels.Parse(config.CollectorFilterSourceSelector); err != nil {
+		errs = append(errs, fmt.Errorf("CollectorFilterSourceSelector is invalid: %s", err))
+	}
+	if _, err := labels.Parse(config.CollectorFilterDestinationSelector); err != nil {
+		errs = append(errs, fmt.Errorf("CollectorFilterDestinationSelector is invalid: %s", err))
+	}
+	if !config.CollectorMetadata && (config.CollectorFilterNamespaceInclude != "" || config.CollectorFilterNamespaceExclude != "" ||
+		config.CollectorFilterSourceSelector != "" || config.CollectorFilterDestinationSelector != "") {
+		errs = append(errs, fmt.Errorf("CollectorFilter namespaces and selectors require CollectorMetadata"))
+	}
+
+	// Validating the Prometheus metrics
+	if config.PrometheusMetrics && config.ManagementAddress == "" {
+		errs = append(errs, fmt.Errorf("PrometheusMetrics requires a ManagementAddress"))
+	}
+	if config.PrometheusMaxSeries <= 0 {
+		errs = append(errs, fmt.Errorf("PrometheusMaxSeries should be positive"))
+	}
+
+	return errs
+}
+
+// ValidateConfigMap returns an error if the ConfigMap data contains unknown keys.
+func ValidateConfigMap(data map[string]string) error {
+	_, err := parseConfigMap(data)
+	return err
 }
 
 // parseTriremeNets returns a parsed array of strings parsed based on white spaces between CIDR entries.
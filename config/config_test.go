@@ -0,0 +1,134 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var parseCommandTests = []struct {
+	args    []string
+	command string
+	valid   bool
+}{
+	{[]string{}, DaemonCommand, true},
+	{[]string{"daemon"}, DaemonCommand, true},
+	{[]string{"enforce"}, EnforceCommand, true},
+	{[]string{"validate-config", "extra"}, ValidateConfigCommand, true},
+	{[]string{"recommend-policies"}, RecommendPoliciesCommand, true},
+	{[]string{"deamon"}, "", false},
+	{[]string{"--version"}, "", false},
+	{[]string{""}, "", false},
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, tt := range parseCommandTests {
+		command, err := parseCommand(tt.args)
+		if tt.valid && (err != nil || command != tt.command) {
+			t.Errorf("parseCommand(%q) => %q, %v, Should be %q", tt.args, command, err, tt.command)
+		}
+		if !tt.valid && (err == nil || !strings.Contains(err.Error(), "Unknown command")) {
+			t.Errorf("parseCommand(%q) => %q, %v, Should be an unknown command error", tt.args, command, err)
+		}
+	}
+}
+
+// validConfig returns a Configuration with the defaults of LoadConfig that passes the validation.
+func validConfig() *Configuration {
+	return &Configuration{
+		AuthType:               "PSK",
+		PSK:                    "secret",
+		KubeNodeName:           "node-1",
+		TriremeNetworks:        "10.0.0.0/8 192.168.0.0/16",
+		FQDNMinTTL:             5 * time.Second,
+		FQDNMaxTTL:             time.Hour,
+		CollectorBufferSize:    10000,
+		CollectorSyslogNetwork: "udp",
+		CollectorSyslogFormat:  "rfc5424",
+		CollectorOTLPInterval:  10 * time.Second,
+		CollectorSampleRate:    1,
+		CollectorQueueSize:     10000,
+		PrometheusMaxSeries:    10000,
+	}
+}
+
+var validateConfigTests = []struct {
+	name   string
+	update func(*Configuration)
+	errors []string
+}{
+	{"valid", func(c *Configuration) {}, nil},
+	{"PKI", func(c *Configuration) { c.AuthType, c.PSK = "PKI", "" }, nil},
+	{"OTLP endpoint", func(c *Configuration) { c.CollectorOTLPEndpoint = "https://otel-collector:4318" }, nil},
+	{"collector filter", func(c *Configuration) {
+		c.CollectorMetadata, c.CollectorFilterVerdicts, c.CollectorFilterSourceSelector = true, "accept reject", "app=web"
+	}, nil},
+	{"missing node name", func(c *Configuration) { c.KubeNodeName = "" }, []string{"NodeName"}},
+	{"remote enforcer without node name", func(c *Configuration) { c.Enforce, c.KubeNodeName = true, "" }, nil},
+	{"auth type", func(c *Configuration) { c.AuthType = "TLS" }, []string{"AuthType"}},
+	{"missing PSK", func(c *Configuration) { c.PSK = "" }, []string{"PSK should be provided"}},
+	{"networks", func(c *Configuration) { c.TriremeNetworks = "10.0.0.0/8 10.0.0.1" }, []string{"TargetNetwork"}},
+	{"selectors", func(c *Configuration) {
+		c.NamespaceIncludeSelector, c.NamespaceExcludeSelector, c.DNSSelector = "a in (", "b!", "=c"
+	}, []string{"NamespaceIncludeSelector", "NamespaceExcludeSelector", "DNSSelector"}},
+	{"FQDN TTLs", func(c *Configuration) { c.FQDNMinTTL = 2 * time.Hour }, []string{"FQDNMinTTL"}},
+	{"flow log rotation", func(c *Configuration) { c.CollectorFileMaxBackups = -1 }, []string{"CollectorFile"}},
+	{"collector sizes", func(c *Configuration) {
+		c.CollectorBufferSize, c.CollectorSpillMaxSize, c.CollectorQueueSize = 0, -1, 0
+	}, []string{"CollectorBufferSize", "CollectorSpillMaxSize", "CollectorQueueSize"}},
+	{"syslog", func(c *Configuration) {
+		c.CollectorSyslogNetwork, c.CollectorSyslogFormat = "http", "json"
+	}, []string{"CollectorSyslogNetwork", "CollectorSyslogFormat"}},
+	{"OTLP", func(c *Configuration) {
+		c.CollectorOTLPEndpoint, c.CollectorOTLPInterval = "otel-collector:4317", 0
+	}, []string{"CollectorOTLPEndpoint", "CollectorOTLPInterval"}},
+	{"aggregation and sampling", func(c *Configuration) {
+		c.CollectorAggregationWindow, c.CollectorSampleRate = -time.Second, 1.5
+	}, []string{"CollectorAggregationWindow", "CollectorSampleRate"}},
+	{"collector filter without metadata", func(c *Configuration) {
+		c.CollectorFilterVerdicts, c.CollectorFilterNamespaceInclude = "drop", "shop"
+	}, []string{"CollectorFilterVerdicts", "require CollectorMetadata"}},
+	{"Prometheus", func(c *Configuration) {
+		c.PrometheusMetrics, c.PrometheusMaxSeries = true, 0
+	}, []string{"ManagementAddress", "PrometheusMaxSeries"}},
+	{"missing config file", func(c *Configuration) { c.ConfigFile = "/nonexistent/trireme.yaml" }, []string{"trireme.yaml"}},
+}
+
+func TestValidateConfig(t *testing.T) {
+	for _, tt := range validateConfigTests {
+		config := validConfig()
+		tt.update(config)
+
+		errs := ValidateConfig(config)
+		if len(errs) != len(tt.errors) {
+			t.Errorf("ValidateConfig(%s) => %v, Should return %d errors", tt.name, errs, len(tt.errors))
+			continue
+		}
+		for i, expected := range tt.errors {
+			if !strings.Contains(errs[i].Error(), expected) {
+				t.Errorf("ValidateConfig(%s) => %q, Should mention %q", tt.name, errs[i], expected)
+			}
+		}
+	}
+}
+
+func TestValidateConfigAllProblems(t *testing.T) {
+	config := validConfig()
+	for _, tt := range validateConfigTests {
+		tt.update(config)
+	}
+
+	errs := ValidateConfig(config)
+	err := validateConfig(config)
+	if err == nil {
+		t.Fatalf("validateConfig => no error, Should report all the problems")
+	}
+	if len(errs) < 10 {
+		t.Errorf("ValidateConfig => %d errors, Should return all the problems at once", len(errs))
+	}
+	for _, e := range errs {
+		if !strings.Contains(err.Error(), e.Error()) {
+			t.Errorf("validateConfig => %q, Should contain %q", err, e)
+		}
+	}
+}
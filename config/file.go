@@ -0,0 +1,244 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configKeys is the schema of the configuration file and ConfigMap. Each key
+// is mapped to true if the setting can be reloaded without restarting the agent.
+var configKeys = map[string]bool{
+	"AuthType":                           false,
+	"KubeNodeName":                       false,
+	"PSK":                                false,
+	"RemoteEnforcer":                     false,
+	"BetaNetPolicies":                    false,
+	"EgressNetPolicies":                  false,
+	"TriremeNetworks":                    false,
+	"NamespaceInclude":                   false,
+	"NamespaceExclude":                   false,
+	"NamespaceIncludeSelector":           false,
+	"NamespaceExcludeSelector":           false,
+	"EnforceKubeSystem":                  false,
+	"EnforcementOptOutNamespaces":        false,
+	"QuarantineForensicNamespace":        false,
+	"ClusterNetworkPolicies":             false,
+	"AutoAllowDNS":                       false,
+	"DNSSelector":                        false,
+	"DNSNamespace":                       false,
+	"DNSServiceName":                     false,
+	"FQDNPolicies":                       false,
+	"FQDNResolver":                       false,
+	"FQDNMinTTL":                         false,
+	"FQDNMaxTTL":                         false,
+	"ServicePolicies":                    false,
+	"StrictHTTPRules":                    false,
+	"KubeconfigPath":                     false,
+	"LogFormat":                          false,
+	"LogLevel":                           true,
+	"ManagementAddress":                  false,
+	"CollectorEndpoint":                  true,
+	"CollectorUser":                      true,
+	"CollectorPass":                      true,
+	"CollectorDB":                        true,
+	"CollectorInsecureSkipVerify":        true,
+	"CollectorBufferSize":                true,
+	"CollectorSpillDir":                  true,
+	"CollectorSpillMaxSize":              true,
+	"CollectorFile":                      true,
+	"CollectorFileMaxSize":               true,
+	"CollectorFileMaxAge":                true,
+	"CollectorFileMaxBackups":            true,
+	"CollectorFileCompress":              true,
+	"CollectorSyslogAddress":             true,
+	"CollectorSyslogNetwork":             true,
+	"CollectorSyslogFormat":              true,
+	"CollectorSyslogSeverities":          true,
+	"CollectorSyslogCA":                  true,
+	"CollectorSyslogInsecureSkipVerify":  true,
+	"CollectorOTLPEndpoint":              true,
+	"CollectorOTLPInterval":              true,
+	"CollectorOTLPClusterName":           true,
+	"CollectorAggregationWindow":         false,
+	"CollectorSampleRate":                false,
+	"CollectorMetadata":                  false,
+	"CollectorFilterNamespaceInclude":    true,
+	"CollectorFilterNamespaceExclude":    true,
+	"CollectorFilterVerdicts":            true,
+	"CollectorFilterSourceSelector":      true,
+	"CollectorFilterDestinationSelector": true,
+	"CollectorQueueSize":                 true,
+	"PrometheusMetrics":                  false,
+	"PrometheusMaxSeries":                false,
+	"PrometheusPortLabel":                false,
+	"AuditMode":                          true,
+	"ConfigMapName":                      false,
+	"ConfigMapNamespace":                 false,
+	"BreakGlassConfigMapName":            false,
+}
+
+// configMapKeys maps the keys used in the trireme-config ConfigMap to the
+// configuration keys.
+var configMapKeys = map[string]string{
+	"trireme.auth_type":                             "AuthType",
+	"trireme.remote_enforcer":                       "RemoteEnforcer",
+	"trireme.beta_net_policies":                     "BetaNetPolicies",
+	"trireme.egress_net_policies":                   "EgressNetPolicies",
+	"trireme.trireme_networks":                      "TriremeNetworks",
+	"trireme.namespace_include":                     "NamespaceInclude",
+	"trireme.namespace_exclude":                     "NamespaceExclude",
+	"trireme.namespace_include_selector":            "NamespaceIncludeSelector",
+	"trireme.namespace_exclude_selector":            "NamespaceExcludeSelector",
+	"trireme.enforce_kube_system":                   "EnforceKubeSystem",
+	"trireme.enforcement_opt_out_namespaces":        "EnforcementOptOutNamespaces",
+	"trireme.quarantine_forensic_namespace":         "QuarantineForensicNamespace",
+	"trireme.cluster_network_policies":              "ClusterNetworkPolicies",
+	"trireme.auto_allow_dns":                        "AutoAllowDNS",
+	"trireme.dns_selector":                          "DNSSelector",
+	"trireme.dns_namespace":                         "DNSNamespace",
+	"trireme.dns_service_name":                      "DNSServiceName",
+	"trireme.fqdn_policies":                         "FQDNPolicies",
+	"trireme.fqdn_resolver":                         "FQDNResolver",
+	"trireme.fqdn_min_ttl":                          "FQDNMinTTL",
+	"trireme.fqdn_max_ttl":                          "FQDNMaxTTL",
+	"trireme.service_policies":                      "ServicePolicies",
+	"trireme.strict_http_rules":                     "StrictHTTPRules",
+	"trireme.log_format":                            "LogFormat",
+	"trireme.log_level":                             "LogLevel",
+	"trireme.collector_endpoint":                    "CollectorEndpoint",
+	"trireme.collector_user":                        "CollectorUser",
+	"trireme.collector_password":                    "CollectorPass",
+	"trireme.collector_db":                          "CollectorDB",
+	"trireme.collector_insecure_skip_verify":        "CollectorInsecureSkipVerify",
+	"trireme.collector_buffer_size":                 "CollectorBufferSize",
+	"trireme.collector_spill_dir":                   "CollectorSpillDir",
+	"trireme.collector_spill_max_size":              "CollectorSpillMaxSize",
+	"trireme.collector_file":                        "CollectorFile",
+	"trireme.collector_file_max_size":               "CollectorFileMaxSize",
+	"trireme.collector_file_max_age":                "CollectorFileMaxAge",
+	"trireme.collector_file_max_backups":            "CollectorFileMaxBackups",
+	"trireme.collector_file_compress":               "CollectorFileCompress",
+	"trireme.collector_syslog_address":              "CollectorSyslogAddress",
+	"trireme.collector_syslog_network":              "CollectorSyslogNetwork",
+	"trireme.collector_syslog_format":               "CollectorSyslogFormat",
+	"trireme.collector_syslog_severities":           "CollectorSyslogSeverities",
+	"trireme.collector_syslog_ca":                   "CollectorSyslogCA",
+	"trireme.collector_syslog_insecure_skip_verify": "CollectorSyslogInsecureSkipVerify",
+	"trireme.collector_otlp_endpoint":               "CollectorOTLPEndpoint",
+	"trireme.collector_otlp_interval":               "CollectorOTLPInterval",
+	"trireme.collector_otlp_cluster_name":           "CollectorOTLPClusterName",
+	"trireme.collector_aggregation_window":          "CollectorAggregationWindow",
+	"trireme.collector_sample_rate":                 "CollectorSampleRate",
+	"trireme.collector_metadata":                    "CollectorMetadata",
+	"trireme.collector_filter_namespace_include":    "CollectorFilterNamespaceInclude",
+	"trireme.collector_filter_namespace_exclude":    "CollectorFilterNamespaceExclude",
+	"trireme.collector_filter_verdicts":             "CollectorFilterVerdicts",
+	"trireme.collector_filter_source_selector":      "CollectorFilterSourceSelector",
+	"trireme.collector_filter_destination_selector": "CollectorFilterDestinationSelector",
+	"trireme.collector_queue_size":                  "CollectorQueueSize",
+	"trireme.prometheus_metrics":                    "PrometheusMetrics",
+	"trireme.prometheus_max_series":                 "PrometheusMaxSeries",
+	"trireme.prometheus_port_label":                 "PrometheusPortLabel",
+	"trireme.audit_mode":                            "AuditMode",
+}
+
+// ignoredConfigMapPrefixes are ConfigMap keys used by the other services of
+// the bundle (Trireme-CSR, Grafana setup). They are not relevant for the enforcer.
+var ignoredConfigMapPrefixes = []string{
+	"trireme.signing_ca_cert",
+	"trireme.grafana_",
+}
+
+// parseConfigFile reads a YAML or JSON configuration file and returns its
+// settings. An error is returned if any key is not part of the schema.
+func parseConfigFile(path string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read config file %s: %s", path, err)
+	}
+
+	settings := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &settings)
+	case ".json":
+		err = json.Unmarshal(content, &settings)
+	default:
+		return nil, fmt.Errorf("Unsupported config file format %s: use yaml or json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse config file %s: %s", path, err)
+	}
+
+	if err := validateKeys(settings); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err)
+	}
+	return settings, nil
+}
+
+// parseConfigMap translates the data of the trireme-config ConfigMap into
+// configuration settings. An error is returned for any unknown trireme key.
+func parseConfigMap(data map[string]string) (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	unknownKeys := []string{}
+
+	for key, value := range data {
+		if isIgnoredConfigMapKey(key) {
+			continue
+		}
+		configKey, ok := configMapKeys[key]
+		if !ok {
+			unknownKeys = append(unknownKeys, key)
+			continue
+		}
+		settings[configKey] = value
+	}
+
+	if len(unknownKeys) > 0 {
+		sort.Strings(unknownKeys)
+		return nil, fmt.Errorf("Unknown ConfigMap keys: %s", strings.Join(unknownKeys, ", "))
+	}
+	return settings, nil
+}
+
+func isIgnoredConfigMapKey(key string) bool {
+	for _, prefix := range ignoredConfigMapPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateKeys returns an error listing all the keys that are not part of the schema.
+// Keys are matched case insensitively as Viper does.
+func validateKeys(settings map[string]interface{}) error {
+	unknownKeys := []string{}
+	for key := range settings {
+		if _, ok := schemaKey(key); !ok {
+			unknownKeys = append(unknownKeys, key)
+		}
+	}
+
+	if len(unknownKeys) > 0 {
+		sort.Strings(unknownKeys)
+		return fmt.Errorf("Unknown keys: %s", strings.Join(unknownKeys, ", "))
+	}
+	return nil
+}
+
+// schemaKey returns the canonical schema key for the key given in parameter.
+func schemaKey(key string) (string, bool) {
+	for configKey := range configKeys {
+		if strings.EqualFold(configKey, key) {
+			return configKey, true
+		}
+	}
+	return "", false
+}
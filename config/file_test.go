@@ -0,0 +1,83 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var configFileTests = []struct {
+	name    string
+	content string
+	valid   bool
+}{
+	{"config.yaml", "LogLevel: debug\nCollectorEndpoint: http://influxdb:8086\n", true},
+	{"config.yml", "loglevel: debug\n", true},
+	{"config.json", `{"AuditMode": true, "TriremeNetworks": "10.0.0.0/8"}`, true},
+	{"config.yaml", "LogLevel: debug\nUnknownKey: value\n", false},
+	{"config.json", `{"LogLevl": "debug"}`, false},
+	{"config.toml", "LogLevel = \"debug\"\n", false},
+	{"config.yaml", "LogLevel: [debug\n", false},
+}
+
+func TestParseConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trireme-config")
+	if err != nil {
+		t.Fatalf("Couldn't create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range configFileTests {
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+			t.Fatalf("Couldn't write config file: %s", err)
+		}
+
+		_, err := parseConfigFile(path)
+		if tt.valid && err != nil {
+			t.Errorf("parseConfigFile(%q) => unexpected error %s", tt.content, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("parseConfigFile(%q) => expected an error", tt.content)
+		}
+	}
+}
+
+var configMapTests = []struct {
+	data  map[string]string
+	key   string
+	value string
+	valid bool
+}{
+	{map[string]string{"trireme.log_level": "debug"}, "LogLevel", "debug", true},
+	{map[string]string{"trireme.collector_password": "secret"}, "CollectorPass", "secret", true},
+	{map[string]string{"trireme.grafana_user": "admin", "trireme.audit_mode": "true"}, "AuditMode", "true", true},
+	{map[string]string{"trireme.signing_ca_cert_key": "/opt/key.pem"}, "", "", true},
+	{map[string]string{"trireme.unknown": "value"}, "", "", false},
+}
+
+func TestParseConfigMap(t *testing.T) {
+	for _, tt := range configMapTests {
+		settings, err := parseConfigMap(tt.data)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("parseConfigMap(%v) => expected an error", tt.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseConfigMap(%v) => unexpected error %s", tt.data, err)
+			continue
+		}
+		if tt.key == "" {
+			if len(settings) != 0 {
+				t.Errorf("parseConfigMap(%v) => %v, expected no settings", tt.data, settings)
+			}
+			continue
+		}
+		if settings[tt.key] != tt.value {
+			t.Errorf("parseConfigMap(%v) => %v, expected %s=%s", tt.data, settings, tt.key, tt.value)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Reloader keeps track of the running Configuration and recomputes it whenever
+// the configuration file or the watched ConfigMap is modified.
+// Only the reloadable subset of the Configuration is ever changed at runtime.
+type Reloader struct {
+	current  *Configuration
+	onChange func(old, updated *Configuration)
+	// configMap holds the reloadable settings of the watched ConfigMap. They are applied on
+	// top of the other sources so that removing them reverts to the file, flag or env value.
+	configMap map[string]interface{}
+	sync.Mutex
+}
+
+// NewReloader creates a Reloader for the running Configuration. onChange is called
+// each time at least one reloadable setting got modified.
+func NewReloader(current *Configuration, onChange func(old, updated *Configuration)) *Reloader {
+	return &Reloader{
+		current:   current,
+		onChange:  onChange,
+		configMap: map[string]interface{}{},
+	}
+}
+
+// WatchConfigFile starts watching the configuration file if one was given.
+func (r *Reloader) WatchConfigFile() {
+	if r.current.ConfigFile == "" {
+		return
+	}
+
+	viper.OnConfigChange(func(event fsnotify.Event) {
+		zap.L().Info("Config file modified", zap.String("file", event.Name))
+		if _, err := parseConfigFile(r.current.ConfigFile); err != nil {
+			zap.L().Error("Ignoring invalid config file", zap.Error(err))
+			return
+		}
+
+		r.Lock()
+		defer r.Unlock()
+		if err := r.reload(); err != nil {
+			zap.L().Error("Error reloading config file", zap.Error(err))
+		}
+	})
+	viper.WatchConfig()
+}
+
+// UpdateFromConfigMap applies the settings from the watched ConfigMap data.
+// Reloadable settings from the ConfigMap take precedence over flags and environment
+// variables as the ConfigMap is the live source of truth once the agent runs.
+// The settings removed from the ConfigMap revert to their value from the other sources.
+func (r *Reloader) UpdateFromConfigMap(data map[string]string) error {
+	settings, err := parseConfigMap(data)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	configMap := map[string]interface{}{}
+	for key, value := range settings {
+		if !configKeys[key] {
+			if fmt.Sprint(viper.Get(key)) != fmt.Sprint(value) {
+				zap.L().Warn("ConfigMap setting cannot be changed at runtime. Restart required", zap.String("key", key))
+			}
+			continue
+		}
+		configMap[key] = value
+	}
+	for key := range r.configMap {
+		if _, ok := configMap[key]; !ok {
+			zap.L().Info("Setting removed from ConfigMap. Reverting it", zap.String("key", key))
+		}
+	}
+
+	previous := r.configMap
+	r.configMap = configMap
+	if err := r.reload(); err != nil {
+		r.configMap = previous
+		return err
+	}
+	return nil
+}
+
+// reload recomputes the Configuration from all the sources and applies
+// the reloadable settings. Must be called with the lock held.
+func (r *Reloader) reload() error {
+	var loaded Configuration
+	if err := viper.Unmarshal(&loaded); err != nil {
+		return fmt.Errorf("Error unmarshalling:%s", err)
+	}
+	defaultEnforceKubeSystem(&loaded)
+	overrides := viper.New()
+	for key, value := range r.configMap {
+		overrides.Set(key, value)
+	}
+	if err := overrides.Unmarshal(&loaded); err != nil {
+		return fmt.Errorf("Error unmarshalling ConfigMap:%s", err)
+	}
+	if err := validateConfig(&loaded); err != nil {
+		return fmt.Errorf("Invalid configuration, keeping the current one: %s", err)
+	}
+
+	updated := *r.current
+	currentValue := reflect.ValueOf(r.current).Elem()
+	loadedValue := reflect.ValueOf(&loaded).Elem()
+	updatedValue := reflect.ValueOf(&updated).Elem()
+
+	changed := false
+	for key, reloadable := range configKeys {
+		if reflect.DeepEqual(currentValue.FieldByName(key).Interface(), loadedValue.FieldByName(key).Interface()) {
+			continue
+		}
+		if !reloadable {
+			zap.L().Warn("Setting cannot be changed at runtime. Restart required", zap.String("key", key))
+			continue
+		}
+		zap.L().Info("Reloading setting", zap.String("key", key))
+		updatedValue.FieldByName(key).Set(loadedValue.FieldByName(key))
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	old := r.current
+	r.current = &updated
+	r.onChange(old, &updated)
+	return nil
+}
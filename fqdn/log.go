@@ -0,0 +1,12 @@
+package fqdn
+
+import (
+	"github.com/aporeto-inc/trireme-kubernetes/logs"
+
+	"go.uber.org/zap"
+)
+
+// logger returns the logger of the resolver subsystem, as FQDNs are resolved for it.
+func logger() *zap.Logger {
+	return logs.L(logs.Resolver)
+}
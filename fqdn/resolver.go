@@ -0,0 +1,193 @@
+// Package fqdn resolves the FQDNs used in egress policies and keeps their
+// addresses up to date according to the TTL of the DNS answers.
+package fqdn
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"go.uber.org/zap"
+)
+
+// DefaultResolvConf is the file used to find the DNS server if none is given.
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// queryTimeout is the timeout of a single DNS query.
+const queryTimeout = 5 * time.Second
+
+// Resolver resolves FQDNs into IPv4 addresses and refreshes them when their TTL expires.
+// onChange is called each time the addresses of a tracked FQDN change.
+type Resolver struct {
+	server   string
+	client   *dns.Client
+	minTTL   time.Duration
+	maxTTL   time.Duration
+	onChange func(name string)
+	entries  map[string]*entry
+	stopped  bool
+	sync.Mutex
+}
+
+// entry is a tracked FQDN.
+type entry struct {
+	ips     []string
+	refresh *time.Timer
+}
+
+// NewResolver creates a Resolver querying the DNS server given as host:port. If server is empty,
+// the first nameserver of DefaultResolvConf is used. The TTLs of the answers are bounded by
+// minTTL and maxTTL.
+func NewResolver(server string, minTTL time.Duration, maxTTL time.Duration, onChange func(name string)) (*Resolver, error) {
+	if server == "" {
+		clientConfig, err := dns.ClientConfigFromFile(DefaultResolvConf)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read DNS configuration: %s", err)
+		}
+		if len(clientConfig.Servers) == 0 {
+			return nil, fmt.Errorf("No nameserver in %s", DefaultResolvConf)
+		}
+		server = net.JoinHostPort(clientConfig.Servers[0], clientConfig.Port)
+	}
+
+	if minTTL > maxTTL {
+		return nil, fmt.Errorf("Minimum TTL %s is greater than maximum TTL %s", minTTL, maxTTL)
+	}
+
+	return &Resolver{
+		server:   server,
+		client:   &dns.Client{Timeout: queryTimeout},
+		minTTL:   minTTL,
+		maxTTL:   maxTTL,
+		onChange: onChange,
+		entries:  map[string]*entry{},
+	}, nil
+}
+
+// Resolve returns the cached addresses of the FQDN. The first call starts tracking the FQDN
+// and returns no address, as the FQDN is resolved asynchronously: onChange is called once
+// its addresses are known. The addresses stay empty as long as the FQDN cannot be resolved.
+func (r *Resolver) Resolve(name string) []string {
+	name = dns.Fqdn(strings.ToLower(name))
+
+	r.Lock()
+	defer r.Unlock()
+
+	if e, ok := r.entries[name]; ok {
+		return e.ips
+	}
+	if r.stopped {
+		return nil
+	}
+
+	r.entries[name] = &entry{
+		refresh: time.AfterFunc(0, func() { r.refresh(name) }),
+	}
+	return nil
+}
+
+// Release stops tracking the FQDN.
+func (r *Resolver) Release(name string) {
+	name = dns.Fqdn(strings.ToLower(name))
+
+	r.Lock()
+	defer r.Unlock()
+
+	if e, ok := r.entries[name]; ok {
+		e.refresh.Stop()
+		delete(r.entries, name)
+	}
+}
+
+// Stop stops tracking all the FQDNs.
+func (r *Resolver) Stop() {
+	r.Lock()
+	defer r.Unlock()
+
+	for name, e := range r.entries {
+		e.refresh.Stop()
+		delete(r.entries, name)
+	}
+	r.stopped = true
+}
+
+// refresh resolves the FQDN again and calls onChange if the addresses changed.
+// The previous addresses are kept if the resolution fails.
+func (r *Resolver) refresh(name string) {
+	ips, ttl, err := r.lookup(name)
+	if err != nil {
+		logger().Warn("Couldn't refresh FQDN. Keeping previous addresses", zap.String("fqdn", name), zap.Error(err))
+		ttl = r.minTTL
+	}
+
+	r.Lock()
+	e, ok := r.entries[name]
+	if !ok {
+		// Released in the meantime.
+		r.Unlock()
+		return
+	}
+	e.refresh = time.AfterFunc(ttl, func() { r.refresh(name) })
+
+	changed := err == nil && !equalIPs(e.ips, ips)
+	if changed {
+		e.ips = ips
+	}
+	r.Unlock()
+
+	if changed {
+		logger().Info("FQDN addresses changed", zap.String("fqdn", name), zap.Strings("ips", ips))
+		r.onChange(strings.TrimSuffix(name, "."))
+	}
+}
+
+// lookup queries the A records of the FQDN. The Trireme datapath only enforces IPv4, so the
+// AAAA records are ignored. It returns the sorted addresses and the bounded minimum TTL of the answers.
+func (r *Resolver) lookup(name string) ([]string, time.Duration, error) {
+	query := &dns.Msg{}
+	query.SetQuestion(name, dns.TypeA)
+
+	answer, _, err := r.client.Exchange(query, r.server)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DNS query for %s failed: %s", name, err)
+	}
+	if answer.Rcode != dns.RcodeSuccess {
+		return nil, 0, fmt.Errorf("DNS query for %s failed: %s", name, dns.RcodeToString[answer.Rcode])
+	}
+
+	ips := []string{}
+	ttl := r.maxTTL
+	for _, record := range answer.Answer {
+		address, ok := record.(*dns.A)
+		if !ok {
+			continue
+		}
+		ips = append(ips, address.A.String())
+		if recordTTL := time.Duration(record.Header().Ttl) * time.Second; recordTTL < ttl {
+			ttl = recordTTL
+		}
+	}
+	if ttl < r.minTTL {
+		ttl = r.minTTL
+	}
+
+	sort.Strings(ips)
+	return ips, ttl, nil
+}
+
+func equalIPs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package fqdn
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testServer is a local DNS server answering A and AAAA queries from a mutable zone.
+type testServer struct {
+	zone map[string][]string
+	sync.Mutex
+}
+
+func (s *testServer) setAnswer(name string, ips ...string) {
+	s.Lock()
+	defer s.Unlock()
+	s.zone[dns.Fqdn(name)] = ips
+}
+
+func (s *testServer) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
+	answer := &dns.Msg{}
+	answer.SetReply(query)
+
+	s.Lock()
+	ips, ok := s.zone[query.Question[0].Name]
+	s.Unlock()
+
+	if !ok {
+		answer.Rcode = dns.RcodeNameError
+	}
+	header := dns.RR_Header{Name: query.Question[0].Name, Rrtype: query.Question[0].Qtype, Class: dns.ClassINET, Ttl: 1}
+	for _, ip := range ips {
+		address := net.ParseIP(ip)
+		switch {
+		case query.Question[0].Qtype == dns.TypeA && address.To4() != nil:
+			answer.Answer = append(answer.Answer, &dns.A{Hdr: header, A: address})
+		case query.Question[0].Qtype == dns.TypeAAAA && address.To4() == nil:
+			answer.Answer = append(answer.Answer, &dns.AAAA{Hdr: header, AAAA: address})
+		}
+	}
+	w.WriteMsg(answer)
+}
+
+func startTestServer(t *testing.T) (*testServer, string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+
+	handler := &testServer{zone: map[string][]string{}}
+	server := &dns.Server{PacketConn: conn, Handler: handler}
+	go server.ActivateAndServe()
+
+	return handler, conn.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+// waitForChange returns the next FQDN passed to onChange.
+func waitForChange(t *testing.T, changes chan string) string {
+	select {
+	case name := <-changes:
+		return name
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Address change was not detected")
+	}
+	return ""
+}
+
+func TestResolve(t *testing.T) {
+	zone, address, stop := startTestServer(t)
+	defer stop()
+	zone.setAnswer("api.example.com", "10.0.0.2", "10.0.0.1", "2001:db8::1")
+
+	changes := make(chan string, 10)
+	resolver, err := NewResolver(address, time.Second, time.Minute, func(name string) { changes <- name })
+	if err != nil {
+		t.Fatalf("NewResolver failed: %s", err)
+	}
+	defer resolver.Stop()
+
+	// The FQDN is resolved asynchronously.
+	if ips := resolver.Resolve("API.example.com"); len(ips) != 0 {
+		t.Errorf("Resolve(api.example.com) => %v on first call, expected no address", ips)
+	}
+	if name := waitForChange(t, changes); name != "api.example.com" {
+		t.Errorf("onChange(%q), expected api.example.com", name)
+	}
+	if ips := resolver.Resolve("api.example.com"); !equalIPs(ips, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Resolve(api.example.com) => %v, expected 10.0.0.1 and 10.0.0.2", ips)
+	}
+
+	// Unknown FQDNs have no address.
+	resolver.Resolve("unknown.example.com")
+	select {
+	case name := <-changes:
+		t.Errorf("onChange(%q) called for an unknown FQDN", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if ips := resolver.Resolve("unknown.example.com"); len(ips) != 0 {
+		t.Errorf("Resolve(unknown.example.com) => %v, expected no address", ips)
+	}
+}
+
+func TestResolveIPv6Only(t *testing.T) {
+	zone, address, stop := startTestServer(t)
+	defer stop()
+	zone.setAnswer("v6.example.com", "2001:db8::2")
+
+	changes := make(chan string, 10)
+	resolver, err := NewResolver(address, time.Second, time.Minute, func(name string) { changes <- name })
+	if err != nil {
+		t.Fatalf("NewResolver failed: %s", err)
+	}
+	defer resolver.Stop()
+
+	// The IPv6 addresses cannot be enforced by Trireme.
+	resolver.Resolve("v6.example.com")
+	select {
+	case name := <-changes:
+		t.Errorf("onChange(%q) called for an IPv6 only FQDN", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if ips := resolver.Resolve("v6.example.com"); len(ips) != 0 {
+		t.Errorf("Resolve(v6.example.com) => %v, expected no address", ips)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	zone, address, stop := startTestServer(t)
+	defer stop()
+	zone.setAnswer("api.example.com", "10.0.0.1")
+
+	changes := make(chan string, 10)
+	resolver, err := NewResolver(address, 10*time.Millisecond, 10*time.Millisecond, func(name string) { changes <- name })
+	if err != nil {
+		t.Fatalf("NewResolver failed: %s", err)
+	}
+	defer resolver.Stop()
+
+	resolver.Resolve("api.example.com")
+	waitForChange(t, changes)
+
+	zone.setAnswer("api.example.com", "10.0.0.3")
+	if name := waitForChange(t, changes); name != "api.example.com" {
+		t.Errorf("onChange(%q), expected api.example.com", name)
+	}
+
+	ips := resolver.Resolve("api.example.com")
+	if !equalIPs(ips, []string{"10.0.0.3"}) {
+		t.Errorf("Resolve(api.example.com) => %v after refresh, expected 10.0.0.3", ips)
+	}
+
+	// Released FQDNs are not refreshed anymore.
+	resolver.Release("api.example.com")
+	zone.setAnswer("api.example.com", "10.0.0.4")
+	select {
+	case name := <-changes:
+		t.Errorf("onChange(%q) called after Release", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -17,6 +17,7 @@ import (
 // Client is the Trireme representation of the Client.
 type Client struct {
 	kubeClient kubernetes.Interface
+	restConfig *restclient.Config
 	localNode  string
 }
 
@@ -56,6 +57,7 @@ func (c *Client) InitKubernetesClient(kubeconfig string) error {
 		return fmt.Errorf("Error creating REST Kube Client: %v", err)
 	}
 	c.kubeClient = myClient
+	c.restConfig = config
 	return nil
 }
 
@@ -111,6 +113,15 @@ func (c *Client) Endpoints(service string, namespace string) (*api.Endpoints, er
 	return endpoints, nil
 }
 
+// Service returns the full service object.
+func (c *Client) Service(service string, namespace string) (*api.Service, error) {
+	svc, err := c.kubeClient.Core().Services(namespace).Get(service, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get service %s from Kubernetes API: %s", service, err)
+	}
+	return svc, nil
+}
+
 // PodLabels returns the list of all labels associated with a pod.
 func (c *Client) PodLabels(podName string, namespace string) (map[string]string, error) {
 	targetPod, err := c.kubeClient.Core().Pods(namespace).Get(podName, metav1.GetOptions{})
@@ -179,6 +190,33 @@ func (c *Client) AddLocalNodeAnnotation(annotationKey, annotationValue string) e
 	return nil
 }
 
+// Node returns the full node object.
+func (c *Client) Node(nodeName string) (*api.Node, error) {
+	node, err := c.kubeClient.Core().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get node %s: %s", nodeName, err)
+	}
+	return node, nil
+}
+
+// ConfigMap returns the full ConfigMap object.
+func (c *Client) ConfigMap(name string, namespace string) (*api.ConfigMap, error) {
+	configMap, err := c.kubeClient.Core().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get ConfigMap %s/%s: %s", namespace, name, err)
+	}
+	return configMap, nil
+}
+
+// ServerVersion returns the version of the Kubernetes API server.
+func (c *Client) ServerVersion() (string, error) {
+	info, err := c.kubeClient.Discovery().ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("Couldn't reach Kubernetes API: %s", err)
+	}
+	return info.String(), nil
+}
+
 // AllNodes return a list of all the nodes on the KubeCluster.
 func (c *Client) AllNodes() (*api.NodeList, error) {
 	nodes, err := c.kubeClient.Core().Nodes().List(metav1.ListOptions{})
@@ -188,6 +226,24 @@ func (c *Client) AllNodes() (*api.NodeList, error) {
 	return nodes, nil
 }
 
+// AllPods returns the pods of all the namespaces.
+func (c *Client) AllPods() (*api.PodList, error) {
+	pods, err := c.kubeClient.Core().Pods(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get pods list : %s", err)
+	}
+	return pods, nil
+}
+
+// AllNetworkPolicies returns the NetworkPolicies of all the namespaces.
+func (c *Client) AllNetworkPolicies() (*networking.NetworkPolicyList, error) {
+	networkPolicies, err := c.kubeClient.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get NetworkPolicies list : %s", err)
+	}
+	return networkPolicies, nil
+}
+
 // KubeClient returns the Kubernetes ClientSet
 func (c *Client) KubeClient() kubernetes.Interface {
 	return c.kubeClient
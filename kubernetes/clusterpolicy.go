@@ -0,0 +1,197 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"go.uber.org/zap"
+)
+
+// ClusterNetworkPolicyGroupVersion is the API group and version of the ClusterNetworkPolicy CRD.
+var ClusterNetworkPolicyGroupVersion = schema.GroupVersion{Group: "trireme.io", Version: "v1alpha1"}
+
+// ClusterNetworkPolicyResource is the plural resource name of the ClusterNetworkPolicy CRD.
+const ClusterNetworkPolicyResource = "clusternetworkpolicies"
+
+// ClusterNetworkPolicyAction is the action applied to the traffic matched by a ClusterNetworkPolicy rule.
+type ClusterNetworkPolicyAction string
+
+const (
+	// ClusterNetworkPolicyAccept accepts the matched traffic.
+	ClusterNetworkPolicyAccept ClusterNetworkPolicyAction = "Accept"
+	// ClusterNetworkPolicyReject rejects the matched traffic.
+	ClusterNetworkPolicyReject ClusterNetworkPolicyAction = "Reject"
+)
+
+// ClusterNetworkPolicy is a cluster scoped network policy. Its rules are applied to the
+// selected pods of all the selected namespaces before the NetworkPolicies rules.
+type ClusterNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterNetworkPolicySpec `json:"spec"`
+}
+
+// ClusterNetworkPolicySpec is the specification of a ClusterNetworkPolicy.
+type ClusterNetworkPolicySpec struct {
+	// Priority orders the ClusterNetworkPolicies. Lower values are evaluated first.
+	Priority int32 `json:"priority"`
+	// NamespaceSelector selects the namespaces the policy applies to. All namespaces if empty.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// PodSelector selects the pods the policy applies to. All pods if empty.
+	PodSelector metav1.LabelSelector `json:"podSelector"`
+	// Ingress and Egress are the rules applied on the traffic received and sent by the pods.
+	Ingress []ClusterNetworkPolicyRule `json:"ingress,omitempty"`
+	Egress  []ClusterNetworkPolicyRule `json:"egress,omitempty"`
+}
+
+// ClusterNetworkPolicyRule matches traffic from or to a list of peers on a list of ports.
+// Empty Peers or Ports match everything.
+type ClusterNetworkPolicyRule struct {
+	Action ClusterNetworkPolicyAction     `json:"action"`
+	Peers  []networking.NetworkPolicyPeer `json:"peers,omitempty"`
+	Ports  []networking.NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// ClusterNetworkPolicyList is a list of ClusterNetworkPolicies.
+type ClusterNetworkPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ClusterNetworkPolicy `json:"items"`
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ClusterNetworkPolicy) DeepCopyInto(out *ClusterNetworkPolicy) {
+	*out = *in
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopyObject returns a deep copy of the ClusterNetworkPolicy.
+func (in *ClusterNetworkPolicy) DeepCopyObject() runtime.Object {
+	out := &ClusterNetworkPolicy{}
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ClusterNetworkPolicySpec) DeepCopyInto(out *ClusterNetworkPolicySpec) {
+	*out = *in
+	out.NamespaceSelector = in.NamespaceSelector.DeepCopy()
+	in.PodSelector.DeepCopyInto(&out.PodSelector)
+	out.Ingress = deepCopyRules(in.Ingress)
+	out.Egress = deepCopyRules(in.Egress)
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *ClusterNetworkPolicyRule) DeepCopyInto(out *ClusterNetworkPolicyRule) {
+	*out = *in
+	if in.Peers != nil {
+		out.Peers = make([]networking.NetworkPolicyPeer, len(in.Peers))
+		for i := range in.Peers {
+			in.Peers[i].DeepCopyInto(&out.Peers[i])
+		}
+	}
+	if in.Ports != nil {
+		out.Ports = make([]networking.NetworkPolicyPort, len(in.Ports))
+		for i := range in.Ports {
+			in.Ports[i].DeepCopyInto(&out.Ports[i])
+		}
+	}
+}
+
+func deepCopyRules(rules []ClusterNetworkPolicyRule) []ClusterNetworkPolicyRule {
+	if rules == nil {
+		return nil
+	}
+	copied := make([]ClusterNetworkPolicyRule, len(rules))
+	for i := range rules {
+		rules[i].DeepCopyInto(&copied[i])
+	}
+	return copied
+}
+
+// DeepCopyObject returns a deep copy of the ClusterNetworkPolicyList.
+func (in *ClusterNetworkPolicyList) DeepCopyObject() runtime.Object {
+	out := &ClusterNetworkPolicyList{}
+	*out = *in
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ClusterNetworkPolicy, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// clusterNetworkPolicyRESTClient returns a REST client for the trireme.io API group.
+func (c *Client) clusterNetworkPolicyRESTClient() (*restclient.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(ClusterNetworkPolicyGroupVersion, &ClusterNetworkPolicy{}, &ClusterNetworkPolicyList{})
+	metav1.AddToGroupVersion(scheme, ClusterNetworkPolicyGroupVersion)
+
+	config := *c.restConfig
+	config.GroupVersion = &ClusterNetworkPolicyGroupVersion
+	config.APIPath = "/apis"
+	config.ContentType = runtime.ContentTypeJSON
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	client, err := restclient.RESTClientFor(&config)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create ClusterNetworkPolicy REST client: %s", err)
+	}
+	return client, nil
+}
+
+// CheckClusterNetworkPolicyCRD returns an error if the Kubernetes API doesn't serve the
+// ClusterNetworkPolicy CRD.
+func (c *Client) CheckClusterNetworkPolicyCRD() error {
+	resources, err := c.kubeClient.Discovery().ServerResourcesForGroupVersion(ClusterNetworkPolicyGroupVersion.String())
+	if err != nil {
+		return fmt.Errorf("Couldn't find the ClusterNetworkPolicy CRD in %s: %s", ClusterNetworkPolicyGroupVersion.String(), err)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == ClusterNetworkPolicyResource {
+			return nil
+		}
+	}
+	return fmt.Errorf("Couldn't find the ClusterNetworkPolicy CRD in %s: %s not served", ClusterNetworkPolicyGroupVersion.String(), ClusterNetworkPolicyResource)
+}
+
+// CreateClusterNetworkPolicyController creates a controller specifically for ClusterNetworkPolicies.
+func (c *Client) CreateClusterNetworkPolicyController(
+	addFunc func(addedApiStruct *ClusterNetworkPolicy) error, deleteFunc func(deletedApiStruct *ClusterNetworkPolicy) error, updateFunc func(oldApiStruct, updatedApiStruct *ClusterNetworkPolicy) error) (cache.Store, cache.Controller, error) {
+
+	client, err := c.clusterNetworkPolicyRESTClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, controller := CreateResourceController(client, ClusterNetworkPolicyResource, "", &ClusterNetworkPolicy{}, fields.Everything(),
+		func(addedApiStruct interface{}) {
+			if err := addFunc(addedApiStruct.(*ClusterNetworkPolicy)); err != nil {
+				logger().Error("Error while handling Add ClusterNetworkPolicy", zap.Error(err))
+			}
+		},
+		func(deletedApiStruct interface{}) {
+			if err := deleteFunc(deletedApiStruct.(*ClusterNetworkPolicy)); err != nil {
+				logger().Error("Error while handling Delete ClusterNetworkPolicy", zap.Error(err))
+			}
+		},
+		func(oldApiStruct, updatedApiStruct interface{}) {
+			if err := updateFunc(oldApiStruct.(*ClusterNetworkPolicy), updatedApiStruct.(*ClusterNetworkPolicy)); err != nil {
+				logger().Error("Error while handling Update ClusterNetworkPolicy", zap.Error(err))
+			}
+		})
+	return store, controller, nil
+}
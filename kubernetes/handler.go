@@ -33,41 +33,53 @@ func (c *Client) CreateNamespaceController(
 	return CreateResourceController(c.KubeClient().Core().RESTClient(), "namespaces", "", &api.Namespace{}, fields.Everything(),
 		func(addedApiStruct interface{}) {
 			if err := addFunc(addedApiStruct.(*api.Namespace)); err != nil {
-				zap.L().Error("Error while handling Add NameSpace", zap.Error(err))
+				logger().Error("Error while handling Add NameSpace", zap.Error(err))
 			}
 		},
 		func(deletedApiStruct interface{}) {
 			if err := deleteFunc(deletedApiStruct.(*api.Namespace)); err != nil {
-				zap.L().Error("Error while handling Delete NameSpace", zap.Error(err))
+				logger().Error("Error while handling Delete NameSpace", zap.Error(err))
 
 			}
 		},
 		func(oldApiStruct, updatedApiStruct interface{}) {
 			if err := updateFunc(oldApiStruct.(*api.Namespace), updatedApiStruct.(*api.Namespace)); err != nil {
-				zap.L().Error("Error while handling Update NameSpace", zap.Error(err))
+				logger().Error("Error while handling Update NameSpace", zap.Error(err))
 
 			}
 		})
 }
 
-// CreateLocalPodController creates a controller specifically for Pods.
+// CreateLocalPodController creates a controller specifically for the Pods of the local node.
 func (c *Client) CreateLocalPodController(namespace string,
 	addFunc func(addedApiStruct *api.Pod) error, deleteFunc func(deletedApiStruct *api.Pod) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Pod) error) (cache.Store, cache.Controller) {
+	return c.createPodController(namespace, c.localNodeSelector(), addFunc, deleteFunc, updateFunc)
+}
+
+// CreatePodController creates a controller specifically for the Pods of all the nodes.
+func (c *Client) CreatePodController(namespace string,
+	addFunc func(addedApiStruct *api.Pod) error, deleteFunc func(deletedApiStruct *api.Pod) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Pod) error) (cache.Store, cache.Controller) {
+	return c.createPodController(namespace, fields.Everything(), addFunc, deleteFunc, updateFunc)
+}
 
-	return CreateResourceController(c.KubeClient().Core().RESTClient(), "pods", namespace, &api.Pod{}, c.localNodeSelector(),
+// createPodController creates a controller for the Pods matching the selector.
+func (c *Client) createPodController(namespace string, selector fields.Selector,
+	addFunc func(addedApiStruct *api.Pod) error, deleteFunc func(deletedApiStruct *api.Pod) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Pod) error) (cache.Store, cache.Controller) {
+
+	return CreateResourceController(c.KubeClient().Core().RESTClient(), "pods", namespace, &api.Pod{}, selector,
 		func(addedApiStruct interface{}) {
 			if err := addFunc(addedApiStruct.(*api.Pod)); err != nil {
-				zap.L().Error("Error while handling Add Pod", zap.Error(err))
+				logger().Error("Error while handling Add Pod", zap.Error(err))
 			}
 		},
 		func(deletedApiStruct interface{}) {
 			if err := deleteFunc(deletedApiStruct.(*api.Pod)); err != nil {
-				zap.L().Error("Error while handling Delete Pod", zap.Error(err))
+				logger().Error("Error while handling Delete Pod", zap.Error(err))
 			}
 		},
 		func(oldApiStruct, updatedApiStruct interface{}) {
 			if err := updateFunc(oldApiStruct.(*api.Pod), updatedApiStruct.(*api.Pod)); err != nil {
-				zap.L().Error("Error while handling Update Pod", zap.Error(err))
+				logger().Error("Error while handling Update Pod", zap.Error(err))
 			}
 		})
 }
@@ -78,17 +90,17 @@ func (c *Client) CreateNetworkPoliciesController(namespace string,
 	return CreateResourceController(c.KubeClient().NetworkingV1().RESTClient(), "networkpolicies", namespace, &networking.NetworkPolicy{}, fields.Everything(),
 		func(addedApiStruct interface{}) {
 			if err := addFunc(addedApiStruct.(*networking.NetworkPolicy)); err != nil {
-				zap.L().Error("Error while handling Add NetworkPolicy", zap.Error(err))
+				logger().Error("Error while handling Add NetworkPolicy", zap.Error(err))
 			}
 		},
 		func(deletedApiStruct interface{}) {
 			if err := deleteFunc(deletedApiStruct.(*networking.NetworkPolicy)); err != nil {
-				zap.L().Error("Error while handling Delete NetworkPolicy", zap.Error(err))
+				logger().Error("Error while handling Delete NetworkPolicy", zap.Error(err))
 			}
 		},
 		func(oldApiStruct, updatedApiStruct interface{}) {
 			if err := updateFunc(oldApiStruct.(*networking.NetworkPolicy), updatedApiStruct.(*networking.NetworkPolicy)); err != nil {
-				zap.L().Error("Error while handling Update NetworkPolicy", zap.Error(err))
+				logger().Error("Error while handling Update NetworkPolicy", zap.Error(err))
 			}
 		})
 }
@@ -99,17 +111,17 @@ func (c *Client) CreateNodeController(
 	return CreateResourceController(c.KubeClient().Core().RESTClient(), "nodes", "", &api.Node{}, fields.Everything(),
 		func(addedApiStruct interface{}) {
 			if err := addFunc(addedApiStruct.(*api.Node)); err != nil {
-				zap.L().Error("Error while handling Add Node", zap.Error(err))
+				logger().Error("Error while handling Add Node", zap.Error(err))
 			}
 		},
 		func(deletedApiStruct interface{}) {
 			if err := deleteFunc(deletedApiStruct.(*api.Node)); err != nil {
-				zap.L().Error("Error while handling Delete Node", zap.Error(err))
+				logger().Error("Error while handling Delete Node", zap.Error(err))
 			}
 		},
 		func(oldApiStruct, updatedApiStruct interface{}) {
 			if err := updateFunc(oldApiStruct.(*api.Node), updatedApiStruct.(*api.Node)); err != nil {
-				zap.L().Error("Error while handling Update Node", zap.Error(err))
+				logger().Error("Error while handling Update Node", zap.Error(err))
 			}
 		})
 }
@@ -117,20 +129,62 @@ func (c *Client) CreateNodeController(
 // CreateServiceController creates a controller specifically for Services.
 func (c *Client) CreateServiceController(namespace string,
 	addFunc func(addedApiStruct *api.Service) error, deleteFunc func(deletedApiStruct *api.Service) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Service) error) (cache.Store, cache.Controller) {
-	return CreateResourceController(c.KubeClient().Core().RESTClient(), "services", "", &api.Service{}, fields.Everything(),
+	return CreateResourceController(c.KubeClient().Core().RESTClient(), "services", namespace, &api.Service{}, fields.Everything(),
 		func(addedApiStruct interface{}) {
 			if err := addFunc(addedApiStruct.(*api.Service)); err != nil {
-				zap.L().Error("Error while handling Add service", zap.Error(err))
+				logger().Error("Error while handling Add service", zap.Error(err))
 			}
 		},
 		func(deletedApiStruct interface{}) {
 			if err := deleteFunc(deletedApiStruct.(*api.Service)); err != nil {
-				zap.L().Error("Error while handling Delete service", zap.Error(err))
+				logger().Error("Error while handling Delete service", zap.Error(err))
 			}
 		},
 		func(oldApiStruct, updatedApiStruct interface{}) {
 			if err := updateFunc(oldApiStruct.(*api.Service), updatedApiStruct.(*api.Service)); err != nil {
-				zap.L().Error("Error while handling Update service", zap.Error(err))
+				logger().Error("Error while handling Update service", zap.Error(err))
+			}
+		})
+}
+
+// CreateEndpointsController creates a controller specifically for Endpoints.
+func (c *Client) CreateEndpointsController(namespace string,
+	addFunc func(addedApiStruct *api.Endpoints) error, deleteFunc func(deletedApiStruct *api.Endpoints) error, updateFunc func(oldApiStruct, updatedApiStruct *api.Endpoints) error) (cache.Store, cache.Controller) {
+	return CreateResourceController(c.KubeClient().Core().RESTClient(), "endpoints", namespace, &api.Endpoints{}, fields.Everything(),
+		func(addedApiStruct interface{}) {
+			if err := addFunc(addedApiStruct.(*api.Endpoints)); err != nil {
+				logger().Error("Error while handling Add endpoints", zap.Error(err))
+			}
+		},
+		func(deletedApiStruct interface{}) {
+			if err := deleteFunc(deletedApiStruct.(*api.Endpoints)); err != nil {
+				logger().Error("Error while handling Delete endpoints", zap.Error(err))
+			}
+		},
+		func(oldApiStruct, updatedApiStruct interface{}) {
+			if err := updateFunc(oldApiStruct.(*api.Endpoints), updatedApiStruct.(*api.Endpoints)); err != nil {
+				logger().Error("Error while handling Update endpoints", zap.Error(err))
+			}
+		})
+}
+
+// CreateConfigMapController creates a controller specifically for a single ConfigMap.
+func (c *Client) CreateConfigMapController(namespace string, name string,
+	addFunc func(addedApiStruct *api.ConfigMap) error, deleteFunc func(deletedApiStruct *api.ConfigMap) error, updateFunc func(oldApiStruct, updatedApiStruct *api.ConfigMap) error) (cache.Store, cache.Controller) {
+	return CreateResourceController(c.KubeClient().Core().RESTClient(), "configmaps", namespace, &api.ConfigMap{}, fields.OneTermEqualSelector("metadata.name", name),
+		func(addedApiStruct interface{}) {
+			if err := addFunc(addedApiStruct.(*api.ConfigMap)); err != nil {
+				logger().Error("Error while handling Add ConfigMap", zap.Error(err))
+			}
+		},
+		func(deletedApiStruct interface{}) {
+			if err := deleteFunc(deletedApiStruct.(*api.ConfigMap)); err != nil {
+				logger().Error("Error while handling Delete ConfigMap", zap.Error(err))
+			}
+		},
+		func(oldApiStruct, updatedApiStruct interface{}) {
+			if err := updateFunc(oldApiStruct.(*api.ConfigMap), updatedApiStruct.(*api.ConfigMap)); err != nil {
+				logger().Error("Error while handling Update ConfigMap", zap.Error(err))
 			}
 		})
 }
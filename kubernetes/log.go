@@ -0,0 +1,12 @@
+package kubernetes
+
+import (
+	"github.com/aporeto-inc/trireme-kubernetes/logs"
+
+	"go.uber.org/zap"
+)
+
+// logger returns the logger of the kubernetes subsystem.
+func logger() *zap.Logger {
+	return logs.L(logs.Kubernetes)
+}
@@ -0,0 +1,80 @@
+package logs
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// Handler returns an HTTP handler to display and change the log levels at runtime.
+// GET returns the level of every subsystem. PUT or POST with a level parameter changes
+// the level of the subsystem parameter, or of every subsystem if no subsystem is given.
+// The levels can only be changed from the node itself as the management server may listen
+// on the host network.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			if !isLoopback(r.RemoteAddr) {
+				http.Error(w, "Log levels can only be changed from localhost", http.StatusForbidden)
+				return
+			}
+			if err := SetLevel(r.FormValue("subsystem"), r.FormValue("level")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(Levels()); err != nil {
+			zap.L().Error("Error encoding log levels", zap.Error(err))
+		}
+	})
+}
+
+// isLoopback returns true if the host:port address given in parameter is a loopback address.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// HandleSignals changes the log levels on signals until stop is closed:
+// SIGUSR1 makes every subsystem one level more verbose (up to trace),
+// SIGUSR2 sets back every subsystem to the configured level.
+func HandleSignals(stop chan struct{}) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case sig := <-c:
+				var err error
+				if sig == syscall.SIGUSR1 {
+					err = IncreaseLevels()
+				} else {
+					err = ResetLevels()
+				}
+				if err != nil {
+					zap.L().Error("Error changing log levels", zap.Error(err))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,39 @@
+package logs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	if err := Setup("json", "info"); err != nil {
+		t.Fatalf("Setup failed: %s", err)
+	}
+
+	tests := []struct {
+		method     string
+		remoteAddr string
+		status     int
+		level      string
+	}{
+		{http.MethodGet, "10.0.0.1:4242", http.StatusOK, "info"},
+		{http.MethodPut, "10.0.0.1:4242", http.StatusForbidden, "info"},
+		{http.MethodPut, "127.0.0.1:4242", http.StatusOK, "debug"},
+		{http.MethodPost, "[::1]:4242", http.StatusOK, "debug"},
+		{http.MethodDelete, "127.0.0.1:4242", http.StatusMethodNotAllowed, "debug"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, "/loglevel?subsystem=resolver&level=debug", nil)
+		request.RemoteAddr = test.remoteAddr
+		recorder := httptest.NewRecorder()
+		Handler().ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s from %s: expected status %d, got %d", test.method, test.remoteAddr, test.status, recorder.Code)
+		}
+		if level := Levels()[Resolver]; level != test.level {
+			t.Errorf("%s from %s: expected level %s, got %s", test.method, test.remoteAddr, test.level, level)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package logs sets up the Zap loggers used by Trireme-Kubernetes.
+// Each subsystem gets its own named logger whose level can be changed at runtime.
+package logs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	tlog "github.com/aporeto-inc/trireme/log"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Subsystems having their own logger.
+const (
+	// Root is the logger used by everything that is not a subsystem.
+	Root = "root"
+	// Resolver is the logger of the resolver package.
+	Resolver = "resolver"
+	// Kubernetes is the logger of the kubernetes package.
+	Kubernetes = "kubernetes"
+	// Auth is the logger of the auth package.
+	Auth = "auth"
+	// Collector is the logger of the collector package.
+	Collector = "collector"
+)
+
+// Subsystems is the list of all the loggers.
+var Subsystems = []string{Root, Resolver, Kubernetes, Auth, Collector}
+
+// TraceLevel is the level used to also enable the Trireme library traces.
+const TraceLevel = "trace"
+
+// verbosity is the ordered list of levels used when increasing verbosity.
+var verbosity = []string{"fatal", "error", "warn", "info", "debug", TraceLevel}
+
+type registry struct {
+	configuredLevel string
+	levelNames      map[string]string
+	levels          map[string]zap.AtomicLevel
+	loggers         map[string]*zap.Logger
+	sync.RWMutex
+}
+
+var loggers = &registry{
+	levelNames: map[string]string{},
+	levels:     map[string]zap.AtomicLevel{},
+	loggers:    map[string]*zap.Logger{},
+}
+
+// Setup builds all the loggers with the format and level given in parameter.
+// The Root logger is also set as the global Zap logger.
+func Setup(logFormat, logLevel string) error {
+	var zapConfig zap.Config
+
+	switch logFormat {
+	case "json":
+		zapConfig = zap.NewProductionConfig()
+		zapConfig.DisableStacktrace = true
+	default:
+		zapConfig = zap.NewDevelopmentConfig()
+		zapConfig.DisableStacktrace = true
+		zapConfig.DisableCaller = true
+		zapConfig.EncoderConfig.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {}
+		zapConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+
+	// The base logger lets everything go through. Filtering happens per subsystem.
+	zapConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	base, err := zapConfig.Build()
+	if err != nil {
+		return err
+	}
+
+	loggers.Lock()
+	defer loggers.Unlock()
+
+	loggers.configuredLevel = logLevel
+	for _, subsystem := range Subsystems {
+		level := zap.NewAtomicLevelAt(zapLevel(logLevel))
+		logger := base.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return &levelFilterCore{Core: core, level: level}
+		}))
+		if subsystem != Root {
+			logger = logger.Named(subsystem)
+		}
+		loggers.levels[subsystem] = level
+		loggers.levelNames[subsystem] = logLevel
+		loggers.loggers[subsystem] = logger
+	}
+	loggers.updateTrace()
+
+	zap.ReplaceGlobals(loggers.loggers[Root])
+	return nil
+}
+
+// L returns the logger for the subsystem given in parameter.
+// The global Zap logger is returned if Setup was not called yet.
+func L(subsystem string) *zap.Logger {
+	loggers.RLock()
+	defer loggers.RUnlock()
+	logger, ok := loggers.loggers[subsystem]
+	if !ok {
+		return zap.L()
+	}
+	return logger
+}
+
+// SetLevel changes the level of the subsystem given in parameter.
+// If subsystem is empty, the level of every subsystem is changed.
+func SetLevel(subsystem, logLevel string) error {
+	if !isValidLevel(logLevel) {
+		return fmt.Errorf("Invalid log level %s", logLevel)
+	}
+
+	loggers.Lock()
+	defer loggers.Unlock()
+
+	if subsystem == "" {
+		subsystem = "all"
+		for name := range loggers.levels {
+			loggers.setLevel(name, logLevel)
+		}
+	} else {
+		if _, ok := loggers.levels[subsystem]; !ok {
+			return fmt.Errorf("Unknown subsystem %s", subsystem)
+		}
+		loggers.setLevel(subsystem, logLevel)
+	}
+	loggers.updateTrace()
+
+	zap.L().Info("Log level changed", zap.String("subsystem", subsystem), zap.String("level", logLevel))
+	return nil
+}
+
+// SetConfiguredLevel changes the configured level and applies it to every subsystem.
+func SetConfiguredLevel(logLevel string) error {
+	if err := SetLevel("", logLevel); err != nil {
+		return err
+	}
+
+	loggers.Lock()
+	defer loggers.Unlock()
+	loggers.configuredLevel = logLevel
+	return nil
+}
+
+// ResetLevels sets back every subsystem to the configured level.
+func ResetLevels() error {
+	loggers.RLock()
+	configuredLevel := loggers.configuredLevel
+	loggers.RUnlock()
+
+	return SetLevel("", configuredLevel)
+}
+
+// IncreaseLevels makes every subsystem one level more verbose, up to trace.
+func IncreaseLevels() error {
+	loggers.Lock()
+	for name, levelName := range loggers.levelNames {
+		loggers.setLevel(name, nextLevel(levelName))
+	}
+	loggers.updateTrace()
+	loggers.Unlock()
+
+	zap.L().Info("Log levels increased", zap.Any("levels", Levels()))
+	return nil
+}
+
+// Levels returns the current level of each subsystem.
+func Levels() map[string]string {
+	loggers.RLock()
+	defer loggers.RUnlock()
+	levels := map[string]string{}
+	for name, levelName := range loggers.levelNames {
+		levels[name] = levelName
+	}
+	return levels
+}
+
+// setLevel must be called with the lock held.
+func (r *registry) setLevel(subsystem, logLevel string) {
+	r.levels[subsystem].SetLevel(zapLevel(logLevel))
+	r.levelNames[subsystem] = logLevel
+}
+
+// updateTrace enables the Trireme library traces if any subsystem is at trace level.
+// Must be called with the lock held.
+func (r *registry) updateTrace() {
+	trace := false
+	for _, levelName := range r.levelNames {
+		if levelName == TraceLevel {
+			trace = true
+		}
+	}
+	tlog.Trace = trace
+}
+
+func isValidLevel(logLevel string) bool {
+	for _, level := range verbosity {
+		if level == logLevel {
+			return true
+		}
+	}
+	return false
+}
+
+func nextLevel(logLevel string) string {
+	for i, level := range verbosity {
+		if level == logLevel && i+1 < len(verbosity) {
+			return verbosity[i+1]
+		}
+	}
+	return TraceLevel
+}
+
+// zapLevel converts the level given in parameter to a Zap level. Default to info.
+func zapLevel(logLevel string) zapcore.Level {
+	switch logLevel {
+	case TraceLevel, "debug":
+		return zap.DebugLevel
+	case "info":
+		return zap.InfoLevel
+	case "warn":
+		return zap.WarnLevel
+	case "error":
+		return zap.ErrorLevel
+	case "fatal":
+		return zap.FatalLevel
+	default:
+		return zap.InfoLevel
+	}
+}
+
+// levelFilterCore is a zapcore.Core that only lets through the entries enabled by its level.
+type levelFilterCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
+}
+
+func (c *levelFilterCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c *levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelFilterCore{
+		Core:  c.Core.With(fields),
+		level: c.level,
+	}
+}
+
+func (c *levelFilterCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(entry.Level) {
+		return checkedEntry
+	}
+	return c.Core.Check(entry, checkedEntry)
+}
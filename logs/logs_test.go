@@ -0,0 +1,79 @@
+package logs
+
+import (
+	"testing"
+
+	tlog "github.com/aporeto-inc/trireme/log"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestIncreaseAndResetLevels(t *testing.T) {
+	if err := Setup("json", "info"); err != nil {
+		t.Fatalf("Setup failed: %s", err)
+	}
+
+	expectLevels := func(expected string, trace bool) {
+		for subsystem, level := range Levels() {
+			if level != expected {
+				t.Errorf("Expected subsystem %s at %s, got %s", subsystem, expected, level)
+			}
+		}
+		if tlog.Trace != trace {
+			t.Errorf("Expected Trireme traces %t", trace)
+		}
+	}
+
+	if err := IncreaseLevels(); err != nil {
+		t.Fatalf("IncreaseLevels failed: %s", err)
+	}
+	expectLevels("debug", false)
+
+	// The most verbose level also enables the Trireme library traces.
+	IncreaseLevels()
+	IncreaseLevels()
+	expectLevels(TraceLevel, true)
+
+	if err := ResetLevels(); err != nil {
+		t.Fatalf("ResetLevels failed: %s", err)
+	}
+	expectLevels("info", false)
+
+	if err := SetLevel(Resolver, TraceLevel); err != nil {
+		t.Fatalf("SetLevel failed: %s", err)
+	}
+	if Levels()[Resolver] != TraceLevel || Levels()[Collector] != "info" || !tlog.Trace {
+		t.Errorf("Unexpected levels %v", Levels())
+	}
+	if err := SetConfiguredLevel("warn"); err != nil {
+		t.Fatalf("SetConfiguredLevel failed: %s", err)
+	}
+	expectLevels("warn", false)
+
+	if err := SetLevel(Resolver, "verbose"); err == nil {
+		t.Errorf("Invalid level accepted")
+	}
+	if err := SetLevel("unknown", "debug"); err == nil {
+		t.Errorf("Unknown subsystem accepted")
+	}
+}
+
+func TestLevelFilterCore(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	level := zap.NewAtomicLevelAt(zap.WarnLevel)
+	logger := zap.New(&levelFilterCore{Core: core, level: level}).With(zap.String("subsystem", "test"))
+
+	logger.Info("filtered")
+	logger.Warn("kept")
+	level.SetLevel(zap.DebugLevel)
+	logger.Debug("kept after level change")
+
+	entries := logs.AllUntimed()
+	if len(entries) != 2 || entries[0].Message != "kept" || entries[1].Message != "kept after level change" {
+		t.Errorf("Unexpected entries %v", entries)
+	}
+	if len(entries) > 0 && entries[0].ContextMap()["subsystem"] != "test" {
+		t.Errorf("Fields lost by With: %v", entries[0].ContextMap())
+	}
+}
@@ -1,28 +1,32 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
-	"time"
 
 	"github.com/aporeto-inc/trireme-kubernetes/auth"
 	"github.com/aporeto-inc/trireme-kubernetes/collector"
 	"github.com/aporeto-inc/trireme-kubernetes/config"
+	"github.com/aporeto-inc/trireme-kubernetes/logs"
 	"github.com/aporeto-inc/trireme-kubernetes/resolver"
 	"github.com/aporeto-inc/trireme-kubernetes/utils"
 	"github.com/aporeto-inc/trireme-kubernetes/version"
 
 	"github.com/aporeto-inc/trireme"
 	"github.com/aporeto-inc/trireme/cmd/remoteenforcer"
+	triremecollector "github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/configurator"
-	tlog "github.com/aporeto-inc/trireme/log"
 	"github.com/aporeto-inc/trireme/monitor"
 
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 func banner(version, revision string) {
@@ -50,11 +54,16 @@ func main() {
 		log.Fatalf("Error loading config: %s", err)
 	}
 
+	// Commands that don't launch Trireme.
+	if exitCode, ok := runCommand(config); ok {
+		os.Exit(exitCode)
+	}
+
 	if !config.Enforce {
 		banner(version.VERSION, version.REVISION)
 	}
 
-	err = setLogs(config.LogFormat, config.LogLevel)
+	err = logs.Setup(config.LogFormat, config.LogLevel)
 	if err != nil {
 		log.Fatalf("Error setting up logs: %s", err)
 	}
@@ -73,6 +82,30 @@ func main() {
 		zap.L().Fatal("Error initializing KubernetesPolicy: ", zap.Error(err))
 	}
 
+	namespaceFilter, err := resolver.NewNamespaceFilter(strings.Fields(config.NamespaceInclude), strings.Fields(config.NamespaceExclude), config.NamespaceIncludeSelector, config.NamespaceExcludeSelector, config.EnforceKubeSystem)
+	if err != nil {
+		zap.L().Fatal("Error initializing namespace filter: ", zap.Error(err))
+	}
+	kubernetesPolicy.SetNamespaceFilter(namespaceFilter)
+	kubernetesPolicy.SetEnforcementOptOutNamespaces(strings.Fields(config.EnforcementOptOutNamespaces))
+	kubernetesPolicy.SetQuarantineForensicNamespace(config.QuarantineForensicNamespace)
+	kubernetesPolicy.SetBreakGlassConfigMap(config.ConfigMapNamespace, config.BreakGlassConfigMapName)
+	kubernetesPolicy.SetClusterNetworkPolicies(config.ClusterNetworkPolicies)
+	if config.AutoAllowDNS {
+		if err := kubernetesPolicy.SetAutoAllowDNS(config.DNSSelector, config.DNSNamespace, config.DNSServiceName); err != nil {
+			zap.L().Fatal("Error initializing DNS allowance: ", zap.Error(err))
+		}
+	}
+	if config.FQDNPolicies {
+		if err := kubernetesPolicy.EnableFQDNPolicies(config.FQDNResolver, config.FQDNMinTTL, config.FQDNMaxTTL); err != nil {
+			zap.L().Fatal("Error initializing FQDN policies: ", zap.Error(err))
+		}
+	}
+	if config.ServicePolicies {
+		kubernetesPolicy.EnableServicePolicies()
+	}
+	kubernetesPolicy.SetStrictHTTPRules(config.StrictHTTPRules)
+
 	var trireme trireme.Trireme
 	var monitor monitor.Monitor
 
@@ -90,11 +123,50 @@ func main() {
 	options.Resolver = kubernetesPolicy
 
 	// Setting up the EventCollector based on the user Config
-	if config.CollectorEndpoint != "" {
-		options.EventCollector = collector.NewInfluxDBCollector(config.CollectorUser, config.CollectorPass, config.CollectorEndpoint, config.CollectorDB, config.CollectorInsecureSkipVerify)
-	} else {
-		options.EventCollector = collector.NewDefaultCollector()
+	// The Prometheus collector is kept across the reloads so that the counters are not reset.
+	var prometheusCollector *collector.PrometheusCollector
+	var metricsHandler http.Handler
+	if config.PrometheusMetrics {
+		prometheusCollector = collector.NewPrometheusCollector(kubernetesPolicy, config.PrometheusMaxSeries, config.PrometheusPortLabel)
+		metricsHandler = prometheusCollector.Handler()
+	}
+	eventCollector := collector.NewReloadableCollector(newEventCollector(config))
+	// The events are filtered after the enrichment which adds the namespaces and labels of the pods.
+	filter, err := newEventFilter(config)
+	if err != nil {
+		zap.L().Fatal("Error initializing collector filter: ", zap.Error(err))
+	}
+	filteringCollector := collector.NewFilteringCollector(eventCollector, filter)
+	var policyCollector triremecollector.EventCollector = filteringCollector
+	var aggregatingCollector *collector.AggregatingCollector
+	if config.CollectorAggregationWindow > 0 || config.CollectorSampleRate < 1 {
+		aggregatingCollector = collector.NewAggregatingCollector(policyCollector, config.CollectorAggregationWindow, config.CollectorSampleRate)
+		policyCollector = aggregatingCollector
+	}
+	// Prometheus counts every flow, the aggregation, the sampling and the filters only apply
+	// to the events exported to the other backends.
+	if prometheusCollector != nil {
+		metricsFanout := collector.NewFanoutCollector(config.CollectorQueueSize)
+		metricsFanout.AddBackend("prometheus", prometheusCollector)
+		metricsFanout.AddBackend("export", policyCollector)
+		policyCollector = metricsFanout
+	}
+	// The flows are enriched before the aggregation, while their pods still exist. The aggregate
+	// keeps the metadata of its first flow.
+	if config.CollectorMetadata {
+		kubernetesPolicy.EnableRemotePodMetadata()
+		policyCollector = collector.NewEnrichingCollector(policyCollector, kubernetesPolicy)
+	}
+	// Every flow is counted by result, before the aggregation and the filters.
+	options.EventCollector = policyCollector
+	if prometheusCollector != nil {
+		metricsCollector, err := collector.NewMetricsCollector(policyCollector, prometheusCollector.Registerer())
+		if err != nil {
+			zap.L().Fatal("Error registering flow metrics", zap.Error(err))
+		}
+		options.EventCollector = metricsCollector
 	}
+	kubernetesPolicy.SetEventCollector(policyCollector)
 
 	if config.AuthType == "PSK" {
 		zap.L().Info("Initializing Trireme with PSK Auth")
@@ -140,15 +212,28 @@ func main() {
 
 	// Register Trireme to the Kubernetes policy resolver
 	kubernetesPolicy.SetPolicyUpdater(trireme)
+	kubernetesPolicy.SetAuditMode(config.AuditMode)
 
 	// Start all the go routines.
 	trireme.Start()
 	zap.L().Debug("Trireme started")
 	monitor.Start()
 	zap.L().Debug("Monitor started")
-	kubernetesPolicy.Run()
+	if err := kubernetesPolicy.Run(); err != nil {
+		zap.L().Fatal("Error starting KubernetesPolicy", zap.Error(err))
+	}
 	zap.L().Debug("PolicyResolver started")
 
+	configWatcherStop := make(chan struct{})
+	watchConfig(config, kubernetesPolicy, eventCollector, filteringCollector, configWatcherStop)
+	zap.L().Debug("Config watcher started")
+
+	logs.HandleSignals(configWatcherStop)
+	if config.ManagementAddress != "" {
+		startManagementServer(config.ManagementAddress, metricsHandler)
+		zap.L().Debug("Management server started", zap.String("address", config.ManagementAddress))
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	zap.L().Info("Everything started. Waiting for Stop signal")
@@ -156,56 +241,94 @@ func main() {
 	<-c
 
 	zap.L().Debug("Stop signal received")
+	close(configWatcherStop)
 	kubernetesPolicy.Stop()
 	zap.L().Debug("KubernetesPolicy stopped")
 	monitor.Stop()
 	zap.L().Debug("Monitor stopped")
 	trireme.Stop()
 	zap.L().Debug("Trireme stopped")
+	if aggregatingCollector != nil {
+		aggregatingCollector.Stop()
+		zap.L().Debug("Aggregated flows sent")
+	}
 
 	zap.L().Info("Everything stopped. Bye Kubernetes!")
 }
 
-// setLogs setups Zap to
-func setLogs(logFormat, logLevel string) error {
-	var zapConfig zap.Config
-
-	switch logFormat {
-	case "json":
-		zapConfig = zap.NewProductionConfig()
-		zapConfig.DisableStacktrace = true
-	default:
-		zapConfig = zap.NewDevelopmentConfig()
-		zapConfig.DisableStacktrace = true
-		zapConfig.DisableCaller = true
-		zapConfig.EncoderConfig.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {}
-		zapConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	}
-
-	// Set the logger
-	switch logLevel {
-	case "trace":
-		tlog.Trace = true
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-	case "debug":
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-	case "info":
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-	case "warn":
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
-	case "error":
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.ErrorLevel)
-	case "fatal":
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.FatalLevel)
-	default:
-		zapConfig.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-	}
-
-	logger, err := zapConfig.Build()
+// newEventCollector returns the EventCollector based on the user Config. The events are
+// sent to all the configured backends.
+func newEventCollector(config *config.Configuration) triremecollector.EventCollector {
+	fanout := collector.NewFanoutCollector(config.CollectorQueueSize)
+	backends := 0
+
+	if config.CollectorFile != "" {
+		fileCollector, err := collector.NewFileCollector(config.CollectorFile, int64(config.CollectorFileMaxSize)*1024*1024, config.CollectorFileMaxAge, config.CollectorFileMaxBackups, config.CollectorFileCompress)
+		if err != nil {
+			zap.L().Error("Error instantiating file collector", zap.Error(err))
+		} else {
+			fanout.AddBackend("file", fileCollector)
+			backends++
+		}
+	}
+	if config.CollectorEndpoint != "" {
+		influxDBCollector, err := collector.NewInfluxDBCollector(config.CollectorUser, config.CollectorPass, config.CollectorEndpoint, config.CollectorDB, config.CollectorInsecureSkipVerify,
+			config.CollectorBufferSize, config.CollectorSpillDir, int64(config.CollectorSpillMaxSize)*1024*1024)
+		if err != nil {
+			zap.L().Error("Error instantiating Influx collector", zap.Error(err))
+		} else {
+			fanout.AddBackend("influxdb", influxDBCollector)
+			backends++
+		}
+	}
+	if config.CollectorSyslogAddress != "" {
+		syslogCollector, err := newSyslogCollector(config)
+		if err != nil {
+			zap.L().Error("Error instantiating syslog collector", zap.Error(err))
+		} else {
+			fanout.AddBackend("syslog", syslogCollector)
+			backends++
+		}
+	}
+	if config.CollectorOTLPEndpoint != "" {
+		otlpCollector, err := collector.NewOTLPCollector(config.CollectorOTLPEndpoint, config.CollectorOTLPInterval, config.CollectorOTLPClusterName, config.KubeNodeName, utils.GenerateNodeName(config.KubeNodeName))
+		if err != nil {
+			zap.L().Error("Error instantiating OTLP collector", zap.Error(err))
+		} else {
+			fanout.AddBackend("otlp", otlpCollector)
+			backends++
+		}
+	}
+	if backends == 0 {
+		return collector.NewDefaultCollector()
+	}
+	return fanout
+}
+
+// newEventFilter returns the EventFilter of the collected events based on the user Config.
+func newEventFilter(config *config.Configuration) (*collector.EventFilter, error) {
+	return collector.NewEventFilter(strings.Fields(config.CollectorFilterNamespaceInclude), strings.Fields(config.CollectorFilterNamespaceExclude),
+		strings.Fields(config.CollectorFilterVerdicts), config.CollectorFilterSourceSelector, config.CollectorFilterDestinationSelector)
+}
+
+// newSyslogCollector returns the SyslogCollector based on the user Config.
+func newSyslogCollector(config *config.Configuration) (*collector.SyslogCollector, error) {
+	severities, err := collector.ParseSyslogSeverities(config.CollectorSyslogSeverities)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.CollectorSyslogInsecureSkipVerify}
+	if config.CollectorSyslogCA != "" {
+		ca, err := ioutil.ReadFile(config.CollectorSyslogCA)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read syslog CA %s: %s", config.CollectorSyslogCA, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificate found in syslog CA %s", config.CollectorSyslogCA)
+		}
 	}
 
-	zap.ReplaceGlobals(logger)
-	return nil
+	return collector.NewSyslogCollector(config.CollectorSyslogNetwork, config.CollectorSyslogAddress, tlsConfig, config.CollectorSyslogFormat, config.KubeNodeName, severities)
 }
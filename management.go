@@ -0,0 +1,27 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/aporeto-inc/trireme-kubernetes/logs"
+
+	"go.uber.org/zap"
+)
+
+// startManagementServer serves the management endpoints on the address given in parameter.
+// The metrics handler is served on /metrics if not nil.
+func startManagementServer(address string, metricsHandler http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/loglevel", logs.Handler())
+	mux.Handle("/debug/vars", expvar.Handler())
+	if metricsHandler != nil {
+		mux.Handle("/metrics", metricsHandler)
+	}
+
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			zap.L().Error("Management server stopped", zap.String("address", address), zap.Error(err))
+		}
+	}()
+}
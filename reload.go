@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/aporeto-inc/trireme-kubernetes/collector"
+	"github.com/aporeto-inc/trireme-kubernetes/config"
+	"github.com/aporeto-inc/trireme-kubernetes/logs"
+	"github.com/aporeto-inc/trireme-kubernetes/resolver"
+
+	api "k8s.io/api/core/v1"
+
+	"go.uber.org/zap"
+)
+
+// watchConfig watches the configuration file and ConfigMap and applies the reloadable
+// settings at runtime until stop is closed.
+func watchConfig(currentConfig *config.Configuration, kubernetesPolicy *resolver.KubernetesPolicy, eventCollector *collector.ReloadableCollector, filteringCollector *collector.FilteringCollector, stop chan struct{}) {
+	reloader := config.NewReloader(currentConfig, func(old, updated *config.Configuration) {
+		applyConfig(old, updated, kubernetesPolicy, eventCollector, filteringCollector)
+	})
+	reloader.WatchConfigFile()
+
+	if currentConfig.ConfigMapName == "" {
+		return
+	}
+
+	updateFromConfigMap := func(configMap *api.ConfigMap) error {
+		zap.L().Info("Config ConfigMap modified", zap.String("namespace", configMap.GetNamespace()), zap.String("name", configMap.GetName()))
+		return reloader.UpdateFromConfigMap(configMap.Data)
+	}
+
+	_, configMapController := kubernetesPolicy.KubernetesClient.CreateConfigMapController(currentConfig.ConfigMapNamespace, currentConfig.ConfigMapName,
+		updateFromConfigMap,
+		func(deletedConfigMap *api.ConfigMap) error {
+			zap.L().Warn("Config ConfigMap deleted. Reverting its settings", zap.String("name", deletedConfigMap.GetName()))
+			return reloader.UpdateFromConfigMap(map[string]string{})
+		},
+		func(oldConfigMap, updatedConfigMap *api.ConfigMap) error {
+			return updateFromConfigMap(updatedConfigMap)
+		})
+	go configMapController.Run(stop)
+}
+
+// applyConfig applies the reloadable settings that changed between old and updated.
+// The collector backends are recreated if their settings changed. The Prometheus collector
+// isn't one of them so that the counters are not reset. The filter of filteringCollector is
+// replaced if its settings changed.
+func applyConfig(old, updated *config.Configuration, kubernetesPolicy *resolver.KubernetesPolicy, eventCollector *collector.ReloadableCollector, filteringCollector *collector.FilteringCollector) {
+	if old.LogLevel != updated.LogLevel {
+		if err := logs.SetConfiguredLevel(updated.LogLevel); err != nil {
+			zap.L().Error("Error changing log level", zap.Error(err))
+		}
+	}
+
+	if old.CollectorEndpoint != updated.CollectorEndpoint ||
+		old.CollectorUser != updated.CollectorUser ||
+		old.CollectorPass != updated.CollectorPass ||
+		old.CollectorDB != updated.CollectorDB ||
+		old.CollectorInsecureSkipVerify != updated.CollectorInsecureSkipVerify ||
+		old.CollectorBufferSize != updated.CollectorBufferSize ||
+		old.CollectorSpillDir != updated.CollectorSpillDir ||
+		old.CollectorSpillMaxSize != updated.CollectorSpillMaxSize ||
+		old.CollectorFile != updated.CollectorFile ||
+		old.CollectorFileMaxSize != updated.CollectorFileMaxSize ||
+		old.CollectorFileMaxAge != updated.CollectorFileMaxAge ||
+		old.CollectorFileMaxBackups != updated.CollectorFileMaxBackups ||
+		old.CollectorFileCompress != updated.CollectorFileCompress ||
+		old.CollectorSyslogAddress != updated.CollectorSyslogAddress ||
+		old.CollectorSyslogNetwork != updated.CollectorSyslogNetwork ||
+		old.CollectorSyslogFormat != updated.CollectorSyslogFormat ||
+		old.CollectorSyslogSeverities != updated.CollectorSyslogSeverities ||
+		old.CollectorSyslogCA != updated.CollectorSyslogCA ||
+		old.CollectorSyslogInsecureSkipVerify != updated.CollectorSyslogInsecureSkipVerify ||
+		old.CollectorOTLPEndpoint != updated.CollectorOTLPEndpoint ||
+		old.CollectorOTLPInterval != updated.CollectorOTLPInterval ||
+		old.CollectorOTLPClusterName != updated.CollectorOTLPClusterName ||
+		old.CollectorQueueSize != updated.CollectorQueueSize {
+		eventCollector.Swap(newEventCollector(updated))
+	}
+
+	if old.CollectorFilterNamespaceInclude != updated.CollectorFilterNamespaceInclude ||
+		old.CollectorFilterNamespaceExclude != updated.CollectorFilterNamespaceExclude ||
+		old.CollectorFilterVerdicts != updated.CollectorFilterVerdicts ||
+		old.CollectorFilterSourceSelector != updated.CollectorFilterSourceSelector ||
+		old.CollectorFilterDestinationSelector != updated.CollectorFilterDestinationSelector {
+		filter, err := newEventFilter(updated)
+		if err != nil {
+			zap.L().Error("Error changing collector filter. Keeping current filter", zap.Error(err))
+		} else {
+			filteringCollector.SetFilter(filter)
+		}
+	}
+
+	if old.AuditMode != updated.AuditMode {
+		kubernetesPolicy.SetAuditMode(updated.AuditMode)
+	}
+}
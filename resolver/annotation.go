@@ -1,5 +1,12 @@
 package resolver
 
+import (
+	"encoding/json"
+	"fmt"
+
+	api "k8s.io/api/core/v1"
+)
+
 // IngressIsolationPolicy type
 type IngressIsolationPolicy string
 
@@ -36,3 +43,84 @@ type NamespaceIngressPolicy struct {
 	// the cluster default ingress isolation policy is applied (currently allow all).
 	Isolation *IngressIsolationPolicy `json:"isolation,omitempty"`
 }
+
+// DefaultPosture is the default policy applied to the pods of a namespace
+// that are not selected by any NetworkPolicy.
+type DefaultPosture string
+
+const (
+	// PostureAllow allows all the traffic. This is the Kubernetes default.
+	PostureAllow DefaultPosture = "allow"
+	// PostureDenyIngress denies all the incoming traffic.
+	PostureDenyIngress DefaultPosture = "deny-ingress"
+	// PostureDenyEgress denies all the outgoing traffic.
+	PostureDenyEgress DefaultPosture = "deny-egress"
+	// PostureDenyAll denies both the incoming and outgoing traffic.
+	PostureDenyAll DefaultPosture = "deny-all"
+)
+
+// deniesIngress returns true if the posture denies the incoming traffic.
+func (p DefaultPosture) deniesIngress() bool {
+	return p == PostureDenyIngress || p == PostureDenyAll
+}
+
+// deniesEgress returns true if the posture denies the outgoing traffic.
+func (p DefaultPosture) deniesEgress() bool {
+	return p == PostureDenyEgress || p == PostureDenyAll
+}
+
+// withIngressDenied returns the posture also denying the incoming traffic.
+func (p DefaultPosture) withIngressDenied() DefaultPosture {
+	if p.deniesEgress() {
+		return PostureDenyAll
+	}
+	return PostureDenyIngress
+}
+
+// withEgressDenied returns the posture also denying the outgoing traffic.
+func (p DefaultPosture) withEgressDenied() DefaultPosture {
+	if p.deniesIngress() {
+		return PostureDenyAll
+	}
+	return PostureDenyEgress
+}
+
+// namespacePosture returns the default posture of the namespace under GA NetworkPolicies.
+// The posture is read from the DefaultPostureAnnotationID annotation. The deprecated Beta
+// DefaultDeny annotation only activates the namespace under Beta NetworkPolicies and is
+// ignored here.
+func namespacePosture(namespace *api.Namespace) (DefaultPosture, error) {
+	if value, ok := namespace.GetAnnotations()[DefaultPostureAnnotationID]; ok {
+		switch posture := DefaultPosture(value); posture {
+		case PostureAllow, PostureDenyIngress, PostureDenyEgress, PostureDenyAll:
+			return posture, nil
+		default:
+			return PostureAllow, fmt.Errorf("Invalid %s annotation %s on namespace %s", DefaultPostureAnnotationID, value, namespace.GetName())
+		}
+	}
+	return PostureAllow, nil
+}
+
+// isNamespaceNetworkPolicyActive returns true if the namespace has NetworkPolicies
+// activated on the annotation
+func isNamespaceNetworkPolicyActive(namespace *api.Namespace) bool {
+	// Check if annotation is present. As NetworkPolicies in K8s are still beta
+	// The format needs to be manually parsed out of JSON.
+	value, ok := namespace.GetAnnotations()[KubernetesNetworkPolicyAnnotationID]
+
+	if !ok {
+		return false
+	}
+	networkPolicyAnnotation := &NamespaceNetworkPolicy{}
+	if err := json.Unmarshal([]byte(value), networkPolicyAnnotation); err != nil {
+		return false
+	}
+
+	if networkPolicyAnnotation != nil &&
+		networkPolicyAnnotation.Ingress != nil &&
+		networkPolicyAnnotation.Ingress.Isolation != nil &&
+		*networkPolicyAnnotation.Ingress.Isolation == DefaultDeny {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package resolver
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+
+	api "k8s.io/api/core/v1"
+
+	"go.uber.org/zap"
+)
+
+// Keys of the break-glass ConfigMap data.
+const (
+	// BreakGlassEnabledKey activates the break-glass when set to true.
+	BreakGlassEnabledKey = "enabled"
+	// BreakGlassExpiresKey is an optional RFC3339 time after which the break-glass is deactivated.
+	BreakGlassExpiresKey = "expires"
+)
+
+// breakGlassReminderInterval is the interval at which the active break-glass is logged.
+const breakGlassReminderInterval = time.Minute
+
+// breakGlass keeps track of the cluster-wide emergency switch that makes every
+// pod policy AllowAll.
+type breakGlass struct {
+	active   bool
+	expires  time.Time
+	expiry   *time.Timer
+	reminder chan struct{}
+	sync.Mutex
+}
+
+// SetBreakGlassConfigMap registers the ConfigMap that is watched for the break-glass switch.
+// Must be called before Run.
+func (k *KubernetesPolicy) SetBreakGlassConfigMap(namespace string, name string) {
+	k.breakGlassNamespace = namespace
+	k.breakGlassName = name
+}
+
+// isBreakGlassActive returns true if the break-glass is currently active.
+func (k *KubernetesPolicy) isBreakGlassActive() bool {
+	k.breakGlass.Lock()
+	defer k.breakGlass.Unlock()
+	return k.breakGlass.active
+}
+
+// watchBreakGlass starts watching the break-glass ConfigMap if one was given.
+func (k *KubernetesPolicy) watchBreakGlass() {
+	if k.breakGlassName == "" {
+		return
+	}
+
+	_, breakGlassController := k.KubernetesClient.CreateConfigMapController(k.breakGlassNamespace, k.breakGlassName,
+		k.updateBreakGlass,
+		func(deletedConfigMap *api.ConfigMap) error {
+			k.setBreakGlass(false, time.Time{})
+			return nil
+		},
+		func(oldConfigMap, updatedConfigMap *api.ConfigMap) error {
+			return k.updateBreakGlass(updatedConfigMap)
+		})
+	go breakGlassController.Run(k.stopAll)
+}
+
+// updateBreakGlass applies the break-glass state defined in the ConfigMap.
+func (k *KubernetesPolicy) updateBreakGlass(configMap *api.ConfigMap) error {
+	active, expires, err := parseBreakGlass(configMap.Data, time.Now())
+	if err != nil {
+		return fmt.Errorf("Invalid break-glass ConfigMap %s/%s: %s", configMap.GetNamespace(), configMap.GetName(), err)
+	}
+
+	k.setBreakGlass(active, expires)
+	return nil
+}
+
+// parseBreakGlass returns the break-glass state from the ConfigMap data.
+// An expiry time in the past deactivates the break-glass.
+func parseBreakGlass(data map[string]string, now time.Time) (bool, time.Time, error) {
+	enabled, ok := data[BreakGlassEnabledKey]
+	if !ok || enabled == "" {
+		return false, time.Time{}, nil
+	}
+
+	active, err := strconv.ParseBool(enabled)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("Invalid %s value %s: %s", BreakGlassEnabledKey, enabled, err)
+	}
+	if !active {
+		return false, time.Time{}, nil
+	}
+
+	value, ok := data[BreakGlassExpiresKey]
+	if !ok || value == "" {
+		return true, time.Time{}, nil
+	}
+
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("Invalid %s value %s: %s", BreakGlassExpiresKey, value, err)
+	}
+	if !expires.After(now) {
+		return false, time.Time{}, nil
+	}
+
+	return true, expires, nil
+}
+
+// setBreakGlass changes the break-glass state and re-resolves every pod policy
+// if the state changed.
+func (k *KubernetesPolicy) setBreakGlass(active bool, expires time.Time) {
+	k.breakGlass.Lock()
+
+	if k.breakGlass.active == active && k.breakGlass.expires.Equal(expires) {
+		k.breakGlass.Unlock()
+		return
+	}
+	changed := k.breakGlass.active != active
+
+	if k.breakGlass.expiry != nil {
+		k.breakGlass.expiry.Stop()
+		k.breakGlass.expiry = nil
+	}
+	if !expires.IsZero() {
+		k.breakGlass.expiry = time.AfterFunc(time.Until(expires), func() {
+			k.expireBreakGlass(expires)
+		})
+	}
+
+	k.breakGlass.active = active
+	k.breakGlass.expires = expires
+
+	if changed && active {
+		k.breakGlass.reminder = make(chan struct{})
+		go remindBreakGlass(k.breakGlass.reminder)
+	}
+	if changed && !active && k.breakGlass.reminder != nil {
+		close(k.breakGlass.reminder)
+		k.breakGlass.reminder = nil
+	}
+
+	k.breakGlass.Unlock()
+
+	if !changed {
+		logger().Warn("Break-glass expiry changed", zap.Time("expires", expires))
+		return
+	}
+
+	if active {
+		logger().Error("BREAK-GLASS ACTIVATED. Every pod policy is now AllowAll", zap.Time("expires", expires))
+		k.reportBreakGlassEvent(EventBreakGlassActivated)
+	} else {
+		logger().Warn("Break-glass deactivated. Resuming normal policy resolution")
+		k.reportBreakGlassEvent(EventBreakGlassDeactivated)
+	}
+
+	k.updateAllPodPolicies()
+}
+
+// expireBreakGlass deactivates the break-glass if it is still set to expire at the given time.
+func (k *KubernetesPolicy) expireBreakGlass(expires time.Time) {
+	k.breakGlass.Lock()
+	expired := k.breakGlass.active && k.breakGlass.expires.Equal(expires)
+	k.breakGlass.Unlock()
+
+	if !expired {
+		return
+	}
+	logger().Warn("Break-glass expired", zap.Time("expires", expires))
+	k.setBreakGlass(false, time.Time{})
+}
+
+// stopBreakGlass stops the break-glass expiry timer and reminder.
+func (k *KubernetesPolicy) stopBreakGlass() {
+	k.breakGlass.Lock()
+	defer k.breakGlass.Unlock()
+
+	if k.breakGlass.expiry != nil {
+		k.breakGlass.expiry.Stop()
+		k.breakGlass.expiry = nil
+	}
+	// A timer already fired will not match the expiry anymore.
+	k.breakGlass.expires = time.Time{}
+
+	if k.breakGlass.reminder != nil {
+		close(k.breakGlass.reminder)
+		k.breakGlass.reminder = nil
+	}
+}
+
+// remindBreakGlass logs periodically that the break-glass is active until stop is closed.
+func remindBreakGlass(stop chan struct{}) {
+	ticker := time.NewTicker(breakGlassReminderInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			logger().Error("BREAK-GLASS ACTIVE. Network policies are not enforced")
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reportBreakGlassEvent reports a cluster-wide break-glass event to the collector.
+func (k *KubernetesPolicy) reportBreakGlassEvent(event string) {
+	if k.eventCollector == nil {
+		return
+	}
+
+	k.eventCollector.CollectContainerEvent(&collector.ContainerRecord{
+		Event: event,
+	})
+}
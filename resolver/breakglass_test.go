@@ -0,0 +1,64 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+)
+
+var now = time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+
+var parseBreakGlassTests = []struct {
+	data    map[string]string
+	active  bool
+	expires time.Time
+	valid   bool
+}{
+	{nil, false, time.Time{}, true},
+	{map[string]string{BreakGlassEnabledKey: ""}, false, time.Time{}, true},
+	{map[string]string{BreakGlassEnabledKey: "false"}, false, time.Time{}, true},
+	{map[string]string{BreakGlassEnabledKey: "true"}, true, time.Time{}, true},
+	{map[string]string{BreakGlassEnabledKey: "yes"}, false, time.Time{}, false},
+	{map[string]string{BreakGlassEnabledKey: "true", BreakGlassExpiresKey: "2017-10-01T13:00:00Z"}, true, now.Add(time.Hour), true},
+	{map[string]string{BreakGlassEnabledKey: "true", BreakGlassExpiresKey: "2017-10-01T12:00:00Z"}, false, time.Time{}, true},
+	{map[string]string{BreakGlassEnabledKey: "true", BreakGlassExpiresKey: "2017-10-01T11:00:00Z"}, false, time.Time{}, true},
+	{map[string]string{BreakGlassEnabledKey: "true", BreakGlassExpiresKey: "tomorrow"}, false, time.Time{}, false},
+	{map[string]string{BreakGlassEnabledKey: "false", BreakGlassExpiresKey: "tomorrow"}, false, time.Time{}, true},
+}
+
+func TestParseBreakGlass(t *testing.T) {
+	for _, tt := range parseBreakGlassTests {
+		active, expires, err := parseBreakGlass(tt.data, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("parseBreakGlass(%v) => error %v, expected valid %t", tt.data, err, tt.valid)
+		}
+		if active != tt.active || !expires.Equal(tt.expires) {
+			t.Errorf("parseBreakGlass(%v) => %t %s, expected %t %s", tt.data, active, expires, tt.active, tt.expires)
+		}
+	}
+}
+
+func TestStopBreakGlass(t *testing.T) {
+	k := &KubernetesPolicy{cache: newCache()}
+
+	k.setBreakGlass(true, time.Now().Add(time.Hour))
+	if !k.isBreakGlassActive() || k.breakGlass.expiry == nil || k.breakGlass.reminder == nil {
+		t.Fatalf("setBreakGlass => expected an active break-glass with its expiry timer and reminder")
+	}
+
+	reminder := k.breakGlass.reminder
+	k.stopBreakGlass()
+	if k.breakGlass.expiry != nil || k.breakGlass.reminder != nil {
+		t.Errorf("stopBreakGlass => expected the expiry timer and reminder to be stopped")
+	}
+	select {
+	case <-reminder:
+	default:
+		t.Errorf("stopBreakGlass => expected the reminder to be closed")
+	}
+
+	// Deactivating after Stop must not close the reminder again.
+	k.setBreakGlass(false, time.Time{})
+	if k.isBreakGlassActive() {
+		t.Errorf("setBreakGlass(false) => expected the break-glass to be inactive")
+	}
+}
@@ -6,7 +6,11 @@ import (
 )
 
 type podCacheEntry struct {
-	contextID string
+	contextID    string
+	podName      string
+	podNamespace string
+	// override is the event of the policy overriding the NetworkPolicies of the pod, if any.
+	override string
 }
 
 // Cache keeps all the state needed for the integration.
@@ -15,6 +19,8 @@ type cache struct {
 	namespaceActivation map[string]*NamespaceWatcher
 	// contextIDCache keeps a mapping between a POD/Namespace name and the corresponding contextID from Trireme.
 	podCache map[string]podCacheEntry
+	// contextIDs indexes the podCache keys by contextID.
+	contextIDs map[string]string
 	sync.RWMutex
 }
 
@@ -22,6 +28,7 @@ func newCache() *cache {
 	return &cache{
 		namespaceActivation: map[string]*NamespaceWatcher{},
 		podCache:            map[string]podCacheEntry{},
+		contextIDs:          map[string]string{},
 	}
 }
 
@@ -33,7 +40,30 @@ func (c *cache) addPodToCache(contextID string, podName string, podNamespace str
 	c.Lock()
 	defer c.Unlock()
 	kubeIdentifier := kubePodIdentifier(podName, podNamespace)
-	c.podCache[kubeIdentifier] = podCacheEntry{contextID: contextID}
+	if previous, ok := c.podCache[kubeIdentifier]; ok {
+		delete(c.contextIDs, previous.contextID)
+	}
+	c.contextIDs[contextID] = kubeIdentifier
+	c.podCache[kubeIdentifier] = podCacheEntry{
+		contextID:    contextID,
+		podName:      podName,
+		podNamespace: podNamespace,
+	}
+}
+
+// setPodOverride records the override of the pod and returns the previous one.
+func (c *cache) setPodOverride(podName string, podNamespace string, override string) string {
+	c.Lock()
+	defer c.Unlock()
+	kubeIdentifier := kubePodIdentifier(podName, podNamespace)
+	cacheEntry, ok := c.podCache[kubeIdentifier]
+	if !ok {
+		return ""
+	}
+	previous := cacheEntry.override
+	cacheEntry.override = override
+	c.podCache[kubeIdentifier] = cacheEntry
+	return previous
 }
 
 func (c *cache) contextIDByPodName(podName string, podNamespace string) (string, error) {
@@ -51,14 +81,37 @@ func (c *cache) deleteFromCacheByPodName(podName string, podNamespace string) er
 	c.Lock()
 	defer c.Unlock()
 	kubeIdentifier := kubePodIdentifier(podName, podNamespace)
-	_, ok := c.podCache[kubeIdentifier]
+	cacheEntry, ok := c.podCache[kubeIdentifier]
 	if !ok {
 		return fmt.Errorf("Pod %v not found in Cache", kubeIdentifier)
 	}
+	delete(c.contextIDs, cacheEntry.contextID)
 	delete(c.podCache, kubeIdentifier)
 	return nil
 }
 
+// podByContextID returns the pod entry of the contextID.
+func (c *cache) podByContextID(contextID string) (podCacheEntry, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	kubeIdentifier, ok := c.contextIDs[contextID]
+	if !ok {
+		return podCacheEntry{}, false
+	}
+	return c.podCache[kubeIdentifier], true
+}
+
+// allPods returns a copy of all the pod entries currently in cache.
+func (c *cache) allPods() []podCacheEntry {
+	c.RLock()
+	defer c.RUnlock()
+	entries := make([]podCacheEntry, 0, len(c.podCache))
+	for _, entry := range c.podCache {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
 func (c *cache) getNamespaceWatcher(namespace string) (*NamespaceWatcher, bool) {
 	c.Lock()
 	defer c.Unlock()
@@ -0,0 +1,33 @@
+package resolver
+
+import "testing"
+
+func TestPodByContextID(t *testing.T) {
+	c := newCache()
+	c.addPodToCache("1234", "nginx", "shop")
+	c.addPodToCache("5678", "redis", "shop")
+
+	entry, ok := c.podByContextID("1234")
+	if !ok || entry.podName != "nginx" || entry.podNamespace != "shop" {
+		t.Errorf("podByContextID(1234) => %v %t, expected shop/nginx", entry, ok)
+	}
+
+	// A new contextID for the same pod replaces the previous one.
+	c.addPodToCache("4321", "nginx", "shop")
+	if _, ok := c.podByContextID("1234"); ok {
+		t.Errorf("podByContextID(1234) => found after the pod contextID changed")
+	}
+	if entry, ok := c.podByContextID("4321"); !ok || entry.podName != "nginx" {
+		t.Errorf("podByContextID(4321) => %v %t, expected shop/nginx", entry, ok)
+	}
+
+	if err := c.deleteFromCacheByPodName("nginx", "shop"); err != nil {
+		t.Fatalf("deleteFromCacheByPodName => unexpected error %s", err)
+	}
+	if _, ok := c.podByContextID("4321"); ok {
+		t.Errorf("podByContextID(4321) => found after the pod was deleted")
+	}
+	if _, ok := c.podByContextID("5678"); !ok {
+		t.Errorf("podByContextID(5678) => not found")
+	}
+}
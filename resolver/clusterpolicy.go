@@ -0,0 +1,376 @@
+package resolver
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aporeto-inc/trireme-kubernetes/kubernetes"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubecache "k8s.io/client-go/tools/cache"
+
+	"go.uber.org/zap"
+)
+
+// clusterRules are the rules generated from the ClusterNetworkPolicies applying to a pod.
+// They are evaluated before the rules generated from the NetworkPolicies.
+type clusterRules struct {
+	receiverRules    []policy.TagSelector
+	transmitterRules []policy.TagSelector
+	networkACLs      []policy.IPRule
+	applicationACLs  []policy.IPRule
+}
+
+// SetClusterNetworkPolicies enables the ClusterNetworkPolicy CRD support. The CRD must be
+// installed in the cluster, or Run fails. Must be called before Run.
+func (k *KubernetesPolicy) SetClusterNetworkPolicies(enabled bool) {
+	k.clusterPoliciesEnabled = enabled
+}
+
+// watchClusterNetworkPolicies starts watching the ClusterNetworkPolicies if enabled. It returns
+// the function reporting if the store is synced. The CRD is checked first, as the store of a
+// missing CRD would never sync.
+func (k *KubernetesPolicy) watchClusterNetworkPolicies() (kubecache.InformerSynced, error) {
+	if !k.clusterPoliciesEnabled {
+		return nil, nil
+	}
+
+	if err := k.KubernetesClient.CheckClusterNetworkPolicyCRD(); err != nil {
+		return nil, err
+	}
+
+	store, controller, err := k.KubernetesClient.CreateClusterNetworkPolicyController(
+		k.addClusterNetworkPolicy,
+		k.deleteClusterNetworkPolicy,
+		k.updateClusterNetworkPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	k.clusterPolicies = store
+	go controller.Run(k.stopAll)
+	return controller.HasSynced, nil
+}
+
+func (k *KubernetesPolicy) addClusterNetworkPolicy(addedPolicy *kubernetes.ClusterNetworkPolicy) error {
+	logger().Info("ClusterNetworkPolicy added", zap.String("name", addedPolicy.GetName()), zap.Int32("priority", addedPolicy.Spec.Priority))
+	k.checkClusterNetworkPolicy(addedPolicy)
+	k.updateAllPodPolicies()
+	return nil
+}
+
+func (k *KubernetesPolicy) deleteClusterNetworkPolicy(deletedPolicy *kubernetes.ClusterNetworkPolicy) error {
+	logger().Info("ClusterNetworkPolicy deleted", zap.String("name", deletedPolicy.GetName()))
+	k.updateAllPodPolicies()
+	return nil
+}
+
+func (k *KubernetesPolicy) updateClusterNetworkPolicy(oldPolicy, updatedPolicy *kubernetes.ClusterNetworkPolicy) error {
+	logger().Info("ClusterNetworkPolicy modified", zap.String("name", updatedPolicy.GetName()), zap.Int32("priority", updatedPolicy.Spec.Priority))
+	k.checkClusterNetworkPolicy(updatedPolicy)
+	k.updateAllPodPolicies()
+	return nil
+}
+
+// checkClusterNetworkPolicy logs and reports the ClusterNetworkPolicy if it is invalid. The
+// invalid policies are skipped when the pod policies are resolved.
+func (k *KubernetesPolicy) checkClusterNetworkPolicy(clusterPolicy *kubernetes.ClusterNetworkPolicy) {
+	err := validateClusterNetworkPolicy(clusterPolicy)
+	if err == nil {
+		return
+	}
+	logger().Error("Invalid ClusterNetworkPolicy. Skipping it", zap.String("name", clusterPolicy.GetName()), zap.Error(err))
+
+	if k.eventCollector == nil {
+		return
+	}
+	k.eventCollector.CollectContainerEvent(&collector.ContainerRecord{
+		Tags: policy.NewTagStoreFromMap(map[string]string{
+			"@clusternetworkpolicy": clusterPolicy.GetName(),
+		}),
+		Event: EventClusterNetworkPolicyInvalid,
+	})
+}
+
+// validateClusterNetworkPolicy returns an error if the selectors or the rules of the
+// ClusterNetworkPolicy are invalid.
+func validateClusterNetworkPolicy(clusterPolicy *kubernetes.ClusterNetworkPolicy) error {
+	_, err := clusterPolicyRules(clusterPolicy, &api.Pod{}, labels.Set{}, &api.NamespaceList{})
+	return err
+}
+
+// sortedClusterNetworkPolicies returns all the known ClusterNetworkPolicies ordered by priority.
+func (k *KubernetesPolicy) sortedClusterNetworkPolicies() []*kubernetes.ClusterNetworkPolicy {
+	if k.clusterPolicies == nil {
+		return nil
+	}
+
+	clusterPolicies := []*kubernetes.ClusterNetworkPolicy{}
+	for _, item := range k.clusterPolicies.List() {
+		clusterPolicies = append(clusterPolicies, item.(*kubernetes.ClusterNetworkPolicy))
+	}
+
+	// The name gives a deterministic order between policies with the same priority.
+	sort.Slice(clusterPolicies, func(i, j int) bool {
+		if clusterPolicies[i].Spec.Priority != clusterPolicies[j].Spec.Priority {
+			return clusterPolicies[i].Spec.Priority < clusterPolicies[j].Spec.Priority
+		}
+		return clusterPolicies[i].GetName() < clusterPolicies[j].GetName()
+	})
+	return clusterPolicies
+}
+
+// generateClusterRules generates the rules of all the ClusterNetworkPolicies applying to the pod,
+// in priority order. The invalid ClusterNetworkPolicies are skipped, so that one of them can't
+// break the resolution of every pod it selects. They are reported by checkClusterNetworkPolicy.
+func generateClusterRules(clusterPolicies []*kubernetes.ClusterNetworkPolicy, pod *api.Pod, allNamespaces *api.NamespaceList) *clusterRules {
+	rules := &clusterRules{}
+
+	namespaceLabels := labels.Set{}
+	for _, namespace := range allNamespaces.Items {
+		if namespace.GetName() == pod.GetNamespace() {
+			namespaceLabels = labels.Set(namespace.GetLabels())
+		}
+	}
+
+	for _, clusterPolicy := range clusterPolicies {
+		policyRules, err := clusterPolicyRules(clusterPolicy, pod, namespaceLabels, allNamespaces)
+		if err != nil {
+			logger().Debug("Skipping invalid ClusterNetworkPolicy", zap.String("name", clusterPolicy.GetName()), zap.Error(err))
+			continue
+		}
+		rules.receiverRules = append(rules.receiverRules, policyRules.receiverRules...)
+		rules.networkACLs = append(rules.networkACLs, policyRules.networkACLs...)
+		rules.transmitterRules = append(rules.transmitterRules, policyRules.transmitterRules...)
+		rules.applicationACLs = append(rules.applicationACLs, policyRules.applicationACLs...)
+	}
+
+	return rules
+}
+
+// clusterPolicyRules generates the rules of the ClusterNetworkPolicy for the pod, which are
+// empty if it doesn't apply to the pod. The whole policy is checked even then.
+func clusterPolicyRules(clusterPolicy *kubernetes.ClusterNetworkPolicy, pod *api.Pod, namespaceLabels labels.Set, allNamespaces *api.NamespaceList) (*clusterRules, error) {
+	rules := &clusterRules{}
+
+	applies, err := clusterPolicyApplies(clusterPolicy, pod, namespaceLabels)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ClusterNetworkPolicy %s: %s", clusterPolicy.GetName(), err)
+	}
+
+	for _, rule := range clusterPolicy.Spec.Ingress {
+		selectors, acls, err := clusterRuleSelectors(rule, allNamespaces)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ClusterNetworkPolicy %s: %s", clusterPolicy.GetName(), err)
+		}
+		rules.receiverRules = append(rules.receiverRules, selectors...)
+		rules.networkACLs = append(rules.networkACLs, acls...)
+	}
+
+	for _, rule := range clusterPolicy.Spec.Egress {
+		selectors, acls, err := clusterRuleSelectors(rule, allNamespaces)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ClusterNetworkPolicy %s: %s", clusterPolicy.GetName(), err)
+		}
+		rules.transmitterRules = append(rules.transmitterRules, selectors...)
+		rules.applicationACLs = append(rules.applicationACLs, acls...)
+	}
+
+	if !applies {
+		return &clusterRules{}, nil
+	}
+	return rules, nil
+}
+
+// allowAllPodPolicy returns the AllowAll policy of the pod, preceded by the rules of the
+// ClusterNetworkPolicies applying to it, which cannot be overridden.
+func (k *KubernetesPolicy) allowAllPodPolicy(pod *api.Pod, tags *policy.TagStore, ips policy.ExtendedMap, triremeNetworks []string) (*policy.PUPolicy, error) {
+	clusterPolicies := k.sortedClusterNetworkPolicies()
+	if len(clusterPolicies) == 0 {
+		return allowAllPolicy(tags, ips, triremeNetworks), nil
+	}
+
+	allNamespaces, err := k.KubernetesClient.AllNamespaces()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't list the namespaces: %s", err)
+	}
+	return clusterAllowAllPolicy(generateClusterRules(clusterPolicies, pod, allNamespaces), tags, ips, triremeNetworks), nil
+}
+
+// clusterPolicyApplies returns true if the ClusterNetworkPolicy selects the pod.
+func clusterPolicyApplies(clusterPolicy *kubernetes.ClusterNetworkPolicy, pod *api.Pod, namespaceLabels labels.Set) (bool, error) {
+	if clusterPolicy.Spec.NamespaceSelector != nil {
+		namespaceSelector, err := metav1.LabelSelectorAsSelector(clusterPolicy.Spec.NamespaceSelector)
+		if err != nil {
+			return false, fmt.Errorf("Error while parsing namespace label selector %s", err)
+		}
+		if !namespaceSelector.Matches(namespaceLabels) {
+			return false, nil
+		}
+	}
+
+	podSelector, err := metav1.LabelSelectorAsSelector(&clusterPolicy.Spec.PodSelector)
+	if err != nil {
+		return false, fmt.Errorf("Error while parsing pod label selector %s", err)
+	}
+	return podSelector.Matches(labels.Set(pod.GetLabels())), nil
+}
+
+// clusterRuleAction translates the ClusterNetworkPolicy action into a Trireme action.
+func clusterRuleAction(action kubernetes.ClusterNetworkPolicyAction) (policy.ActionType, error) {
+	switch action {
+	case kubernetes.ClusterNetworkPolicyAccept:
+		return policy.Accept, nil
+	case kubernetes.ClusterNetworkPolicyReject:
+		return policy.Reject, nil
+	default:
+		return 0, fmt.Errorf("Unknown action %s", action)
+	}
+}
+
+// clusterRuleSelectors generates the TagSelectors matching the Trireme peers and the IPRules
+// matching the IPBlock peers of a ClusterNetworkPolicy rule.
+func clusterRuleSelectors(rule kubernetes.ClusterNetworkPolicyRule, allNamespaces *api.NamespaceList) ([]policy.TagSelector, []policy.IPRule, error) {
+	action, err := clusterRuleAction(rule.Action)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Empty ports match every port.
+	ports := rule.Ports
+	if len(ports) == 0 {
+		ports = nil
+	}
+	portClause := portSelector(ports)
+	for _, port := range ports {
+		// A port entry without port number matches every port.
+		if port.Port == nil {
+			portClause = []policy.KeyValueOperator{}
+		}
+	}
+
+	// No peers: every Trireme and external endpoint is matched.
+	if len(rule.Peers) == 0 {
+		selector := policy.TagSelector{
+			Clause: append(portClause, rulesAllowAll()[0].Clause...),
+			Policy: &policy.FlowPolicy{
+				Action: action,
+			},
+		}
+		acls, err := clusterRuleACLs("0.0.0.0/0", ports, action)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []policy.TagSelector{selector}, acls, nil
+	}
+
+	selectors := []policy.TagSelector{}
+	ipRules := []policy.IPRule{}
+	for _, peer := range rule.Peers {
+		// Each peer is ORed.
+		if peer.IPBlock != nil {
+			if len(peer.IPBlock.Except) > 0 {
+				return nil, nil, fmt.Errorf("IPBlock except is not supported")
+			}
+			acls, err := clusterRuleACLs(peer.IPBlock.CIDR, ports, action)
+			if err != nil {
+				return nil, nil, err
+			}
+			ipRules = append(ipRules, acls...)
+			continue
+		}
+
+		completeClause := []policy.KeyValueOperator{}
+		completeClause = append(completeClause, portClause...)
+
+		// Without namespace selector, the pods of all the namespaces are matched.
+		if peer.NamespaceSelector != nil {
+			namespaces, err := matchingNamespaces(peer.NamespaceSelector, allNamespaces)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(namespaces) == 0 {
+				continue
+			}
+			completeClause = append(completeClause, policy.KeyValueOperator{
+				Key:      "@namespace",
+				Operator: policy.Equal,
+				Value:    namespaces,
+			})
+		} else {
+			completeClause = append(completeClause, rulesAllowAll()[0].Clause...)
+		}
+
+		if peer.PodSelector != nil {
+			podSelector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+			}
+			podRequirements, _ := podSelector.Requirements()
+			completeClause = append(completeClause, requirementsClause(podRequirements)...)
+		}
+
+		selectors = append(selectors, policy.TagSelector{
+			Clause: completeClause,
+			Policy: &policy.FlowPolicy{
+				Action: action,
+			},
+		})
+	}
+
+	return selectors, ipRules, nil
+}
+
+// clusterRuleACLs generates the IPRules for a CIDR on the given ports. All ports are
+// matched if none are given.
+func clusterRuleACLs(cidr string, ports []networking.NetworkPolicyPort, action policy.ActionType) ([]policy.IPRule, error) {
+	if len(ports) == 0 {
+		acls := []policy.IPRule{}
+		for _, protocol := range []string{"TCP", "UDP"} {
+			acls = append(acls, policy.IPRule{
+				Address:  cidr,
+				Port:     "0:65535",
+				Protocol: protocol,
+				Policy: &policy.FlowPolicy{
+					Action: action,
+				},
+			})
+		}
+		return acls, nil
+	}
+
+	acls := []policy.IPRule{}
+	for _, portEntry := range ports {
+		proto := "TCP"
+		if portEntry.Protocol != nil {
+			switch *portEntry.Protocol {
+			case api.ProtocolTCP:
+			case api.ProtocolUDP:
+				proto = "UDP"
+			default:
+				return nil, fmt.Errorf("Unknown ProtocolType")
+			}
+		}
+
+		port := "0:65535"
+		if portEntry.Port != nil {
+			port = portEntry.Port.String()
+		}
+
+		acls = append(acls, policy.IPRule{
+			Address:  cidr,
+			Port:     port,
+			Protocol: proto,
+			Policy: &policy.FlowPolicy{
+				Action: action,
+			},
+		})
+	}
+	return acls, nil
+}
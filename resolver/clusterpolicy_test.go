@@ -0,0 +1,241 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme-kubernetes/kubernetes"
+
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubecache "k8s.io/client-go/tools/cache"
+)
+
+func clusterPolicy(name string, priority int32, podLabels map[string]string, ingress ...kubernetes.ClusterNetworkPolicyRule) *kubernetes.ClusterNetworkPolicy {
+	return &kubernetes.ClusterNetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: kubernetes.ClusterNetworkPolicySpec{
+			Priority:    priority,
+			PodSelector: metav1.LabelSelector{MatchLabels: podLabels},
+			Ingress:     ingress,
+		},
+	}
+}
+
+func clusterRule(action kubernetes.ClusterNetworkPolicyAction, peers []networking.NetworkPolicyPeer, ports ...int) kubernetes.ClusterNetworkPolicyRule {
+	rule := kubernetes.ClusterNetworkPolicyRule{Action: action, Peers: peers}
+	for _, port := range ports {
+		portNumber := intstr.FromInt(port)
+		rule.Ports = append(rule.Ports, networking.NetworkPolicyPort{Port: &portNumber})
+	}
+	return rule
+}
+
+var clusterNamespaces = &api.NamespaceList{Items: []api.Namespace{
+	*namespace("shop", map[string]string{"env": "prod"}),
+	*namespace("ci", map[string]string{"env": "ci"}),
+	*namespace("monitoring", map[string]string{"env": "prod", "team": "ops"}),
+}}
+
+// clauseValues returns the values of the clause key, or nil if the key is missing.
+func clauseValues(selector policy.TagSelector, key string) []string {
+	for _, kvo := range selector.Clause {
+		if kvo.Key == key {
+			return kvo.Value
+		}
+	}
+	return nil
+}
+
+var clusterRuleSelectorsTests = []struct {
+	name       string
+	rule       kubernetes.ClusterNetworkPolicyRule
+	selectors  int
+	namespaces []string
+	ports      []string
+	acls       []string
+	action     policy.ActionType
+	valid      bool
+}{
+	{"no peers", clusterRule(kubernetes.ClusterNetworkPolicyAccept, nil), 1, []string{"*"}, nil, []string{"0.0.0.0/0:0:65535", "0.0.0.0/0:0:65535"}, policy.Accept, true},
+	{"no peers on ports", clusterRule(kubernetes.ClusterNetworkPolicyReject, nil, 80, 443), 1, []string{"*"}, []string{"80", "443"}, []string{"0.0.0.0/0:80", "0.0.0.0/0:443"}, policy.Reject, true},
+	{"namespace selector", clusterRule(kubernetes.ClusterNetworkPolicyAccept, []networking.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}}}), 1, []string{"shop", "monitoring"}, nil, nil, policy.Accept, true},
+	{"namespace selector without match", clusterRule(kubernetes.ClusterNetworkPolicyAccept, []networking.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "dev"}}}}), 0, nil, nil, nil, policy.Accept, true},
+	{"pod selector in all namespaces", clusterRule(kubernetes.ClusterNetworkPolicyReject, []networking.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "scanner"}}}}, 22), 1, []string{"*"}, []string{"22"}, nil, policy.Reject, true},
+	{"IPBlock", clusterRule(kubernetes.ClusterNetworkPolicyReject, []networking.NetworkPolicyPeer{{IPBlock: &networking.IPBlock{CIDR: "10.1.0.0/16"}}}, 22), 0, nil, nil, []string{"10.1.0.0/16:22"}, policy.Reject, true},
+	{"IPBlock with except", clusterRule(kubernetes.ClusterNetworkPolicyReject, []networking.NetworkPolicyPeer{{IPBlock: &networking.IPBlock{CIDR: "10.1.0.0/16", Except: []string{"10.1.1.0/24"}}}}), 0, nil, nil, nil, policy.Reject, false},
+	{"unknown action", clusterRule("Drop", nil), 0, nil, nil, nil, policy.Reject, false},
+}
+
+func TestClusterRuleSelectors(t *testing.T) {
+	for _, tt := range clusterRuleSelectorsTests {
+		selectors, acls, err := clusterRuleSelectors(tt.rule, clusterNamespaces)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: clusterRuleSelectors => error %v, expected valid %t", tt.name, err, tt.valid)
+		}
+		if err != nil {
+			continue
+		}
+
+		if len(selectors) != tt.selectors {
+			t.Fatalf("%s: clusterRuleSelectors => %d selectors, expected %d", tt.name, len(selectors), tt.selectors)
+		}
+		for _, selector := range selectors {
+			if selector.Policy.Action != tt.action {
+				t.Errorf("%s: clusterRuleSelectors => action %v, expected %v", tt.name, selector.Policy.Action, tt.action)
+			}
+			if namespaces := clauseValues(selector, "@namespace"); !equalStrings(namespaces, tt.namespaces) {
+				t.Errorf("%s: clusterRuleSelectors => namespaces %v, expected %v", tt.name, namespaces, tt.namespaces)
+			}
+			if ports := clauseValues(selector, "$sys:port"); !equalStrings(ports, tt.ports) {
+				t.Errorf("%s: clusterRuleSelectors => ports %v, expected %v", tt.name, ports, tt.ports)
+			}
+		}
+
+		aclStrings := []string{}
+		for _, acl := range acls {
+			if acl.Policy.Action != tt.action {
+				t.Errorf("%s: clusterRuleSelectors => ACL action %v, expected %v", tt.name, acl.Policy.Action, tt.action)
+			}
+			aclStrings = append(aclStrings, acl.Address+":"+acl.Port)
+		}
+		if len(aclStrings) == 0 {
+			aclStrings = nil
+		}
+		if !equalStrings(aclStrings, tt.acls) {
+			t.Errorf("%s: clusterRuleSelectors => ACLs %v, expected %v", tt.name, aclStrings, tt.acls)
+		}
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSortedClusterNetworkPolicies(t *testing.T) {
+	k := &KubernetesPolicy{}
+	if clusterPolicies := k.sortedClusterNetworkPolicies(); clusterPolicies != nil {
+		t.Errorf("sortedClusterNetworkPolicies without store => %v, expected nil", clusterPolicies)
+	}
+
+	k.clusterPolicies = kubecache.NewStore(kubecache.MetaNamespaceKeyFunc)
+	for _, clusterPolicy := range []*kubernetes.ClusterNetworkPolicy{
+		clusterPolicy("b-allow", 100, nil),
+		clusterPolicy("deny-scanners", 10, nil),
+		clusterPolicy("a-allow", 100, nil),
+		clusterPolicy("baseline", 1000, nil),
+	} {
+		if err := k.clusterPolicies.Add(clusterPolicy); err != nil {
+			t.Fatalf("Store add failed: %s", err)
+		}
+	}
+
+	names := []string{}
+	for _, clusterPolicy := range k.sortedClusterNetworkPolicies() {
+		names = append(names, clusterPolicy.GetName())
+	}
+	expected := []string{"deny-scanners", "a-allow", "b-allow", "baseline"}
+	if !equalStrings(names, expected) {
+		t.Errorf("sortedClusterNetworkPolicies => %v, expected %v", names, expected)
+	}
+}
+
+func TestGenerateClusterRules(t *testing.T) {
+	scanners := []networking.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "scanner"}}}}
+	clusterPolicies := []*kubernetes.ClusterNetworkPolicy{
+		clusterPolicy("deny-scanners", 10, nil, clusterRule(kubernetes.ClusterNetworkPolicyReject, scanners)),
+		clusterPolicy("allow-db", 20, map[string]string{"app": "db"}, clusterRule(kubernetes.ClusterNetworkPolicyAccept, nil, 5432)),
+		clusterPolicy("allow-web", 20, map[string]string{"app": "web"}, clusterRule(kubernetes.ClusterNetworkPolicyAccept, nil, 80)),
+	}
+	prodOnly := clusterPolicy("prod-only", 30, nil, clusterRule(kubernetes.ClusterNetworkPolicyReject, nil))
+	prodOnly.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+	clusterPolicies = append(clusterPolicies, prodOnly)
+
+	tests := []struct {
+		pod     *api.Pod
+		actions []policy.ActionType
+	}{
+		{pod("shop", map[string]string{"app": "db"}, nil), []policy.ActionType{policy.Reject, policy.Accept, policy.Reject}},
+		{pod("ci", map[string]string{"app": "db"}, nil), []policy.ActionType{policy.Reject, policy.Accept}},
+		{pod("ci", map[string]string{"app": "cache"}, nil), []policy.ActionType{policy.Reject}},
+	}
+	for _, tt := range tests {
+		rules := generateClusterRules(clusterPolicies, tt.pod, clusterNamespaces)
+
+		// The rules keep the priority order so that a higher priority deny wins.
+		actions := []policy.ActionType{}
+		for _, rule := range rules.receiverRules {
+			actions = append(actions, rule.Policy.Action)
+		}
+		if len(actions) != len(tt.actions) {
+			t.Fatalf("generateClusterRules(%s %v) => actions %v, expected %v", tt.pod.GetNamespace(), tt.pod.GetLabels(), actions, tt.actions)
+		}
+		for i := range actions {
+			if actions[i] != tt.actions[i] {
+				t.Errorf("generateClusterRules(%s %v) => actions %v, expected %v", tt.pod.GetNamespace(), tt.pod.GetLabels(), actions, tt.actions)
+				break
+			}
+		}
+		if len(rules.transmitterRules) != 0 {
+			t.Errorf("generateClusterRules => unexpected egress rules")
+		}
+	}
+
+	// The cluster rules are evaluated before the NetworkPolicies rules.
+	rules := generateClusterRules(clusterPolicies[:1], pod("shop", nil, nil), clusterNamespaces)
+	tags := policy.NewTagStoreFromMap(map[string]string{"@namespace": "shop"})
+	puPolicy, err := generatePUPolicy(&[]networking.NetworkPolicyIngressRule{}, &[]networking.NetworkPolicyEgressRule{}, nil, nil, rules, nil, "shop", clusterNamespaces, tags, nil, nil, PostureAllow, false, nil)
+	if err != nil {
+		t.Fatalf("generatePUPolicy => unexpected error %s", err)
+	}
+	if receiverRules := puPolicy.ReceiverRules(); len(receiverRules) == 0 || receiverRules[0].Policy.Action != policy.Reject {
+		t.Errorf("generatePUPolicy => expected the cluster deny rule first")
+	}
+
+	// The invalid policies are skipped, the other ones still apply.
+	invalid := clusterPolicy("invalid", 10, nil, clusterRule("Drop", nil))
+	if err := validateClusterNetworkPolicy(invalid); err == nil {
+		t.Errorf("validateClusterNetworkPolicy with invalid action => expected an error")
+	}
+	if err := validateClusterNetworkPolicy(clusterPolicies[0]); err != nil {
+		t.Errorf("validateClusterNetworkPolicy => unexpected error %s", err)
+	}
+	rules = generateClusterRules([]*kubernetes.ClusterNetworkPolicy{invalid, clusterPolicies[0]}, pod("shop", nil, nil), clusterNamespaces)
+	if len(rules.receiverRules) != 1 || rules.receiverRules[0].Policy.Action != policy.Reject {
+		t.Errorf("generateClusterRules with an invalid policy => %d rules, expected the deny rule of the valid policy", len(rules.receiverRules))
+	}
+}
+
+func TestClusterAllowAllPolicy(t *testing.T) {
+	tags := policy.NewTagStoreFromMap(map[string]string{"@namespace": "ci"})
+
+	metadata := clusterPolicy("deny-metadata", 10, nil)
+	metadata.Spec.Egress = []kubernetes.ClusterNetworkPolicyRule{clusterRule(kubernetes.ClusterNetworkPolicyReject, []networking.NetworkPolicyPeer{{IPBlock: &networking.IPBlock{CIDR: "169.254.169.254/32"}}})}
+	rules := generateClusterRules([]*kubernetes.ClusterNetworkPolicy{metadata}, pod("ci", nil, nil), clusterNamespaces)
+
+	puPolicy := clusterAllowAllPolicy(rules, tags, nil, nil)
+	acls := puPolicy.ApplicationACLs()
+	if len(acls) == 0 || acls[0].Address != "169.254.169.254/32" || acls[0].Policy.Action != policy.Reject {
+		t.Errorf("clusterAllowAllPolicy => expected the cluster deny ACL first, got %+v", acls)
+	}
+	if !aclsAcceptAll(acls) || !aclsAcceptAll(puPolicy.NetworkACLs()) || !acceptsAll(puPolicy.ReceiverRules()) || !acceptsAll(puPolicy.TransmitterRules()) {
+		t.Errorf("clusterAllowAllPolicy => expected the other traffic to be accepted")
+	}
+
+	// Without cluster rules, the policy is the AllowAll policy.
+	puPolicy = clusterAllowAllPolicy(&clusterRules{}, tags, nil, nil)
+	if len(puPolicy.TransmitterRules()) != 0 || !acceptsAll(puPolicy.ReceiverRules()) {
+		t.Errorf("clusterAllowAllPolicy without cluster rules => expected the AllowAll policy")
+	}
+}
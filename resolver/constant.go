@@ -15,3 +15,43 @@ const KubernetesInfraContainerName = "POD"
 // KubernetesNetworkPolicyAnnotationID is the string used as an annotation key
 // to define if a namespace should have the networkpolicy framework enabled.
 const KubernetesNetworkPolicyAnnotationID = "net.beta.kubernetes.io/network-policy"
+
+// DefaultPostureAnnotationID is the namespace annotation defining the default posture
+// of the pods not selected by any NetworkPolicy: allow, deny-ingress, deny-egress or deny-all.
+const DefaultPostureAnnotationID = "trireme.io/default-posture"
+
+// EgressFQDNsAnnotationID is the NetworkPolicy annotation listing the FQDNs the selected pods
+// can reach, separated by commas or spaces. Each FQDN can be followed by :port.
+const EgressFQDNsAnnotationID = "trireme.io/egress-fqdns"
+
+// EgressServicesAnnotationID is the NetworkPolicy annotation listing the services the selected pods
+// can reach, separated by commas or spaces. Each service is given as [namespace/]name[:port].
+const EgressServicesAnnotationID = "trireme.io/egress-services"
+
+// NotBeforeAnnotationID and NotAfterAnnotationID are the NetworkPolicy annotations defining
+// the RFC3339 time window during which the NetworkPolicy is enforced. Either bound is optional.
+const (
+	NotBeforeAnnotationID = "trireme.io/not-before"
+	NotAfterAnnotationID  = "trireme.io/not-after"
+)
+
+// HTTPRulesAnnotationID is the NetworkPolicy annotation restricting its ingress rules to HTTP
+// methods and path prefixes, given as a JSON list of {"ingress": <rule index>, "methods": [...], "paths": [...]}.
+const HTTPRulesAnnotationID = "trireme.io/http-rules"
+
+// EncryptionAnnotationID is the namespace annotation requiring the encryption of the traffic
+// between the namespace pods and their peers when set to EncryptionRequired.
+const EncryptionAnnotationID = "trireme.io/encryption"
+
+// EnforcementAnnotationID is the pod annotation used to disable the enforcement on a pod.
+const EnforcementAnnotationID = "trireme.io/enforcement"
+
+// EnforcementDisabled is the value of the EnforcementAnnotationID annotation disabling enforcement.
+const EnforcementDisabled = "disabled"
+
+// QuarantineLabelKey is the pod label used to isolate a pod. Its policy is replaced
+// by a deny-all policy when set to QuarantineLabelValue.
+const QuarantineLabelKey = "trireme.io/quarantine"
+
+// QuarantineLabelValue is the value of the QuarantineLabelKey label isolating a pod.
+const QuarantineLabelValue = "true"
@@ -0,0 +1,162 @@
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"go.uber.org/zap"
+)
+
+// SystemDNSPolicyID is the PolicyID of the rules generated to allow the cluster DNS.
+const SystemDNSPolicyID = "system:dns"
+
+// dnsPort is the port allowed to reach the cluster DNS.
+const dnsPort = "53"
+
+// systemRules are the rules generated by the agent itself for egress isolated pods.
+// They are evaluated after the NetworkPolicies rules.
+type systemRules struct {
+	transmitterRules []policy.TagSelector
+	applicationACLs  []policy.IPRule
+}
+
+// with returns the system rules extended with the given transmitter rules and ACLs.
+func (s *systemRules) with(transmitterRules []policy.TagSelector, applicationACLs []policy.IPRule) *systemRules {
+	if len(transmitterRules) == 0 && len(applicationACLs) == 0 {
+		return s
+	}
+	if s == nil {
+		s = &systemRules{}
+	}
+	s.transmitterRules = append(s.transmitterRules, transmitterRules...)
+	s.applicationACLs = append(s.applicationACLs, applicationACLs...)
+	return s
+}
+
+// dnsAllowance defines the cluster DNS that egress isolated pods are allowed to reach.
+type dnsAllowance struct {
+	selector  labels.Selector
+	namespace string
+	service   string
+	// pods tracks the egress isolated pods to update when the DNS service or endpoints change.
+	pods *podTracker
+}
+
+// SetAutoAllowDNS allows every egress isolated pod to reach the cluster DNS on port 53.
+// The DNS pods are matched by the label selector in the namespace and the DNS service
+// IPs are allowed as well. They are read from the Services and Endpoints stores. Must be
+// called before Run.
+func (k *KubernetesPolicy) SetAutoAllowDNS(selector string, namespace string, service string) error {
+	parsedSelector, err := labels.Parse(selector)
+	if err != nil {
+		return fmt.Errorf("Invalid DNS selector %s: %s", selector, err)
+	}
+
+	k.dns = &dnsAllowance{
+		selector:  parsedSelector,
+		namespace: namespace,
+		service:   service,
+		pods:      newPodTracker(),
+	}
+	if service != "" {
+		k.watchedServices()
+	}
+	return nil
+}
+
+// dnsRules generates the transmitter rules and ACLs allowing the cluster DNS.
+// Nothing is returned if the DNS allowance is disabled.
+func (k *KubernetesPolicy) dnsRules() *systemRules {
+	if k.dns == nil {
+		return nil
+	}
+
+	requirements, _ := k.dns.selector.Requirements()
+
+	completeClause := []policy.KeyValueOperator{
+		policy.KeyValueOperator{
+			Key:      "$sys:port",
+			Operator: policy.Equal,
+			Value:    []string{dnsPort},
+		},
+	}
+	completeClause = append(completeClause, namespaceSelector(k.dns.namespace)...)
+	completeClause = append(completeClause, requirementsClause(requirements)...)
+
+	rules := []policy.TagSelector{
+		policy.TagSelector{
+			Clause: completeClause,
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: SystemDNSPolicyID,
+			},
+		},
+	}
+
+	// The DNS pods are usually not policed. They are reached through ACLs on their IPs.
+	acls := []policy.IPRule{}
+	for _, ip := range k.dnsIPs() {
+		for _, protocol := range []string{"UDP", "TCP"} {
+			acls = append(acls, policy.IPRule{
+				Address:  ip + "/32",
+				Port:     dnsPort,
+				Protocol: protocol,
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: SystemDNSPolicyID,
+				},
+			})
+		}
+	}
+
+	return &systemRules{
+		transmitterRules: rules,
+		applicationACLs:  acls,
+	}
+}
+
+// dnsIPs returns the cluster IP of the DNS service and the IPs of its endpoints.
+func (k *KubernetesPolicy) dnsIPs() []string {
+	if k.dns.service == "" || k.services == nil {
+		return nil
+	}
+
+	ips := []string{}
+	key := serviceKey(k.dns.namespace, k.dns.service)
+	item, exists, err := k.services.services.GetByKey(key)
+	if err != nil || !exists {
+		logger().Warn("Couldn't find the DNS service", zap.String("service", key))
+	} else if service := item.(*api.Service); service.Spec.ClusterIP != "" && service.Spec.ClusterIP != api.ClusterIPNone {
+		ips = append(ips, service.Spec.ClusterIP)
+	}
+
+	item, exists, err = k.services.endpoints.GetByKey(key)
+	if err != nil || !exists {
+		logger().Warn("Couldn't find the DNS endpoints", zap.String("service", key))
+		return ips
+	}
+	for _, subset := range item.(*api.Endpoints).Subsets {
+		for _, address := range subset.Addresses {
+			ips = append(ips, address.IP)
+		}
+	}
+	return ips
+}
+
+// trackDNS records if the pod was allowed to reach the cluster DNS, to update its policy
+// when the DNS service or endpoints change.
+func (k *KubernetesPolicy) trackDNS(key string, allowed bool) {
+	if k.dns == nil {
+		return
+	}
+
+	dependencies := []string{}
+	if allowed && k.dns.service != "" {
+		dependencies = append(dependencies, serviceKey(k.dns.namespace, k.dns.service))
+	}
+	k.dns.pods.track(key, dependencies)
+}
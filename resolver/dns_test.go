@@ -0,0 +1,121 @@
+package resolver
+
+import (
+	"testing"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubecache "k8s.io/client-go/tools/cache"
+)
+
+func dnsPolicy(t *testing.T, service string, objects ...interface{}) *KubernetesPolicy {
+	k := &KubernetesPolicy{}
+	if err := k.SetAutoAllowDNS("k8s-app=kube-dns", "kube-system", service); err != nil {
+		t.Fatalf("SetAutoAllowDNS => unexpected error %s", err)
+	}
+	if k.services != nil {
+		k.services.services = kubecache.NewStore(kubecache.MetaNamespaceKeyFunc)
+		k.services.endpoints = kubecache.NewStore(kubecache.MetaNamespaceKeyFunc)
+	}
+
+	for _, object := range objects {
+		var err error
+		switch object.(type) {
+		case *api.Service:
+			err = k.services.services.Add(object)
+		case *api.Endpoints:
+			err = k.services.endpoints.Add(object)
+		}
+		if err != nil {
+			t.Fatalf("Store add failed: %s", err)
+		}
+	}
+	return k
+}
+
+var dnsService = &api.Service{
+	ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+	Spec:       api.ServiceSpec{ClusterIP: "10.96.0.10"},
+}
+
+var dnsEndpoints = &api.Endpoints{
+	ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+	Subsets: []api.EndpointSubset{
+		{Addresses: []api.EndpointAddress{{IP: "10.32.0.2"}, {IP: "10.32.0.3"}}},
+	},
+}
+
+func TestDNSRules(t *testing.T) {
+	k := &KubernetesPolicy{}
+	if rules := k.dnsRules(); rules != nil {
+		t.Errorf("dnsRules without DNS allowance => %v, expected nil", rules)
+	}
+
+	tests := []struct {
+		name    string
+		k       *KubernetesPolicy
+		aclIPs  []string
+		service bool
+	}{
+		{"service and endpoints", dnsPolicy(t, "kube-dns", dnsService, dnsEndpoints), []string{"10.96.0.10/32", "10.32.0.2/32", "10.32.0.3/32"}, true},
+		{"service without endpoints", dnsPolicy(t, "kube-dns", dnsService), []string{"10.96.0.10/32"}, true},
+		{"missing service", dnsPolicy(t, "kube-dns"), []string{}, true},
+		{"no service", dnsPolicy(t, ""), []string{}, false},
+	}
+	for _, tt := range tests {
+		if (tt.k.services != nil) != tt.service {
+			t.Errorf("%s: SetAutoAllowDNS => services watched %t, expected %t", tt.name, tt.k.services != nil, tt.service)
+		}
+		if tt.k.services != nil && tt.k.services.policies {
+			t.Errorf("%s: SetAutoAllowDNS => service policies unexpectedly enabled", tt.name)
+		}
+
+		rules := tt.k.dnsRules()
+		if len(rules.transmitterRules) != 1 {
+			t.Fatalf("%s: dnsRules => %d transmitter rules, expected 1", tt.name, len(rules.transmitterRules))
+		}
+		rule := rules.transmitterRules[0]
+		if rule.Policy.PolicyID != SystemDNSPolicyID {
+			t.Errorf("%s: dnsRules => PolicyID %s, expected %s", tt.name, rule.Policy.PolicyID, SystemDNSPolicyID)
+		}
+		if ports := clauseValues(rule, "$sys:port"); !equalStrings(ports, []string{dnsPort}) {
+			t.Errorf("%s: dnsRules => ports %v, expected %s", tt.name, ports, dnsPort)
+		}
+		if namespaces := clauseValues(rule, "@namespace"); !equalStrings(namespaces, []string{"kube-system"}) {
+			t.Errorf("%s: dnsRules => namespaces %v, expected kube-system", tt.name, namespaces)
+		}
+		if values := clauseValues(rule, "k8s-app"); !equalStrings(values, []string{"kube-dns"}) {
+			t.Errorf("%s: dnsRules => k8s-app %v, expected kube-dns", tt.name, values)
+		}
+
+		// Each IP is allowed on UDP and TCP.
+		aclIPs := []string{}
+		for i, acl := range rules.applicationACLs {
+			if acl.Port != dnsPort || acl.Policy.PolicyID != SystemDNSPolicyID {
+				t.Errorf("%s: dnsRules => unexpected ACL %v", tt.name, acl)
+			}
+			if i%2 == 0 {
+				aclIPs = append(aclIPs, acl.Address)
+			}
+		}
+		if len(rules.applicationACLs) != 2*len(tt.aclIPs) || !equalStrings(aclIPs, tt.aclIPs) {
+			t.Errorf("%s: dnsRules => ACLs on %v, expected %v", tt.name, aclIPs, tt.aclIPs)
+		}
+	}
+}
+
+func TestTrackDNS(t *testing.T) {
+	k := dnsPolicy(t, "kube-dns", dnsService, dnsEndpoints)
+	key := serviceKey("kube-system", "kube-dns")
+
+	k.trackDNS(podKey("nginx", "shop"), true)
+	k.trackDNS(podKey("redis", "shop"), false)
+	if users := k.dns.pods.users(key); !equalStrings(users, []string{"shop/nginx"}) {
+		t.Errorf("trackDNS => DNS users %v, expected [shop/nginx]", users)
+	}
+
+	k.trackDNS(podKey("nginx", "shop"), false)
+	if users := k.dns.pods.users(key); len(users) != 0 {
+		t.Errorf("trackDNS => DNS users %v, expected none", users)
+	}
+}
@@ -0,0 +1,137 @@
+package resolver
+
+import (
+	"sort"
+
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+
+	"go.uber.org/zap"
+)
+
+// EncryptionRequired is the value of the EncryptionAnnotationID annotation requiring
+// the encryption of the traffic of the namespace pods.
+const EncryptionRequired = "required"
+
+// isNamespaceEncrypted returns true if the namespace requires encryption.
+func isNamespaceEncrypted(namespace *api.Namespace) bool {
+	value, ok := namespace.GetAnnotations()[EncryptionAnnotationID]
+	if !ok {
+		return false
+	}
+	if value != EncryptionRequired {
+		logger().Error("Ignoring invalid encryption annotation", zap.String("namespace", namespace.GetName()), zap.String("value", value))
+		return false
+	}
+	return true
+}
+
+// isEncryptionUpdateNeeded returns true if the encryption annotation of the namespace changed.
+func isEncryptionUpdateNeeded(oldNS, updatedNS *api.Namespace) bool {
+	return oldNS.GetAnnotations()[EncryptionAnnotationID] != updatedNS.GetAnnotations()[EncryptionAnnotationID]
+}
+
+// encryptedNamespaces returns the namespaces requiring encryption. Only the active namespaces
+// are considered, as the pods of the other namespaces are not policed and would not encrypt.
+func (k *KubernetesPolicy) encryptedNamespaces(allNamespaces *api.NamespaceList) map[string]bool {
+	encrypted := map[string]bool{}
+	for _, namespace := range allNamespaces.Items {
+		if !isNamespaceEncrypted(&namespace) {
+			continue
+		}
+		if !k.cache.isNamespaceActive(namespace.GetName()) {
+			logger().Warn("Encryption ignored on namespace not policed", zap.String("namespace", namespace.GetName()))
+			continue
+		}
+		encrypted[namespace.GetName()] = true
+	}
+	return encrypted
+}
+
+// encryptRules sets the Encrypt action on the accepting rules whose peers are in an encrypted
+// namespace, or on all of them if the pod namespace is encrypted. The traffic between two pods is
+// encrypted if either namespace requires it, so that both ends agree. Rules spanning encrypted and
+// plaintext namespaces are split, the encrypted part first.
+func encryptRules(rules []policy.TagSelector, podNamespace string, encrypted map[string]bool) []policy.TagSelector {
+	if len(encrypted) == 0 {
+		return rules
+	}
+
+	encryptedList := []string{}
+	for namespace := range encrypted {
+		encryptedList = append(encryptedList, namespace)
+	}
+	sort.Strings(encryptedList)
+
+	encryptedRules := []policy.TagSelector{}
+	for _, rule := range rules {
+		if rule.Policy == nil || rule.Policy.Action&policy.Accept == 0 {
+			encryptedRules = append(encryptedRules, rule)
+			continue
+		}
+
+		if encrypted[podNamespace] {
+			encryptedRules = append(encryptedRules, withNamespaces(rule, -1, nil, true))
+			continue
+		}
+
+		index := namespaceClauseIndex(rule)
+		if index < 0 {
+			encryptedRules = append(encryptedRules, rule)
+			continue
+		}
+
+		encryptedPeers := []string{}
+		plaintextPeers := []string{}
+		for _, namespace := range rule.Clause[index].Value {
+			switch {
+			case namespace == "*":
+				encryptedPeers = append(encryptedPeers, encryptedList...)
+				plaintextPeers = append(plaintextPeers, namespace)
+			case encrypted[namespace]:
+				encryptedPeers = append(encryptedPeers, namespace)
+			default:
+				plaintextPeers = append(plaintextPeers, namespace)
+			}
+		}
+
+		if len(encryptedPeers) > 0 {
+			encryptedRules = append(encryptedRules, withNamespaces(rule, index, encryptedPeers, true))
+		}
+		if len(plaintextPeers) > 0 {
+			encryptedRules = append(encryptedRules, withNamespaces(rule, index, plaintextPeers, false))
+		}
+	}
+	return encryptedRules
+}
+
+// namespaceClauseIndex returns the index of the @namespace clause of the rule, -1 if none.
+func namespaceClauseIndex(rule policy.TagSelector) int {
+	for i, clause := range rule.Clause {
+		if clause.Key == "@namespace" && clause.Operator == policy.Equal {
+			return i
+		}
+	}
+	return -1
+}
+
+// withNamespaces returns a copy of the rule with the namespaces of the clause at index replaced,
+// if index is not negative, and the Encrypt action set if encrypt is true.
+func withNamespaces(rule policy.TagSelector, index int, namespaces []string, encrypt bool) policy.TagSelector {
+	clause := make([]policy.KeyValueOperator, len(rule.Clause))
+	copy(clause, rule.Clause)
+	if index >= 0 {
+		clause[index].Value = namespaces
+	}
+
+	flowPolicy := *rule.Policy
+	if encrypt {
+		flowPolicy.Action |= policy.Encrypt
+	}
+
+	return policy.TagSelector{
+		Clause: clause,
+		Policy: &flowPolicy,
+	}
+}
@@ -0,0 +1,89 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+)
+
+func encryptedNamespace(name string, value string) api.Namespace {
+	ns := namespace(name, nil)
+	ns.SetAnnotations(map[string]string{EncryptionAnnotationID: value})
+	return *ns
+}
+
+func TestEncryptedNamespaces(t *testing.T) {
+	k := &KubernetesPolicy{cache: newCache()}
+	for _, name := range []string{"shop", "billing", "ci"} {
+		k.cache.activateNamespaceWatcher(name, &NamespaceWatcher{})
+	}
+
+	allNamespaces := &api.NamespaceList{Items: []api.Namespace{
+		encryptedNamespace("shop", EncryptionRequired),
+		encryptedNamespace("billing", "yes"),
+		*namespace("ci", nil),
+		// Not policed.
+		encryptedNamespace("legacy", EncryptionRequired),
+	}}
+
+	encrypted := k.encryptedNamespaces(allNamespaces)
+	if len(encrypted) != 1 || !encrypted["shop"] {
+		t.Errorf("encryptedNamespaces => %v, expected only shop", encrypted)
+	}
+}
+
+func namespaceRule(action policy.ActionType, namespaces ...string) policy.TagSelector {
+	return policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{Key: "app", Operator: policy.Equal, Value: []string{"web"}},
+			{Key: "@namespace", Operator: policy.Equal, Value: namespaces},
+		},
+		Policy: &policy.FlowPolicy{Action: action},
+	}
+}
+
+var encryptRulesTests = []struct {
+	name         string
+	rules        []policy.TagSelector
+	podNamespace string
+	namespaces   [][]string
+	encrypt      []bool
+}{
+	{"plaintext peer", []policy.TagSelector{namespaceRule(policy.Accept, "ci")}, "ci", [][]string{{"ci"}}, []bool{false}},
+	{"encrypted peer", []policy.TagSelector{namespaceRule(policy.Accept, "shop")}, "ci", [][]string{{"shop"}}, []bool{true}},
+	{"encrypted pod namespace", []policy.TagSelector{namespaceRule(policy.Accept, "ci")}, "shop", [][]string{{"ci"}}, []bool{true}},
+	{"split rule", []policy.TagSelector{namespaceRule(policy.Accept, "ci", "shop")}, "ci", [][]string{{"shop"}, {"ci"}}, []bool{true, false}},
+	{"all namespaces", []policy.TagSelector{namespaceRule(policy.Accept, "*")}, "ci", [][]string{{"billing", "shop"}, {"*"}}, []bool{true, false}},
+	{"reject rule", []policy.TagSelector{namespaceRule(policy.Reject, "shop")}, "ci", [][]string{{"shop"}}, []bool{false}},
+}
+
+func TestEncryptRules(t *testing.T) {
+	encrypted := map[string]bool{"shop": true, "billing": true}
+	for _, tt := range encryptRulesTests {
+		rules := encryptRules(tt.rules, tt.podNamespace, encrypted)
+		if len(rules) != len(tt.namespaces) {
+			t.Fatalf("%s: encryptRules => %d rules, expected %d", tt.name, len(rules), len(tt.namespaces))
+		}
+		for i, rule := range rules {
+			if namespaces := clauseValues(rule, "@namespace"); !equalStrings(namespaces, tt.namespaces[i]) {
+				t.Errorf("%s: encryptRules => rule %d on namespaces %v, expected %v", tt.name, i, namespaces, tt.namespaces[i])
+			}
+			if encrypt := rule.Policy.Action&policy.Encrypt != 0; encrypt != tt.encrypt[i] {
+				t.Errorf("%s: encryptRules => rule %d encrypted %t, expected %t", tt.name, i, encrypt, tt.encrypt[i])
+			}
+		}
+	}
+
+	// The original rules are left untouched.
+	rules := []policy.TagSelector{namespaceRule(policy.Accept, "ci", "shop")}
+	encryptRules(rules, "ci", encrypted)
+	if rules[0].Policy.Action != policy.Accept || len(clauseValues(rules[0], "@namespace")) != 2 {
+		t.Errorf("encryptRules modified the original rules")
+	}
+
+	if result := encryptRules(rules, "ci", nil); len(result) != 1 || result[0].Policy.Action != policy.Accept {
+		t.Errorf("encryptRules without encrypted namespaces => %v, expected the rules unchanged", result)
+	}
+}
@@ -0,0 +1,86 @@
+package resolver
+
+import (
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+
+	"go.uber.org/zap"
+)
+
+// Container events reported to the collector for audit purposes.
+const (
+	// EventEnforcementBypass is reported when a pod policy becomes AllowAll because of the
+	// EnforcementAnnotationID annotation, and EventEnforcementBypassEnded when it doesn't anymore.
+	EventEnforcementBypass      = "enforcementbypass"
+	EventEnforcementBypassEnded = "enforcementbypassended"
+	// EventQuarantine is reported when a pod policy becomes DenyAll because of the
+	// QuarantineLabelKey label, and EventQuarantineEnded when the pod is released.
+	EventQuarantine      = "quarantine"
+	EventQuarantineEnded = "quarantineended"
+	// EventBreakGlass is reported when a pod policy becomes AllowAll because the break-glass
+	// is active, and EventBreakGlassEnded when it doesn't anymore.
+	EventBreakGlass      = "breakglass"
+	EventBreakGlassEnded = "breakglassended"
+	// EventBreakGlassActivated and EventBreakGlassDeactivated are reported once
+	// when the cluster-wide break-glass changes state.
+	EventBreakGlassActivated   = "breakglassactivated"
+	EventBreakGlassDeactivated = "breakglassdeactivated"
+	// EventNetworkPolicyStarted and EventNetworkPolicyExpired are reported when a NetworkPolicy
+	// enters or leaves its time window.
+	EventNetworkPolicyStarted = "networkpolicystarted"
+	EventNetworkPolicyExpired = "networkpolicyexpired"
+	// EventHTTPRulesNotEnforced is reported when a NetworkPolicy has HTTP rules,
+	// which cannot be enforced.
+	EventHTTPRulesNotEnforced = "httprulesnotenforced"
+	// EventClusterNetworkPolicyInvalid is reported when an invalid ClusterNetworkPolicy is added
+	// or modified. It is skipped until it is fixed.
+	EventClusterNetworkPolicyInvalid = "clusternetworkpolicyinvalid"
+)
+
+// overrideEndedEvents are the events reported when a pod policy isn't overridden anymore.
+var overrideEndedEvents = map[string]string{
+	EventEnforcementBypass: EventEnforcementBypassEnded,
+	EventQuarantine:        EventQuarantineEnded,
+	EventBreakGlass:        EventBreakGlassEnded,
+}
+
+// SetEventCollector registers the collector used to report the audit events.
+func (k *KubernetesPolicy) SetEventCollector(eventCollector collector.EventCollector) {
+	k.eventCollector = eventCollector
+}
+
+// reportPodEvent reports an audit event for the pod to the collector.
+func (k *KubernetesPolicy) reportPodEvent(pod *api.Pod, event string) {
+	if k.eventCollector == nil {
+		return
+	}
+
+	contextID, err := k.cache.contextIDByPodName(pod.GetName(), pod.GetNamespace())
+	if err != nil {
+		logger().Debug("Couldn't find pod contextID for event", zap.String("event", event), zap.Error(err))
+	}
+
+	k.eventCollector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		IPAddress: pod.Status.PodIP,
+		Tags:      policy.NewTagStoreFromMap(pod.GetLabels()),
+		Event:     event,
+	})
+}
+
+// reportPodOverride reports the events of the pod entering or leaving an override of its
+// NetworkPolicies. Nothing is reported if the override didn't change since the last resolve.
+func (k *KubernetesPolicy) reportPodOverride(pod *api.Pod, override string) {
+	previous := k.cache.setPodOverride(pod.GetName(), pod.GetNamespace(), override)
+	if previous == override {
+		return
+	}
+	if previous != "" {
+		k.reportPodEvent(pod, overrideEndedEvents[previous])
+	}
+	if override != "" {
+		k.reportPodEvent(pod, override)
+	}
+}
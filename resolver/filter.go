@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"fmt"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// kubeSystemNamespace is the namespace of the Kubernetes system components.
+const kubeSystemNamespace = "kube-system"
+
+// NamespaceFilter decides which namespaces are policed based on include and exclude
+// lists of names and label selectors. Exclusions take precedence over inclusions.
+type NamespaceFilter struct {
+	include           map[string]bool
+	exclude           map[string]bool
+	includeSelector   labels.Selector
+	excludeSelector   labels.Selector
+	enforceKubeSystem bool
+}
+
+// NewNamespaceFilter creates a NamespaceFilter. If no include list or selector is given,
+// every namespace that is not excluded is policed. kube-system is only policed if
+// enforceKubeSystem is set, which is the default with the GA NetworkPolicies.
+func NewNamespaceFilter(include []string, exclude []string, includeSelector string, excludeSelector string, enforceKubeSystem bool) (*NamespaceFilter, error) {
+	filter := &NamespaceFilter{
+		include:           map[string]bool{},
+		exclude:           map[string]bool{},
+		enforceKubeSystem: enforceKubeSystem,
+	}
+
+	for _, namespace := range include {
+		filter.include[namespace] = true
+	}
+	for _, namespace := range exclude {
+		filter.exclude[namespace] = true
+	}
+
+	if includeSelector != "" {
+		selector, err := labels.Parse(includeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid namespace include selector %s: %s", includeSelector, err)
+		}
+		filter.includeSelector = selector
+	}
+	if excludeSelector != "" {
+		selector, err := labels.Parse(excludeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid namespace exclude selector %s: %s", excludeSelector, err)
+		}
+		filter.excludeSelector = selector
+	}
+
+	return filter, nil
+}
+
+// defaultNamespaceFilter polices every namespace except kube-system.
+func defaultNamespaceFilter() *NamespaceFilter {
+	return &NamespaceFilter{
+		include: map[string]bool{},
+		exclude: map[string]bool{},
+	}
+}
+
+// isPoliced returns true if the namespace should be policed.
+func (f *NamespaceFilter) isPoliced(namespace *api.Namespace) bool {
+	name := namespace.GetName()
+	namespaceLabels := labels.Set(namespace.GetLabels())
+
+	if isNamespaceKubeSystem(name) && !f.enforceKubeSystem {
+		return false
+	}
+
+	if f.exclude[name] {
+		return false
+	}
+	if f.excludeSelector != nil && f.excludeSelector.Matches(namespaceLabels) {
+		return false
+	}
+
+	// Without any inclusion criteria, everything that is not excluded is policed.
+	if len(f.include) == 0 && f.includeSelector == nil {
+		return true
+	}
+
+	if f.include[name] {
+		return true
+	}
+	return f.includeSelector != nil && f.includeSelector.Matches(namespaceLabels)
+}
@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"testing"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func namespace(name string, namespaceLabels map[string]string) *api.Namespace {
+	return &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: namespaceLabels,
+		},
+	}
+}
+
+var namespaceFilterTests = []struct {
+	include           []string
+	exclude           []string
+	includeSelector   string
+	excludeSelector   string
+	enforceKubeSystem bool
+	namespace         *api.Namespace
+	policed           bool
+}{
+	{nil, nil, "", "", false, namespace("default", nil), true},
+	{nil, nil, "", "", false, namespace("kube-system", nil), false},
+	{nil, nil, "", "", true, namespace("kube-system", nil), true},
+	{nil, []string{"kube-system"}, "", "", true, namespace("kube-system", nil), false},
+	{nil, []string{"monitoring"}, "", "", false, namespace("monitoring", nil), false},
+	{nil, nil, "", "infra=true", false, namespace("logging", map[string]string{"infra": "true"}), false},
+	{nil, nil, "", "infra=true", false, namespace("logging", map[string]string{"infra": "false"}), true},
+	{[]string{"beer"}, nil, "", "", false, namespace("beer", nil), true},
+	{[]string{"beer"}, nil, "", "", false, namespace("default", nil), false},
+	{nil, nil, "env in (prod,staging)", "", false, namespace("shop", map[string]string{"env": "prod"}), true},
+	{nil, nil, "env in (prod,staging)", "", false, namespace("ci", map[string]string{"env": "ci"}), false},
+	{[]string{"beer"}, nil, "env=prod", "", false, namespace("shop", map[string]string{"env": "prod"}), true},
+	{[]string{"beer"}, []string{"beer"}, "", "", false, namespace("beer", nil), false},
+}
+
+func TestNamespaceFilter(t *testing.T) {
+	for _, tt := range namespaceFilterTests {
+		filter, err := NewNamespaceFilter(tt.include, tt.exclude, tt.includeSelector, tt.excludeSelector, tt.enforceKubeSystem)
+		if err != nil {
+			t.Fatalf("NewNamespaceFilter => unexpected error %s", err)
+		}
+
+		if policed := filter.isPoliced(tt.namespace); policed != tt.policed {
+			t.Errorf("isPoliced(%s %v) => %t, expected %t", tt.namespace.GetName(), tt.namespace.GetLabels(), policed, tt.policed)
+		}
+	}
+}
+
+func TestNamespaceFilterInvalidSelector(t *testing.T) {
+	if _, err := NewNamespaceFilter(nil, nil, "env in (prod", "", false); err == nil {
+		t.Errorf("NewNamespaceFilter with invalid selector => expected an error")
+	}
+}
@@ -0,0 +1,172 @@
+package resolver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aporeto-inc/trireme-kubernetes/fqdn"
+
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"go.uber.org/zap"
+)
+
+// FQDNPolicyIDPrefix prefixes the PolicyID of the rules generated from the FQDNs of a NetworkPolicy.
+const FQDNPolicyIDPrefix = "fqdn:"
+
+// fqdnEntry is an FQDN allowed by a policy, on a single port or on all ports if empty.
+type fqdnEntry struct {
+	name     string
+	port     string
+	policyID string
+}
+
+// fqdnTracker keeps track of the FQDNs used by each pod, to update the pod
+// policies when the FQDN addresses change.
+type fqdnTracker struct {
+	resolver *fqdn.Resolver
+	pods     *podTracker
+}
+
+// EnableFQDNPolicies enables the FQDNs given in the EgressFQDNsAnnotationID annotation
+// of the NetworkPolicies. FQDNs are resolved through the DNS server given as host:port, or the
+// system one if empty, and refreshed according to their TTL bounded by minTTL and maxTTL.
+// Must be called before Run.
+func (k *KubernetesPolicy) EnableFQDNPolicies(server string, minTTL time.Duration, maxTTL time.Duration) error {
+	resolver, err := fqdn.NewResolver(server, minTTL, maxTTL, k.updateFQDNPodPolicies)
+	if err != nil {
+		return fmt.Errorf("Couldn't create FQDN resolver: %s", err)
+	}
+
+	k.fqdns = &fqdnTracker{
+		resolver: resolver,
+		pods:     newPodTracker(),
+	}
+	return nil
+}
+
+// parseFQDNs parses the EgressFQDNsAnnotationID annotation: a comma or space separated
+// list of FQDNs, each optionally followed by :port.
+func parseFQDNs(value string) ([]fqdnEntry, error) {
+	entries := []fqdnEntry{}
+	separator := func(r rune) bool { return r == ',' || unicode.IsSpace(r) }
+	for _, field := range strings.FieldsFunc(value, separator) {
+		entry := fqdnEntry{name: field}
+
+		if i := strings.LastIndex(field, ":"); i >= 0 {
+			port, err := strconv.Atoi(field[i+1:])
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("Invalid port in %s", field)
+			}
+			entry.name = field[:i]
+			entry.port = strconv.Itoa(port)
+		}
+
+		if entry.name == "" || strings.Contains(entry.name, "*") || strings.Contains(entry.name, "/") {
+			return nil, fmt.Errorf("Invalid FQDN %s", field)
+		}
+		entry.name = strings.ToLower(strings.TrimSuffix(entry.name, "."))
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// fqdnACLs generates the ACLs allowing the FQDNs of the NetworkPolicies selecting the pod,
+// as well as the extra FQDNs allowed by other means. It returns true if at least one of
+// those NetworkPolicies has FQDNs, as the pod is then egress isolated.
+func (k *KubernetesPolicy) fqdnACLs(pod *api.Pod, networkPolicies *networking.NetworkPolicyList, extra []fqdnEntry) ([]policy.IPRule, bool) {
+	if k.fqdns == nil {
+		return nil, false
+	}
+
+	allowed := []fqdnEntry{}
+	selected := false
+
+	for _, networkPolicy := range networkPolicies.Items {
+		value, ok := networkPolicy.GetAnnotations()[EgressFQDNsAnnotationID]
+		if !ok {
+			continue
+		}
+
+		podSelector, err := metav1.LabelSelectorAsSelector(&networkPolicy.Spec.PodSelector)
+		if err != nil || !podSelector.Matches(labels.Set(pod.GetLabels())) {
+			continue
+		}
+
+		entries, err := parseFQDNs(value)
+		if err != nil {
+			logger().Error("Ignoring invalid FQDNs annotation", zap.String("networkPolicy", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Error(err))
+			continue
+		}
+		selected = true
+
+		for _, entry := range entries {
+			entry.policyID = FQDNPolicyIDPrefix + networkPolicy.GetName()
+			allowed = append(allowed, entry)
+		}
+	}
+	allowed = append(allowed, extra...)
+
+	acls := []policy.IPRule{}
+	names := []string{}
+	for _, entry := range allowed {
+		names = append(names, entry.name)
+
+		// Until the FQDN is resolved, its traffic is denied. The pod policy is updated
+		// once its addresses are known.
+		ips := k.fqdns.resolver.Resolve(entry.name)
+		acls = append(acls, fqdnIPRules(ips, entry.port, entry.policyID)...)
+	}
+
+	k.trackPodFQDNs(podKey(pod.GetName(), pod.GetNamespace()), names)
+	return acls, selected
+}
+
+// fqdnIPRules generates the TCP and UDP IPRules for the addresses of an FQDN.
+func fqdnIPRules(ips []string, port string, policyID string) []policy.IPRule {
+	if port == "" {
+		port = "0:65535"
+	}
+
+	acls := []policy.IPRule{}
+	for _, ip := range ips {
+		address := ip + "/32"
+		for _, protocol := range []string{"TCP", "UDP"} {
+			acls = append(acls, policy.IPRule{
+				Address:  address,
+				Port:     port,
+				Protocol: protocol,
+				Policy: &policy.FlowPolicy{
+					Action:   policy.Accept,
+					PolicyID: policyID,
+				},
+			})
+		}
+	}
+	return acls
+}
+
+// trackPodFQDNs replaces the FQDNs used by the pod. The FQDNs not used by any pod anymore
+// are released.
+func (k *KubernetesPolicy) trackPodFQDNs(key string, names []string) {
+	if k.fqdns == nil {
+		return
+	}
+
+	for _, name := range k.fqdns.pods.track(key, names) {
+		k.fqdns.resolver.Release(name)
+	}
+}
+
+// updateFQDNPodPolicies updates the policy of all the pods using the FQDN.
+func (k *KubernetesPolicy) updateFQDNPodPolicies(name string) {
+	k.updateTrackedPodPolicies(k.fqdns.pods, name)
+}
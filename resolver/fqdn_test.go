@@ -0,0 +1,21 @@
+package resolver
+
+import "testing"
+
+func TestFQDNIPRules(t *testing.T) {
+	acls := fqdnIPRules([]string{"10.0.0.1", "10.0.0.2"}, "", "fqdn:api")
+
+	expected := []string{"10.0.0.1/32", "10.0.0.1/32", "10.0.0.2/32", "10.0.0.2/32"}
+	if len(acls) != len(expected) {
+		t.Fatalf("fqdnIPRules => %d ACLs, expected %d", len(acls), len(expected))
+	}
+	for i, acl := range acls {
+		if acl.Address != expected[i] || acl.Port != "0:65535" || acl.Policy.PolicyID != "fqdn:api" {
+			t.Errorf("fqdnIPRules => unexpected ACL %s %s %s", acl.Address, acl.Port, acl.Policy.PolicyID)
+		}
+	}
+
+	if acls := fqdnIPRules(nil, "443", "fqdn:api"); len(acls) != 0 {
+		t.Errorf("fqdnIPRules without address => %d ACLs, expected none", len(acls))
+	}
+}
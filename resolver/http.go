@@ -0,0 +1,175 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"go.uber.org/zap"
+)
+
+// httpMethods are the HTTP methods accepted in the HTTP rules.
+var httpMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"OPTIONS": true,
+	"CONNECT": true,
+	"TRACE":   true,
+}
+
+// httpRule restricts an ingress rule of a NetworkPolicy to HTTP methods and path prefixes.
+// Empty methods or paths allow all of them.
+type httpRule struct {
+	Ingress int      `json:"ingress"`
+	Methods []string `json:"methods,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+}
+
+// String formats the rule for logging.
+func (r httpRule) String() string {
+	methods := "*"
+	if len(r.Methods) > 0 {
+		methods = strings.Join(r.Methods, ",")
+	}
+	paths := "/*"
+	if len(r.Paths) > 0 {
+		paths = strings.Join(r.Paths, ",")
+	}
+	return fmt.Sprintf("ingress[%d] %s %s", r.Ingress, methods, paths)
+}
+
+// SetStrictHTTPRules defines how the ingress rules restricted by HTTP rules are enforced.
+// Trireme only enforces L3/L4 rules, so the HTTP rules are never translated, only reported:
+// in strict mode the restricted ingress rules are removed and the selected pods stay ingress
+// isolated, denying the traffic. Otherwise they are enforced at L4, allowing all the HTTP
+// requests. Must be called before Run.
+func (k *KubernetesPolicy) SetStrictHTTPRules(strict bool) {
+	k.strictHTTPRules = strict
+}
+
+// networkPolicyHTTPRules parses and validates the HTTPRulesAnnotationID annotation of the NetworkPolicy.
+func networkPolicyHTTPRules(networkPolicy *networking.NetworkPolicy) ([]httpRule, error) {
+	value, ok := networkPolicy.GetAnnotations()[HTTPRulesAnnotationID]
+	if !ok {
+		return nil, nil
+	}
+
+	rules := []httpRule{}
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("Invalid %s annotation: %s", HTTPRulesAnnotationID, err)
+	}
+
+	for i, rule := range rules {
+		if rule.Ingress < 0 || rule.Ingress >= len(networkPolicy.Spec.Ingress) {
+			return nil, fmt.Errorf("HTTP rule %d refers to unknown ingress rule %d", i, rule.Ingress)
+		}
+		for j, method := range rule.Methods {
+			method = strings.ToUpper(method)
+			if !httpMethods[method] {
+				return nil, fmt.Errorf("HTTP rule %d has invalid method %s", i, method)
+			}
+			rules[i].Methods[j] = method
+		}
+		for _, path := range rule.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return nil, fmt.Errorf("HTTP rule %d has invalid path %s", i, path)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// reportHTTPRules reports the HTTP rules of the NetworkPolicy that cannot be enforced.
+func (k *KubernetesPolicy) reportHTTPRules(networkPolicy *networking.NetworkPolicy) {
+	rules, err := networkPolicyHTTPRules(networkPolicy)
+	if err != nil {
+		logger().Error("Invalid HTTP rules", zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Error(err))
+		k.reportNetworkPolicyEvent(networkPolicy, EventHTTPRulesNotEnforced)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	formatted := []string{}
+	for _, rule := range rules {
+		formatted = append(formatted, rule.String())
+	}
+
+	enforcement := "Enforcing ingress rules at L4 only"
+	if k.strictHTTPRules {
+		enforcement = "Ingress rules removed"
+	}
+	logger().Warn("HTTP rules cannot be enforced. "+enforcement, zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Strings("httpRules", formatted))
+	k.reportNetworkPolicyEvent(networkPolicy, EventHTTPRulesNotEnforced)
+}
+
+// enforceableNetworkPolicies removes the ingress rules restricted by HTTP rules in strict mode.
+// NetworkPolicies with invalid HTTP rules lose all their ingress rules. The NetworkPolicies
+// that lost ingress rules are returned as well, as the pods they select must stay isolated.
+func (k *KubernetesPolicy) enforceableNetworkPolicies(networkPolicies *networking.NetworkPolicyList) (*networking.NetworkPolicyList, []networking.NetworkPolicy) {
+	if !k.strictHTTPRules {
+		return networkPolicies, nil
+	}
+
+	enforceableList := &networking.NetworkPolicyList{}
+	restricted := []networking.NetworkPolicy{}
+	for _, networkPolicy := range networkPolicies.Items {
+		if _, ok := networkPolicy.GetAnnotations()[HTTPRulesAnnotationID]; !ok {
+			enforceableList.Items = append(enforceableList.Items, networkPolicy)
+			continue
+		}
+
+		removed := map[int]bool{}
+		rules, err := networkPolicyHTTPRules(&networkPolicy)
+		for i := range networkPolicy.Spec.Ingress {
+			removed[i] = err != nil
+		}
+		for _, rule := range rules {
+			removed[rule.Ingress] = true
+		}
+
+		// The range copy is modified, the store keeps the original ingress rules.
+		enforceable := networkPolicy
+		enforceable.Spec.Ingress = []networking.NetworkPolicyIngressRule{}
+		for i, ingressRule := range networkPolicy.Spec.Ingress {
+			if !removed[i] {
+				enforceable.Spec.Ingress = append(enforceable.Spec.Ingress, ingressRule)
+			}
+		}
+		if len(enforceable.Spec.Ingress) < len(networkPolicy.Spec.Ingress) {
+			restricted = append(restricted, networkPolicy)
+		}
+		enforceableList.Items = append(enforceableList.Items, enforceable)
+	}
+	return enforceableList, restricted
+}
+
+// isIngressRestricted returns true if one of the NetworkPolicies that lost ingress rules selects
+// the pod. Without those rules the pod could be left without any ingress rule, which would allow
+// all the traffic: its ingress must be denied by default instead.
+func isIngressRestricted(pod *api.Pod, restricted []networking.NetworkPolicy) bool {
+	for _, networkPolicy := range restricted {
+		if networkPolicy.GetNamespace() != pod.GetNamespace() {
+			continue
+		}
+		podSelector, err := metav1.LabelSelectorAsSelector(&networkPolicy.Spec.PodSelector)
+		if err != nil {
+			// An invalid selector cannot be trusted to exclude the pod.
+			return true
+		}
+		if podSelector.Matches(labels.Set(pod.GetLabels())) {
+			return true
+		}
+	}
+	return false
+}
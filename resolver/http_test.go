@@ -0,0 +1,121 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/kubepox"
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func httpNetworkPolicy(name string, httpRules string, ports ...int) networking.NetworkPolicy {
+	networkPolicy := networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "shop",
+		},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+		},
+	}
+	if httpRules != "" {
+		networkPolicy.SetAnnotations(map[string]string{HTTPRulesAnnotationID: httpRules})
+	}
+	for _, port := range ports {
+		portNumber := intstr.FromInt(port)
+		networkPolicy.Spec.Ingress = append(networkPolicy.Spec.Ingress, networking.NetworkPolicyIngressRule{
+			Ports: []networking.NetworkPolicyPort{{Port: &portNumber}},
+			From:  []networking.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}},
+		})
+	}
+	return networkPolicy
+}
+
+var enforceableNetworkPoliciesTests = []struct {
+	name          string
+	strict        bool
+	networkPolicy networking.NetworkPolicy
+	ingressRules  int
+	restricted    bool
+}{
+	{"without HTTP rules", true, httpNetworkPolicy("plain", "", 80, 443), 2, false},
+	{"not strict", false, httpNetworkPolicy("http", `[{"ingress":0,"methods":["GET"]}]`, 80, 443), 2, false},
+	{"restricted rule", true, httpNetworkPolicy("http", `[{"ingress":0,"methods":["GET"]}]`, 80, 443), 1, true},
+	{"invalid HTTP rules", true, httpNetworkPolicy("http", `[{"ingress":5}]`, 80, 443), 0, true},
+	{"empty HTTP rules", true, httpNetworkPolicy("http", `[]`, 80), 1, false},
+}
+
+func TestEnforceableNetworkPolicies(t *testing.T) {
+	for _, tt := range enforceableNetworkPoliciesTests {
+		k := &KubernetesPolicy{}
+		k.SetStrictHTTPRules(tt.strict)
+
+		networkPolicies := &networking.NetworkPolicyList{Items: []networking.NetworkPolicy{tt.networkPolicy}}
+		enforceable, restricted := k.enforceableNetworkPolicies(networkPolicies)
+		if ingressRules := len(enforceable.Items[0].Spec.Ingress); ingressRules != tt.ingressRules {
+			t.Errorf("%s: enforceableNetworkPolicies => %d ingress rules, expected %d", tt.name, ingressRules, tt.ingressRules)
+		}
+		if (len(restricted) > 0) != tt.restricted {
+			t.Errorf("%s: enforceableNetworkPolicies => restricted %v, expected %t", tt.name, restricted, tt.restricted)
+		}
+		if len(networkPolicies.Items[0].Spec.Ingress) != len(tt.networkPolicy.Spec.Ingress) {
+			t.Errorf("%s: enforceableNetworkPolicies modified the original NetworkPolicy", tt.name)
+		}
+	}
+}
+
+func TestStrictHTTPRulesDeny(t *testing.T) {
+	k := &KubernetesPolicy{}
+	k.SetStrictHTTPRules(true)
+
+	// The only ingress rule of the NetworkPolicy is restricted by an HTTP rule.
+	networkPolicies := &networking.NetworkPolicyList{Items: []networking.NetworkPolicy{
+		httpNetworkPolicy("http", `[{"ingress":0,"paths":["/public"]}]`, 80),
+	}}
+	apiPod := pod("shop", map[string]string{"app": "api"}, nil)
+	otherPod := pod("shop", map[string]string{"app": "web"}, nil)
+
+	enforceable, restricted := k.enforceableNetworkPolicies(networkPolicies)
+	if !isIngressRestricted(apiPod, restricted) {
+		t.Fatalf("isIngressRestricted => false for a pod selected by the restricted NetworkPolicy")
+	}
+	if isIngressRestricted(otherPod, restricted) {
+		t.Errorf("isIngressRestricted => true for a pod not selected by the restricted NetworkPolicy")
+	}
+
+	ingressRules, err := kubepox.ListIngressRulesPerPod(apiPod, enforceable)
+	if err != nil {
+		t.Fatalf("ListIngressRulesPerPod => unexpected error %s", err)
+	}
+	if len(*ingressRules) != 0 {
+		t.Fatalf("ListIngressRulesPerPod => %d rules, expected none", len(*ingressRules))
+	}
+
+	allNamespaces := &api.NamespaceList{Items: []api.Namespace{*namespace("shop", nil)}}
+	tags := policy.NewTagStoreFromMap(map[string]string{"app": "api", "@namespace": "shop"})
+	puPolicy, err := generatePUPolicy(ingressRules, &[]networking.NetworkPolicyEgressRule{}, nil, nil, nil, nil, "shop", allNamespaces, tags, nil, nil, PostureAllow.withIngressDenied(), false, nil)
+	if err != nil {
+		t.Fatalf("generatePUPolicy => unexpected error %s", err)
+	}
+	if len(puPolicy.ReceiverRules()) != 0 || len(puPolicy.NetworkACLs()) != 0 {
+		t.Errorf("generatePUPolicy => expected the ingress to be denied, got %d rules and %d ACLs", len(puPolicy.ReceiverRules()), len(puPolicy.NetworkACLs()))
+	}
+}
+
+func TestWithIngressDenied(t *testing.T) {
+	tests := map[DefaultPosture]DefaultPosture{
+		PostureAllow:       PostureDenyIngress,
+		PostureDenyIngress: PostureDenyIngress,
+		PostureDenyEgress:  PostureDenyAll,
+		PostureDenyAll:     PostureDenyAll,
+	}
+	for posture, expected := range tests {
+		if result := posture.withIngressDenied(); result != expected {
+			t.Errorf("%s.withIngressDenied() => %s, expected %s", posture, result, expected)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"strings"
+
+	"github.com/aporeto-inc/trireme-kubernetes/collector"
+
+	"github.com/aporeto-inc/kubepox"
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+
+	"go.uber.org/zap"
+)
+
+// NetworkPolicyID returns the PolicyID of the rules generated from the NetworkPolicy.
+func NetworkPolicyID(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+// NetworkPolicy returns the NetworkPolicy, as namespace/name, that generated the rules of policyID.
+// The rules generated for the services, the FQDNs and the system have a prefixed PolicyID.
+func (k *KubernetesPolicy) NetworkPolicy(policyID string) (string, bool) {
+	if strings.Contains(policyID, ":") || strings.Count(policyID, "/") != 1 {
+		return "", false
+	}
+	return policyID, true
+}
+
+// PodMetadata returns the Kubernetes metadata of the local pod of the contextID.
+func (k *KubernetesPolicy) PodMetadata(contextID string) (*collector.PodMetadata, bool) {
+	entry, ok := k.cache.podByContextID(contextID)
+	if !ok {
+		return nil, false
+	}
+
+	metadata := &collector.PodMetadata{
+		Name:      entry.podName,
+		Namespace: entry.podNamespace,
+	}
+
+	nsWatcher, exist := k.cache.getNamespaceWatcher(entry.podNamespace)
+	if !exist {
+		return metadata, true
+	}
+	item, exists, err := nsWatcher.podStore.GetByKey(kubePodIdentifier(entry.podName, entry.podNamespace))
+	if err != nil || !exists {
+		return metadata, true
+	}
+
+	return podMetadata(item.(*api.Pod)), true
+}
+
+// podMetadata returns the Kubernetes metadata of the pod.
+func podMetadata(pod *api.Pod) *collector.PodMetadata {
+	return &collector.PodMetadata{
+		Name:           pod.GetName(),
+		Namespace:      pod.GetNamespace(),
+		Node:           pod.Spec.NodeName,
+		Workload:       podWorkload(pod),
+		ServiceAccount: pod.Spec.ServiceAccountName,
+		Labels:         pod.GetLabels(),
+	}
+}
+
+// podRulePolicyIDs returns the NetworkPolicy ID of each ingress and egress rule applied to the pod,
+// in the order of the rules listed by kubepox. The IDs are nil if the rules cannot be attributed.
+func podRulePolicyIDs(pod *api.Pod, networkPolicies *networking.NetworkPolicyList, ingressCount int, egressCount int) ([]string, []string) {
+	ingressPolicyIDs := []string{}
+	egressPolicyIDs := []string{}
+
+	for _, networkPolicy := range networkPolicies.Items {
+		singlePolicy := &networking.NetworkPolicyList{Items: []networking.NetworkPolicy{networkPolicy}}
+		policyID := NetworkPolicyID(networkPolicy.GetNamespace(), networkPolicy.GetName())
+
+		ingressRules, err := kubepox.ListIngressRulesPerPod(pod, singlePolicy)
+		if err != nil {
+			return nil, nil
+		}
+		if ingressRules != nil {
+			for range *ingressRules {
+				ingressPolicyIDs = append(ingressPolicyIDs, policyID)
+			}
+		}
+
+		egressRules, err := kubepox.ListEgressRulesPerPod(pod, singlePolicy)
+		if err != nil {
+			return nil, nil
+		}
+		if egressRules != nil {
+			for range *egressRules {
+				egressPolicyIDs = append(egressPolicyIDs, policyID)
+			}
+		}
+	}
+
+	if len(ingressPolicyIDs) != ingressCount || len(egressPolicyIDs) != egressCount {
+		logger().Debug("Couldn't attribute the rules to the NetworkPolicies", zap.String("name", pod.GetName()), zap.String("namespace", pod.GetNamespace()))
+		return nil, nil
+	}
+	return ingressPolicyIDs, egressPolicyIDs
+}
+
+// setPolicyID sets the PolicyID of the rules and ACLs generated from a NetworkPolicy rule.
+func setPolicyID(rules []policy.TagSelector, acls []policy.IPRule, policyIDs []string, index int) {
+	if index >= len(policyIDs) {
+		return
+	}
+
+	for _, rule := range rules {
+		rule.Policy.PolicyID = policyIDs[index]
+	}
+	for _, acl := range acls {
+		acl.Policy.PolicyID = policyIDs[index]
+	}
+}
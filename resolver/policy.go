@@ -3,23 +3,29 @@
 package resolver
 
 import (
-	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/aporeto-inc/trireme-kubernetes/kubernetes"
 
 	"github.com/aporeto-inc/kubepox"
 	"github.com/aporeto-inc/trireme"
+	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/monitor"
 	"github.com/aporeto-inc/trireme/policy"
 
 	api "k8s.io/api/core/v1"
 	networking "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	kubecache "k8s.io/client-go/tools/cache"
 
 	"go.uber.org/zap"
 )
 
+// policyStoresSyncTimeout is the time given to the stores used to resolve the pod policies to sync.
+var policyStoresSyncTimeout = 2 * time.Minute
+
 // KubernetesPolicy represents a Trireme Policer for Kubernetes.
 // It implements the Trireme Resolver interface and implements the policies defined
 // by Kubernetes NetworkPolicy API.
@@ -29,8 +35,37 @@ type KubernetesPolicy struct {
 	KubernetesClient *kubernetes.Client
 	betaPolicies     bool
 	egressPolicies   bool
-	cache            *cache
-	stopAll          chan struct{}
+	auditMode        bool
+	namespaceFilter  *NamespaceFilter
+	optOutNamespaces map[string]bool
+	// forensicNamespace is the namespace still allowed to reach quarantined pods.
+	forensicNamespace string
+	// breakGlassNamespace and breakGlassName define the ConfigMap watched for the break-glass.
+	breakGlassNamespace string
+	breakGlassName      string
+	breakGlass          breakGlass
+	// clusterPolicies is the store of the ClusterNetworkPolicies if enabled.
+	clusterPoliciesEnabled bool
+	clusterPolicies        kubecache.Store
+	// dns is the cluster DNS allowed for egress isolated pods. Disabled if nil.
+	dns *dnsAllowance
+	// fqdns tracks the FQDNs allowed by the NetworkPolicies. Disabled if nil.
+	fqdns *fqdnTracker
+	// services tracks the services allowed by the NetworkPolicies. Disabled if nil.
+	services *serviceTracker
+	// remotePods indexes the pods of all the nodes by IP. Disabled if nil.
+	remotePods *remotePods
+	// windows schedules the NetworkPolicies time windows.
+	windows *policyWindows
+	// strictHTTPRules removes the ingress rules restricted by HTTP rules.
+	strictHTTPRules bool
+	eventCollector  collector.EventCollector
+	cache           *cache
+	stopAll         chan struct{}
+	// synced is closed once the stores used to resolve the pod policies are synced.
+	synced chan struct{}
+	// settingsLock protects the settings that can be changed at runtime.
+	settingsLock sync.RWMutex
 }
 
 // NewKubernetesPolicy creates a new policy engine for the Trireme package
@@ -45,43 +80,42 @@ func NewKubernetesPolicy(kubeconfig string, nodename string, triremeNetworks []s
 		KubernetesClient: client,
 		betaPolicies:     betaPolicies,
 		egressPolicies:   egressPolicies,
+		namespaceFilter:  defaultNamespaceFilter(),
+		optOutNamespaces: map[string]bool{},
 		cache:            newCache(),
+		windows:          newPolicyWindows(),
+		synced:           make(chan struct{}),
 	}, nil
 }
 
-// isNamespaceNetworkPolicyActive returns true if the namespace has NetworkPolicies
-// activated on the annotation
-func isNamespaceNetworkPolicyActive(namespace *api.Namespace) bool {
-	// Statically never actvating anything into Kube-System namespace.
-	// TODO: Allow KubeSystem to have networking policies enabled ?
-	if namespace.GetName() == "kube-system" {
-		return false
-	}
-
-	// Check if annotation is present. As NetworkPolicies in K8s are still beta
-	// The format needs to be manually parsed out of JSON.
-	value, ok := namespace.GetAnnotations()[KubernetesNetworkPolicyAnnotationID]
-
-	if !ok {
-		return false
-	}
-	networkPolicyAnnotation := &NamespaceNetworkPolicy{}
-	if err := json.Unmarshal([]byte(value), networkPolicyAnnotation); err != nil {
-		return false
+// namespacePosture returns the default posture of the pods of the namespace.
+// Under Beta NetworkPolicies, all the traffic not allowed by a policy is denied.
+func (k *KubernetesPolicy) namespacePosture(namespaceName string, allNamespaces *api.NamespaceList) DefaultPosture {
+	if k.betaPolicies {
+		return PostureDenyAll
 	}
 
-	if networkPolicyAnnotation != nil &&
-		networkPolicyAnnotation.Ingress != nil &&
-		networkPolicyAnnotation.Ingress.Isolation != nil &&
-		*networkPolicyAnnotation.Ingress.Isolation == DefaultDeny {
-		return true
+	for _, namespace := range allNamespaces.Items {
+		if namespace.GetName() != namespaceName {
+			continue
+		}
+		posture, err := namespacePosture(&namespace)
+		if err != nil {
+			logger().Error("Ignoring namespace default posture", zap.Error(err))
+		}
+		return posture
 	}
-	return false
+	return PostureAllow
 }
 
 // isNamespaceKubeSystem returns true if the namespace is kube-system
 func isNamespaceKubeSystem(namespace string) bool {
-	return namespace == "kube-system"
+	return namespace == kubeSystemNamespace
+}
+
+// isPostureUpdateNeeded returns true if the default posture annotation of the namespace changed.
+func isPostureUpdateNeeded(oldNS, updatedNS *api.Namespace) bool {
+	return oldNS.GetAnnotations()[DefaultPostureAnnotationID] != updatedNS.GetAnnotations()[DefaultPostureAnnotationID]
 }
 
 func isPolicyUpdateNeeded(oldPod, newPod *api.Pod) bool {
@@ -91,15 +125,72 @@ func isPolicyUpdateNeeded(oldPod, newPod *api.Pod) bool {
 	if !labels.Equals(oldPod.GetLabels(), newPod.GetLabels()) {
 		return true
 	}
+	if oldPod.GetAnnotations()[EnforcementAnnotationID] != newPod.GetAnnotations()[EnforcementAnnotationID] {
+		return true
+	}
 	return false
 }
 
+// isQuarantined returns true if the pod has the quarantine label.
+func isQuarantined(pod *api.Pod) bool {
+	return pod.GetLabels()[QuarantineLabelKey] == QuarantineLabelValue
+}
+
+// isEnforcementDisabled returns true if the pod has the annotation disabling enforcement.
+func isEnforcementDisabled(pod *api.Pod) bool {
+	return pod.GetAnnotations()[EnforcementAnnotationID] == EnforcementDisabled
+}
+
 // SetPolicyUpdater registers the interface used for updating Policies explicitely.
 func (k *KubernetesPolicy) SetPolicyUpdater(policyUpdater trireme.PolicyUpdater) error {
 	k.policyUpdater = policyUpdater
 	return nil
 }
 
+// SetNamespaceFilter registers the filter deciding which namespaces are policed.
+// Must be called before Run.
+func (k *KubernetesPolicy) SetNamespaceFilter(namespaceFilter *NamespaceFilter) {
+	k.namespaceFilter = namespaceFilter
+}
+
+// SetEnforcementOptOutNamespaces registers the namespaces in which pods are allowed to
+// disable enforcement with the EnforcementAnnotationID annotation. "*" allows every namespace.
+// Must be called before Run.
+func (k *KubernetesPolicy) SetEnforcementOptOutNamespaces(namespaces []string) {
+	k.optOutNamespaces = map[string]bool{}
+	for _, namespace := range namespaces {
+		k.optOutNamespaces[namespace] = true
+	}
+}
+
+// isEnforcementOptOutAllowed returns true if pods in the namespace can disable enforcement.
+func (k *KubernetesPolicy) isEnforcementOptOutAllowed(namespace string) bool {
+	return k.optOutNamespaces["*"] || k.optOutNamespaces[namespace]
+}
+
+// SetQuarantineForensicNamespace registers the namespace that is still allowed to
+// communicate with quarantined pods. Must be called before Run.
+func (k *KubernetesPolicy) SetQuarantineForensicNamespace(namespace string) {
+	k.forensicNamespace = namespace
+}
+
+// SetAuditMode enables or disables the audit mode. In audit mode, policies are computed
+// and logged but every pod gets an AllowAll policy.
+func (k *KubernetesPolicy) SetAuditMode(auditMode bool) {
+	k.settingsLock.Lock()
+	k.auditMode = auditMode
+	k.settingsLock.Unlock()
+
+	logger().Info("Audit mode changed", zap.Bool("auditMode", auditMode))
+	k.updateAllPodPolicies()
+}
+
+func (k *KubernetesPolicy) settings() (triremeNetworks []string, auditMode bool) {
+	k.settingsLock.RLock()
+	defer k.settingsLock.RUnlock()
+	return k.triremeNetworks, k.auditMode
+}
+
 // ResolvePolicy generates the Policy for the target PU.
 // The policy for the PU will be based on the defined
 // Kubernetes NetworkPolicies on the Pod to which the PU belongs.
@@ -110,7 +201,7 @@ func (k *KubernetesPolicy) ResolvePolicy(contextID string, runtimeGetter policy.
 	tagContent, ok := runtimeGetter.Tag(KubernetesContainerName)
 	if !ok || tagContent != KubernetesInfraContainerName {
 		// return AllowAll
-		zap.L().Info("Container is not Infra Container. AllowingAll", zap.String("contextID", contextID))
+		logger().Info("Container is not Infra Container. AllowingAll", zap.String("contextID", contextID))
 		return notInfraContainerPolicy(), nil
 	}
 
@@ -125,18 +216,21 @@ func (k *KubernetesPolicy) ResolvePolicy(contextID string, runtimeGetter policy.
 
 	// Keep the mapping in cache: ContextID <--> PodNamespace/PodName
 	k.cache.addPodToCache(contextID, podName, podNamespace)
+
+	// Containers can start before Run synced the stores.
+	<-k.synced
 	return k.resolvePodPolicy(podName, podNamespace)
 }
 
 // HandlePUEvent  is called by Trireme for notification that a specific PU got an event.
 func (k *KubernetesPolicy) HandlePUEvent(contextID string, eventType monitor.Event) {
-	zap.L().Debug("Trireme Container Event", zap.String("contextID", contextID), zap.Any("eventType", eventType))
+	logger().Debug("Trireme Container Event", zap.String("contextID", contextID), zap.Any("eventType", eventType))
 }
 
 // resolvePodPolicy generates the Trireme Policy for a specific Kube Pod and Namespace.
 func (k *KubernetesPolicy) resolvePodPolicy(kubernetesPod string, kubernetesNamespace string) (*policy.PUPolicy, error) {
 	// Query Kube API to get the Pod's label and IP.
-	zap.L().Info("Resolving policy for POD", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace))
+	logger().Info("Resolving policy for POD", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace))
 	pod, err := k.KubernetesClient.Pod(kubernetesPod, kubernetesNamespace)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't get labels for pod %s : %v", kubernetesPod, err)
@@ -156,16 +250,29 @@ func (k *KubernetesPolicy) resolvePodPolicy(kubernetesPod string, kubernetesName
 		return notInfraContainerPolicy(), nil
 	}
 
-	// Check if the Pod's namespace is activated.
+	triremeNetworks, auditMode := k.settings()
+
+	overridingPolicy, override, err := k.overridingPolicy(pod, triremeNetworks)
+	if err != nil {
+		return nil, err
+	}
+	k.reportPodOverride(pod, override)
+	if override != "" {
+		return overridingPolicy, nil
+	}
+
+	// Check if the Pod's namespace is activated. The ClusterNetworkPolicies still apply.
 	if !k.cache.isNamespaceActive(kubernetesNamespace) {
 
-		zap.L().Info("Pod namespace is not NetworkPolicyActivated, AllowAll", zap.String("podNamespace", kubernetesNamespace))
+		logger().Info("Pod namespace is not NetworkPolicyActivated, AllowAll", zap.String("podNamespace", kubernetesNamespace))
 		// adding the namespace as an extra label.
 		podLabels["@namespace"] = kubernetesNamespace
 		ips := policy.ExtendedMap{policy.DefaultNamespace: pod.Status.PodIP}
-		allowAllPuPolicy := allowAllPolicy(policy.NewTagStoreFromMap(podLabels), ips, k.triremeNetworks)
+		if auditMode {
+			return allowAllPolicy(policy.NewTagStoreFromMap(podLabels), ips, triremeNetworks), nil
+		}
 
-		return allowAllPuPolicy, nil
+		return k.allowAllPodPolicy(pod, policy.NewTagStoreFromMap(podLabels), ips, triremeNetworks)
 	}
 
 	// adding the namespace as an extra label.
@@ -184,6 +291,7 @@ func (k *KubernetesPolicy) resolvePodPolicy(kubernetesPod string, kubernetesName
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't generate current NetPolicies for the namespace %s ", kubernetesNamespace)
 	}
+	namespaceRules, restrictedRules := k.enforceableNetworkPolicies(activeNetworkPolicies(namespaceRules, time.Now()))
 
 	ingressPodRules, err := k.KubernetesClient.IngressPodRules(kubernetesPod, kubernetesNamespace, namespaceRules)
 	if err != nil {
@@ -195,23 +303,104 @@ func (k *KubernetesPolicy) resolvePodPolicy(kubernetesPod string, kubernetesName
 		return nil, fmt.Errorf("Couldn't get the NetworkPolicies for Pod %s : %s", kubernetesPod, err)
 	}
 
-	allNamespaces, _ := k.KubernetesClient.AllNamespaces()
+	allNamespaces, err := k.KubernetesClient.AllNamespaces()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't list the namespaces: %s", err)
+	}
 
 	ips := policy.ExtendedMap{policy.DefaultNamespace: pod.Status.PodIP}
 
-	puPolicy, err := generatePUPolicy(ingressPodRules, egressPodRules, kubernetesNamespace, allNamespaces, policy.NewTagStoreFromMap(podLabels), ips, k.triremeNetworks, k.betaPolicies, k.egressPolicies)
+	clusterPodRules := generateClusterRules(k.sortedClusterNetworkPolicies(), pod, allNamespaces)
+
+	posture := k.namespacePosture(kubernetesNamespace, allNamespaces)
+	if isIngressRestricted(pod, restrictedRules) {
+		posture = posture.withIngressDenied()
+	}
+
+	// Pods selected by a NetworkPolicy with FQDNs or services are egress isolated.
+	servicePodRules, servicePodACLs, externalNames, serviceSelected := k.serviceRules(pod, namespaceRules)
+	fqdnPodACLs, fqdnSelected := k.fqdnACLs(pod, namespaceRules, externalNames)
+	if fqdnSelected || serviceSelected {
+		posture = posture.withEgressDenied()
+	}
+
+	var systemPodRules *systemRules
+	egressIsolated := isEgressIsolated(egressPodRules, posture, k.egressPolicies)
+	if egressIsolated {
+		systemPodRules = k.dnsRules().with(servicePodRules, servicePodACLs).with(nil, fqdnPodACLs)
+	}
+	k.trackDNS(podKey(kubernetesPod, kubernetesNamespace), egressIsolated)
+
+	ingressPolicyIDs, egressPolicyIDs := podRulePolicyIDs(pod, namespaceRules, len(*ingressPodRules), len(*egressPodRules))
+
+	puPolicy, err := generatePUPolicy(ingressPodRules, egressPodRules, ingressPolicyIDs, egressPolicyIDs, clusterPodRules, systemPodRules, kubernetesNamespace, allNamespaces, policy.NewTagStoreFromMap(podLabels), ips, triremeNetworks, posture, k.egressPolicies, k.encryptedNamespaces(allNamespaces))
 	if err != nil {
 		return nil, err
 	}
 
+	// In audit mode, the generated rules are only logged.
+	if auditMode {
+		logger().Info("Audit mode. Policy not enforced for POD", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace))
+		return allowAllPolicy(policy.NewTagStoreFromMap(podLabels), ips, triremeNetworks), nil
+	}
+
 	return puPolicy, nil
 }
 
+// overridingPolicy returns the policy overriding the NetworkPolicies of the pod, if any, and
+// the event reporting the override. Quarantined pods are denied everything. The break-glass
+// allows everything. The enforcement opt-out allows everything but the ClusterNetworkPolicies.
+func (k *KubernetesPolicy) overridingPolicy(pod *api.Pod, triremeNetworks []string) (*policy.PUPolicy, string, error) {
+	kubernetesPod := pod.GetName()
+	kubernetesNamespace := pod.GetNamespace()
+	podLabels := pod.GetLabels()
+	ips := policy.ExtendedMap{policy.DefaultNamespace: pod.Status.PodIP}
+
+	// Quarantined pods are isolated right away, overriding every other policy.
+	if isQuarantined(pod) {
+		logger().Warn("Pod quarantined. DenyAll", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace), zap.String("forensicNamespace", k.forensicNamespace))
+
+		podLabels["@namespace"] = kubernetesNamespace
+		return quarantinePolicy(policy.NewTagStoreFromMap(podLabels), ips, triremeNetworks, k.forensicNamespace), EventQuarantine, nil
+	}
+
+	// The break-glass bypasses every policy except the quarantine.
+	if k.isBreakGlassActive() {
+		logger().Warn("Break-glass active. AllowAll", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace))
+
+		podLabels["@namespace"] = kubernetesNamespace
+		return allowAllPolicy(policy.NewTagStoreFromMap(podLabels), ips, triremeNetworks), EventBreakGlass, nil
+	}
+
+	// Pods can opt out of enforcement only in the allowed namespaces. Every bypass is audited.
+	if isEnforcementDisabled(pod) {
+		if k.isEnforcementOptOutAllowed(kubernetesNamespace) {
+			logger().Warn("Enforcement disabled by annotation. AllowAll", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace))
+
+			podLabels["@namespace"] = kubernetesNamespace
+			puPolicy, err := k.allowAllPodPolicy(pod, policy.NewTagStoreFromMap(podLabels), ips, triremeNetworks)
+			return puPolicy, EventEnforcementBypass, err
+		}
+		logger().Warn("Enforcement opt-out annotation not allowed in namespace. Ignoring", zap.String("name", kubernetesPod), zap.String("namespace", kubernetesNamespace))
+	}
+
+	return nil, "", nil
+}
+
 // updatePodPolicy updates (and replace) the policy of the pod given in parameter.
 func (k *KubernetesPolicy) updatePodPolicy(pod *api.Pod) error {
-	podName := pod.GetName()
-	podNamespace := pod.GetNamespace()
-	zap.L().Info("Update pod Policy", zap.String("podNamespace", podNamespace), zap.String("podName", podName))
+	return k.updatePodPolicyByName(pod.GetName(), pod.GetNamespace())
+}
+
+// updatePodPolicyByName updates (and replace) the policy of the pod podNamespace/podName.
+func (k *KubernetesPolicy) updatePodPolicyByName(podName string, podNamespace string) error {
+	logger().Info("Update pod Policy", zap.String("podNamespace", podNamespace), zap.String("podName", podName))
+
+	// The pods known before the stores are synced are resolved once they are.
+	if !k.isSynced() {
+		logger().Debug("Policy stores not synced yet. Skipping pod update", zap.String("podNamespace", podNamespace), zap.String("podName", podName))
+		return nil
+	}
 
 	if k.policyUpdater == nil {
 		return fmt.Errorf("PolicyUpdate failed: No PolicyUpdater registered")
@@ -235,9 +424,30 @@ func (k *KubernetesPolicy) updatePodPolicy(pod *api.Pod) error {
 	return nil
 }
 
+// updateAllPodPolicies updates the policy of all the pods known in cache.
+func (k *KubernetesPolicy) updateAllPodPolicies() {
+	for _, entry := range k.cache.allPods() {
+		if err := k.updatePodPolicyByName(entry.podName, entry.podNamespace); err != nil {
+			logger().Error("Error updating pod policy", zap.String("name", entry.podName), zap.String("namespace", entry.podNamespace), zap.Error(err))
+		}
+	}
+}
+
+// updateNamespacePodPolicies updates the policy of all the pods known in cache for a namespace.
+func (k *KubernetesPolicy) updateNamespacePodPolicies(namespace string) {
+	for _, entry := range k.cache.allPods() {
+		if entry.podNamespace != namespace {
+			continue
+		}
+		if err := k.updatePodPolicyByName(entry.podName, entry.podNamespace); err != nil {
+			logger().Error("Error updating pod policy", zap.String("name", entry.podName), zap.String("namespace", entry.podNamespace), zap.Error(err))
+		}
+	}
+}
+
 // activateNamespace starts to watch the pods and networkpolicies in the parameter namespace.
 func (k *KubernetesPolicy) activateNamespace(namespace *api.Namespace) error {
-	zap.L().Info("Activating namespace for NetworkPolicies", zap.String("namespace", namespace.GetName()))
+	logger().Info("Activating namespace for NetworkPolicies", zap.String("namespace", namespace.GetName()))
 
 	podControllerStop := make(chan struct{})
 	podStore, podController := k.KubernetesClient.CreateLocalPodController(namespace.GetName(),
@@ -245,7 +455,7 @@ func (k *KubernetesPolicy) activateNamespace(namespace *api.Namespace) error {
 		k.deletePod,
 		k.updatePod)
 	go podController.Run(podControllerStop)
-	zap.L().Debug("Pod Controller created", zap.String("namespace", namespace.GetName()))
+	logger().Debug("Pod Controller created", zap.String("namespace", namespace.GetName()))
 
 	npControllerStop := make(chan struct{})
 	npStore, npController := k.KubernetesClient.CreateNetworkPoliciesController(namespace.Name,
@@ -253,99 +463,213 @@ func (k *KubernetesPolicy) activateNamespace(namespace *api.Namespace) error {
 		k.deleteNetworkPolicy,
 		k.updateNetworkPolicy)
 	go npController.Run(npControllerStop)
-	zap.L().Debug("NetworkPolicy controller created", zap.String("namespace", namespace.GetName()))
+	logger().Debug("NetworkPolicy controller created", zap.String("namespace", namespace.GetName()))
 
 	namespaceWatcher := NewNamespaceWatcher(namespace.Name, podStore, podController, podControllerStop, npStore, npController, npControllerStop)
 	k.cache.activateNamespaceWatcher(namespace.GetName(), namespaceWatcher)
-	zap.L().Debug("Finished namespace activation", zap.String("namespace", namespace.GetName()))
+	logger().Debug("Finished namespace activation", zap.String("namespace", namespace.GetName()))
 
 	return nil
 }
 
 // deactivateNamespace stops all the watching on the specified namespace.
 func (k *KubernetesPolicy) deactivateNamespace(namespace *api.Namespace) error {
-	zap.L().Info("Deactivating namespace for NetworkPolicies ", zap.String("namespace", namespace.GetName()))
+	logger().Info("Deactivating namespace for NetworkPolicies ", zap.String("namespace", namespace.GetName()))
 	k.cache.deactivateNamespaceWatcher(namespace.GetName())
+	k.cancelNamespaceNetworkPolicyWindows(namespace.GetName())
 	return nil
 }
 
 // Run starts the KubernetesPolicer by watching for Namespace Changes.
-// Run is blocking. Use go
-func (k *KubernetesPolicy) Run() {
+// Run blocks until the stores used to resolve the pod policies are synced. It returns an error
+// if they can't be synced within policyStoresSyncTimeout, as no pod could be resolved.
+func (k *KubernetesPolicy) Run() error {
 	k.stopAll = make(chan struct{})
+
+	// The stores read while resolving the pod policies are synced before any pod is resolved.
+	synced := k.watchServices()
+	clusterPoliciesSynced, err := k.watchClusterNetworkPolicies()
+	if err != nil {
+		return fmt.Errorf("Couldn't watch ClusterNetworkPolicies: %s", err)
+	}
+	if clusterPoliciesSynced != nil {
+		synced = append(synced, clusterPoliciesSynced)
+	}
+	if err := waitForCacheSync(k.stopAll, policyStoresSyncTimeout, synced...); err != nil {
+		return err
+	}
+	close(k.synced)
+
 	_, nsController := k.KubernetesClient.CreateNamespaceController(
 		k.addNamespace,
 		k.deleteNamespace,
 		k.updateNamespace)
 	go nsController.Run(k.stopAll)
+	k.watchUnpolicedPods()
+	k.watchRemotePods()
+	k.watchBreakGlass()
+	return nil
+}
+
+// watchUnpolicedPods watches the pods of the node in every namespace. The pods of the activated
+// namespaces are watched by their NamespaceWatcher. The policy of the other pods still depends
+// on their quarantine label and enforcement annotation, which must apply right away.
+func (k *KubernetesPolicy) watchUnpolicedPods() {
+	_, podController := k.KubernetesClient.CreateLocalPodController(api.NamespaceAll,
+		func(addedPod *api.Pod) error {
+			return nil
+		},
+		func(deletedPod *api.Pod) error {
+			return nil
+		},
+		k.updateUnpolicedPod)
+	go podController.Run(k.stopAll)
+}
+
+// updateUnpolicedPod updates the policy of the pod if its namespace isn't activated.
+func (k *KubernetesPolicy) updateUnpolicedPod(oldPod, updatedPod *api.Pod) error {
+	if k.cache.isNamespaceActive(updatedPod.GetNamespace()) {
+		return nil
+	}
+	return k.updatePod(oldPod, updatedPod)
+}
+
+// waitForCacheSync waits until the stores are synced, at most for timeout. A store that can't
+// be listed, for instance without RBAC permission, never syncs.
+func waitForCacheSync(stop <-chan struct{}, timeout time.Duration, synced ...kubecache.InformerSynced) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	syncStop := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+		case <-time.After(timeout):
+		case <-done:
+			return
+		}
+		close(syncStop)
+	}()
+
+	if !kubecache.WaitForCacheSync(syncStop, synced...) {
+		return fmt.Errorf("Couldn't sync the policy stores within %s", timeout)
+	}
+	return nil
+}
+
+// isSynced returns true once the stores used to resolve the pod policies are synced.
+func (k *KubernetesPolicy) isSynced() bool {
+	select {
+	case <-k.synced:
+		return true
+	default:
+		return false
+	}
 }
 
 // Stop Stops all the channels
 func (k *KubernetesPolicy) Stop() {
-	k.stopAll <- struct{}{}
+	close(k.stopAll)
+	k.stopBreakGlass()
+	k.stopNetworkPolicyWindows()
+	if k.fqdns != nil {
+		k.fqdns.resolver.Stop()
+	}
 	for _, namespaceWatcher := range k.cache.namespaceActivation {
 		namespaceWatcher.stopWatchingNamespace()
 	}
 }
 
-func (k *KubernetesPolicy) addNamespace(addedNS *api.Namespace) error {
-	if k.cache.isNamespaceActive(addedNS.GetName()) {
-		// Namespace already activated
-		zap.L().Info("Namespace Added. already active", zap.String("namespace", addedNS.GetName()))
-		return nil
+// isNamespaceActivationNeeded returns true if the namespace should be activated,
+// along with the reason for logging.
+func (k *KubernetesPolicy) isNamespaceActivationNeeded(namespace *api.Namespace) (bool, string) {
+	if !k.namespaceFilter.isPoliced(namespace) {
+		return false, "Excluded from enforcement"
 	}
 
 	if !k.betaPolicies {
 		// Every namespace is activated under GA networkpolicies
-		zap.L().Info("Namespace Added. Activating GA NetworkPolicies", zap.String("namespace", addedNS.GetName()))
-		return k.activateNamespace(addedNS)
+		return true, "GA NetworkPolicies"
 	}
 
-	if !isNamespaceNetworkPolicyActive(addedNS) {
+	if !isNamespaceNetworkPolicyActive(namespace) {
 		// Beta Policies: Namespace doesn't have Beta NetworkPolicies annotations
-		zap.L().Info("Namespace Added. Doesn't have Beta NetworkPolicies annotations. Not activating", zap.String("namespace", addedNS.GetName()))
-		return nil
+		return false, "Doesn't have Beta NetworkPolicies annotations"
 	}
 
 	// Beta Policies: Namespace has the annotations
-	zap.L().Info("Namespace Added. Has Beta NetworkPolicies. Activating", zap.String("namespace", addedNS.GetName()))
+	return true, "Has Beta NetworkPolicies"
+}
+
+func (k *KubernetesPolicy) addNamespace(addedNS *api.Namespace) error {
+	if k.cache.isNamespaceActive(addedNS.GetName()) {
+		// Namespace already activated
+		logger().Info("Namespace Added. already active", zap.String("namespace", addedNS.GetName()))
+		return nil
+	}
+
+	activationNeeded, reason := k.isNamespaceActivationNeeded(addedNS)
+	if !activationNeeded {
+		logger().Info("Namespace Added. Not activating", zap.String("namespace", addedNS.GetName()), zap.String("reason", reason))
+		return nil
+	}
+
+	logger().Info("Namespace Added. Activating", zap.String("namespace", addedNS.GetName()), zap.String("reason", reason))
 	return k.activateNamespace(addedNS)
 }
 
 func (k *KubernetesPolicy) deleteNamespace(deletedNS *api.Namespace) error {
 	if k.cache.isNamespaceActive(deletedNS.GetName()) {
-		zap.L().Info("Namespace Deleted. Removing", zap.String("namespace", deletedNS.GetName()))
+		logger().Info("Namespace Deleted. Removing", zap.String("namespace", deletedNS.GetName()))
 		return k.deactivateNamespace(deletedNS)
 	}
 	return nil
 }
 
+// updateNamespace re-evaluates the activation of the namespace as its labels or
+// annotations might have changed.
 func (k *KubernetesPolicy) updateNamespace(oldNS, updatedNS *api.Namespace) error {
-	if !k.betaPolicies {
-		// GA Policies. No changes.
+	activationNeeded, reason := k.isNamespaceActivationNeeded(updatedNS)
+	active := k.cache.isNamespaceActive(updatedNS.GetName())
+
+	if activationNeeded == active {
+		logger().Debug("Namespace Modified. No activation change", zap.String("namespace", updatedNS.GetName()), zap.Bool("active", active), zap.String("reason", reason))
+
+		// The default posture applies to the pods of active namespaces only.
+		if active && isPostureUpdateNeeded(oldNS, updatedNS) {
+			logger().Info("Namespace default posture modified", zap.String("namespace", updatedNS.GetName()))
+			k.updateNamespacePodPolicies(updatedNS.GetName())
+		}
+		// The peers of the namespace pods must agree on the encryption.
+		if active && isEncryptionUpdateNeeded(oldNS, updatedNS) {
+			logger().Info("Namespace encryption modified", zap.String("namespace", updatedNS.GetName()))
+			k.updateAllPodPolicies()
+		}
 		return nil
 	}
 
-	// Beta NetworkPolicies: Checking if Namespace needs to be activated//deactivated.
-	if isNamespaceNetworkPolicyActive(updatedNS) {
-		if k.cache.isNamespaceActive(updatedNS.GetName()) {
-			zap.L().Info("Namespace Modified. Already active for beta NetPolicies", zap.String("namespace", updatedNS.GetName()))
-			return nil
-		}
-		zap.L().Info("Namespace Modified. Activating for beta NetPolicies", zap.String("namespace", updatedNS.GetName()))
+	// The encryption of the namespace only applies while it is active.
+	if isNamespaceEncrypted(updatedNS) {
+		defer k.updateAllPodPolicies()
+	}
+
+	if activationNeeded {
+		logger().Info("Namespace Modified. Activating", zap.String("namespace", updatedNS.GetName()), zap.String("reason", reason))
 		return k.activateNamespace(updatedNS)
 	}
 
-	if k.cache.isNamespaceActive(updatedNS.GetName()) {
-		zap.L().Info("Namespace Modified. Deactivating for beta NetPolicies", zap.String("namespace", updatedNS.GetName()))
-		return k.deactivateNamespace(updatedNS)
+	logger().Info("Namespace Modified. Deactivating", zap.String("namespace", updatedNS.GetName()), zap.String("reason", reason))
+	if err := k.deactivateNamespace(updatedNS); err != nil {
+		return err
 	}
-	zap.L().Info("Namespace Modified. Doesn't have Beta NetworkPolicies annotations. Not activating", zap.String("namespace", updatedNS.GetName()))
+
+	// The pods of a deactivated namespace are not policed anymore.
+	k.updateNamespacePodPolicies(updatedNS.GetName())
 	return nil
 }
 
 func (k *KubernetesPolicy) addPod(addedPod *api.Pod) error {
-	zap.L().Debug("Pod Added", zap.String("name", addedPod.GetName()), zap.String("namespace", addedPod.GetNamespace()))
+	logger().Debug("Pod Added", zap.String("name", addedPod.GetName()), zap.String("namespace", addedPod.GetNamespace()))
 
 	err := k.updatePodPolicy(addedPod)
 	if err != nil {
@@ -355,7 +679,13 @@ func (k *KubernetesPolicy) addPod(addedPod *api.Pod) error {
 }
 
 func (k *KubernetesPolicy) deletePod(deletedPod *api.Pod) error {
-	zap.L().Debug("Pod Deleted", zap.String("name", deletedPod.GetName()), zap.String("namespace", deletedPod.GetNamespace()))
+	logger().Debug("Pod Deleted", zap.String("name", deletedPod.GetName()), zap.String("namespace", deletedPod.GetNamespace()))
+
+	k.trackPodFQDNs(podKey(deletedPod.GetName(), deletedPod.GetNamespace()), nil)
+	if k.services != nil {
+		k.services.pods.track(podKey(deletedPod.GetName(), deletedPod.GetNamespace()), nil)
+	}
+	k.trackDNS(podKey(deletedPod.GetName(), deletedPod.GetNamespace()), false)
 
 	err := k.cache.deleteFromCacheByPodName(deletedPod.GetName(), deletedPod.GetNamespace())
 	if err != nil {
@@ -365,10 +695,10 @@ func (k *KubernetesPolicy) deletePod(deletedPod *api.Pod) error {
 }
 
 func (k *KubernetesPolicy) updatePod(oldPod, updatedPod *api.Pod) error {
-	zap.L().Debug("Pod Modified detected", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
+	logger().Debug("Pod Modified detected", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
 
 	if !isPolicyUpdateNeeded(oldPod, updatedPod) {
-		zap.L().Debug("No modified labels for Pod", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
+		logger().Debug("No modified labels for Pod", zap.String("name", updatedPod.GetName()), zap.String("namespace", updatedPod.GetNamespace()))
 		return nil
 	}
 	err := k.updatePodPolicy(updatedPod)
@@ -379,7 +709,9 @@ func (k *KubernetesPolicy) updatePod(oldPod, updatedPod *api.Pod) error {
 }
 
 func (k *KubernetesPolicy) addNetworkPolicy(addedNP *networking.NetworkPolicy) error {
-	zap.L().Debug("NetworkPolicy Added.", zap.String("name", addedNP.GetName()), zap.String("namespace", addedNP.GetNamespace()))
+	logger().Debug("NetworkPolicy Added.", zap.String("name", addedNP.GetName()), zap.String("namespace", addedNP.GetNamespace()))
+	k.scheduleNetworkPolicyWindow(addedNP, true)
+	k.reportHTTPRules(addedNP)
 
 	// TODO: Filter on pods from localNode only.
 	allLocalPods, err := k.KubernetesClient.LocalPods(addedNP.Namespace)
@@ -392,7 +724,7 @@ func (k *KubernetesPolicy) addNetworkPolicy(addedNP *networking.NetworkPolicy) e
 	}
 	//Reresolve all affected pods
 	for _, pod := range affectedPods.Items {
-		zap.L().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.Name), zap.String("namespace", pod.Namespace))
+		logger().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.Name), zap.String("namespace", pod.Namespace))
 		err := k.updatePodPolicy(&pod)
 		if err != nil {
 			return fmt.Errorf("UpdatePolicy failed: %s", err)
@@ -402,7 +734,8 @@ func (k *KubernetesPolicy) addNetworkPolicy(addedNP *networking.NetworkPolicy) e
 }
 
 func (k *KubernetesPolicy) deleteNetworkPolicy(deletedNP *networking.NetworkPolicy) error {
-	zap.L().Debug("NetworkPolicy Deleted.", zap.String("name", deletedNP.GetName()), zap.String("namespace", deletedNP.GetNamespace()))
+	logger().Debug("NetworkPolicy Deleted.", zap.String("name", deletedNP.GetName()), zap.String("namespace", deletedNP.GetNamespace()))
+	k.cancelNetworkPolicyWindow(deletedNP)
 
 	// TODO: Filter on pods from localNode only.
 	allLocalPods, err := k.KubernetesClient.LocalPods(deletedNP.Namespace)
@@ -415,7 +748,7 @@ func (k *KubernetesPolicy) deleteNetworkPolicy(deletedNP *networking.NetworkPoli
 	}
 	//Reresolve all affected pods
 	for _, pod := range affectedPods.Items {
-		zap.L().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.GetName()), zap.String("namespace", pod.GetNamespace()))
+		logger().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.GetName()), zap.String("namespace", pod.GetNamespace()))
 		err := k.updatePodPolicy(&pod)
 		if err != nil {
 			return fmt.Errorf("UpdatePolicy failed: %s", err)
@@ -425,7 +758,11 @@ func (k *KubernetesPolicy) deleteNetworkPolicy(deletedNP *networking.NetworkPoli
 }
 
 func (k *KubernetesPolicy) updateNetworkPolicy(oldNP, updatedNP *networking.NetworkPolicy) error {
-	zap.L().Debug("NetworkPolicy Modified", zap.String("name", updatedNP.GetName()), zap.String("namespace", updatedNP.GetNamespace()))
+	logger().Debug("NetworkPolicy Modified", zap.String("name", updatedNP.GetName()), zap.String("namespace", updatedNP.GetNamespace()))
+	k.scheduleNetworkPolicyWindow(updatedNP, false)
+	if oldNP.GetAnnotations()[HTTPRulesAnnotationID] != updatedNP.GetAnnotations()[HTTPRulesAnnotationID] {
+		k.reportHTTPRules(updatedNP)
+	}
 
 	// TODO: Filter on pods from localNode only.
 	allLocalPods, err := k.KubernetesClient.LocalPods(updatedNP.Namespace)
@@ -438,7 +775,7 @@ func (k *KubernetesPolicy) updateNetworkPolicy(oldNP, updatedNP *networking.Netw
 	}
 	//Reresolve all affected pods
 	for _, pod := range affectedPods.Items {
-		zap.L().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.GetName()), zap.String("name", pod.GetNamespace()))
+		logger().Debug("Updating pod based on a K8S NetworkPolicy Change", zap.String("name", pod.GetName()), zap.String("name", pod.GetNamespace()))
 		err := k.updatePodPolicy(&pod)
 		if err != nil {
 			return fmt.Errorf("UpdatePolicy failed: %s", err)
@@ -0,0 +1,281 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubecache "k8s.io/client-go/tools/cache"
+)
+
+func pod(namespace string, podLabels map[string]string, podAnnotations map[string]string) *api.Pod {
+	if podLabels == nil {
+		podLabels = map[string]string{}
+	}
+	return &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nginx",
+			Namespace:   namespace,
+			Labels:      podLabels,
+			Annotations: podAnnotations,
+		},
+		Status: api.PodStatus{PodIP: "10.0.0.1"},
+	}
+}
+
+// acceptsAll returns true if the rules accept every namespace.
+func acceptsAll(rules []policy.TagSelector) bool {
+	for _, rule := range rules {
+		if len(rule.Clause) == 1 && rule.Clause[0].Key == "@namespace" && rule.Clause[0].Value[0] == "*" && rule.Policy.Action&policy.Accept != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// aclsAcceptAll returns true if the ACLs accept every address.
+func aclsAcceptAll(acls []policy.IPRule) bool {
+	for _, acl := range acls {
+		if acl.Address == "0.0.0.0/0" && acl.Policy.Action&policy.Accept != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyAccepts returns true if the rules only accept the namespace.
+func onlyAccepts(rules []policy.TagSelector, namespace string) bool {
+	for _, rule := range rules {
+		if len(rule.Clause) != 1 || rule.Clause[0].Key != "@namespace" || rule.Clause[0].Value[0] != namespace {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	overrideNone     = "none"
+	overrideAllowAll = "allow-all"
+	overrideDenyAll  = "deny-all"
+)
+
+var overridingPolicyTests = []struct {
+	name             string
+	optOutNamespaces []string
+	pod              *api.Pod
+	expected         string
+}{
+	{"no annotation", []string{"debug"}, pod("debug", nil, nil), overrideNone},
+	{"opt-out allowed", []string{"debug"}, pod("debug", nil, map[string]string{EnforcementAnnotationID: EnforcementDisabled}), overrideAllowAll},
+	{"opt-out allowed everywhere", []string{"*"}, pod("shop", nil, map[string]string{EnforcementAnnotationID: EnforcementDisabled}), overrideAllowAll},
+	{"opt-out not allowed", []string{"debug"}, pod("shop", nil, map[string]string{EnforcementAnnotationID: EnforcementDisabled}), overrideNone},
+	{"opt-out without allowed namespaces", nil, pod("debug", nil, map[string]string{EnforcementAnnotationID: EnforcementDisabled}), overrideNone},
+	{"invalid opt-out value", []string{"debug"}, pod("debug", nil, map[string]string{EnforcementAnnotationID: "off"}), overrideNone},
+	{"quarantined", nil, pod("shop", map[string]string{QuarantineLabelKey: QuarantineLabelValue}, nil), overrideDenyAll},
+	{"quarantined with opt-out", []string{"*"}, pod("shop", map[string]string{QuarantineLabelKey: QuarantineLabelValue}, map[string]string{EnforcementAnnotationID: EnforcementDisabled}), overrideDenyAll},
+	{"quarantine label not true", nil, pod("shop", map[string]string{QuarantineLabelKey: "false"}, nil), overrideNone},
+}
+
+func TestOverridingPolicy(t *testing.T) {
+	for _, tt := range overridingPolicyTests {
+		k := &KubernetesPolicy{
+			forensicNamespace: "forensics",
+			cache:             newCache(),
+		}
+		k.SetEnforcementOptOutNamespaces(tt.optOutNamespaces)
+
+		puPolicy, override, err := k.overridingPolicy(tt.pod, nil)
+		if err != nil {
+			t.Fatalf("%s: overridingPolicy => unexpected error %s", tt.name, err)
+		}
+		result := overrideNone
+		if override != "" {
+			switch {
+			case acceptsAll(puPolicy.ReceiverRules()) && aclsAcceptAll(puPolicy.NetworkACLs()) && aclsAcceptAll(puPolicy.ApplicationACLs()):
+				result = overrideAllowAll
+			case len(puPolicy.NetworkACLs()) == 0 && len(puPolicy.ApplicationACLs()) == 0 && onlyAccepts(puPolicy.ReceiverRules(), "forensics") && onlyAccepts(puPolicy.TransmitterRules(), "forensics"):
+				result = overrideDenyAll
+			default:
+				result = "unexpected policy"
+			}
+		}
+		if result != tt.expected {
+			t.Errorf("%s: overridingPolicy => %s, expected %s", tt.name, result, tt.expected)
+		}
+	}
+}
+
+func TestQuarantinePolicyForensicNamespace(t *testing.T) {
+	tags := policy.NewTagStoreFromMap(map[string]string{"app": "nginx"})
+
+	isolated := quarantinePolicy(tags, nil, nil, "")
+	if len(isolated.ReceiverRules()) != 0 || len(isolated.TransmitterRules()) != 0 || len(isolated.NetworkACLs()) != 0 || len(isolated.ApplicationACLs()) != 0 {
+		t.Errorf("quarantinePolicy without forensic namespace => expected no rule and no ACL")
+	}
+
+	forensics := quarantinePolicy(tags, nil, nil, "forensics")
+	if len(forensics.ReceiverRules()) != 1 || !onlyAccepts(forensics.ReceiverRules(), "forensics") || !onlyAccepts(forensics.TransmitterRules(), "forensics") {
+		t.Errorf("quarantinePolicy with forensic namespace => expected only the forensic namespace to be accepted")
+	}
+}
+
+var postureTests = []struct {
+	posture        DefaultPosture
+	egressPolicies bool
+	ingressAllowed bool
+	egressAllowed  bool
+}{
+	{PostureAllow, true, true, true},
+	{PostureDenyIngress, true, false, true},
+	{PostureDenyEgress, true, true, false},
+	{PostureDenyAll, true, false, false},
+	{PostureDenyAll, false, false, true},
+}
+
+func TestGeneratePUPolicyPosture(t *testing.T) {
+	tags := policy.NewTagStoreFromMap(map[string]string{"app": "nginx", "@namespace": "shop"})
+	allNamespaces := &api.NamespaceList{Items: []api.Namespace{*namespace("shop", nil)}}
+
+	for _, tt := range postureTests {
+		puPolicy, err := generatePUPolicy(&[]networking.NetworkPolicyIngressRule{}, &[]networking.NetworkPolicyEgressRule{}, nil, nil, nil, nil, "shop", allNamespaces, tags, nil, nil, tt.posture, tt.egressPolicies, nil)
+		if err != nil {
+			t.Fatalf("generatePUPolicy(%s) => unexpected error %s", tt.posture, err)
+		}
+
+		ingressAllowed := acceptsAll(puPolicy.ReceiverRules()) && aclsAcceptAll(puPolicy.NetworkACLs())
+		if ingressAllowed != tt.ingressAllowed {
+			t.Errorf("generatePUPolicy(%s, egress %t) => ingress allowed %t, expected %t", tt.posture, tt.egressPolicies, ingressAllowed, tt.ingressAllowed)
+		}
+		if !tt.ingressAllowed && (len(puPolicy.ReceiverRules()) != 0 || len(puPolicy.NetworkACLs()) != 0) {
+			t.Errorf("generatePUPolicy(%s, egress %t) => expected no ingress rule", tt.posture, tt.egressPolicies)
+		}
+
+		egressAllowed := acceptsAll(puPolicy.TransmitterRules()) && aclsAcceptAll(puPolicy.ApplicationACLs())
+		if egressAllowed != tt.egressAllowed {
+			t.Errorf("generatePUPolicy(%s, egress %t) => egress allowed %t, expected %t", tt.posture, tt.egressPolicies, egressAllowed, tt.egressAllowed)
+		}
+		if !tt.egressAllowed && (len(puPolicy.TransmitterRules()) != 0 || len(puPolicy.ApplicationACLs()) != 0) {
+			t.Errorf("generatePUPolicy(%s, egress %t) => expected no egress rule", tt.posture, tt.egressPolicies)
+		}
+	}
+}
+
+func postureNamespace(annotations map[string]string) *api.Namespace {
+	ns := namespace("shop", nil)
+	ns.SetAnnotations(annotations)
+	return ns
+}
+
+var namespacePostureTests = []struct {
+	namespace *api.Namespace
+	posture   DefaultPosture
+	valid     bool
+}{
+	{postureNamespace(nil), PostureAllow, true},
+	{postureNamespace(map[string]string{DefaultPostureAnnotationID: "allow"}), PostureAllow, true},
+	{postureNamespace(map[string]string{DefaultPostureAnnotationID: "deny-ingress"}), PostureDenyIngress, true},
+	{postureNamespace(map[string]string{DefaultPostureAnnotationID: "deny-egress"}), PostureDenyEgress, true},
+	{postureNamespace(map[string]string{DefaultPostureAnnotationID: "deny-all"}), PostureDenyAll, true},
+	{postureNamespace(map[string]string{DefaultPostureAnnotationID: "deny"}), PostureAllow, false},
+	{postureNamespace(map[string]string{KubernetesNetworkPolicyAnnotationID: `{"ingress":{"isolation":"DefaultDeny"}}`}), PostureAllow, true},
+	{postureNamespace(map[string]string{KubernetesNetworkPolicyAnnotationID: `{"ingress":{"isolation":"DefaultDeny"}}`, DefaultPostureAnnotationID: "deny-egress"}), PostureDenyEgress, true},
+}
+
+func TestNamespacePosture(t *testing.T) {
+	for _, tt := range namespacePostureTests {
+		posture, err := namespacePosture(tt.namespace)
+		if (err == nil) != tt.valid {
+			t.Errorf("namespacePosture(%v) => error %v, expected valid %t", tt.namespace.GetAnnotations(), err, tt.valid)
+		}
+		if posture != tt.posture {
+			t.Errorf("namespacePosture(%v) => %s, expected %s", tt.namespace.GetAnnotations(), posture, tt.posture)
+		}
+	}
+
+	allNamespaces := &api.NamespaceList{Items: []api.Namespace{*postureNamespace(map[string]string{DefaultPostureAnnotationID: "deny-ingress"})}}
+	k := &KubernetesPolicy{}
+	if posture := k.namespacePosture("shop", allNamespaces); posture != PostureDenyIngress {
+		t.Errorf("namespacePosture(shop) => %s, expected %s", posture, PostureDenyIngress)
+	}
+	if posture := k.namespacePosture("unknown", allNamespaces); posture != PostureAllow {
+		t.Errorf("namespacePosture(unknown) => %s, expected %s", posture, PostureAllow)
+	}
+	k.betaPolicies = true
+	if posture := k.namespacePosture("shop", allNamespaces); posture != PostureDenyAll {
+		t.Errorf("namespacePosture(shop) with Beta NetworkPolicies => %s, expected %s", posture, PostureDenyAll)
+	}
+}
+
+func TestUpdatePodPolicyBeforeSync(t *testing.T) {
+	k := &KubernetesPolicy{
+		cache:  newCache(),
+		synced: make(chan struct{}),
+	}
+
+	if err := k.updatePodPolicyByName("nginx", "shop"); err != nil {
+		t.Errorf("updatePodPolicyByName before sync => unexpected error %s", err)
+	}
+
+	close(k.synced)
+	if err := k.updatePodPolicyByName("nginx", "shop"); err == nil {
+		t.Errorf("updatePodPolicyByName after sync without PolicyUpdater => expected an error")
+	}
+}
+
+func TestWaitForCacheSync(t *testing.T) {
+	stop := make(chan struct{})
+	synced := func() bool { return true }
+	notSynced := func() bool { return false }
+
+	if err := waitForCacheSync(stop, time.Second, synced); err != nil {
+		t.Errorf("waitForCacheSync of synced stores => unexpected error %s", err)
+	}
+	if err := waitForCacheSync(stop, 200*time.Millisecond, synced, notSynced); err == nil {
+		t.Errorf("waitForCacheSync of a store never synced => expected an error")
+	}
+
+	close(stop)
+	if err := waitForCacheSync(stop, time.Minute, []kubecache.InformerSynced{notSynced}...); err == nil {
+		t.Errorf("waitForCacheSync after stop => expected an error")
+	}
+}
+
+// eventRecorder records the container events.
+type eventRecorder struct {
+	events []string
+}
+
+func (r *eventRecorder) CollectFlowEvent(record *collector.FlowRecord) {}
+
+func (r *eventRecorder) CollectContainerEvent(record *collector.ContainerRecord) {
+	r.events = append(r.events, record.Event)
+}
+
+func TestReportPodOverride(t *testing.T) {
+	recorder := &eventRecorder{}
+	k := &KubernetesPolicy{cache: newCache(), eventCollector: recorder}
+	k.cache.addPodToCache("abc", "nginx", "shop")
+	nginx := pod("shop", nil, nil)
+
+	// Only the changes are reported, not every resolve.
+	for _, override := range []string{"", EventQuarantine, EventQuarantine, "", "", EventBreakGlass, EventEnforcementBypass} {
+		k.reportPodOverride(nginx, override)
+	}
+	expected := []string{EventQuarantine, EventQuarantineEnded, EventBreakGlass, EventBreakGlassEnded, EventEnforcementBypass}
+	if !equalStrings(recorder.events, expected) {
+		t.Errorf("reportPodOverride => events %v, expected %v", recorder.events, expected)
+	}
+
+	// A new PU of the pod starts without override.
+	recorder.events = nil
+	k.cache.addPodToCache("def", "nginx", "shop")
+	k.reportPodOverride(nginx, EventEnforcementBypass)
+	if !equalStrings(recorder.events, []string{EventEnforcementBypass}) {
+		t.Errorf("reportPodOverride for a new PU => events %v, expected %v", recorder.events, []string{EventEnforcementBypass})
+	}
+}
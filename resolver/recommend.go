@@ -0,0 +1,545 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme-kubernetes/collector"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// RecommendedPolicyPrefix prefixes the names of the recommended NetworkPolicies.
+const RecommendedPolicyPrefix = "recommended-"
+
+// volatileLabels are set by the controllers on their pods and change with each revision or
+// each pod. They aren't used to select the pods.
+var volatileLabels = map[string]bool{
+	podTemplateHashLabel:                 true,
+	"controller-revision-hash":           true,
+	"pod-template-generation":            true,
+	"statefulset.kubernetes.io/pod-name": true,
+	"controller-uid":                     true,
+	"job-name":                           true,
+}
+
+// RecommendationLimits are the parts of the enforced policy that aren't evaluated when the observed
+// flows are checked against the current NetworkPolicies. Only the ingress rules of the active
+// NetworkPolicies and the default postures of the namespaces are evaluated, for TCP.
+var RecommendationLimits = []string{
+	"ClusterNetworkPolicies",
+	"egress rules, services and FQDNs",
+	"HTTP rules",
+	"quarantine, break-glass and enforcement opt-out",
+}
+
+// Recommendation is the result of a Recommender.
+type Recommendation struct {
+	// NetworkPolicies allow the observed flows to each group of pods.
+	NetworkPolicies []*networking.NetworkPolicy
+	// Blocked are the observed accepted flows that the current NetworkPolicies reject.
+	Blocked []*BlockedFlow
+	// Warnings describe the observed flows that couldn't be allowed.
+	Warnings []string
+	// Ignored is the number of rejected flows and of flows that don't reach a current pod, or
+	// don't leave one if egress rules are recommended.
+	Ignored int
+}
+
+// BlockedFlow is an observed flow rejected by the current NetworkPolicies, within the
+// RecommendationLimits. The pods are given as namespace/Kind/name of their workload, and the
+// other endpoints as IPs.
+type BlockedFlow struct {
+	Source      string
+	Destination string
+	Port        uint16
+	Count       int
+}
+
+// NewRecommender returns a Recommender of the NetworkPolicies allowing the accepted flows observed
+// to the current pods. The pods of a namespace with the same labels, except the labels set by their
+// controllers, share a NetworkPolicy. The sources are selected by their labels, or by their IP
+// if they aren't a current pod. If egress is true, the groups sending flows also get egress rules
+// allowing their destinations the same way. The flows are also checked against the
+// NetworkPolicies active at the given time and the default posture of the namespaces.
+func NewRecommender(pods *api.PodList, namespaces *api.NamespaceList, networkPolicies *networking.NetworkPolicyList, egress bool, now time.Time) *Recommender {
+	r := &Recommender{
+		egress:          egress,
+		byName:          map[string]*api.Pod{},
+		byIP:            map[string]*api.Pod{},
+		namespaces:      map[string]*api.Namespace{},
+		networkPolicies: activeNetworkPolicies(networkPolicies, now),
+		groups:          map[string]*podGroup{},
+		blocked:         map[BlockedFlow]*BlockedFlow{},
+		warnings:        map[string]bool{},
+		recommendation:  &Recommendation{},
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == api.PodSucceeded || pod.Status.Phase == api.PodFailed {
+			continue
+		}
+		r.byName[kubePodIdentifier(pod.GetName(), pod.GetNamespace())] = pod
+		if pod.Status.PodIP != "" && !pod.Spec.HostNetwork {
+			r.byIP[pod.Status.PodIP] = pod
+		}
+	}
+	for i := range namespaces.Items {
+		r.namespaces[namespaces.Items[i].GetName()] = &namespaces.Items[i]
+	}
+	return r
+}
+
+// podGroup is the pods of a namespace with the same stable labels, and the peers allowed on
+// each port of the group, for ingress, and on each port of the peers, for egress.
+type podGroup struct {
+	name      string
+	namespace string
+	labels    map[string]string
+	ingress   map[uint16]map[string]networking.NetworkPolicyPeer
+	egress    map[uint16]map[string]networking.NetworkPolicyPeer
+}
+
+// Recommender accumulates the observed flows one at a time, so that the flow logs don't have
+// to be loaded in memory.
+type Recommender struct {
+	egress          bool
+	byName          map[string]*api.Pod
+	byIP            map[string]*api.Pod
+	namespaces      map[string]*api.Namespace
+	networkPolicies *networking.NetworkPolicyList
+	groups          map[string]*podGroup
+	blocked         map[BlockedFlow]*BlockedFlow
+	warnings        map[string]bool
+	recommendation  *Recommendation
+}
+
+// Add allows the flow to its destination pod, and from its source pod if egress is true.
+func (r *Recommender) Add(flow *collector.FlowEvent) {
+	if flow.Action != "accept" || flow.Destination == nil {
+		r.recommendation.Ignored++
+		return
+	}
+	destination := r.pod(flow.Destination, flow.Tags, collector.DestinationTagPrefix)
+	source := r.pod(flow.Source, flow.Tags, collector.SourceTagPrefix)
+	if destination == nil && (!r.egress || source == nil) {
+		r.recommendation.Ignored++
+		return
+	}
+
+	sourceIP := ""
+	if flow.Source != nil {
+		sourceIP = flow.Source.IP
+	}
+	port := flow.Destination.Port
+	count := flow.Count
+	if count == 0 {
+		count = 1
+	}
+
+	if destination != nil {
+		if !r.ingressAllowed(destination, source, sourceIP, port) {
+			r.block(destination, source, sourceIP, port, count)
+		}
+		r.allow(destination, source, sourceIP, port, true)
+	}
+	if r.egress && source != nil {
+		r.allow(source, destination, flow.Destination.IP, port, false)
+	}
+}
+
+// block reports the flow from the source pod or IP to the destination pod as blocked.
+func (r *Recommender) block(destination *api.Pod, source *api.Pod, sourceIP string, port uint16, count int) {
+	key := BlockedFlow{Source: sourceIP, Destination: podDescription(destination), Port: port}
+	if source != nil {
+		key.Source = podDescription(source)
+	}
+	if blocked, ok := r.blocked[key]; ok {
+		blocked.Count += count
+		return
+	}
+	blocked := key
+	blocked.Count = count
+	r.blocked[key] = &blocked
+}
+
+// allow adds the remote pod or IP as a peer of the group of the local pod on the port, to its
+// ingress rules if ingress is true and to its egress rules otherwise.
+func (r *Recommender) allow(local *api.Pod, remote *api.Pod, remoteIP string, port uint16, ingress bool) {
+	group, err := r.group(local)
+	if err != nil {
+		r.warnings[err.Error()] = true
+		return
+	}
+	peerKey, peer, err := r.peer(local, remote, remoteIP)
+	if err != nil {
+		r.warnings[err.Error()] = true
+		return
+	}
+
+	peers := group.egress
+	if ingress {
+		peers = group.ingress
+	}
+	if _, ok := peers[port]; !ok {
+		peers[port] = map[string]networking.NetworkPolicyPeer{}
+	}
+	peers[port][peerKey] = peer
+}
+
+// pod returns the current pod of the flow endpoint, found with the Kubernetes metadata tags of
+// the flow or with its IP.
+func (r *Recommender) pod(endpoint *collector.EndpointEvent, tags []string, prefix string) *api.Pod {
+	name := collector.TagValue(tags, prefix+"pod")
+	namespace := collector.TagValue(tags, prefix+"namespace")
+	if pod, ok := r.byName[kubePodIdentifier(name, namespace)]; ok && name != "" {
+		return pod
+	}
+	if endpoint == nil {
+		return nil
+	}
+	return r.byIP[endpoint.IP]
+}
+
+// group returns the group of the pod, created if needed.
+func (r *Recommender) group(pod *api.Pod) (*podGroup, error) {
+	podLabels := stableLabels(pod)
+	if len(podLabels) == 0 {
+		return nil, fmt.Errorf("Pod %s has no labels to select it", kubePodIdentifier(pod.GetName(), pod.GetNamespace()))
+	}
+
+	key := pod.GetNamespace() + "/" + labels.Set(podLabels).String()
+	group, ok := r.groups[key]
+	if !ok {
+		group = &podGroup{
+			name:      groupName(pod),
+			namespace: pod.GetNamespace(),
+			labels:    podLabels,
+			ingress:   map[uint16]map[string]networking.NetworkPolicyPeer{},
+			egress:    map[uint16]map[string]networking.NetworkPolicyPeer{},
+		}
+		r.groups[key] = group
+	}
+	return group, nil
+}
+
+// peer returns the NetworkPolicy peer selecting the remote pod or IP of a flow of the local pod,
+// and its key.
+func (r *Recommender) peer(local *api.Pod, remote *api.Pod, remoteIP string) (string, networking.NetworkPolicyPeer, error) {
+	if remote == nil {
+		ip := net.ParseIP(remoteIP)
+		if ip == nil {
+			return "", networking.NetworkPolicyPeer{}, fmt.Errorf("Invalid IP %s", remoteIP)
+		}
+		cidr := remoteIP + "/32"
+		if ip.To4() == nil {
+			cidr = remoteIP + "/128"
+		}
+		return "ip:" + cidr, networking.NetworkPolicyPeer{IPBlock: &networking.IPBlock{CIDR: cidr}}, nil
+	}
+
+	podLabels := stableLabels(remote)
+	if len(podLabels) == 0 {
+		return "", networking.NetworkPolicyPeer{}, fmt.Errorf("Pod %s has no labels to select it", kubePodIdentifier(remote.GetName(), remote.GetNamespace()))
+	}
+	peer := networking.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{MatchLabels: podLabels}}
+	key := "pod:" + remote.GetNamespace() + "/" + labels.Set(podLabels).String()
+	if remote.GetNamespace() == local.GetNamespace() {
+		return key, peer, nil
+	}
+
+	namespace, ok := r.namespaces[remote.GetNamespace()]
+	if !ok || len(namespace.GetLabels()) == 0 {
+		return "", networking.NetworkPolicyPeer{}, fmt.Errorf("Namespace %s has no labels to select it", remote.GetNamespace())
+	}
+	peer.NamespaceSelector = &metav1.LabelSelector{MatchLabels: namespace.GetLabels()}
+	return key, peer, nil
+}
+
+// ingressAllowed returns true if the current NetworkPolicies and the default posture of its
+// namespace allow the flow from the source pod or IP to the port of the destination pod.
+func (r *Recommender) ingressAllowed(destination *api.Pod, source *api.Pod, sourceIP string, port uint16) bool {
+	isolated := false
+	if namespace, ok := r.namespaces[destination.GetNamespace()]; ok {
+		if posture, err := namespacePosture(namespace); err == nil && posture.deniesIngress() {
+			isolated = true
+		}
+	}
+
+	for i := range r.networkPolicies.Items {
+		networkPolicy := &r.networkPolicies.Items[i]
+		if networkPolicy.GetNamespace() != destination.GetNamespace() || !hasIngressPolicyType(networkPolicy) || !selectorMatches(&networkPolicy.Spec.PodSelector, destination.GetLabels()) {
+			continue
+		}
+		isolated = true
+
+		for _, rule := range networkPolicy.Spec.Ingress {
+			if portsMatch(rule.Ports, destination, port) && r.peersMatch(rule.From, networkPolicy.GetNamespace(), source, sourceIP) {
+				return true
+			}
+		}
+	}
+	return !isolated
+}
+
+// peersMatch returns true if one of the peers, of a NetworkPolicy of the given namespace,
+// selects the source pod or IP. No peers select every source.
+func (r *Recommender) peersMatch(peers []networking.NetworkPolicyPeer, namespace string, source *api.Pod, sourceIP string) bool {
+	if len(peers) == 0 {
+		return true
+	}
+
+	for _, peer := range peers {
+		switch {
+		case peer.IPBlock != nil:
+			if ipBlockMatches(peer.IPBlock, sourceIP) {
+				return true
+			}
+		case source == nil:
+		case peer.NamespaceSelector != nil:
+			sourceNamespace, ok := r.namespaces[source.GetNamespace()]
+			if ok && selectorMatches(peer.NamespaceSelector, sourceNamespace.GetLabels()) &&
+				(peer.PodSelector == nil || selectorMatches(peer.PodSelector, source.GetLabels())) {
+				return true
+			}
+		case peer.PodSelector != nil:
+			if source.GetNamespace() == namespace && selectorMatches(peer.PodSelector, source.GetLabels()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Result returns the recommendation for the flows added so far, sorted by namespace and name.
+func (r *Recommender) Result() *Recommendation {
+	keys := []string{}
+	for key := range r.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	names := map[string]bool{}
+	for _, key := range keys {
+		group := r.groups[key]
+		if len(group.ingress) == 0 && len(group.egress) == 0 {
+			continue
+		}
+
+		// Two groups of a namespace can have the same workload name.
+		name := group.name
+		for i := 2; names[group.namespace+"/"+name]; i++ {
+			name = fmt.Sprintf("%s-%d", group.name, i)
+		}
+		names[group.namespace+"/"+name] = true
+
+		r.recommendation.NetworkPolicies = append(r.recommendation.NetworkPolicies, group.networkPolicy(name))
+	}
+
+	for _, blocked := range r.blocked {
+		r.recommendation.Blocked = append(r.recommendation.Blocked, blocked)
+	}
+	sort.Slice(r.recommendation.Blocked, func(i, j int) bool {
+		a, b := r.recommendation.Blocked[i], r.recommendation.Blocked[j]
+		if a.Destination != b.Destination {
+			return a.Destination < b.Destination
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Port < b.Port
+	})
+
+	for warning := range r.warnings {
+		r.recommendation.Warnings = append(r.recommendation.Warnings, warning)
+	}
+	sort.Strings(r.recommendation.Warnings)
+
+	return r.recommendation
+}
+
+// networkPolicy returns the NetworkPolicy allowing the peers of the group. Only the directions
+// with peers are in its policy types, so that the other direction isn't isolated.
+func (g *podGroup) networkPolicy(name string) *networking.NetworkPolicy {
+	spec := networking.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: g.labels},
+	}
+	for _, rule := range policyRules(g.ingress) {
+		spec.Ingress = append(spec.Ingress, networking.NetworkPolicyIngressRule{Ports: rule.ports, From: rule.peers})
+	}
+	for _, rule := range policyRules(g.egress) {
+		spec.Egress = append(spec.Egress, networking.NetworkPolicyEgressRule{Ports: rule.ports, To: rule.peers})
+	}
+	if len(spec.Ingress) > 0 {
+		spec.PolicyTypes = append(spec.PolicyTypes, networking.PolicyTypeIngress)
+	}
+	if len(spec.Egress) > 0 {
+		spec.PolicyTypes = append(spec.PolicyTypes, networking.PolicyTypeEgress)
+	}
+
+	return &networking.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "NetworkPolicy",
+			APIVersion: "networking.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RecommendedPolicyPrefix + name,
+			Namespace: g.namespace,
+		},
+		Spec: spec,
+	}
+}
+
+// policyRule is a rule of a recommended NetworkPolicy.
+type policyRule struct {
+	peers []networking.NetworkPolicyPeer
+	ports []networking.NetworkPolicyPort
+}
+
+// policyRules returns the rules allowing the peers on each TCP port. The ports with the same
+// peers share a rule.
+func policyRules(peersByPort map[uint16]map[string]networking.NetworkPolicyPeer) []*policyRule {
+	ports := []int{}
+	for port := range peersByPort {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+
+	indexes := map[string]int{}
+	rules := []*policyRule{}
+	for _, port := range ports {
+		peers := peersByPort[uint16(port)]
+		peerKeys := []string{}
+		for key := range peers {
+			peerKeys = append(peerKeys, key)
+		}
+		sort.Strings(peerKeys)
+
+		key := strings.Join(peerKeys, " ")
+		index, ok := indexes[key]
+		if !ok {
+			rule := &policyRule{}
+			for _, peerKey := range peerKeys {
+				rule.peers = append(rule.peers, peers[peerKey])
+			}
+			index = len(rules)
+			indexes[key] = index
+			rules = append(rules, rule)
+		}
+
+		protocol := api.ProtocolTCP
+		value := intstr.FromInt(port)
+		rules[index].ports = append(rules[index].ports, networking.NetworkPolicyPort{Protocol: &protocol, Port: &value})
+	}
+	return rules
+}
+
+// stableLabels returns the labels of the pod without the volatileLabels.
+func stableLabels(pod *api.Pod) map[string]string {
+	podLabels := map[string]string{}
+	for key, value := range pod.GetLabels() {
+		if !volatileLabels[key] {
+			podLabels[key] = value
+		}
+	}
+	return podLabels
+}
+
+// groupName returns the name of the workload of the pod, or of the pod itself.
+func groupName(pod *api.Pod) string {
+	name := pod.GetName()
+	if workload := podWorkload(pod); workload != "" {
+		name = workload[strings.Index(workload, "/")+1:]
+	} else if app, ok := pod.GetLabels()["app"]; ok {
+		name = app
+	}
+	return strings.ToLower(name)
+}
+
+// podDescription returns the pod as namespace/Kind/name of its workload, or namespace/Pod/name.
+func podDescription(pod *api.Pod) string {
+	workload := podWorkload(pod)
+	if workload == "" {
+		workload = "Pod/" + pod.GetName()
+	}
+	return pod.GetNamespace() + "/" + workload
+}
+
+// hasIngressPolicyType returns true if the NetworkPolicy applies to the incoming traffic.
+func hasIngressPolicyType(networkPolicy *networking.NetworkPolicy) bool {
+	if len(networkPolicy.Spec.PolicyTypes) == 0 {
+		return true
+	}
+	for _, policyType := range networkPolicy.Spec.PolicyTypes {
+		if policyType == networking.PolicyTypeIngress {
+			return true
+		}
+	}
+	return false
+}
+
+// selectorMatches returns true if the label selector selects the labels. Invalid selectors
+// select nothing.
+func selectorMatches(selector *metav1.LabelSelector, podLabels map[string]string) bool {
+	parsed, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return parsed.Matches(labels.Set(podLabels))
+}
+
+// portsMatch returns true if one of the TCP ports is the port of the destination pod. Named
+// ports are resolved with the container ports of the pod. No ports match every port.
+func portsMatch(ports []networking.NetworkPolicyPort, destination *api.Pod, port uint16) bool {
+	if len(ports) == 0 {
+		return true
+	}
+
+	for _, policyPort := range ports {
+		if policyPort.Protocol != nil && *policyPort.Protocol != api.ProtocolTCP {
+			continue
+		}
+		if policyPort.Port == nil {
+			return true
+		}
+		if policyPort.Port.Type == intstr.Int {
+			if policyPort.Port.IntVal == int32(port) {
+				return true
+			}
+			continue
+		}
+		for _, container := range destination.Spec.Containers {
+			for _, containerPort := range container.Ports {
+				if containerPort.Name == policyPort.Port.StrVal && containerPort.ContainerPort == int32(port) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ipBlockMatches returns true if the IP is in the CIDR of the block and not in its exceptions.
+func ipBlockMatches(ipBlock *networking.IPBlock, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	if _, network, err := net.ParseCIDR(ipBlock.CIDR); err != nil || !network.Contains(ip) {
+		return false
+	}
+	for _, except := range ipBlock.Except {
+		if _, network, err := net.ParseCIDR(except); err == nil && network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package resolver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-kubernetes/collector"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func recommendPod(namespace, name, ip string, podLabels map[string]string, ownerKind, ownerName string) api.Pod {
+	pod := api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: podLabels},
+		Status:     api.PodStatus{Phase: api.PodRunning, PodIP: ip},
+	}
+	if ownerKind != "" {
+		controller := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: ownerName, Controller: &controller}}
+	}
+	return pod
+}
+
+func recommendFlow(source, destination string, port uint16, action string, tags ...string) *collector.FlowEvent {
+	return &collector.FlowEvent{
+		Type:        "flow",
+		Count:       1,
+		Source:      &collector.EndpointEvent{IP: source, Type: "address"},
+		Destination: &collector.EndpointEvent{IP: destination, Port: port, Type: "pu"},
+		Action:      action,
+		Tags:        tags,
+	}
+}
+
+// recommendCluster returns the pods, namespaces, NetworkPolicies and flows of the
+// recommendation tests.
+func recommendCluster() (*api.PodList, *api.NamespaceList, *networking.NetworkPolicyList, []*collector.FlowEvent) {
+	pods := &api.PodList{Items: []api.Pod{
+		recommendPod("shop", "frontend-abc-1", "10.0.0.2", map[string]string{"app": "frontend", "pod-template-hash": "abc"}, "ReplicaSet", "frontend-abc"),
+		recommendPod("shop", "frontend-abc-2", "10.0.0.3", map[string]string{"app": "frontend", "pod-template-hash": "abc"}, "ReplicaSet", "frontend-abc"),
+		recommendPod("shop", "db-0", "10.0.0.4", map[string]string{"app": "db", "controller-revision-hash": "x"}, "StatefulSet", "db"),
+		recommendPod("monitoring", "prometheus", "10.0.1.1", map[string]string{"app": "prometheus"}, "", ""),
+		recommendPod("ci", "runner", "10.0.2.1", nil, "", ""),
+	}}
+	namespaces := &api.NamespaceList{Items: []api.Namespace{
+		*namespace("shop", map[string]string{"env": "prod"}),
+		*namespace("monitoring", map[string]string{"team": "ops"}),
+		*namespace("ci", nil),
+	}}
+
+	dbPort := intstr.FromInt(5432)
+	networkPolicies := &networking.NetworkPolicyList{Items: []networking.NetworkPolicy{{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "shop"},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Ingress: []networking.NetworkPolicyIngressRule{{
+				Ports: []networking.NetworkPolicyPort{{Port: &dbPort}},
+				From:  []networking.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}}},
+			}},
+		},
+	}}}
+
+	flows := []*collector.FlowEvent{
+		recommendFlow("10.0.0.2", "10.0.0.4", 5432, "accept"),
+		// The pods are found with the Kubernetes metadata before their IP.
+		recommendFlow("10.9.9.9", "10.0.0.4", 9187, "accept", collector.SourceTagPrefix+"pod=prometheus", collector.SourceTagPrefix+"namespace=monitoring"),
+		recommendFlow("203.0.113.9", "10.0.0.3", 8080, "accept"),
+		recommendFlow("10.0.2.1", "10.0.0.2", 8080, "accept"),
+		recommendFlow("203.0.113.9", "10.0.0.4", 5432, "reject"),
+		recommendFlow("10.0.0.2", "8.8.8.8", 53, "accept"),
+	}
+	return pods, namespaces, networkPolicies, flows
+}
+
+// recommend returns the recommendation for the flows added one at a time.
+func recommend(flows []*collector.FlowEvent, pods *api.PodList, namespaces *api.NamespaceList, networkPolicies *networking.NetworkPolicyList, egress bool) *Recommendation {
+	recommender := NewRecommender(pods, namespaces, networkPolicies, egress, time.Now())
+	for _, flow := range flows {
+		recommender.Add(flow)
+	}
+	return recommender.Result()
+}
+
+func TestRecommendPolicies(t *testing.T) {
+	pods, namespaces, networkPolicies, flows := recommendCluster()
+	recommendation := recommend(flows, pods, namespaces, networkPolicies, false)
+
+	if len(recommendation.NetworkPolicies) != 2 {
+		t.Fatalf("Expected 2 NetworkPolicies, got %d", len(recommendation.NetworkPolicies))
+	}
+
+	db := recommendation.NetworkPolicies[0]
+	if db.Name != "recommended-db" || db.Namespace != "shop" || !reflect.DeepEqual(db.Spec.PodSelector.MatchLabels, map[string]string{"app": "db"}) {
+		t.Errorf("Unexpected NetworkPolicy %s/%s selecting %v", db.Namespace, db.Name, db.Spec.PodSelector.MatchLabels)
+	}
+	if len(db.Spec.Ingress) != 2 ||
+		db.Spec.Ingress[0].Ports[0].Port.IntValue() != 5432 ||
+		!reflect.DeepEqual(db.Spec.Ingress[0].From[0].PodSelector.MatchLabels, map[string]string{"app": "frontend"}) ||
+		db.Spec.Ingress[0].From[0].NamespaceSelector != nil ||
+		db.Spec.Ingress[1].Ports[0].Port.IntValue() != 9187 ||
+		!reflect.DeepEqual(db.Spec.Ingress[1].From[0].NamespaceSelector.MatchLabels, map[string]string{"team": "ops"}) {
+		t.Errorf("Unexpected ingress rules %+v", db.Spec.Ingress)
+	}
+	if len(db.Spec.Egress) != 0 || !reflect.DeepEqual(db.Spec.PolicyTypes, []networking.PolicyType{networking.PolicyTypeIngress}) {
+		t.Errorf("Unexpected egress rules %+v and policy types %v", db.Spec.Egress, db.Spec.PolicyTypes)
+	}
+
+	frontend := recommendation.NetworkPolicies[1]
+	if frontend.Name != "recommended-frontend" || len(frontend.Spec.Ingress) != 1 || frontend.Spec.Ingress[0].From[0].IPBlock.CIDR != "203.0.113.9/32" {
+		t.Errorf("Unexpected NetworkPolicy %s %+v", frontend.Name, frontend.Spec.Ingress)
+	}
+
+	expectedBlocked := []*BlockedFlow{{Source: "monitoring/Pod/prometheus", Destination: "shop/StatefulSet/db", Port: 9187, Count: 1}}
+	if !reflect.DeepEqual(recommendation.Blocked, expectedBlocked) {
+		t.Errorf("Unexpected blocked flows %+v", recommendation.Blocked)
+	}
+	if !reflect.DeepEqual(recommendation.Warnings, []string{"Pod ci/runner has no labels to select it"}) {
+		t.Errorf("Unexpected warnings %v", recommendation.Warnings)
+	}
+	if recommendation.Ignored != 2 {
+		t.Errorf("Expected 2 ignored flows, got %d", recommendation.Ignored)
+	}
+}
+
+func TestRecommendEgressPolicies(t *testing.T) {
+	pods, namespaces, networkPolicies, flows := recommendCluster()
+	recommendation := recommend(flows, pods, namespaces, networkPolicies, true)
+
+	if len(recommendation.NetworkPolicies) != 3 {
+		t.Fatalf("Expected 3 NetworkPolicies, got %d", len(recommendation.NetworkPolicies))
+	}
+
+	// The sources that aren't destinations only get egress rules.
+	prometheus := recommendation.NetworkPolicies[0]
+	if prometheus.Name != "recommended-prometheus" || len(prometheus.Spec.Ingress) != 0 ||
+		!reflect.DeepEqual(prometheus.Spec.PolicyTypes, []networking.PolicyType{networking.PolicyTypeEgress}) {
+		t.Errorf("Unexpected NetworkPolicy %s with policy types %v", prometheus.Name, prometheus.Spec.PolicyTypes)
+	}
+	if len(prometheus.Spec.Egress) != 1 ||
+		prometheus.Spec.Egress[0].Ports[0].Port.IntValue() != 9187 ||
+		!reflect.DeepEqual(prometheus.Spec.Egress[0].To[0].PodSelector.MatchLabels, map[string]string{"app": "db"}) ||
+		!reflect.DeepEqual(prometheus.Spec.Egress[0].To[0].NamespaceSelector.MatchLabels, map[string]string{"env": "prod"}) {
+		t.Errorf("Unexpected egress rules %+v", prometheus.Spec.Egress)
+	}
+
+	db := recommendation.NetworkPolicies[1]
+	if db.Name != "recommended-db" || len(db.Spec.Ingress) != 2 || len(db.Spec.Egress) != 0 {
+		t.Errorf("Unexpected NetworkPolicy %s %+v", db.Name, db.Spec)
+	}
+
+	// The destinations that aren't current pods are allowed by their IP.
+	frontend := recommendation.NetworkPolicies[2]
+	if frontend.Name != "recommended-frontend" || len(frontend.Spec.Ingress) != 1 ||
+		!reflect.DeepEqual(frontend.Spec.PolicyTypes, []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress}) {
+		t.Errorf("Unexpected NetworkPolicy %s with policy types %v", frontend.Name, frontend.Spec.PolicyTypes)
+	}
+	if len(frontend.Spec.Egress) != 2 ||
+		frontend.Spec.Egress[0].Ports[0].Port.IntValue() != 53 ||
+		frontend.Spec.Egress[0].To[0].IPBlock.CIDR != "8.8.8.8/32" ||
+		frontend.Spec.Egress[1].Ports[0].Port.IntValue() != 5432 ||
+		!reflect.DeepEqual(frontend.Spec.Egress[1].To[0].PodSelector.MatchLabels, map[string]string{"app": "db"}) ||
+		frontend.Spec.Egress[1].To[0].NamespaceSelector != nil {
+		t.Errorf("Unexpected egress rules %+v", frontend.Spec.Egress)
+	}
+
+	if !reflect.DeepEqual(recommendation.Warnings, []string{"Pod ci/runner has no labels to select it"}) {
+		t.Errorf("Unexpected warnings %v", recommendation.Warnings)
+	}
+	if recommendation.Ignored != 1 {
+		t.Errorf("Expected 1 ignored flow, got %d", recommendation.Ignored)
+	}
+}
@@ -0,0 +1,86 @@
+package resolver
+
+import (
+	"sync"
+
+	"github.com/aporeto-inc/trireme-kubernetes/collector"
+
+	api "k8s.io/api/core/v1"
+)
+
+// remotePods indexes the metadata of the pods of all the nodes by IP. The flows only
+// identify the local pods, the remote ones are found by the IP of the flow endpoint.
+type remotePods struct {
+	pods map[string]*collector.PodMetadata
+	sync.RWMutex
+}
+
+// EnableRemotePodMetadata watches the pods of all the nodes so that the remote endpoints
+// of the flows are resolved. Must be called before Run.
+func (k *KubernetesPolicy) EnableRemotePodMetadata() {
+	k.remotePods = &remotePods{
+		pods: map[string]*collector.PodMetadata{},
+	}
+}
+
+// RemotePodMetadata returns the Kubernetes metadata of the pod of the IP on any node.
+// Always false if the remote pods aren't watched.
+func (k *KubernetesPolicy) RemotePodMetadata(ip string) (*collector.PodMetadata, bool) {
+	if k.remotePods == nil || ip == "" {
+		return nil, false
+	}
+	return k.remotePods.get(ip)
+}
+
+// watchRemotePods starts watching the pods of all the nodes if enabled.
+func (k *KubernetesPolicy) watchRemotePods() {
+	if k.remotePods == nil {
+		return
+	}
+
+	_, podController := k.KubernetesClient.CreatePodController(api.NamespaceAll,
+		func(addedPod *api.Pod) error {
+			k.remotePods.update(nil, addedPod)
+			return nil
+		},
+		func(deletedPod *api.Pod) error {
+			k.remotePods.update(deletedPod, nil)
+			return nil
+		},
+		func(oldPod, updatedPod *api.Pod) error {
+			k.remotePods.update(oldPod, updatedPod)
+			return nil
+		})
+	go podController.Run(k.stopAll)
+}
+
+// get returns the metadata of the pod of the IP.
+func (r *remotePods) get(ip string) (*collector.PodMetadata, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	metadata, ok := r.pods[ip]
+	return metadata, ok
+}
+
+// update replaces the old pod by the updated one in the index. Either can be nil.
+// The IP of the old pod is only released if it wasn't taken over by another pod.
+func (r *remotePods) update(oldPod, updatedPod *api.Pod) {
+	r.Lock()
+	defer r.Unlock()
+
+	if oldPod != nil {
+		if metadata, ok := r.pods[oldPod.Status.PodIP]; ok && metadata.Namespace == oldPod.GetNamespace() && metadata.Name == oldPod.GetName() {
+			delete(r.pods, oldPod.Status.PodIP)
+		}
+	}
+	if updatedPod != nil && indexedPod(updatedPod) {
+		r.pods[updatedPod.Status.PodIP] = podMetadata(updatedPod)
+	}
+}
+
+// indexedPod returns true if the pod owns its IP. The host network pods share the IP
+// of their node and the terminated pods released it.
+func indexedPod(pod *api.Pod) bool {
+	return pod.Status.PodIP != "" && !pod.Spec.HostNetwork &&
+		pod.Status.Phase != api.PodSucceeded && pod.Status.Phase != api.PodFailed
+}
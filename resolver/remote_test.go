@@ -0,0 +1,46 @@
+package resolver
+
+import (
+	"testing"
+
+	api "k8s.io/api/core/v1"
+)
+
+func TestRemotePods(t *testing.T) {
+	k := &KubernetesPolicy{}
+	if _, ok := k.RemotePodMetadata("10.0.0.1"); ok {
+		t.Errorf("RemotePodMetadata => found while the remote pods aren't watched")
+	}
+	k.EnableRemotePodMetadata()
+
+	nginx := pod("shop", map[string]string{"app": "nginx"}, nil)
+	k.remotePods.update(nil, nginx)
+	if metadata, ok := k.RemotePodMetadata("10.0.0.1"); !ok || metadata.Namespace != "shop" || metadata.Labels["app"] != "nginx" {
+		t.Errorf("RemotePodMetadata => %+v %t, expected shop/nginx", metadata, ok)
+	}
+
+	// The IP is taken over by a new pod before the old one is deleted.
+	redis := pod("cache", nil, nil)
+	redis.Name = "redis"
+	k.remotePods.update(nil, redis)
+	k.remotePods.update(nginx, nil)
+	if metadata, ok := k.RemotePodMetadata("10.0.0.1"); !ok || metadata.Name != "redis" {
+		t.Errorf("RemotePodMetadata => %+v %t, expected cache/redis", metadata, ok)
+	}
+
+	// A terminated pod releases its IP.
+	terminated := *redis
+	terminated.Status.Phase = api.PodSucceeded
+	k.remotePods.update(redis, &terminated)
+	if metadata, ok := k.RemotePodMetadata("10.0.0.1"); ok {
+		t.Errorf("RemotePodMetadata => %+v, expected none after the pod terminated", metadata)
+	}
+
+	// The host network pods share the IP of their node.
+	hostNetwork := pod("kube-system", nil, nil)
+	hostNetwork.Spec.HostNetwork = true
+	k.remotePods.update(nil, hostNetwork)
+	if metadata, ok := k.RemotePodMetadata("10.0.0.1"); ok {
+		t.Errorf("RemotePodMetadata => %+v, expected none for a host network pod", metadata)
+	}
+}
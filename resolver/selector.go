@@ -74,6 +74,45 @@ func clauseDoesNotExist(requirement labels.Requirement) []policy.KeyValueOperato
 	}
 }
 
+// requirementsClause generates the clauses matching all the label requirements.
+func requirementsClause(requirements labels.Requirements) []policy.KeyValueOperator {
+	completeClause := []policy.KeyValueOperator{}
+	for _, requirement := range requirements {
+		// Each requirement is ANDed
+		switch requirement.Operator() {
+		case selection.Equals:
+			completeClause = append(completeClause, clauseEquals(requirement)...)
+		case selection.NotEquals:
+			completeClause = append(completeClause, clauseNotEquals(requirement)...)
+		case selection.In:
+			completeClause = append(completeClause, clauseIn(requirement)...)
+		case selection.NotIn:
+			completeClause = append(completeClause, clauseNotIn(requirement)...)
+		case selection.Exists:
+			completeClause = append(completeClause, clauseExists(requirement)...)
+		case selection.DoesNotExist:
+			completeClause = append(completeClause, clauseDoesNotExist(requirement)...)
+		}
+	}
+	return completeClause
+}
+
+// matchingNamespaces returns the names of the namespaces matched by the selector.
+func matchingNamespaces(selector *metav1.LabelSelector, allNamespaces *api.NamespaceList) ([]string, error) {
+	namespaceSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing namespace label selector %s", err)
+	}
+
+	matched := []string{}
+	for _, namespace := range allNamespaces.Items {
+		if namespaceSelector.Matches(labels.Set(namespace.GetLabels())) {
+			matched = append(matched, namespace.GetName())
+		}
+	}
+	return matched, nil
+}
+
 // portSelector generates all the clauses for the ports
 func portSelector(ports []networking.NetworkPolicyPort) []policy.KeyValueOperator {
 	// If Port is not defined, then no need for specific traffic matching.
@@ -128,30 +167,8 @@ func podIngressRules(rule *networking.NetworkPolicyIngressRule, namespace string
 		completeClause = append(completeClause, namespaceSelector(namespace)...)
 
 		// Go over each specific requirement and add it as a clause.
-		for _, requirement := range peerRequirements {
-			// Each requirement is ANDed
-			switch requirement.Operator() {
-			case selection.Equals:
-				requirementClause := clauseEquals(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.NotEquals:
-				requirementClause := clauseNotEquals(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.In:
-				requirementClause := clauseIn(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.NotIn:
-				requirementClause := clauseNotIn(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.Exists:
-				requirementClause := clauseExists(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.DoesNotExist:
-				requirementClause := clauseDoesNotExist(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			}
+		completeClause = append(completeClause, requirementsClause(peerRequirements)...)
 
-		}
 		selector := policy.TagSelector{
 			Clause: completeClause,
 			Policy: &policy.FlowPolicy{
@@ -185,30 +202,8 @@ func podEgressRules(rule *networking.NetworkPolicyEgressRule, namespace string)
 		completeClause = append(completeClause, namespaceSelector(namespace)...)
 
 		// Go over each specific requirement and add it as a clause.
-		for _, requirement := range peerRequirements {
-			// Each requirement is ANDed
-			switch requirement.Operator() {
-			case selection.Equals:
-				requirementClause := clauseEquals(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.NotEquals:
-				requirementClause := clauseNotEquals(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.In:
-				requirementClause := clauseIn(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.NotIn:
-				requirementClause := clauseNotIn(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.Exists:
-				requirementClause := clauseExists(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			case selection.DoesNotExist:
-				requirementClause := clauseDoesNotExist(requirement)
-				completeClause = append(completeClause, requirementClause...)
-			}
+		completeClause = append(completeClause, requirementsClause(peerRequirements)...)
 
-		}
 		selector := policy.TagSelector{
 			Clause: completeClause,
 			Policy: &policy.FlowPolicy{
@@ -301,8 +296,9 @@ func aclEgressRules(rule networking.NetworkPolicyEgressRule) ([]policy.IPRule, e
 	return aclPolicy, nil
 }
 
-func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngressRule, podNamespace string, allNamespaces *api.NamespaceList, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string, betaPolicies bool) ([]policy.TagSelector, []policy.IPRule, error) {
-	if !betaPolicies && len(*ingressKubeRules) == 0 {
+func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngressRule, policyIDs []string, podNamespace string, allNamespaces *api.NamespaceList, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string, defaultDeny bool) ([]policy.TagSelector, []policy.IPRule, error) {
+	// Without any rule, the traffic is allowed unless denied by default.
+	if !defaultDeny && len(*ingressKubeRules) == 0 {
 		return rulesAndACLsAllowAll()
 	}
 
@@ -310,7 +306,7 @@ func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngres
 	ipRules := []policy.IPRule{}
 
 	// generate IngressRule with tags
-	for _, rule := range *ingressKubeRules {
+	for i, rule := range *ingressKubeRules {
 
 		// From is not set, Only using the Port information.
 		if rule.From == nil {
@@ -323,6 +319,7 @@ func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngres
 			if err != nil {
 				return nil, nil, fmt.Errorf("Error creating pod ACLRules: %s", err)
 			}
+			setPolicyID(nil, aclSelectorRules, policyIDs, i)
 			ipRules = append(ipRules, aclSelectorRules...)
 			continue
 		}
@@ -337,6 +334,7 @@ func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngres
 		if err != nil {
 			return nil, nil, fmt.Errorf("Error creating pod policyRule: %s", err)
 		}
+		setPolicyID(podSelectorRules, nil, policyIDs, i)
 		receiverRules = append(receiverRules, podSelectorRules...)
 
 		// Phase2: populate the clauses related to the namespace rules. (namepace selector...)
@@ -344,6 +342,7 @@ func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngres
 		if err != nil {
 			return nil, nil, fmt.Errorf("Error creating pod namespaceRule: %s", err)
 		}
+		setPolicyID(namespaceSelectorRules, nil, policyIDs, i)
 
 		receiverRules = append(receiverRules, namespaceSelectorRules...)
 	}
@@ -351,8 +350,9 @@ func generateIngressRulesList(ingressKubeRules *[]networking.NetworkPolicyIngres
 	return receiverRules, ipRules, nil
 }
 
-func generateEgressRulesList(egressKubeRules *[]networking.NetworkPolicyEgressRule, podNamespace string, allNamespaces *api.NamespaceList, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string, betaPolicies bool) ([]policy.TagSelector, []policy.IPRule, error) {
-	if !betaPolicies && len(*egressKubeRules) == 0 {
+func generateEgressRulesList(egressKubeRules *[]networking.NetworkPolicyEgressRule, policyIDs []string, podNamespace string, allNamespaces *api.NamespaceList, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string, defaultDeny bool) ([]policy.TagSelector, []policy.IPRule, error) {
+	// Without any rule, the traffic is allowed unless denied by default.
+	if !defaultDeny && len(*egressKubeRules) == 0 {
 		return rulesAndACLsAllowAll()
 	}
 
@@ -360,7 +360,7 @@ func generateEgressRulesList(egressKubeRules *[]networking.NetworkPolicyEgressRu
 	ipRules := []policy.IPRule{}
 
 	// generate IngressRule with tags
-	for _, rule := range *egressKubeRules {
+	for i, rule := range *egressKubeRules {
 
 		// To is not set, Only using the Port information.
 		if rule.To == nil {
@@ -373,6 +373,7 @@ func generateEgressRulesList(egressKubeRules *[]networking.NetworkPolicyEgressRu
 			if err != nil {
 				return nil, nil, fmt.Errorf("Error creating pod ACLRules: %s", err)
 			}
+			setPolicyID(nil, aclSelectorRules, policyIDs, i)
 			ipRules = append(ipRules, aclSelectorRules...)
 			continue
 		}
@@ -387,6 +388,7 @@ func generateEgressRulesList(egressKubeRules *[]networking.NetworkPolicyEgressRu
 		if err != nil {
 			return nil, nil, fmt.Errorf("Error creating pod policyRule: %s", err)
 		}
+		setPolicyID(podSelectorRules, nil, policyIDs, i)
 		transmitterRules = append(transmitterRules, podSelectorRules...)
 
 		// Phase2: populate the clauses related to the namespace rules. (namepace selector...)
@@ -394,6 +396,7 @@ func generateEgressRulesList(egressKubeRules *[]networking.NetworkPolicyEgressRu
 		if err != nil {
 			return nil, nil, fmt.Errorf("Error creating pod namespaceRule: %s", err)
 		}
+		setPolicyID(namespaceSelectorRules, nil, policyIDs, i)
 
 		transmitterRules = append(transmitterRules, namespaceSelectorRules...)
 	}
@@ -407,14 +410,12 @@ func namespaceIngressRules(rule *networking.NetworkPolicyIngressRule, podNamespa
 	matchedNamespaces := map[string]bool{}
 	for _, peer := range rule.From {
 		// Individual From. Each From is ORed.
-		namespaceSelector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+		namespaces, err := matchingNamespaces(peer.NamespaceSelector, allNamespaces)
 		if err != nil {
-			return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+			return nil, err
 		}
-		for _, namespace := range allNamespaces.Items {
-			if namespaceSelector.Matches(labels.Set(namespace.GetLabels())) {
-				matchedNamespaces[namespace.GetName()] = true
-			}
+		for _, namespace := range namespaces {
+			matchedNamespaces[namespace] = true
 		}
 	}
 
@@ -453,14 +454,12 @@ func namespaceEgressRules(rule *networking.NetworkPolicyEgressRule, podNamespace
 	matchedNamespaces := map[string]bool{}
 	for _, peer := range rule.To {
 		// Individual From. Each From is ORed.
-		namespaceSelector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+		namespaces, err := matchingNamespaces(peer.NamespaceSelector, allNamespaces)
 		if err != nil {
-			return nil, fmt.Errorf("Error while parsing Peer label selector %s", err)
+			return nil, err
 		}
-		for _, namespace := range allNamespaces.Items {
-			if namespaceSelector.Matches(labels.Set(namespace.GetLabels())) {
-				matchedNamespaces[namespace.GetName()] = true
-			}
+		for _, namespace := range namespaces {
+			matchedNamespaces[namespace] = true
 		}
 	}
 
@@ -493,10 +492,15 @@ func namespaceEgressRules(rule *networking.NetworkPolicyEgressRule, podNamespace
 	return receiverRules, nil
 }
 
-// generatePUPolicy creates a PUPolicy representation
-func generatePUPolicy(ingressKubeRules *[]networking.NetworkPolicyIngressRule, egressKubeRules *[]networking.NetworkPolicyEgressRule, podNamespace string, allNamespaces *api.NamespaceList, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string, betaPolicies bool, egressPolicies bool) (*policy.PUPolicy, error) {
+// generatePUPolicy creates a PUPolicy representation. The cluster rules are evaluated
+// before the NetworkPolicies rules so that they cannot be overridden. The system rules are
+// only added to egress isolated pods. The posture defines if the traffic is denied when
+// no NetworkPolicy rule applies. The accepting rules involving the encrypted namespaces
+// are encrypted. The rules generated from the NetworkPolicies get the PolicyID at the same
+// index in ingressPolicyIDs and egressPolicyIDs.
+func generatePUPolicy(ingressKubeRules *[]networking.NetworkPolicyIngressRule, egressKubeRules *[]networking.NetworkPolicyEgressRule, ingressPolicyIDs []string, egressPolicyIDs []string, cluster *clusterRules, system *systemRules, podNamespace string, allNamespaces *api.NamespaceList, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string, posture DefaultPosture, egressPolicies bool, encrypted map[string]bool) (*policy.PUPolicy, error) {
 
-	ingressRulesList, ingressACLs, err := generateIngressRulesList(ingressKubeRules, podNamespace, allNamespaces, tags, ips, triremeNets, betaPolicies)
+	ingressRulesList, ingressACLs, err := generateIngressRulesList(ingressKubeRules, ingressPolicyIDs, podNamespace, allNamespaces, tags, ips, triremeNets, posture.deniesIngress())
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't generate ingress rules: %s", err)
 	}
@@ -507,12 +511,27 @@ func generatePUPolicy(ingressKubeRules *[]networking.NetworkPolicyIngressRule, e
 		return nil, fmt.Errorf("Error genrating allowAll policy for egress")
 	}
 	if egressPolicies {
-		egressRulesList, egressACLs, err = generateEgressRulesList(egressKubeRules, podNamespace, allNamespaces, tags, ips, triremeNets, betaPolicies)
+		egressRulesList, egressACLs, err = generateEgressRulesList(egressKubeRules, egressPolicyIDs, podNamespace, allNamespaces, tags, ips, triremeNets, posture.deniesEgress())
 		if err != nil {
 			return nil, fmt.Errorf("Couldn't generate ingress rules: %s", err)
 		}
 	}
 
+	if system != nil && isEgressIsolated(egressKubeRules, posture, egressPolicies) {
+		egressRulesList = append(egressRulesList, system.transmitterRules...)
+		egressACLs = append(egressACLs, system.applicationACLs...)
+	}
+
+	if cluster != nil {
+		ingressRulesList = append(cluster.receiverRules, ingressRulesList...)
+		ingressACLs = append(cluster.networkACLs, ingressACLs...)
+		egressRulesList = append(cluster.transmitterRules, egressRulesList...)
+		egressACLs = append(cluster.applicationACLs, egressACLs...)
+	}
+
+	ingressRulesList = encryptRules(ingressRulesList, podNamespace, encrypted)
+	egressRulesList = encryptRules(egressRulesList, podNamespace, encrypted)
+
 	excluded := []string{}
 	containerPolicy := policy.NewPUPolicy("", policy.Police, egressACLs, ingressACLs, egressRulesList, ingressRulesList, tags, tags, ips, triremeNets, excluded)
 
@@ -520,6 +539,11 @@ func generatePUPolicy(ingressKubeRules *[]networking.NetworkPolicyIngressRule, e
 	return containerPolicy, nil
 }
 
+// isEgressIsolated returns true if the outgoing traffic of the pod is restricted.
+func isEgressIsolated(egressKubeRules *[]networking.NetworkPolicyEgressRule, posture DefaultPosture, egressPolicies bool) bool {
+	return egressPolicies && (len(*egressKubeRules) > 0 || posture.deniesEgress())
+}
+
 func rulesAndACLsAllowAll() ([]policy.TagSelector, []policy.IPRule, error) {
 	return rulesAllowAll(), aclsAllowAll(), nil
 }
@@ -576,6 +600,37 @@ func allowAllPolicy(tags *policy.TagStore, ips policy.ExtendedMap, triremeNets [
 	return policy.NewPUPolicy("", policy.Police, ingressACLs, egressACLs, nil, receivingRules, tags, tags, ips, triremeNets, nil)
 }
 
+// clusterAllowAllPolicy returns a policy allowing all the traffic not matched by the cluster
+// rules, which are evaluated first.
+func clusterAllowAllPolicy(cluster *clusterRules, tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string) *policy.PUPolicy {
+	if len(cluster.receiverRules) == 0 && len(cluster.networkACLs) == 0 && len(cluster.transmitterRules) == 0 && len(cluster.applicationACLs) == 0 {
+		return allowAllPolicy(tags, ips, triremeNets)
+	}
+
+	ingressRules := append(append([]policy.TagSelector{}, cluster.receiverRules...), rulesAllowAll()...)
+	ingressACLs := append(append([]policy.IPRule{}, cluster.networkACLs...), aclsAllowAll()...)
+	egressRules := append(append([]policy.TagSelector{}, cluster.transmitterRules...), rulesAllowAll()...)
+	egressACLs := append(append([]policy.IPRule{}, cluster.applicationACLs...), aclsAllowAll()...)
+
+	return policy.NewPUPolicy("", policy.Police, egressACLs, ingressACLs, egressRules, ingressRules, tags, tags, ips, triremeNets, nil)
+}
+
+// quarantinePolicy returns a policy rejecting all the traffic of the PU in both directions.
+// Traffic to and from the forensic namespace is still allowed if one is given.
+func quarantinePolicy(tags *policy.TagStore, ips policy.ExtendedMap, triremeNets []string, forensicNamespace string) *policy.PUPolicy {
+	rules := []policy.TagSelector{}
+	if forensicNamespace != "" {
+		rules = append(rules, policy.TagSelector{
+			Clause: namespaceSelector(forensicNamespace),
+			Policy: &policy.FlowPolicy{
+				Action: policy.Accept,
+			},
+		})
+	}
+
+	return policy.NewPUPolicy("", policy.Police, nil, nil, rules, rules, tags, tags, ips, triremeNets, nil)
+}
+
 // notInfraContainerPolicy is a policy that should apply to the other containers in a pod that are not the infra container.
 func notInfraContainerPolicy() *policy.PUPolicy {
 	return policy.NewPUPolicyWithDefaults()
@@ -586,23 +641,23 @@ func logRules(containerPolicy *policy.PUPolicy) {
 	// INGRESS or RECEIVER or NETWORK Rules and ACLs.
 	for i, rule := range containerPolicy.ReceiverRules() {
 		for _, clause := range rule.Clause {
-			zap.L().Debug("Trireme receiver RULES for POD", zap.Int("i", i), zap.Any("selector", clause))
+			logger().Debug("Trireme receiver RULES for POD", zap.Int("i", i), zap.String("policyID", rule.Policy.PolicyID), zap.Any("selector", clause))
 		}
 	}
 	for i, acl := range containerPolicy.NetworkACLs() {
-		zap.L().Debug("Trireme receiver ACL for POD", zap.Int("i", i), zap.Any("Address", acl.Address), zap.Any("Port", acl.Port), zap.Any("Policy", acl.Policy), zap.Any("Protocol", acl.Protocol))
+		logger().Debug("Trireme receiver ACL for POD", zap.Int("i", i), zap.String("policyID", acl.Policy.PolicyID), zap.Any("Address", acl.Address), zap.Any("Port", acl.Port), zap.Any("Policy", acl.Policy), zap.Any("Protocol", acl.Protocol))
 	}
 
 	// EGRESS or TRANSMITTER or APPLICATION Rules and ACLs.
 	for i, rule := range containerPolicy.TransmitterRules() {
 		for _, clause := range rule.Clause {
-			zap.L().Debug("Trireme transmitter RULES for POD", zap.Int("i", i), zap.Any("selector", clause))
+			logger().Debug("Trireme transmitter RULES for POD", zap.Int("i", i), zap.String("policyID", rule.Policy.PolicyID), zap.Any("selector", clause))
 		}
 	}
 	for i, acl := range containerPolicy.ApplicationACLs() {
-		zap.L().Debug("Trireme transmitter ACL for POD", zap.Int("i", i), zap.Any("Address", acl.Address), zap.Any("Port", acl.Port))
+		logger().Debug("Trireme transmitter ACL for POD", zap.Int("i", i), zap.String("policyID", acl.Policy.PolicyID), zap.Any("Address", acl.Address), zap.Any("Port", acl.Port))
 	}
 
 	// POD Tags.
-	zap.L().Debug("Trireme tags for container X", zap.Any("identity", containerPolicy.Identity()))
+	logger().Debug("Trireme tags for container X", zap.Any("identity", containerPolicy.Identity()))
 }
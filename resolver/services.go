@@ -0,0 +1,335 @@
+package resolver
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubecache "k8s.io/client-go/tools/cache"
+
+	"go.uber.org/zap"
+)
+
+// ServicePolicyIDPrefix prefixes the PolicyID of the rules generated from the services of a NetworkPolicy.
+const ServicePolicyIDPrefix = "service:"
+
+// serviceEntry is a service allowed by a NetworkPolicy, on a single service port
+// (name or number) or on all its ports if empty.
+type serviceEntry struct {
+	namespace string
+	name      string
+	port      string
+}
+
+// serviceTracker keeps the Services and Endpoints of the cluster, and the services used
+// by each pod to update the pod policies when a service or its endpoints change.
+// The stores are also used to find the cluster DNS.
+type serviceTracker struct {
+	services  kubecache.Store
+	endpoints kubecache.Store
+	pods      *podTracker
+	// policies is true if the services of the NetworkPolicies are enabled.
+	policies bool
+}
+
+// EnableServicePolicies enables the services given in the EgressServicesAnnotationID annotation
+// of the NetworkPolicies. Must be called before Run.
+func (k *KubernetesPolicy) EnableServicePolicies() {
+	k.watchedServices().policies = true
+}
+
+// watchedServices returns the serviceTracker, creating it if needed.
+func (k *KubernetesPolicy) watchedServices() *serviceTracker {
+	if k.services == nil {
+		k.services = &serviceTracker{
+			pods: newPodTracker(),
+		}
+	}
+	return k.services
+}
+
+// watchServices starts watching the Services and Endpoints of all the namespaces if needed.
+// It returns the functions reporting if the stores are synced.
+func (k *KubernetesPolicy) watchServices() []kubecache.InformerSynced {
+	if k.services == nil {
+		return nil
+	}
+
+	serviceStore, serviceController := k.KubernetesClient.CreateServiceController("",
+		k.serviceChanged,
+		k.serviceChanged,
+		func(oldService, updatedService *api.Service) error {
+			if reflect.DeepEqual(oldService.Spec, updatedService.Spec) {
+				return nil
+			}
+			return k.serviceChanged(updatedService)
+		})
+	k.services.services = serviceStore
+	go serviceController.Run(k.stopAll)
+
+	endpointsStore, endpointsController := k.KubernetesClient.CreateEndpointsController("",
+		k.endpointsChanged,
+		k.endpointsChanged,
+		func(oldEndpoints, updatedEndpoints *api.Endpoints) error {
+			if reflect.DeepEqual(oldEndpoints.Subsets, updatedEndpoints.Subsets) {
+				return nil
+			}
+			return k.endpointsChanged(updatedEndpoints)
+		})
+	k.services.endpoints = endpointsStore
+	go endpointsController.Run(k.stopAll)
+
+	return []kubecache.InformerSynced{serviceController.HasSynced, endpointsController.HasSynced}
+}
+
+func (k *KubernetesPolicy) serviceChanged(service *api.Service) error {
+	logger().Debug("Service modified", zap.String("name", service.GetName()), zap.String("namespace", service.GetNamespace()))
+	k.updateServicePodPolicies(serviceKey(service.GetNamespace(), service.GetName()))
+	return nil
+}
+
+func (k *KubernetesPolicy) endpointsChanged(endpoints *api.Endpoints) error {
+	logger().Debug("Endpoints modified", zap.String("name", endpoints.GetName()), zap.String("namespace", endpoints.GetNamespace()))
+	k.updateServicePodPolicies(serviceKey(endpoints.GetNamespace(), endpoints.GetName()))
+	return nil
+}
+
+// updateServicePodPolicies updates the policy of the pods allowed to reach the service,
+// either through a NetworkPolicy or as the cluster DNS.
+func (k *KubernetesPolicy) updateServicePodPolicies(key string) {
+	k.updateTrackedPodPolicies(k.services.pods, key)
+	if k.dns != nil {
+		k.updateTrackedPodPolicies(k.dns.pods, key)
+	}
+}
+
+// serviceKey is the store key of a service and its endpoints.
+func serviceKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+// parseServices parses the EgressServicesAnnotationID annotation: a comma or space separated list
+// of services given as [namespace/]name[:port]. The namespace defaults to the NetworkPolicy one.
+func parseServices(value string, defaultNamespace string) ([]serviceEntry, error) {
+	entries := []serviceEntry{}
+	separator := func(r rune) bool { return r == ',' || unicode.IsSpace(r) }
+	for _, field := range strings.FieldsFunc(value, separator) {
+		entry := serviceEntry{namespace: defaultNamespace, name: field}
+
+		if i := strings.Index(entry.name, ":"); i >= 0 {
+			entry.port = entry.name[i+1:]
+			entry.name = entry.name[:i]
+			if entry.port == "" {
+				return nil, fmt.Errorf("Invalid port in %s", field)
+			}
+		}
+		if i := strings.Index(entry.name, "/"); i >= 0 {
+			entry.namespace = entry.name[:i]
+			entry.name = entry.name[i+1:]
+		}
+
+		if entry.namespace == "" || entry.name == "" || strings.Contains(entry.name, "/") {
+			return nil, fmt.Errorf("Invalid service %s", field)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// serviceRules generates the transmitter rules and ACLs allowing the services of the NetworkPolicies
+// selecting the pod. The FQDNs of the ExternalName services are returned to be resolved. It returns
+// true if at least one of those NetworkPolicies has services, as the pod is then egress isolated.
+func (k *KubernetesPolicy) serviceRules(pod *api.Pod, networkPolicies *networking.NetworkPolicyList) ([]policy.TagSelector, []policy.IPRule, []fqdnEntry, bool) {
+	if k.services == nil || !k.services.policies {
+		return nil, nil, nil, false
+	}
+
+	rules := []policy.TagSelector{}
+	acls := []policy.IPRule{}
+	externalNames := []fqdnEntry{}
+	keys := []string{}
+	selected := false
+
+	for _, networkPolicy := range networkPolicies.Items {
+		value, ok := networkPolicy.GetAnnotations()[EgressServicesAnnotationID]
+		if !ok {
+			continue
+		}
+
+		podSelector, err := metav1.LabelSelectorAsSelector(&networkPolicy.Spec.PodSelector)
+		if err != nil || !podSelector.Matches(labels.Set(pod.GetLabels())) {
+			continue
+		}
+
+		entries, err := parseServices(value, networkPolicy.GetNamespace())
+		if err != nil {
+			logger().Error("Ignoring invalid services annotation", zap.String("networkPolicy", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Error(err))
+			continue
+		}
+		selected = true
+
+		for _, entry := range entries {
+			key := serviceKey(entry.namespace, entry.name)
+			// Services are tracked even if they don't exist yet.
+			keys = append(keys, key)
+
+			serviceRules, serviceACLs, externalName, err := k.serviceEntryRules(entry)
+			if err != nil {
+				logger().Warn("Couldn't allow service", zap.String("service", key), zap.String("networkPolicy", networkPolicy.GetName()), zap.Error(err))
+				continue
+			}
+			rules = append(rules, serviceRules...)
+			acls = append(acls, serviceACLs...)
+			externalNames = append(externalNames, externalName...)
+		}
+	}
+
+	k.services.pods.track(podKey(pod.GetName(), pod.GetNamespace()), keys)
+	return rules, acls, externalNames, selected
+}
+
+// serviceEntryRules generates the rules allowing a single service:
+// the pods selected by the service, its cluster IP and its endpoints,
+// or its FQDN for ExternalName services.
+func (k *KubernetesPolicy) serviceEntryRules(entry serviceEntry) ([]policy.TagSelector, []policy.IPRule, []fqdnEntry, error) {
+	key := serviceKey(entry.namespace, entry.name)
+	policyID := ServicePolicyIDPrefix + key
+
+	item, exists, err := k.services.services.GetByKey(key)
+	if err != nil || !exists {
+		return nil, nil, nil, fmt.Errorf("Service not found")
+	}
+	service := item.(*api.Service)
+
+	ports := []api.ServicePort{}
+	for _, port := range service.Spec.Ports {
+		if entry.port == "" || entry.port == port.Name || entry.port == strconv.Itoa(int(port.Port)) {
+			ports = append(ports, port)
+		}
+	}
+	if entry.port != "" && len(ports) == 0 {
+		return nil, nil, nil, fmt.Errorf("Port %s not found", entry.port)
+	}
+
+	if service.Spec.Type == api.ServiceTypeExternalName {
+		if k.fqdns == nil {
+			return nil, nil, nil, fmt.Errorf("ExternalName services require FQDN policies")
+		}
+		if len(ports) == 0 {
+			return nil, nil, []fqdnEntry{{name: service.Spec.ExternalName, policyID: policyID}}, nil
+		}
+		externalNames := []fqdnEntry{}
+		for _, port := range ports {
+			externalNames = append(externalNames, fqdnEntry{name: service.Spec.ExternalName, port: strconv.Itoa(int(port.Port)), policyID: policyID})
+		}
+		return nil, nil, externalNames, nil
+	}
+
+	rules := []policy.TagSelector{}
+	if len(service.Spec.Selector) > 0 {
+		rules = append(rules, servicePodRule(service, ports, policyID))
+	}
+
+	// The pods behind the service may not be policed. They are reached through ACLs
+	// on the cluster IP and the endpoints.
+	acls := []policy.IPRule{}
+	if service.Spec.ClusterIP != "" && service.Spec.ClusterIP != api.ClusterIPNone {
+		for _, port := range ports {
+			acls = append(acls, serviceIPRules(service.Spec.ClusterIP, port.Port, port.Protocol, policyID)...)
+		}
+	}
+
+	item, exists, err = k.services.endpoints.GetByKey(key)
+	if err == nil && exists {
+		endpoints := item.(*api.Endpoints)
+		for _, subset := range endpoints.Subsets {
+			for _, port := range subset.Ports {
+				if !isEndpointPortSelected(port, ports) {
+					continue
+				}
+				for _, address := range subset.Addresses {
+					acls = append(acls, serviceIPRules(address.IP, port.Port, port.Protocol, policyID)...)
+				}
+			}
+		}
+	}
+
+	return rules, acls, nil, nil
+}
+
+// servicePodRule generates the rule matching the pods selected by the service on their target ports.
+func servicePodRule(service *api.Service, ports []api.ServicePort, policyID string) policy.TagSelector {
+	targetPorts := []string{}
+	allPorts := false
+	for _, port := range ports {
+		switch {
+		case port.TargetPort.Type == intstr.String:
+			// Named target ports depend on each pod spec.
+			allPorts = true
+		case port.TargetPort.IntValue() == 0:
+			targetPorts = append(targetPorts, strconv.Itoa(int(port.Port)))
+		default:
+			targetPorts = append(targetPorts, port.TargetPort.String())
+		}
+	}
+
+	completeClause := []policy.KeyValueOperator{}
+	if !allPorts && len(targetPorts) > 0 {
+		completeClause = append(completeClause, policy.KeyValueOperator{
+			Key:      "$sys:port",
+			Operator: policy.Equal,
+			Value:    targetPorts,
+		})
+	}
+	completeClause = append(completeClause, namespaceSelector(service.GetNamespace())...)
+
+	requirements, _ := labels.SelectorFromSet(labels.Set(service.Spec.Selector)).Requirements()
+	completeClause = append(completeClause, requirementsClause(requirements)...)
+
+	return policy.TagSelector{
+		Clause: completeClause,
+		Policy: &policy.FlowPolicy{
+			Action:   policy.Accept,
+			PolicyID: policyID,
+		},
+	}
+}
+
+// isEndpointPortSelected returns true if the endpoint port belongs to one of the service ports.
+// Endpoint ports have the name of their service port.
+func isEndpointPortSelected(port api.EndpointPort, ports []api.ServicePort) bool {
+	for _, servicePort := range ports {
+		if servicePort.Name == port.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceIPRules generates the IPRule allowing an address on a port.
+func serviceIPRules(ip string, port int32, protocol api.Protocol, policyID string) []policy.IPRule {
+	if protocol != api.ProtocolTCP && protocol != api.ProtocolUDP {
+		return nil
+	}
+
+	return []policy.IPRule{
+		policy.IPRule{
+			Address:  ip + "/32",
+			Port:     strconv.Itoa(int(port)),
+			Protocol: string(protocol),
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Accept,
+				PolicyID: policyID,
+			},
+		},
+	}
+}
@@ -0,0 +1,272 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/policy"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubecache "k8s.io/client-go/tools/cache"
+)
+
+func servicesPolicy(t *testing.T, objects ...interface{}) *KubernetesPolicy {
+	k := &KubernetesPolicy{}
+	k.EnableServicePolicies()
+	k.services.services = kubecache.NewStore(kubecache.MetaNamespaceKeyFunc)
+	k.services.endpoints = kubecache.NewStore(kubecache.MetaNamespaceKeyFunc)
+
+	for _, object := range objects {
+		var err error
+		switch object.(type) {
+		case *api.Service:
+			err = k.services.services.Add(object)
+		case *api.Endpoints:
+			err = k.services.endpoints.Add(object)
+		}
+		if err != nil {
+			t.Fatalf("Store add failed: %s", err)
+		}
+	}
+	return k
+}
+
+func servicesNetworkPolicy(services string) *networking.NetworkPolicyList {
+	return &networking.NetworkPolicyList{Items: []networking.NetworkPolicy{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "egress-services",
+			Namespace:   "shop",
+			Annotations: map[string]string{EgressServicesAnnotationID: services},
+		},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}}}
+}
+
+// aclTargets returns the address:port/protocol of the ACLs.
+func aclTargets(acls []policy.IPRule) []string {
+	targets := []string{}
+	for _, acl := range acls {
+		targets = append(targets, acl.Address+":"+acl.Port+"/"+acl.Protocol)
+	}
+	return targets
+}
+
+var apiService = &api.Service{
+	ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+	Spec: api.ServiceSpec{
+		Selector:  map[string]string{"app": "api"},
+		ClusterIP: "10.96.0.20",
+		Ports: []api.ServicePort{
+			{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080), Protocol: api.ProtocolTCP},
+			{Name: "metrics", Port: 9090, Protocol: api.ProtocolTCP},
+		},
+	},
+}
+
+var apiEndpoints = &api.Endpoints{
+	ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop"},
+	Subsets: []api.EndpointSubset{{
+		Addresses: []api.EndpointAddress{{IP: "10.32.0.5"}},
+		Ports: []api.EndpointPort{
+			{Name: "http", Port: 8080, Protocol: api.ProtocolTCP},
+			{Name: "metrics", Port: 9090, Protocol: api.ProtocolTCP},
+		},
+	}},
+}
+
+var databaseService = &api.Service{
+	ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "data"},
+	Spec: api.ServiceSpec{
+		ClusterIP: api.ClusterIPNone,
+		Ports:     []api.ServicePort{{Name: "postgres", Port: 5432, Protocol: api.ProtocolTCP}},
+	},
+}
+
+var databaseEndpoints = &api.Endpoints{
+	ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "data"},
+	Subsets: []api.EndpointSubset{{
+		Addresses: []api.EndpointAddress{{IP: "192.168.1.10"}, {IP: "192.168.1.11"}},
+		Ports:     []api.EndpointPort{{Name: "postgres", Port: 5432, Protocol: api.ProtocolTCP}},
+	}},
+}
+
+var paymentsService = &api.Service{
+	ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: "shop"},
+	Spec: api.ServiceSpec{
+		Type:         api.ServiceTypeExternalName,
+		ExternalName: "payments.example.com",
+		Ports:        []api.ServicePort{{Name: "https", Port: 443, Protocol: api.ProtocolTCP}},
+	},
+}
+
+var parseServicesTests = []struct {
+	value   string
+	entries []serviceEntry
+	valid   bool
+}{
+	{"", []serviceEntry{}, true},
+	{"api", []serviceEntry{{"shop", "api", ""}}, true},
+	{"api:http, data/database:5432", []serviceEntry{{"shop", "api", "http"}, {"data", "database", "5432"}}, true},
+	{"api data/database", []serviceEntry{{"shop", "api", ""}, {"data", "database", ""}}, true},
+	{"api:", nil, false},
+	{"/api", nil, false},
+	{"data/", nil, false},
+	{"a/b/c", nil, false},
+}
+
+func TestParseServices(t *testing.T) {
+	for _, tt := range parseServicesTests {
+		entries, err := parseServices(tt.value, "shop")
+		if tt.valid && err != nil {
+			t.Errorf("parseServices(%q) => unexpected error %s", tt.value, err)
+			continue
+		}
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("parseServices(%q) => %v, expected an error", tt.value, entries)
+			}
+			continue
+		}
+		if len(entries) != len(tt.entries) {
+			t.Errorf("parseServices(%q) => %v, expected %v", tt.value, entries, tt.entries)
+			continue
+		}
+		for i := range entries {
+			if entries[i] != tt.entries[i] {
+				t.Errorf("parseServices(%q) => %v, expected %v", tt.value, entries, tt.entries)
+			}
+		}
+	}
+}
+
+func TestServiceRules(t *testing.T) {
+	withFQDNs := servicesPolicy(t, paymentsService)
+	withFQDNs.fqdns = &fqdnTracker{pods: newPodTracker()}
+
+	tests := []struct {
+		name          string
+		k             *KubernetesPolicy
+		podLabels     map[string]string
+		services      string
+		rulePorts     []string
+		acls          []string
+		externalNames []fqdnEntry
+		selected      bool
+	}{
+		{"selector service", servicesPolicy(t, apiService, apiEndpoints), nil, "api",
+			[]string{"8080", "9090"},
+			[]string{"10.96.0.20/32:80/TCP", "10.96.0.20/32:9090/TCP", "10.32.0.5/32:8080/TCP", "10.32.0.5/32:9090/TCP"}, nil, true},
+		{"selector service port", servicesPolicy(t, apiService, apiEndpoints), nil, "api:http",
+			[]string{"8080"}, []string{"10.96.0.20/32:80/TCP", "10.32.0.5/32:8080/TCP"}, nil, true},
+		{"selector service without endpoints", servicesPolicy(t, apiService), nil, "api:9090",
+			[]string{"9090"}, []string{"10.96.0.20/32:9090/TCP"}, nil, true},
+		{"selector-less headless service", servicesPolicy(t, databaseService, databaseEndpoints), nil, "data/database",
+			nil, []string{"192.168.1.10/32:5432/TCP", "192.168.1.11/32:5432/TCP"}, nil, true},
+		{"unknown port", servicesPolicy(t, apiService, apiEndpoints), nil, "api:grpc", nil, []string{}, nil, true},
+		{"missing service", servicesPolicy(t), nil, "api", nil, []string{}, nil, true},
+		{"ExternalName without FQDN policies", servicesPolicy(t, paymentsService), nil, "payments", nil, []string{}, nil, true},
+		{"ExternalName", withFQDNs, nil, "payments", nil, []string{},
+			[]fqdnEntry{{name: "payments.example.com", port: "443", policyID: ServicePolicyIDPrefix + "shop/payments"}}, true},
+		{"pod not selected", servicesPolicy(t, apiService, apiEndpoints), map[string]string{"app": "api"}, "api", nil, []string{}, nil, false},
+		{"invalid annotation", servicesPolicy(t, apiService, apiEndpoints), nil, "api:", nil, []string{}, nil, false},
+	}
+	for _, tt := range tests {
+		podLabels := tt.podLabels
+		if podLabels == nil {
+			podLabels = map[string]string{"app": "web"}
+		}
+
+		rules, acls, externalNames, selected := tt.k.serviceRules(pod("shop", podLabels, nil), servicesNetworkPolicy(tt.services))
+		if selected != tt.selected {
+			t.Errorf("%s: serviceRules => selected %t, expected %t", tt.name, selected, tt.selected)
+		}
+
+		if tt.rulePorts == nil && len(rules) != 0 {
+			t.Errorf("%s: serviceRules => %d rules, expected none", tt.name, len(rules))
+		}
+		if tt.rulePorts != nil {
+			if len(rules) != 1 {
+				t.Fatalf("%s: serviceRules => %d rules, expected 1", tt.name, len(rules))
+			}
+			if rule := rules[0]; rule.Clause[0].Key != "$sys:port" || !equalStrings(rule.Clause[0].Value, tt.rulePorts) ||
+				rule.Policy.PolicyID != ServicePolicyIDPrefix+"shop/api" {
+				t.Errorf("%s: serviceRules => rule %v, expected ports %v", tt.name, rule, tt.rulePorts)
+			}
+		}
+
+		if targets := aclTargets(acls); !equalStrings(targets, tt.acls) {
+			t.Errorf("%s: serviceRules => ACLs %v, expected %v", tt.name, targets, tt.acls)
+		}
+		if len(externalNames) != len(tt.externalNames) || (len(externalNames) > 0 && externalNames[0] != tt.externalNames[0]) {
+			t.Errorf("%s: serviceRules => ExternalNames %v, expected %v", tt.name, externalNames, tt.externalNames)
+		}
+	}
+}
+
+func TestServiceRulesDisabled(t *testing.T) {
+	k := dnsPolicy(t, "kube-dns", dnsService, apiService)
+	if _, _, _, selected := k.serviceRules(pod("shop", map[string]string{"app": "web"}, nil), servicesNetworkPolicy("api")); selected {
+		t.Errorf("serviceRules without service policies => selected, expected the annotation to be ignored")
+	}
+}
+
+func TestServiceRulesUpdate(t *testing.T) {
+	k := servicesPolicy(t, apiService, apiEndpoints)
+	webPod := pod("shop", map[string]string{"app": "web"}, nil)
+	key := serviceKey("shop", "api")
+
+	// The pods are tracked to be updated when the service or its endpoints change.
+	k.serviceRules(webPod, servicesNetworkPolicy("api:http"))
+	if users := k.services.pods.users(key); !equalStrings(users, []string{"shop/nginx"}) {
+		t.Errorf("serviceRules => service users %v, expected [shop/nginx]", users)
+	}
+
+	tests := []struct {
+		name   string
+		object interface{}
+		acls   []string
+	}{
+		{"endpoints added", &api.Endpoints{
+			ObjectMeta: apiEndpoints.ObjectMeta,
+			Subsets: []api.EndpointSubset{{
+				Addresses: []api.EndpointAddress{{IP: "10.32.0.5"}, {IP: "10.32.0.6"}},
+				Ports:     []api.EndpointPort{{Name: "http", Port: 8080, Protocol: api.ProtocolTCP}},
+			}},
+		}, []string{"10.96.0.20/32:80/TCP", "10.32.0.5/32:8080/TCP", "10.32.0.6/32:8080/TCP"}},
+		{"cluster IP changed", &api.Service{
+			ObjectMeta: apiService.ObjectMeta,
+			Spec: api.ServiceSpec{
+				Selector:  apiService.Spec.Selector,
+				ClusterIP: "10.96.0.30",
+				Ports:     apiService.Spec.Ports,
+			},
+		}, []string{"10.96.0.30/32:80/TCP", "10.32.0.5/32:8080/TCP", "10.32.0.6/32:8080/TCP"}},
+		{"endpoints removed", &api.Endpoints{ObjectMeta: apiEndpoints.ObjectMeta}, []string{"10.96.0.30/32:80/TCP"}},
+	}
+	for _, tt := range tests {
+		var err error
+		switch tt.object.(type) {
+		case *api.Service:
+			err = k.services.services.Update(tt.object)
+		case *api.Endpoints:
+			err = k.services.endpoints.Update(tt.object)
+		}
+		if err != nil {
+			t.Fatalf("Store update failed: %s", err)
+		}
+
+		_, acls, _, _ := k.serviceRules(webPod, servicesNetworkPolicy("api:http"))
+		if targets := aclTargets(acls); !equalStrings(targets, tt.acls) {
+			t.Errorf("%s: serviceRules => ACLs %v, expected %v", tt.name, targets, tt.acls)
+		}
+	}
+
+	k.serviceRules(webPod, &networking.NetworkPolicyList{})
+	if users := k.services.pods.users(key); len(users) != 0 {
+		t.Errorf("serviceRules => service users %v, expected none", users)
+	}
+}
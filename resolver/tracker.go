@@ -0,0 +1,87 @@
+package resolver
+
+import (
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// podTracker keeps track of the external dependencies (FQDNs, services) used by the policy
+// of each pod, to update the pod policies when a dependency changes.
+type podTracker struct {
+	// pods maps each dependency to the pods using it.
+	pods map[string]map[string]bool
+	// dependencies maps each pod to the dependencies it uses.
+	dependencies map[string][]string
+	sync.Mutex
+}
+
+func newPodTracker() *podTracker {
+	return &podTracker{
+		pods:         map[string]map[string]bool{},
+		dependencies: map[string][]string{},
+	}
+}
+
+// podKey identifies a pod in a podTracker.
+func podKey(podName string, podNamespace string) string {
+	return podNamespace + "/" + podName
+}
+
+// track replaces the dependencies used by the pod. It returns the dependencies
+// that are not used by any pod anymore.
+func (t *podTracker) track(key string, dependencies []string) []string {
+	t.Lock()
+	defer t.Unlock()
+
+	used := map[string]bool{}
+	for _, dependency := range dependencies {
+		used[dependency] = true
+		if _, ok := t.pods[dependency]; !ok {
+			t.pods[dependency] = map[string]bool{}
+		}
+		t.pods[dependency][key] = true
+	}
+
+	released := []string{}
+	for _, dependency := range t.dependencies[key] {
+		if used[dependency] {
+			continue
+		}
+		delete(t.pods[dependency], key)
+		if len(t.pods[dependency]) == 0 {
+			delete(t.pods, dependency)
+			released = append(released, dependency)
+		}
+	}
+
+	if len(dependencies) == 0 {
+		delete(t.dependencies, key)
+	} else {
+		t.dependencies[key] = dependencies
+	}
+	return released
+}
+
+// users returns the keys of the pods using the dependency.
+func (t *podTracker) users(dependency string) []string {
+	t.Lock()
+	defer t.Unlock()
+
+	keys := []string{}
+	for key := range t.pods[dependency] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// updateTrackedPodPolicies updates the policy of all the pods using the dependency.
+func (k *KubernetesPolicy) updateTrackedPodPolicies(tracker *podTracker, dependency string) {
+	for _, key := range tracker.users(dependency) {
+		parts := strings.SplitN(key, "/", 2)
+		if err := k.updatePodPolicyByName(parts[1], parts[0]); err != nil {
+			logger().Error("Error updating pod policy after dependency change", zap.String("dependency", dependency), zap.String("pod", key), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/policy"
+
+	networking "k8s.io/api/networking/v1"
+
+	"go.uber.org/zap"
+)
+
+// policyWindow is the time window during which a NetworkPolicy is enforced.
+// A zero bound is unbounded.
+type policyWindow struct {
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+// windowState is the state of a NetworkPolicy relative to its window.
+type windowState int
+
+const (
+	windowPending windowState = iota
+	windowActive
+	windowExpired
+)
+
+// policyWindows keeps the timers re-resolving the pods of the NetworkPolicies
+// at the boundaries of their windows.
+type policyWindows struct {
+	timers map[string]*time.Timer
+	sync.Mutex
+}
+
+func newPolicyWindows() *policyWindows {
+	return &policyWindows{
+		timers: map[string]*time.Timer{},
+	}
+}
+
+// networkPolicyWindow parses the NotBeforeAnnotationID and NotAfterAnnotationID annotations
+// of the NetworkPolicy.
+func networkPolicyWindow(networkPolicy *networking.NetworkPolicy) (policyWindow, error) {
+	window := policyWindow{}
+	annotations := networkPolicy.GetAnnotations()
+
+	if value, ok := annotations[NotBeforeAnnotationID]; ok {
+		notBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return window, fmt.Errorf("Invalid %s annotation %s: %s", NotBeforeAnnotationID, value, err)
+		}
+		window.notBefore = notBefore
+	}
+
+	if value, ok := annotations[NotAfterAnnotationID]; ok {
+		notAfter, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return window, fmt.Errorf("Invalid %s annotation %s: %s", NotAfterAnnotationID, value, err)
+		}
+		window.notAfter = notAfter
+	}
+
+	if !window.notBefore.IsZero() && !window.notAfter.IsZero() && !window.notBefore.Before(window.notAfter) {
+		return window, fmt.Errorf("%s %s is not before %s %s", NotBeforeAnnotationID, window.notBefore, NotAfterAnnotationID, window.notAfter)
+	}
+	return window, nil
+}
+
+// state returns the state of the window at the given time.
+func (w policyWindow) state(now time.Time) windowState {
+	if !w.notBefore.IsZero() && now.Before(w.notBefore) {
+		return windowPending
+	}
+	if !w.notAfter.IsZero() && !now.Before(w.notAfter) {
+		return windowExpired
+	}
+	return windowActive
+}
+
+// nextBoundary returns the next bound of the window after the given time, if any.
+func (w policyWindow) nextBoundary(now time.Time) (time.Time, bool) {
+	switch w.state(now) {
+	case windowPending:
+		return w.notBefore, true
+	case windowActive:
+		return w.notAfter, !w.notAfter.IsZero()
+	default:
+		return time.Time{}, false
+	}
+}
+
+// activeNetworkPolicies returns the NetworkPolicies enforced at the given time. NetworkPolicies
+// with an invalid window are ignored so that a malformed temporary exception is never permanent.
+func activeNetworkPolicies(networkPolicies *networking.NetworkPolicyList, now time.Time) *networking.NetworkPolicyList {
+	activeList := &networking.NetworkPolicyList{}
+
+	for _, networkPolicy := range networkPolicies.Items {
+		window, err := networkPolicyWindow(&networkPolicy)
+		if err != nil {
+			logger().Error("Ignoring NetworkPolicy with invalid window", zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Error(err))
+			continue
+		}
+		if window.state(now) != windowActive {
+			logger().Debug("Ignoring NetworkPolicy outside of its window", zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()))
+			continue
+		}
+		activeList.Items = append(activeList.Items, networkPolicy)
+	}
+	return activeList
+}
+
+// scheduleNetworkPolicyWindow schedules the re-resolution of the pods selected by the
+// NetworkPolicy at the next boundary of its window. The expired NetworkPolicies are
+// reported when they are added.
+func (k *KubernetesPolicy) scheduleNetworkPolicyWindow(networkPolicy *networking.NetworkPolicy, added bool) {
+	key := networkPolicy.GetNamespace() + "/" + networkPolicy.GetName()
+	k.cancelNetworkPolicyWindow(networkPolicy)
+
+	window, err := networkPolicyWindow(networkPolicy)
+	if err != nil {
+		logger().Error("NetworkPolicy with invalid window is ignored", zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	if added && window.state(now) == windowExpired {
+		logger().Info("NetworkPolicy expired", zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Time("notAfter", window.notAfter))
+		k.reportNetworkPolicyEvent(networkPolicy, EventNetworkPolicyExpired)
+	}
+
+	boundary, ok := window.nextBoundary(now)
+	if !ok {
+		return
+	}
+
+	k.windows.Lock()
+	defer k.windows.Unlock()
+
+	var timer *time.Timer
+	timer = time.AfterFunc(boundary.Sub(now), func() {
+		// The timer may fire while the NetworkPolicy is being canceled.
+		if !k.windows.isScheduled(key, timer) {
+			return
+		}
+		k.networkPolicyWindowBoundary(networkPolicy, window)
+	})
+	k.windows.timers[key] = timer
+}
+
+// isScheduled returns true if the timer is still the one scheduled for the NetworkPolicy.
+func (w *policyWindows) isScheduled(key string, timer *time.Timer) bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.timers[key] == timer
+}
+
+// cancelNetworkPolicyWindow stops the timer of the NetworkPolicy window.
+func (k *KubernetesPolicy) cancelNetworkPolicyWindow(networkPolicy *networking.NetworkPolicy) {
+	key := networkPolicy.GetNamespace() + "/" + networkPolicy.GetName()
+
+	k.windows.Lock()
+	defer k.windows.Unlock()
+
+	if timer, ok := k.windows.timers[key]; ok {
+		timer.Stop()
+		delete(k.windows.timers, key)
+	}
+}
+
+// cancelNamespaceNetworkPolicyWindows stops the timers of the NetworkPolicy windows of the namespace.
+func (k *KubernetesPolicy) cancelNamespaceNetworkPolicyWindows(namespace string) {
+	k.windows.Lock()
+	defer k.windows.Unlock()
+
+	for key, timer := range k.windows.timers {
+		if strings.HasPrefix(key, namespace+"/") {
+			timer.Stop()
+			delete(k.windows.timers, key)
+		}
+	}
+}
+
+// stopNetworkPolicyWindows stops all the timers of the NetworkPolicy windows.
+func (k *KubernetesPolicy) stopNetworkPolicyWindows() {
+	k.windows.Lock()
+	defer k.windows.Unlock()
+
+	for key, timer := range k.windows.timers {
+		timer.Stop()
+		delete(k.windows.timers, key)
+	}
+}
+
+// networkPolicyWindowBoundary is called when the NetworkPolicy window opens or closes.
+func (k *KubernetesPolicy) networkPolicyWindowBoundary(networkPolicy *networking.NetworkPolicy, window policyWindow) {
+	if window.state(time.Now()) == windowExpired {
+		logger().Info("NetworkPolicy expired", zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Time("notAfter", window.notAfter))
+		k.reportNetworkPolicyEvent(networkPolicy, EventNetworkPolicyExpired)
+	} else {
+		logger().Info("NetworkPolicy window started", zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Time("notBefore", window.notBefore))
+		k.reportNetworkPolicyEvent(networkPolicy, EventNetworkPolicyStarted)
+	}
+
+	// Re-resolve the selected pods as if the NetworkPolicy was modified, which also
+	// schedules the next boundary.
+	if err := k.updateNetworkPolicy(networkPolicy, networkPolicy); err != nil {
+		logger().Error("Couldn't update pods at NetworkPolicy window boundary", zap.String("name", networkPolicy.GetName()), zap.String("namespace", networkPolicy.GetNamespace()), zap.Error(err))
+	}
+}
+
+// reportNetworkPolicyEvent reports a NetworkPolicy window event to the collector.
+func (k *KubernetesPolicy) reportNetworkPolicyEvent(networkPolicy *networking.NetworkPolicy, event string) {
+	if k.eventCollector == nil {
+		return
+	}
+
+	k.eventCollector.CollectContainerEvent(&collector.ContainerRecord{
+		Tags: policy.NewTagStoreFromMap(map[string]string{
+			"@namespace":     networkPolicy.GetNamespace(),
+			"@networkpolicy": networkPolicy.GetName(),
+		}),
+		Event: event,
+	})
+}
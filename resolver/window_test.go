@@ -0,0 +1,169 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func windowNetworkPolicy(namespace string, name string, notBefore string, notAfter string) *networking.NetworkPolicy {
+	annotations := map[string]string{}
+	if notBefore != "" {
+		annotations[NotBeforeAnnotationID] = notBefore
+	}
+	if notAfter != "" {
+		annotations[NotAfterAnnotationID] = notAfter
+	}
+	return &networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+	}
+}
+
+var (
+	windowStart = time.Date(2017, 10, 1, 9, 0, 0, 0, time.UTC)
+	windowEnd   = time.Date(2017, 10, 1, 17, 0, 0, 0, time.UTC)
+)
+
+var networkPolicyWindowTests = []struct {
+	notBefore string
+	notAfter  string
+	window    policyWindow
+	valid     bool
+}{
+	{"", "", policyWindow{}, true},
+	{"2017-10-01T09:00:00Z", "", policyWindow{notBefore: windowStart}, true},
+	{"", "2017-10-01T17:00:00Z", policyWindow{notAfter: windowEnd}, true},
+	{"2017-10-01T09:00:00Z", "2017-10-01T17:00:00Z", policyWindow{notBefore: windowStart, notAfter: windowEnd}, true},
+	{"2017-10-01T11:00:00+02:00", "", policyWindow{notBefore: windowStart}, true},
+	{"2017-10-01", "", policyWindow{}, false},
+	{"", "tomorrow", policyWindow{}, false},
+	{"2017-10-01T17:00:00Z", "2017-10-01T09:00:00Z", policyWindow{}, false},
+	{"2017-10-01T09:00:00Z", "2017-10-01T09:00:00Z", policyWindow{}, false},
+}
+
+func TestNetworkPolicyWindow(t *testing.T) {
+	for _, tt := range networkPolicyWindowTests {
+		window, err := networkPolicyWindow(windowNetworkPolicy("shop", "np", tt.notBefore, tt.notAfter))
+		if (err == nil) != tt.valid {
+			t.Errorf("networkPolicyWindow(%q, %q) => error %v, expected valid %t", tt.notBefore, tt.notAfter, err, tt.valid)
+		}
+		if err != nil {
+			continue
+		}
+		if !window.notBefore.Equal(tt.window.notBefore) || !window.notAfter.Equal(tt.window.notAfter) {
+			t.Errorf("networkPolicyWindow(%q, %q) => %v, expected %v", tt.notBefore, tt.notAfter, window, tt.window)
+		}
+	}
+}
+
+var windowStateTests = []struct {
+	window   policyWindow
+	now      time.Time
+	state    windowState
+	boundary time.Time
+	next     bool
+}{
+	{policyWindow{}, windowStart, windowActive, time.Time{}, false},
+	{policyWindow{notBefore: windowStart, notAfter: windowEnd}, windowStart.Add(-time.Second), windowPending, windowStart, true},
+	// The window starts at notBefore included and ends at notAfter excluded.
+	{policyWindow{notBefore: windowStart, notAfter: windowEnd}, windowStart, windowActive, windowEnd, true},
+	{policyWindow{notBefore: windowStart, notAfter: windowEnd}, windowEnd.Add(-time.Second), windowActive, windowEnd, true},
+	{policyWindow{notBefore: windowStart, notAfter: windowEnd}, windowEnd, windowExpired, time.Time{}, false},
+	{policyWindow{notBefore: windowStart}, windowEnd, windowActive, time.Time{}, false},
+	{policyWindow{notAfter: windowEnd}, windowStart, windowActive, windowEnd, true},
+}
+
+func TestWindowState(t *testing.T) {
+	for _, tt := range windowStateTests {
+		if state := tt.window.state(tt.now); state != tt.state {
+			t.Errorf("state(%v) of %v => %d, expected %d", tt.now, tt.window, state, tt.state)
+		}
+		boundary, next := tt.window.nextBoundary(tt.now)
+		if next != tt.next || !boundary.Equal(tt.boundary) {
+			t.Errorf("nextBoundary(%v) of %v => %v %t, expected %v %t", tt.now, tt.window, boundary, next, tt.boundary, tt.next)
+		}
+	}
+}
+
+func TestActiveNetworkPolicies(t *testing.T) {
+	networkPolicies := &networking.NetworkPolicyList{Items: []networking.NetworkPolicy{
+		*windowNetworkPolicy("shop", "permanent", "", ""),
+		*windowNetworkPolicy("shop", "business-hours", "2017-10-01T09:00:00Z", "2017-10-01T17:00:00Z"),
+		*windowNetworkPolicy("shop", "from-noon", "2017-10-01T12:00:00Z", ""),
+		*windowNetworkPolicy("shop", "invalid", "noon", ""),
+	}}
+
+	tests := []struct {
+		now    time.Time
+		active []string
+	}{
+		{windowStart.Add(-time.Second), []string{"permanent"}},
+		{windowStart, []string{"permanent", "business-hours"}},
+		{windowStart.Add(3 * time.Hour), []string{"permanent", "business-hours", "from-noon"}},
+		{windowEnd, []string{"permanent", "from-noon"}},
+	}
+	for _, tt := range tests {
+		names := []string{}
+		for _, networkPolicy := range activeNetworkPolicies(networkPolicies, tt.now).Items {
+			names = append(names, networkPolicy.GetName())
+		}
+		if !equalStrings(names, tt.active) {
+			t.Errorf("activeNetworkPolicies(%v) => %v, expected %v", tt.now, names, tt.active)
+		}
+	}
+}
+
+func TestCancelNetworkPolicyWindows(t *testing.T) {
+	k := &KubernetesPolicy{windows: newPolicyWindows()}
+	notBefore := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	k.scheduleNetworkPolicyWindow(windowNetworkPolicy("shop", "a", notBefore, ""), true)
+	k.scheduleNetworkPolicyWindow(windowNetworkPolicy("shop", "b", notBefore, ""), true)
+	k.scheduleNetworkPolicyWindow(windowNetworkPolicy("shopping", "c", notBefore, ""), true)
+	// Permanent NetworkPolicies have no boundary.
+	k.scheduleNetworkPolicyWindow(windowNetworkPolicy("shop", "d", "", ""), true)
+	if len(k.windows.timers) != 3 {
+		t.Fatalf("scheduleNetworkPolicyWindow => %d timers, expected 3", len(k.windows.timers))
+	}
+
+	k.cancelNetworkPolicyWindow(windowNetworkPolicy("shop", "a", "", ""))
+	if _, ok := k.windows.timers["shop/a"]; ok {
+		t.Errorf("cancelNetworkPolicyWindow => timer of shop/a not canceled")
+	}
+
+	k.cancelNamespaceNetworkPolicyWindows("shop")
+	if _, ok := k.windows.timers["shop/b"]; ok {
+		t.Errorf("cancelNamespaceNetworkPolicyWindows(shop) => timer of shop/b not canceled")
+	}
+	if _, ok := k.windows.timers["shopping/c"]; !ok {
+		t.Errorf("cancelNamespaceNetworkPolicyWindows(shop) => timer of shopping/c canceled")
+	}
+
+	k.stopNetworkPolicyWindows()
+	if len(k.windows.timers) != 0 {
+		t.Errorf("stopNetworkPolicyWindows => %d timers left", len(k.windows.timers))
+	}
+}
+
+func TestCanceledWindowTimerIgnored(t *testing.T) {
+	windows := newPolicyWindows()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	if windows.isScheduled("shop/a", timer) {
+		t.Errorf("isScheduled => true for a canceled timer")
+	}
+	windows.timers["shop/a"] = timer
+	if !windows.isScheduled("shop/a", timer) {
+		t.Errorf("isScheduled => false for the scheduled timer")
+	}
+	if windows.isScheduled("shop/a", time.NewTimer(time.Hour)) {
+		t.Errorf("isScheduled => true for a replaced timer")
+	}
+}
@@ -0,0 +1,44 @@
+package resolver
+
+import (
+	"strings"
+
+	api "k8s.io/api/core/v1"
+)
+
+// podTemplateHashLabel is the label set by the Deployments on the pods of their ReplicaSets.
+const podTemplateHashLabel = "pod-template-hash"
+
+// PUEndpoint returns the namespace and the workload of the local pod of the contextID.
+// The workload is given as Kind/name, for example Deployment/frontend, or empty for bare pods.
+func (k *KubernetesPolicy) PUEndpoint(contextID string) (namespace string, workload string, ok bool) {
+	entry, ok := k.cache.podByContextID(contextID)
+	if !ok {
+		return "", "", false
+	}
+
+	nsWatcher, exist := k.cache.getNamespaceWatcher(entry.podNamespace)
+	if !exist {
+		return entry.podNamespace, "", true
+	}
+	item, exists, err := nsWatcher.podStore.GetByKey(kubePodIdentifier(entry.podName, entry.podNamespace))
+	if err != nil || !exists {
+		return entry.podNamespace, "", true
+	}
+	return entry.podNamespace, podWorkload(item.(*api.Pod)), true
+}
+
+// podWorkload returns the controller of the pod as Kind/name. The pods of the ReplicaSets
+// created by a Deployment are reported with the Deployment.
+func podWorkload(pod *api.Pod) string {
+	for _, owner := range pod.GetOwnerReferences() {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		if hash, ok := pod.GetLabels()[podTemplateHashLabel]; ok && owner.Kind == "ReplicaSet" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment/" + strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+		return owner.Kind + "/" + owner.Name
+	}
+	return ""
+}
@@ -0,0 +1,23 @@
+package version
+
+import "runtime"
+
+// Info contains the version and build information of Trireme-Kubernetes.
+type Info struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	GoVersion string `json:"goVersion"`
+	Compiler  string `json:"compiler"`
+	Platform  string `json:"platform"`
+}
+
+// BuildInfo returns the version and build information of the running binary.
+func BuildInfo() Info {
+	return Info{
+		Version:   VERSION,
+		Revision:  REVISION,
+		GoVersion: runtime.Version(),
+		Compiler:  runtime.Compiler,
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}